@@ -0,0 +1,157 @@
+// Package calib converts raw sensor values (ADC counts, voltages...) into
+// physical values.
+//
+// A calibration Model is either linear, polynomial, piecewise (different
+// models over different ranges of raw values) or a lookup table interpolated
+// linearly.
+// Calibrations of sensors are stored in versioned JSON files (see File),
+// with their validity date ranges, sensor serial numbers and uncertainties.
+package calib
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Model converts raw values into physical values.
+type Model interface {
+	// Eval returns the physical value corresponding to the raw value x.
+	Eval(x float64) float64
+}
+
+// Linear is the model:
+//
+//	y = Slope * x + Intercept
+type Linear struct {
+	Slope     float64
+	Intercept float64
+}
+
+// TwoPoint returns the linear model going through (x0, y0) and (x1, y1).
+func TwoPoint(x0, y0, x1, y1 float64) (Linear, error) {
+	if x0 == x1 {
+		return Linear{}, fmt.Errorf("calib: degenerate two-point calibration (x0 == x1 == %v)", x0)
+	}
+	slope := (y1 - y0) / (x1 - x0)
+	return Linear{Slope: slope, Intercept: y0 - slope*x0}, nil
+}
+
+func (m Linear) Eval(x float64) float64 {
+	return m.Slope*x + m.Intercept
+}
+
+// Poly is the polynomial model:
+//
+//	y = Coeffs[0] + Coeffs[1]*x + Coeffs[2]*x^2 + ...
+type Poly struct {
+	Coeffs []float64
+}
+
+func (m Poly) Eval(x float64) float64 {
+	y := 0.0
+	for i := len(m.Coeffs) - 1; i >= 0; i-- {
+		y = y*x + m.Coeffs[i]
+	}
+	return y
+}
+
+// Segment is the range [Min, Max) of raw values where Model applies.
+type Segment struct {
+	Min   float64
+	Max   float64
+	Model Model
+}
+
+// Piecewise applies different models over different ranges of raw values.
+// Segments are sorted and must not overlap. Raw values outside of all the
+// segments are converted to NaN.
+type Piecewise struct {
+	Segments []Segment
+}
+
+// NewPiecewise returns a piecewise model made of the given segments.
+func NewPiecewise(segs []Segment) (Piecewise, error) {
+	if len(segs) == 0 {
+		return Piecewise{}, fmt.Errorf("calib: piecewise model without segments")
+	}
+	segs = append([]Segment(nil), segs...)
+	sort.Sort(byMin(segs))
+	for i, seg := range segs {
+		if seg.Model == nil {
+			return Piecewise{}, fmt.Errorf("calib: segment [%v, %v) has no model", seg.Min, seg.Max)
+		}
+		if !(seg.Min < seg.Max) {
+			return Piecewise{}, fmt.Errorf("calib: invalid segment [%v, %v)", seg.Min, seg.Max)
+		}
+		if i > 0 && seg.Min < segs[i-1].Max {
+			return Piecewise{}, fmt.Errorf(
+				"calib: overlapping segments [%v, %v) and [%v, %v)",
+				segs[i-1].Min, segs[i-1].Max, seg.Min, seg.Max,
+			)
+		}
+	}
+	return Piecewise{Segments: segs}, nil
+}
+
+func (m Piecewise) Eval(x float64) float64 {
+	i := sort.Search(len(m.Segments), func(i int) bool { return m.Segments[i].Max > x })
+	if i == len(m.Segments) || x < m.Segments[i].Min {
+		return math.NaN()
+	}
+	return m.Segments[i].Model.Eval(x)
+}
+
+// Point is a (raw, physical) pair of values.
+type Point struct {
+	X float64
+	Y float64
+}
+
+// LUT is a lookup table, interpolated linearly between its points.
+// Raw values outside of the table are clamped to its first and last points.
+type LUT struct {
+	Points []Point // sorted by X
+}
+
+// NewLUT returns a lookup table made of the given points.
+func NewLUT(pts []Point) (LUT, error) {
+	if len(pts) == 0 {
+		return LUT{}, fmt.Errorf("calib: empty lookup table")
+	}
+	pts = append([]Point(nil), pts...)
+	sort.Sort(byX(pts))
+	for i := 1; i < len(pts); i++ {
+		if pts[i].X == pts[i-1].X {
+			return LUT{}, fmt.Errorf("calib: duplicate raw value %v in lookup table", pts[i].X)
+		}
+	}
+	return LUT{Points: pts}, nil
+}
+
+func (m LUT) Eval(x float64) float64 {
+	pts := m.Points
+	switch {
+	case len(pts) == 0:
+		return math.NaN()
+	case x <= pts[0].X:
+		return pts[0].Y
+	case x >= pts[len(pts)-1].X:
+		return pts[len(pts)-1].Y
+	}
+	i := sort.Search(len(pts), func(i int) bool { return pts[i].X > x })
+	p0, p1 := pts[i-1], pts[i]
+	return p0.Y + (x-p0.X)*(p1.Y-p0.Y)/(p1.X-p0.X)
+}
+
+type byMin []Segment
+
+func (p byMin) Len() int           { return len(p) }
+func (p byMin) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+func (p byMin) Less(i, j int) bool { return p[i].Min < p[j].Min }
+
+type byX []Point
+
+func (p byX) Len() int           { return len(p) }
+func (p byX) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+func (p byX) Less(i, j int) bool { return p[i].X < p[j].X }
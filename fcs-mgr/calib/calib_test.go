@@ -0,0 +1,118 @@
+package calib
+
+import (
+	"math"
+	"testing"
+)
+
+func TestEval(t *testing.T) {
+	lin := Linear{Slope: 2, Intercept: 1}
+	pw, err := NewPiecewise([]Segment{
+		{Min: 10, Max: 20, Model: Linear{Slope: -1, Intercept: 40}},
+		{Min: 0, Max: 10, Model: lin},
+		{Min: 25, Max: 30, Model: Poly{Coeffs: []float64{5}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lut, err := NewLUT([]Point{{X: 10, Y: 0}, {X: 0, Y: 100}, {X: 20, Y: 50}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	two, err := TwoPoint(0, -20, 0x8000, 80)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nan := math.NaN()
+	for _, tc := range []struct {
+		name string
+		m    Model
+		x    float64
+		want float64
+	}{
+		{"linear", lin, 3, 7},
+		{"linear", lin, -3, -5},
+
+		{"poly", Poly{Coeffs: []float64{1, 2, 0.5}}, 0, 1},
+		{"poly", Poly{Coeffs: []float64{1, 2, 0.5}}, 2, 7},
+		{"poly", Poly{Coeffs: []float64{1, 2, 0.5}}, -2, -1},
+		{"poly-constant", Poly{Coeffs: []float64{3}}, 1e6, 3},
+		{"poly-empty", Poly{}, 2, 0},
+
+		{"piecewise-first", pw, 0, 1},
+		{"piecewise-first", pw, 9.5, 20},
+		{"piecewise-boundary", pw, 10, 30}, // segments are [min, max)
+		{"piecewise-second", pw, 19, 21},
+		{"piecewise-gap", pw, 20, nan},
+		{"piecewise-gap", pw, 22, nan},
+		{"piecewise-third", pw, 25, 5},
+		{"piecewise-below", pw, -1, nan},
+		{"piecewise-above", pw, 30, nan},
+
+		{"lut-point", lut, 10, 0},
+		{"lut-interp", lut, 5, 50},
+		{"lut-interp", lut, 15, 25},
+		{"lut-first", lut, 0, 100},
+		{"lut-last", lut, 20, 50},
+		{"lut-clamp-below", lut, -100, 100},
+		{"lut-clamp-above", lut, 100, 50},
+		{"lut-single", LUT{Points: []Point{{X: 1, Y: 2}}}, 5, 2},
+		{"lut-empty", LUT{}, 5, nan},
+
+		{"two-point", two, 0, -20},
+		{"two-point", two, 0x8000, 80},
+		{"two-point", two, 0x4000, 30},
+		{"two-point-extrapolate", two, 0x10000, 180},
+		{"two-point-extrapolate", two, -0x8000, -120},
+	} {
+		got := tc.m.Eval(tc.x)
+		switch {
+		case math.IsNaN(tc.want):
+			if !math.IsNaN(got) {
+				t.Fatalf("%s: eval(%v): got=%v, want=NaN", tc.name, tc.x, got)
+			}
+		case math.Abs(got-tc.want) > 1e-9:
+			t.Fatalf("%s: eval(%v): got=%v, want=%v", tc.name, tc.x, got, tc.want)
+		}
+	}
+
+	if pw.Segments[0].Min != 0 || pw.Segments[2].Min != 25 {
+		t.Fatalf("segments are not sorted: %+v", pw.Segments)
+	}
+	if lut.Points[0].X != 0 || lut.Points[2].X != 20 {
+		t.Fatalf("points are not sorted: %+v", lut.Points)
+	}
+}
+
+func TestModelErrors(t *testing.T) {
+	_, err := TwoPoint(1, 2, 1, 3)
+	if err == nil {
+		t.Fatalf("expected an error for a degenerate two-point calibration")
+	}
+
+	for _, tc := range []struct {
+		name string
+		segs []Segment
+	}{
+		{"empty", nil},
+		{"no model", []Segment{{Min: 0, Max: 1}}},
+		{"empty segment", []Segment{{Min: 1, Max: 1, Model: Linear{}}}},
+		{"reversed segment", []Segment{{Min: 2, Max: 1, Model: Linear{}}}},
+		{"overlap", []Segment{{Min: 0, Max: 2, Model: Linear{}}, {Min: 1, Max: 3, Model: Linear{}}}},
+	} {
+		_, err := NewPiecewise(tc.segs)
+		if err == nil {
+			t.Fatalf("%s: expected an error", tc.name)
+		}
+	}
+
+	_, err = NewLUT(nil)
+	if err == nil {
+		t.Fatalf("expected an error for an empty lookup table")
+	}
+	_, err = NewLUT([]Point{{X: 1, Y: 1}, {X: 0, Y: 0}, {X: 1, Y: 2}})
+	if err == nil {
+		t.Fatalf("expected an error for duplicate raw values")
+	}
+}
@@ -0,0 +1,340 @@
+package calib
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Version is the version of the calibration file format written by this
+// package. Files with a higher version are rejected.
+const Version = 1
+
+// Calibration is the calibration of a sensor, valid over a date range.
+type Calibration struct {
+	Sensor      string    // name of the calibrated sensor or channel
+	Serial      string    // serial number of the sensor
+	Revision    int       // revision of the calibration of the sensor
+	ValidFrom   time.Time // start of validity (zero: no lower bound)
+	ValidTo     time.Time // end of validity, excluded (zero: no upper bound)
+	Unit        string    // physical unit of the calibrated values
+	Uncertainty float64   // absolute uncertainty on calibrated values, in Unit
+	Comment     string
+	Model       Model
+}
+
+// Valid returns whether the calibration is valid at time t.
+// A zero t matches any validity range.
+func (c *Calibration) Valid(t time.Time) bool {
+	if t.IsZero() {
+		return true
+	}
+	if !c.ValidFrom.IsZero() && t.Before(c.ValidFrom) {
+		return false
+	}
+	if !c.ValidTo.IsZero() && !t.Before(c.ValidTo) {
+		return false
+	}
+	return true
+}
+
+// Eval returns the physical value corresponding to the raw value x.
+func (c *Calibration) Eval(x float64) float64 {
+	return c.Model.Eval(x)
+}
+
+// File is a set of calibrations.
+//
+// Files are stored as JSON:
+//
+//	{
+//	  "version": 1,
+//	  "calibrations": [
+//	    {
+//	      "sensor": "temp", "serial": "HD2001-0042", "revision": 2,
+//	      "valid_from": "2015-06-01T00:00:00Z", "valid_to": "2016-06-01T00:00:00Z",
+//	      "unit": "C", "uncertainty": 0.1,
+//	      "model": {"kind": "linear", "slope": 0.003125, "intercept": -20}
+//	    }
+//	  ]
+//	}
+//
+// Models are described by their kind and parameters:
+//
+//	{"kind": "linear", "slope": 2, "intercept": 1}
+//	{"kind": "poly", "coeffs": [1, 2, 0.5]}
+//	{"kind": "piecewise", "segments": [{"min": 0, "max": 10, "model": {...}}, ...]}
+//	{"kind": "lut", "points": [[0, 1], [10, 21], ...]}
+type File struct {
+	Version      int
+	Calibrations []Calibration
+}
+
+// Open loads the calibration file fname.
+func Open(fname string) (*File, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	file, err := Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("calib: error decoding file [%s]: %v", fname, err)
+	}
+	return file, nil
+}
+
+// Decode decodes a calibration file from r.
+func Decode(r io.Reader) (*File, error) {
+	var raw struct {
+		Version      int               `json:"version"`
+		Calibrations []jsonCalibration `json:"calibrations"`
+	}
+	err := json.NewDecoder(r).Decode(&raw)
+	if err != nil {
+		return nil, err
+	}
+	if raw.Version < 1 || raw.Version > Version {
+		return nil, fmt.Errorf("unsupported calibration file version %d", raw.Version)
+	}
+
+	f := &File{
+		Version:      raw.Version,
+		Calibrations: make([]Calibration, len(raw.Calibrations)),
+	}
+	for i, jc := range raw.Calibrations {
+		c, err := jc.calibration()
+		if err != nil {
+			return nil, fmt.Errorf("calibration #%d (sensor %q): %v", i, jc.Sensor, err)
+		}
+		f.Calibrations[i] = c
+	}
+	return f, nil
+}
+
+// Encode writes the calibration file to w.
+func (f *File) Encode(w io.Writer) error {
+	raw := struct {
+		Version      int               `json:"version"`
+		Calibrations []jsonCalibration `json:"calibrations"`
+	}{
+		Version:      Version,
+		Calibrations: make([]jsonCalibration, len(f.Calibrations)),
+	}
+	for i, c := range f.Calibrations {
+		jc, err := newJSONCalibration(c)
+		if err != nil {
+			return fmt.Errorf("calib: calibration #%d (sensor %q): %v", i, c.Sensor, err)
+		}
+		raw.Calibrations[i] = jc
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(raw)
+}
+
+// Lookup returns the calibration of a sensor, identified by its name or its
+// serial number, valid at time t.
+// When several calibrations are valid, the one with the highest revision is
+// returned.
+func (f *File) Lookup(sensor string, t time.Time) (*Calibration, error) {
+	var (
+		cal   *Calibration
+		found = false
+	)
+	for i := range f.Calibrations {
+		c := &f.Calibrations[i]
+		if c.Sensor != sensor && c.Serial != sensor {
+			continue
+		}
+		found = true
+		if !c.Valid(t) {
+			continue
+		}
+		if cal == nil || c.Revision > cal.Revision {
+			cal = c
+		}
+	}
+	switch {
+	case cal != nil:
+		return cal, nil
+	case found:
+		return nil, fmt.Errorf("calib: no calibration of sensor %q valid at %v", sensor, t)
+	default:
+		return nil, fmt.Errorf("calib: no calibration for sensor %q", sensor)
+	}
+}
+
+// Span returns the time range [beg, end) around t over which the validity of
+// the calibrations of a sensor does not change, so Lookup keeps returning the
+// same calibration. Zero bounds are unbounded.
+func (f *File) Span(sensor string, t time.Time) (beg, end time.Time) {
+	for i := range f.Calibrations {
+		c := &f.Calibrations[i]
+		if c.Sensor != sensor && c.Serial != sensor {
+			continue
+		}
+		for _, b := range []time.Time{c.ValidFrom, c.ValidTo} {
+			switch {
+			case b.IsZero():
+			case !t.Before(b):
+				if beg.IsZero() || b.After(beg) {
+					beg = b
+				}
+			default:
+				if end.IsZero() || b.Before(end) {
+					end = b
+				}
+			}
+		}
+	}
+	return beg, end
+}
+
+type jsonCalibration struct {
+	Sensor      string     `json:"sensor"`
+	Serial      string     `json:"serial,omitempty"`
+	Revision    int        `json:"revision"`
+	ValidFrom   *time.Time `json:"valid_from,omitempty"`
+	ValidTo     *time.Time `json:"valid_to,omitempty"`
+	Unit        string     `json:"unit,omitempty"`
+	Uncertainty float64    `json:"uncertainty,omitempty"`
+	Comment     string     `json:"comment,omitempty"`
+	Model       *jsonModel `json:"model"`
+}
+
+func newJSONCalibration(c Calibration) (jsonCalibration, error) {
+	jc := jsonCalibration{
+		Sensor:      c.Sensor,
+		Serial:      c.Serial,
+		Revision:    c.Revision,
+		Unit:        c.Unit,
+		Uncertainty: c.Uncertainty,
+		Comment:     c.Comment,
+	}
+	if !c.ValidFrom.IsZero() {
+		jc.ValidFrom = &c.ValidFrom
+	}
+	if !c.ValidTo.IsZero() {
+		jc.ValidTo = &c.ValidTo
+	}
+	m, err := newJSONModel(c.Model)
+	if err != nil {
+		return jc, err
+	}
+	jc.Model = m
+	return jc, nil
+}
+
+func (jc jsonCalibration) calibration() (Calibration, error) {
+	c := Calibration{
+		Sensor:      jc.Sensor,
+		Serial:      jc.Serial,
+		Revision:    jc.Revision,
+		Unit:        jc.Unit,
+		Uncertainty: jc.Uncertainty,
+		Comment:     jc.Comment,
+	}
+	if c.Sensor == "" && c.Serial == "" {
+		return c, fmt.Errorf("missing sensor name or serial number")
+	}
+	if jc.ValidFrom != nil {
+		c.ValidFrom = *jc.ValidFrom
+	}
+	if jc.ValidTo != nil {
+		c.ValidTo = *jc.ValidTo
+	}
+	if !c.ValidFrom.IsZero() && !c.ValidTo.IsZero() && !c.ValidFrom.Before(c.ValidTo) {
+		return c, fmt.Errorf("empty validity range [%v, %v)", c.ValidFrom, c.ValidTo)
+	}
+	if c.Uncertainty < 0 {
+		return c, fmt.Errorf("negative uncertainty %v", c.Uncertainty)
+	}
+	if jc.Model == nil {
+		return c, fmt.Errorf("missing model")
+	}
+	m, err := jc.Model.model()
+	if err != nil {
+		return c, err
+	}
+	c.Model = m
+	return c, nil
+}
+
+type jsonModel struct {
+	Kind      string        `json:"kind"`
+	Slope     float64       `json:"slope,omitempty"`
+	Intercept float64       `json:"intercept,omitempty"`
+	Coeffs    []float64     `json:"coeffs,omitempty"`
+	Segments  []jsonSegment `json:"segments,omitempty"`
+	Points    [][2]float64  `json:"points,omitempty"`
+}
+
+type jsonSegment struct {
+	Min   float64    `json:"min"`
+	Max   float64    `json:"max"`
+	Model *jsonModel `json:"model"`
+}
+
+func newJSONModel(m Model) (*jsonModel, error) {
+	switch m := m.(type) {
+	case Linear:
+		return &jsonModel{Kind: "linear", Slope: m.Slope, Intercept: m.Intercept}, nil
+	case Poly:
+		return &jsonModel{Kind: "poly", Coeffs: m.Coeffs}, nil
+	case Piecewise:
+		jm := &jsonModel{Kind: "piecewise", Segments: make([]jsonSegment, len(m.Segments))}
+		for i, seg := range m.Segments {
+			sm, err := newJSONModel(seg.Model)
+			if err != nil {
+				return nil, err
+			}
+			jm.Segments[i] = jsonSegment{Min: seg.Min, Max: seg.Max, Model: sm}
+		}
+		return jm, nil
+	case LUT:
+		jm := &jsonModel{Kind: "lut", Points: make([][2]float64, len(m.Points))}
+		for i, pt := range m.Points {
+			jm.Points[i] = [2]float64{pt.X, pt.Y}
+		}
+		return jm, nil
+	}
+	return nil, fmt.Errorf("unsupported model type %T", m)
+}
+
+func (jm *jsonModel) model() (Model, error) {
+	switch jm.Kind {
+	case "linear":
+		return Linear{Slope: jm.Slope, Intercept: jm.Intercept}, nil
+	case "poly":
+		if len(jm.Coeffs) == 0 {
+			return nil, fmt.Errorf("polynomial model without coefficients")
+		}
+		return Poly{Coeffs: jm.Coeffs}, nil
+	case "piecewise":
+		segs := make([]Segment, len(jm.Segments))
+		for i, seg := range jm.Segments {
+			if seg.Model == nil {
+				return nil, fmt.Errorf("segment #%d has no model", i)
+			}
+			m, err := seg.Model.model()
+			if err != nil {
+				return nil, fmt.Errorf("segment #%d: %v", i, err)
+			}
+			segs[i] = Segment{Min: seg.Min, Max: seg.Max, Model: m}
+		}
+		return NewPiecewise(segs)
+	case "lut":
+		pts := make([]Point, len(jm.Points))
+		for i, pt := range jm.Points {
+			pts[i] = Point{X: pt[0], Y: pt[1]}
+		}
+		return NewLUT(pts)
+	case "":
+		return nil, fmt.Errorf("missing model kind")
+	}
+	return nil, fmt.Errorf("unknown model kind %q", jm.Kind)
+}
@@ -0,0 +1,157 @@
+package calib
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func date(m time.Month, d int) time.Time {
+	return time.Date(2016, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+func TestLookupSpan(t *testing.T) {
+	f := &File{
+		Version: Version,
+		Calibrations: []Calibration{
+			{Sensor: "temp", Revision: 1, Model: Linear{Slope: 1}},
+			{Sensor: "temp", Revision: 2, ValidFrom: date(3, 1), ValidTo: date(6, 1), Model: Linear{Slope: 2}},
+			{Sensor: "other", Revision: 3, ValidFrom: date(2, 1), Model: Linear{Slope: 3}},
+		},
+	}
+
+	for _, tc := range []struct {
+		t        time.Time
+		rev      int
+		beg, end time.Time
+	}{
+		{date(1, 1), 1, time.Time{}, date(3, 1)},
+		{date(3, 1), 2, date(3, 1), date(6, 1)},
+		{date(4, 1), 2, date(3, 1), date(6, 1)},
+		{date(6, 1), 1, date(6, 1), time.Time{}},
+	} {
+		cal, err := f.Lookup("temp", tc.t)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cal.Revision != tc.rev {
+			t.Fatalf("%v: invalid revision: got=%d, want=%d", tc.t, cal.Revision, tc.rev)
+		}
+		beg, end := f.Span("temp", tc.t)
+		if !beg.Equal(tc.beg) || !end.Equal(tc.end) {
+			t.Fatalf("%v: invalid span: got=[%v, %v), want=[%v, %v)", tc.t, beg, end, tc.beg, tc.end)
+		}
+	}
+}
+
+const testFile = `{
+  "version": 1,
+  "calibrations": [
+    {
+      "sensor": "temp", "serial": "HD2001-0042", "revision": 2,
+      "valid_from": "2016-03-01T00:00:00Z", "valid_to": "2016-06-01T00:00:00Z",
+      "unit": "C", "uncertainty": 0.1, "comment": "bench",
+      "model": {"kind": "linear", "slope": 0.003125, "intercept": -20}
+    },
+    {
+      "sensor": "press", "revision": 1,
+      "model": {"kind": "poly", "coeffs": [600, 0.015625, 1e-9]}
+    },
+    {
+      "serial": "PT100-7",
+      "model": {
+        "kind": "piecewise",
+        "segments": [
+          {"min": 100, "max": 200, "model": {"kind": "lut", "points": [[100, 0], [200, 10]]}},
+          {"min": 0, "max": 100, "model": {"kind": "linear", "slope": 0.1}}
+        ]
+      }
+    }
+  ]
+}`
+
+func TestDecodeEncode(t *testing.T) {
+	f, err := Decode(strings.NewReader(testFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &File{
+		Version: 1,
+		Calibrations: []Calibration{
+			{
+				Sensor: "temp", Serial: "HD2001-0042", Revision: 2,
+				ValidFrom: date(3, 1), ValidTo: date(6, 1),
+				Unit: "C", Uncertainty: 0.1, Comment: "bench",
+				Model: Linear{Slope: 0.003125, Intercept: -20},
+			},
+			{
+				Sensor: "press", Revision: 1,
+				Model: Poly{Coeffs: []float64{600, 0.015625, 1e-9}},
+			},
+			{
+				Serial: "PT100-7",
+				Model: Piecewise{Segments: []Segment{
+					{Min: 0, Max: 100, Model: Linear{Slope: 0.1}},
+					{Min: 100, Max: 200, Model: LUT{Points: []Point{{X: 100, Y: 0}, {X: 200, Y: 10}}}},
+				}},
+			},
+		},
+	}
+	if !reflect.DeepEqual(f, want) {
+		t.Fatalf("invalid file:\ngot= %+v\nwant=%+v", f, want)
+	}
+	if got := f.Calibrations[2].Eval(150); got != 5 {
+		t.Fatalf("invalid calibrated value: %v", got)
+	}
+
+	var buf bytes.Buffer
+	err = f.Encode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `"version": 1`) {
+		t.Fatalf("missing version:\n%s", buf.String())
+	}
+	got, err := Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid round-trip:\ngot= %+v\nwant=%+v", got, want)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	const model = `"model": {"kind": "linear", "slope": 1}`
+	for _, tc := range []struct {
+		name string
+		file string
+		err  string
+	}{
+		{"no version", `{"calibrations": []}`, "unsupported calibration file version 0"},
+		{"future version", `{"version": 2, "calibrations": []}`, "unsupported calibration file version 2"},
+		{"no sensor", `{"version": 1, "calibrations": [{` + model + `}]}`, "missing sensor name or serial number"},
+		{"no model", `{"version": 1, "calibrations": [{"sensor": "a"}]}`, "missing model"},
+		{"no kind", `{"version": 1, "calibrations": [{"sensor": "a", "model": {}}]}`, "missing model kind"},
+		{"unknown kind", `{"version": 1, "calibrations": [{"sensor": "a", "model": {"kind": "spline"}}]}`, "unknown model kind"},
+		{"empty poly", `{"version": 1, "calibrations": [{"sensor": "a", "model": {"kind": "poly"}}]}`, "without coefficients"},
+		{"empty lut", `{"version": 1, "calibrations": [{"sensor": "a", "model": {"kind": "lut"}}]}`, "empty lookup table"},
+		{"empty range", `{"version": 1, "calibrations": [{"sensor": "a", "valid_from": "2016-06-01T00:00:00Z", "valid_to": "2016-03-01T00:00:00Z", ` + model + `}]}`, "empty validity range"},
+		{"uncertainty", `{"version": 1, "calibrations": [{"sensor": "a", "uncertainty": -1, ` + model + `}]}`, "negative uncertainty"},
+		{"segment", `{"version": 1, "calibrations": [{"sensor": "a", "model": {"kind": "piecewise", "segments": [{"min": 0, "max": 1}]}}]}`, "segment #0 has no model"},
+	} {
+		_, err := Decode(strings.NewReader(tc.file))
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Fatalf("%s: got error %v, want %q", tc.name, err, tc.err)
+		}
+	}
+
+	f := &File{Calibrations: []Calibration{{Sensor: "a", Model: nil}}}
+	err := f.Encode(new(bytes.Buffer))
+	if err == nil {
+		t.Fatalf("expected an error encoding a calibration without model")
+	}
+}
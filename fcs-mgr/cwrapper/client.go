@@ -0,0 +1,339 @@
+package cwrapper
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// DefaultTimeout is the default time a Client waits for a reply.
+const DefaultTimeout = 5 * time.Second
+
+// AbortError describes an aborted SDO transfer.
+type AbortError struct {
+	Node     uint8
+	Index    uint16
+	SubIndex uint8
+	Code     uint32
+}
+
+func (e *AbortError) Error() string {
+	return fmt.Sprintf(
+		"cwrapper: SDO transfer aborted (node=0x%x, object=0x%04x:%x): 0x%08x (%s)",
+		e.Node, e.Index, e.SubIndex, e.Code, AbortDescription(e.Code),
+	)
+}
+
+// AbortDescription returns the description of a SDO abort code, as defined
+// by CiA 301.
+func AbortDescription(code uint32) string {
+	if msg, ok := abortCodes[code]; ok {
+		return msg
+	}
+	return "unknown abort code"
+}
+
+var abortCodes = map[uint32]string{
+	0x05030000: "toggle bit not alternated",
+	0x05040000: "SDO protocol timed out",
+	0x05040001: "command specifier not valid or unknown",
+	0x05040005: "out of memory",
+	0x06010000: "unsupported access to an object",
+	0x06010001: "attempt to read a write only object",
+	0x06010002: "attempt to write a read only object",
+	0x06020000: "object does not exist in the object dictionary",
+	0x06040041: "object cannot be mapped to the PDO",
+	0x06060000: "access failed due to a hardware error",
+	0x06070010: "data type does not match, length of service parameter does not match",
+	0x06090011: "sub-index does not exist",
+	0x06090030: "invalid value for parameter",
+	0x06090031: "value of parameter written too high",
+	0x06090032: "value of parameter written too low",
+	0x08000000: "general error",
+	0x08000020: "data cannot be transferred or stored to the application",
+	0x08000021: "data cannot be transferred or stored to the application because of local control",
+	0x08000022: "data cannot be transferred or stored to the application because of the present device state",
+}
+
+// Client is the FCS subsystem end of a c-wrapper connection.
+//
+// Commands are sent one at a time: Client is safe for concurrent use, but
+// concurrent commands are serialized.
+type Client struct {
+	// Timeout is the time to wait for a reply.
+	Timeout time.Duration
+
+	mu      sync.Mutex // serializes commands
+	wmu     sync.Mutex // serializes writes
+	conn    io.ReadWriteCloser
+	replies chan Message
+	events  chan<- Message
+	done    chan struct{}
+	err     error // error which stopped the reading loop
+}
+
+// Dial connects to the c-wrapper listening at addr.
+// See NewClient for the meaning of events.
+func Dial(addr string, events chan<- Message) (*Client, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn, events), nil
+}
+
+// NewClient returns a Client communicating with a c-wrapper over conn.
+//
+// Unsolicited messages (boot-ups and emergencies) are sent to events, if not
+// nil. They are dropped if events is full.
+// Up to 16 replies are buffered for the next command: older replies are
+// dropped, so a peer sending replies nobody waits for does not delay events.
+func NewClient(conn io.ReadWriteCloser, events chan<- Message) *Client {
+	c := &Client{
+		Timeout: DefaultTimeout,
+		conn:    conn,
+		replies: make(chan Message, 16),
+		events:  events,
+		done:    make(chan struct{}),
+	}
+	go c.read()
+	return c
+}
+
+func (c *Client) read() {
+	defer close(c.done)
+	scan := bufio.NewScanner(c.conn)
+	for scan.Scan() {
+		line := scan.Text()
+		if line == "" {
+			continue
+		}
+		msg, err := DecodeReply(line)
+		if err != nil {
+			log.Printf("cwrapper: dropping malformed message: %v\n", err)
+			continue
+		}
+		switch msg.(type) {
+		case Boot, Emcy:
+			if c.events == nil {
+				continue
+			}
+			select {
+			case c.events <- msg:
+			default:
+			}
+		default:
+			select {
+			case c.replies <- msg:
+			default:
+				// no command is consuming the replies: drop the oldest
+				// one rather than blocking the delivery of events.
+				// Only this goroutine sends replies, so the second send
+				// can not block.
+				select {
+				case <-c.replies:
+				default:
+				}
+				c.replies <- msg
+			}
+		}
+	}
+	c.err = scan.Err()
+	if c.err == nil {
+		c.err = io.EOF
+	}
+}
+
+// Close sends a quit command and closes the connection.
+func (c *Client) Close() error {
+	err := c.Send(Quit{})
+	if e := c.conn.Close(); e != nil && err == nil {
+		err = e
+	}
+	return err
+}
+
+// Send sends a command to the c-wrapper, without waiting for any reply.
+func (c *Client) Send(cmd Message) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	_, err := io.WriteString(c.conn, Encode(cmd)+"\n")
+	return err
+}
+
+// recv waits for the next reply.
+func (c *Client) recv() (Message, error) {
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	select {
+	case msg := <-c.replies:
+		return checkReply(msg)
+	case <-c.done:
+		// drain replies received before the end of the connection.
+		select {
+		case msg := <-c.replies:
+			return checkReply(msg)
+		default:
+		}
+		return nil, c.err
+	case <-time.After(timeout):
+		return nil, fmt.Errorf("cwrapper: timeout waiting for reply")
+	}
+}
+
+// checkReply returns msg, or the error it reports.
+func checkReply(msg Message) (Message, error) {
+	if e, ok := msg.(Error); ok {
+		return nil, e
+	}
+	return msg, nil
+}
+
+// flush discards the stale replies to previous commands which timed out.
+func (c *Client) flush() {
+	for {
+		select {
+		case <-c.replies:
+		default:
+			return
+		}
+	}
+}
+
+// Do sends cmd and returns the reply of the c-wrapper.
+// For Sync commands, the returned reply is the final SyncReply message: use
+// Sync to retrieve the PDOs.
+func (c *Client) Do(cmd Message) (Message, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.do(cmd)
+}
+
+func (c *Client) do(cmd Message) (Message, error) {
+	c.flush()
+	err := c.Send(cmd)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		reply, err := c.recv()
+		if err != nil {
+			return nil, err
+		}
+		if !answers(reply, cmd) {
+			// PDOs of a previous sync or late reply to a command which
+			// timed out.
+			continue
+		}
+		return reply, nil
+	}
+}
+
+// answers returns whether reply is the reply to cmd.
+func answers(reply, cmd Message) bool {
+	switch cmd := cmd.(type) {
+	case ReadSDO:
+		r, ok := reply.(ReadSDOReply)
+		return ok && r.Node == cmd.Node && r.Index == cmd.Index && r.SubIndex == cmd.SubIndex
+	case WriteSDO:
+		r, ok := reply.(WriteSDOReply)
+		return ok && r.Node == cmd.Node && r.Index == cmd.Index && r.SubIndex == cmd.SubIndex
+	case Info:
+		r, ok := reply.(InfoReply)
+		return ok && r.Node == cmd.Node
+	case NMT:
+		r, ok := reply.(NMTReply)
+		return ok && r.Cmd == cmd.Cmd && r.Node == cmd.Node
+	case Sync:
+		_, ok := reply.(SyncReply)
+		return ok
+	}
+	return reply.Name() == cmd.Name()
+}
+
+// ReadSDO reads the object at index:sub of node.
+func (c *Client) ReadSDO(node uint8, index uint16, sub uint8) (uint32, error) {
+	reply, err := c.Do(ReadSDO{Node: node, Index: index, SubIndex: sub})
+	if err != nil {
+		return 0, err
+	}
+	r := reply.(ReadSDOReply)
+	if r.Abort != 0 {
+		return 0, &AbortError{Node: node, Index: index, SubIndex: sub, Code: r.Abort}
+	}
+	return r.Data, nil
+}
+
+// WriteSDO writes the size bytes of data to the object at index:sub of node.
+func (c *Client) WriteSDO(node uint8, index uint16, sub uint8, size uint8, data uint32) error {
+	reply, err := c.Do(WriteSDO{Node: node, Index: index, SubIndex: sub, Size: size, Data: data})
+	if err != nil {
+		return err
+	}
+	r := reply.(WriteSDOReply)
+	if r.Abort != 0 {
+		return &AbortError{Node: node, Index: index, SubIndex: sub, Code: r.Abort}
+	}
+	return nil
+}
+
+// Info returns the identity of node.
+func (c *Client) Info(node uint8) (InfoReply, error) {
+	reply, err := c.Do(Info{Node: node})
+	if err != nil {
+		return InfoReply{}, err
+	}
+	return reply.(InfoReply), nil
+}
+
+// Sync sends a SYNC on the bus and returns the collected PDOs.
+func (c *Client) Sync() ([]PDO, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.flush()
+	err := c.Send(Sync{})
+	if err != nil {
+		return nil, err
+	}
+
+	var pdos []PDO
+	for {
+		reply, err := c.recv()
+		if err != nil {
+			return pdos, err
+		}
+		switch reply := reply.(type) {
+		case PDO:
+			pdos = append(pdos, reply)
+		case SyncReply:
+			if reply.N != len(pdos) {
+				return pdos, fmt.Errorf("cwrapper: received %d PDOs. expected %d", len(pdos), reply.N)
+			}
+			return pdos, nil
+		default:
+			// late reply to a command which timed out.
+			continue
+		}
+	}
+}
+
+// NMT sends the network management command cmd to node.
+// Node Broadcast addresses all the nodes.
+func (c *Client) NMT(cmd NMTCommand, node uint8) error {
+	reply, err := c.Do(NMT{Cmd: cmd, Node: node})
+	if err != nil {
+		return err
+	}
+	r := reply.(NMTReply)
+	if r.Err != 0 {
+		return fmt.Errorf("cwrapper: NMT command %q failed for node 0x%x (err=0x%x)", cmd, node, r.Err)
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package cwrapper
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestClient returns a client connected to a fake c-wrapper, answering
+// each command with the lines returned by serve.
+func newTestClient(t *testing.T, serve func(cmd Message) []string) *Client {
+	cli, srv := net.Pipe()
+	c := NewClient(cli, nil)
+	c.Timeout = 200 * time.Millisecond
+	go func() {
+		conn := NewConn(srv)
+		defer conn.Close()
+		for {
+			cmd, err := conn.ReadCommand()
+			if err != nil {
+				return
+			}
+			for _, line := range serve(cmd) {
+				_, err = srv.Write([]byte(line + "\n"))
+				if err != nil {
+					return
+				}
+			}
+		}
+	}()
+	t.Cleanup(func() { cli.Close() })
+	return c
+}
+
+func TestClientStaleReply(t *testing.T) {
+	late := make(chan string, 1)
+	c := newTestClient(t, func(cmd Message) []string {
+		switch cmd := cmd.(type) {
+		case ReadSDO:
+			if cmd.Node == 1 {
+				// answer too late.
+				late <- Encode(ReadSDOReply{Node: 1, Index: cmd.Index, SubIndex: cmd.SubIndex, Data: 0xbad})
+				return nil
+			}
+			return []string{
+				<-late,
+				Encode(ReadSDOReply{Node: cmd.Node, Index: cmd.Index, SubIndex: 2, Data: 0xbad}),
+				Encode(ReadSDOReply{Node: cmd.Node, Index: cmd.Index, SubIndex: cmd.SubIndex, Data: 0x600d}),
+			}
+		case Info:
+			return []string{
+				Encode(InfoReply{Node: cmd.Node + 1}),
+				Encode(InfoReply{Node: cmd.Node, Serial: 42}),
+			}
+		}
+		return nil
+	})
+
+	_, err := c.ReadSDO(1, 0x6000, 1)
+	if err == nil || !strings.Contains(err.Error(), "timeout") {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+
+	v, err := c.ReadSDO(2, 0x6000, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != 0x600d {
+		t.Fatalf("invalid value: got=0x%x, want=0x600d", v)
+	}
+
+	info, err := c.Info(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Serial != 42 {
+		t.Fatalf("invalid info reply: %#v", info)
+	}
+}
+
+func TestClientMalformedReply(t *testing.T) {
+	c := newTestClient(t, func(msg Message) []string {
+		cmd := msg.(WriteSDO)
+		return []string{
+			"garbage,zz",
+			"rsdo,1",
+			Encode(WriteSDOReply{Node: cmd.Node, Index: cmd.Index, SubIndex: cmd.SubIndex, Abort: 0x06010002}),
+		}
+	})
+
+	err := c.WriteSDO(1, 0x1000, 0, 4, 1)
+	e, ok := err.(*AbortError)
+	if !ok {
+		t.Fatalf("expected an abort error, got %v", err)
+	}
+	if e.Code != 0x06010002 || e.Index != 0x1000 {
+		t.Fatalf("invalid abort error: %v", e)
+	}
+
+	// the connection is still usable.
+	err = c.WriteSDO(1, 0x1000, 1, 4, 1)
+	if _, ok := err.(*AbortError); !ok {
+		t.Fatalf("expected an abort error, got %v", err)
+	}
+}
+
+func TestClientSync(t *testing.T) {
+	c := newTestClient(t, func(cmd Message) []string {
+		return []string{
+			Encode(ReadSDOReply{Node: 1, Index: 0x6000}), // late reply
+			Encode(PDO{COBID: 0x181, Data: []byte{1, 2}}),
+			Encode(PDO{COBID: 0x182, Data: []byte{3}}),
+			Encode(SyncReply{N: 2}),
+		}
+	})
+
+	pdos, err := c.Sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pdos) != 2 || pdos[1].Node() != 2 {
+		t.Fatalf("invalid PDOs: %v", pdos)
+	}
+}
+
+func TestClientStrayReplies(t *testing.T) {
+	cli, srv := net.Pipe()
+	defer srv.Close()
+	events := make(chan Message, 1)
+	c := NewClient(cli, events)
+	c.Timeout = time.Second
+	defer cli.Close()
+
+	// replies nobody waits for do not block the delivery of events.
+	conn := NewConn(srv)
+	go func() {
+		for i := 0; i < 100; i++ {
+			err := conn.Send(ReadSDOReply{Node: 1, Index: 0x6000, Data: uint32(i)})
+			if err != nil {
+				return
+			}
+		}
+		conn.Send(Boot{Node: 3})
+	}()
+	select {
+	case evt := <-events:
+		if evt != (Boot{Node: 3}) {
+			t.Fatalf("invalid event: %v", evt)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("event not delivered")
+	}
+
+	go func() {
+		cmd, err := conn.ReadCommand()
+		if err != nil {
+			return
+		}
+		r := cmd.(ReadSDO)
+		conn.Send(ReadSDOReply{Node: r.Node, Index: r.Index, SubIndex: r.SubIndex, Data: 0x600d})
+	}()
+	v, err := c.ReadSDO(1, 0x6000, 1)
+	if err != nil || v != 0x600d {
+		t.Fatalf("invalid value: 0x%x (err=%v)", v, err)
+	}
+}
+
+func TestClientErrorBeforeClose(t *testing.T) {
+	cli, srv := net.Pipe()
+	c := NewClient(cli, nil)
+	defer cli.Close()
+
+	// the error is received before the end of the connection.
+	err := NewConn(srv).Send(Error{Code: ErrUnknownNode, Msg: "unknown node 42"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Close()
+	<-c.done
+
+	_, err = c.recv()
+	e, ok := err.(Error)
+	if !ok || e.Code != ErrUnknownNode {
+		t.Fatalf("expected an unknown node error, got %v", err)
+	}
+}
@@ -0,0 +1,472 @@
+// Package cwrapper implements the TCP protocol spoken between the FCS
+// subsystem and the c-wrapper driving the CANopen bus from the PC-104.
+//
+// The FCS subsystem and the c-wrapper exchange newline-terminated ASCII
+// messages. A message is a comma-separated list of fields, the first one
+// being the message name. Numbers are hexadecimal, without any "0x" prefix.
+//
+// Commands sent by the FCS subsystem:
+//
+//	rsdo,<node>,<index>,<subindex>               read an SDO object
+//	wsdo,<node>,<index>,<subindex>,<size>,<data> write an SDO object
+//	info,<node>                                  retrieve node identity
+//	sync                                         send a SYNC and collect PDOs
+//	srtn,<node>                                  NMT start remote node
+//	stop,<node>                                  NMT stop remote node
+//	preo,<node>                                  NMT enter pre-operational
+//	rstn,<node>                                  NMT reset node
+//	rstc,<node>                                  NMT reset communication
+//	quit                                         close the connection
+//
+// Replies and messages sent by the c-wrapper:
+//
+//	rsdo,<node>,<index>,<subindex>,<abort>,<data>
+//	wsdo,<node>,<index>,<subindex>,<abort>
+//	info,<node>,<device-type>,<vendor>,<product>,<revision>,<serial>
+//	pdo,<cob-id>,<data>                  one per PDO, data as hex bytes
+//	sync,<n>                             after the n PDOs triggered by a sync
+//	srtn|stop|preo|rstn|rstc,<node>,<error>
+//	boot,<node>                          unsolicited, node boot-up
+//	emcy,<node>,<code>,<register>,<data> unsolicited, emergency
+//	erro,<code>,<message>
+//
+// NMT commands addressed to node 0 apply to all the nodes.
+//
+// SDO replies repeat the object of the command they answer, so late replies
+// to commands which timed out can be told apart. Error codes are 32-bit
+// two's complement numbers. Error messages are sent as Go quoted strings, so
+// they cannot break the framing of messages; unquoted messages are accepted.
+package cwrapper
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Port is the default TCP port of the c-wrapper protocol.
+const Port = 50000
+
+// Broadcast is the node ID addressing all the nodes in NMT commands.
+const Broadcast = 0
+
+// Message is a message of the c-wrapper protocol.
+type Message interface {
+	// Name returns the name of the message, as sent on the wire.
+	Name() string
+
+	fields() []string
+}
+
+// Encode returns the wire representation of msg, without the trailing
+// newline.
+func Encode(msg Message) string {
+	fields := msg.fields()
+	if len(fields) == 0 {
+		return msg.Name()
+	}
+	return msg.Name() + "," + strings.Join(fields, ",")
+}
+
+// NMTCommand is a network management command.
+type NMTCommand string
+
+const (
+	NMTStart          NMTCommand = "srtn"
+	NMTStop           NMTCommand = "stop"
+	NMTPreOperational NMTCommand = "preo"
+	NMTResetNode      NMTCommand = "rstn"
+	NMTResetComm      NMTCommand = "rstc"
+)
+
+// Error codes of Error messages.
+const (
+	ErrUnknownCommand = 1
+	ErrMalformed      = 2
+	ErrUnknownNode    = 3
+)
+
+// ReadSDO is the command reading the object at Index:SubIndex of a node.
+type ReadSDO struct {
+	Node     uint8
+	Index    uint16
+	SubIndex uint8
+}
+
+// WriteSDO is the command writing Data to the object at Index:SubIndex of a
+// node. Size is the size of the object in bytes.
+type WriteSDO struct {
+	Node     uint8
+	Index    uint16
+	SubIndex uint8
+	Size     uint8
+	Data     uint32
+}
+
+// Info is the command retrieving the identity of a node.
+type Info struct {
+	Node uint8
+}
+
+// Sync is the command sending a SYNC on the bus and collecting the PDOs.
+type Sync struct{}
+
+// NMT is a network management command.
+type NMT struct {
+	Cmd  NMTCommand
+	Node uint8
+}
+
+// Quit is the command closing the connection.
+type Quit struct{}
+
+// ReadSDOReply is the reply to a ReadSDO command.
+// Abort is the SDO abort code of the transfer (0 on success.)
+type ReadSDOReply struct {
+	Node     uint8
+	Index    uint16
+	SubIndex uint8
+	Abort    uint32
+	Data     uint32
+}
+
+// WriteSDOReply is the reply to a WriteSDO command.
+// Abort is the SDO abort code of the transfer (0 on success.)
+type WriteSDOReply struct {
+	Node     uint8
+	Index    uint16
+	SubIndex uint8
+	Abort    uint32
+}
+
+// InfoReply is the reply to an Info command.
+type InfoReply struct {
+	Node       uint8
+	DeviceType uint32
+	Vendor     uint32
+	Product    uint32
+	Revision   uint32
+	Serial     uint32
+}
+
+// PDO is a process data object collected during a Sync command.
+type PDO struct {
+	COBID uint16
+	Data  []byte
+}
+
+// Node returns the ID of the node which sent the PDO.
+func (pdo PDO) Node() uint8 {
+	return uint8(pdo.COBID & 0x7f)
+}
+
+// SyncReply ends the reply to a Sync command, after N PDO messages.
+type SyncReply struct {
+	N int
+}
+
+// NMTReply is the reply to a NMT command.
+type NMTReply struct {
+	Cmd  NMTCommand
+	Node uint8
+	Err  uint32
+}
+
+// Boot is the unsolicited message sent when a node boots up.
+type Boot struct {
+	Node uint8
+}
+
+// Emcy is the unsolicited message sent when a node raises an emergency.
+type Emcy struct {
+	Node     uint8
+	Code     uint16
+	Register uint8
+	Data     uint64 // manufacturer specific error field (5 bytes)
+}
+
+// emcyDataMask masks the 5 bytes of the manufacturer specific error field.
+const emcyDataMask = 1<<40 - 1
+
+// Error is sent by the c-wrapper when a command could not be processed.
+// Code is a 32-bit error code.
+type Error struct {
+	Code int
+	Msg  string
+}
+
+func (ReadSDO) Name() string       { return "rsdo" }
+func (WriteSDO) Name() string      { return "wsdo" }
+func (Info) Name() string          { return "info" }
+func (Sync) Name() string          { return "sync" }
+func (msg NMT) Name() string       { return string(msg.Cmd) }
+func (Quit) Name() string          { return "quit" }
+func (ReadSDOReply) Name() string  { return "rsdo" }
+func (WriteSDOReply) Name() string { return "wsdo" }
+func (InfoReply) Name() string     { return "info" }
+func (PDO) Name() string           { return "pdo" }
+func (SyncReply) Name() string     { return "sync" }
+func (msg NMTReply) Name() string  { return string(msg.Cmd) }
+func (Boot) Name() string          { return "boot" }
+func (Emcy) Name() string          { return "emcy" }
+func (Error) Name() string         { return "erro" }
+
+func (msg ReadSDO) fields() []string {
+	return []string{hex8(msg.Node), hex16(msg.Index), hex8(msg.SubIndex)}
+}
+
+func (msg WriteSDO) fields() []string {
+	return []string{
+		hex8(msg.Node), hex16(msg.Index), hex8(msg.SubIndex),
+		hex8(msg.Size), hex32(msg.Data),
+	}
+}
+
+func (msg Info) fields() []string { return []string{hex8(msg.Node)} }
+func (Sync) fields() []string     { return nil }
+func (msg NMT) fields() []string  { return []string{hex8(msg.Node)} }
+func (Quit) fields() []string     { return nil }
+
+func (msg ReadSDOReply) fields() []string {
+	return []string{
+		hex8(msg.Node), hex16(msg.Index), hex8(msg.SubIndex),
+		hex32(msg.Abort), hex32(msg.Data),
+	}
+}
+
+func (msg WriteSDOReply) fields() []string {
+	return []string{
+		hex8(msg.Node), hex16(msg.Index), hex8(msg.SubIndex),
+		hex32(msg.Abort),
+	}
+}
+
+func (msg InfoReply) fields() []string {
+	return []string{
+		hex8(msg.Node),
+		hex32(msg.DeviceType), hex32(msg.Vendor), hex32(msg.Product),
+		hex32(msg.Revision), hex32(msg.Serial),
+	}
+}
+
+func (msg PDO) fields() []string {
+	return []string{hex16(msg.COBID), hex.EncodeToString(msg.Data)}
+}
+
+func (msg SyncReply) fields() []string {
+	return []string{strconv.FormatUint(uint64(msg.N), 16)}
+}
+
+func (msg NMTReply) fields() []string {
+	return []string{hex8(msg.Node), hex32(msg.Err)}
+}
+
+func (msg Boot) fields() []string { return []string{hex8(msg.Node)} }
+
+func (msg Emcy) fields() []string {
+	return []string{
+		hex8(msg.Node), hex16(msg.Code), hex8(msg.Register),
+		strconv.FormatUint(msg.Data&emcyDataMask, 16),
+	}
+}
+
+func (msg Error) fields() []string {
+	return []string{hex32(uint32(msg.Code)), strconv.Quote(msg.Msg)}
+}
+
+func (msg Error) Error() string {
+	return fmt.Sprintf("cwrapper: error 0x%x: %s", msg.Code, msg.Msg)
+}
+
+func hex8(v uint8) string   { return strconv.FormatUint(uint64(v), 16) }
+func hex16(v uint16) string { return strconv.FormatUint(uint64(v), 16) }
+func hex32(v uint32) string { return strconv.FormatUint(uint64(v), 16) }
+
+// SyntaxError describes a malformed message.
+type SyntaxError struct {
+	Line string
+	Code int // error code to report to the peer
+	Msg  string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("cwrapper: %s (line=%q)", e.Msg, e.Line)
+}
+
+// DecodeCommand decodes a command sent by the FCS subsystem.
+func DecodeCommand(line string) (Message, error) {
+	d := newDecoder(line)
+	var msg Message
+	switch d.name {
+	case "rsdo":
+		d.nargs(3)
+		msg = ReadSDO{Node: d.node(), Index: d.u16(), SubIndex: d.u8()}
+	case "wsdo":
+		d.nargs(5)
+		msg = WriteSDO{Node: d.node(), Index: d.u16(), SubIndex: d.u8(), Size: d.u8(), Data: d.u32()}
+	case "info":
+		d.nargs(1)
+		msg = Info{Node: d.node()}
+	case "sync":
+		d.nargs(0)
+		msg = Sync{}
+	case "srtn", "stop", "preo", "rstn", "rstc":
+		d.nargs(1)
+		msg = NMT{Cmd: NMTCommand(d.name), Node: d.node()}
+	case "quit":
+		d.nargs(0)
+		msg = Quit{}
+	default:
+		d.fail(ErrUnknownCommand, "unknown command %q", d.name)
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	return msg, nil
+}
+
+// DecodeReply decodes a reply or an unsolicited message sent by the
+// c-wrapper.
+func DecodeReply(line string) (Message, error) {
+	d := newDecoder(line)
+	var msg Message
+	switch d.name {
+	case "rsdo":
+		d.nargs(5)
+		msg = ReadSDOReply{Node: d.node(), Index: d.u16(), SubIndex: d.u8(), Abort: d.u32(), Data: d.u32()}
+	case "wsdo":
+		d.nargs(4)
+		msg = WriteSDOReply{Node: d.node(), Index: d.u16(), SubIndex: d.u8(), Abort: d.u32()}
+	case "info":
+		d.nargs(6)
+		msg = InfoReply{
+			Node:       d.node(),
+			DeviceType: d.u32(),
+			Vendor:     d.u32(),
+			Product:    d.u32(),
+			Revision:   d.u32(),
+			Serial:     d.u32(),
+		}
+	case "pdo":
+		d.nargs(2)
+		msg = PDO{COBID: d.cobid(), Data: d.bytes()}
+	case "sync":
+		d.nargs(1)
+		msg = SyncReply{N: int(d.u32())}
+	case "srtn", "stop", "preo", "rstn", "rstc":
+		d.nargs(2)
+		msg = NMTReply{Cmd: NMTCommand(d.name), Node: d.node(), Err: d.u32()}
+	case "boot":
+		d.nargs(1)
+		msg = Boot{Node: d.node()}
+	case "emcy":
+		d.nargs(4)
+		msg = Emcy{Node: d.node(), Code: d.u16(), Register: d.u8(), Data: d.num(40)}
+	case "erro":
+		d.toks = strings.SplitN(d.line, ",", 3)[1:]
+		d.nargs(2)
+		msg = Error{Code: int(int32(d.u32())), Msg: d.quoted()}
+	default:
+		d.fail(ErrUnknownCommand, "unknown message %q", d.name)
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	return msg, nil
+}
+
+// decoder decodes the fields of a message.
+// The first error encountered is recorded and stops the decoding.
+type decoder struct {
+	line string
+	name string
+	toks []string
+	err  error
+}
+
+func newDecoder(line string) *decoder {
+	line = strings.TrimRight(line, "\r\n")
+	toks := strings.Split(line, ",")
+	return &decoder{line: line, name: toks[0], toks: toks[1:]}
+}
+
+func (d *decoder) fail(code int, format string, args ...interface{}) {
+	if d.err != nil {
+		return
+	}
+	d.err = &SyntaxError{Line: d.line, Code: code, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (d *decoder) nargs(n int) {
+	if len(d.toks) != n {
+		d.fail(ErrMalformed,
+			"invalid number of fields for %q. got %d. want %d",
+			d.name, len(d.toks), n,
+		)
+	}
+}
+
+func (d *decoder) next() string {
+	if d.err != nil || len(d.toks) == 0 {
+		return ""
+	}
+	tok := d.toks[0]
+	d.toks = d.toks[1:]
+	return tok
+}
+
+func (d *decoder) num(bits int) uint64 {
+	if d.err != nil {
+		return 0
+	}
+	tok := d.next()
+	v, err := strconv.ParseUint(tok, 16, bits)
+	if err != nil {
+		d.fail(ErrMalformed, "invalid %d-bit field %q", bits, tok)
+		return 0
+	}
+	return v
+}
+
+func (d *decoder) u8() uint8   { return uint8(d.num(8)) }
+func (d *decoder) u16() uint16 { return uint16(d.num(16)) }
+func (d *decoder) u32() uint32 { return uint32(d.num(32)) }
+
+func (d *decoder) node() uint8 {
+	id := d.u8()
+	if id > 127 {
+		d.fail(ErrUnknownNode, "invalid node ID 0x%x", id)
+	}
+	return id
+}
+
+func (d *decoder) cobid() uint16 {
+	id := d.u16()
+	if id > 0x7ff {
+		d.fail(ErrMalformed, "invalid COB-ID 0x%x", id)
+	}
+	return id
+}
+
+func (d *decoder) bytes() []byte {
+	if d.err != nil {
+		return nil
+	}
+	tok := d.next()
+	buf, err := hex.DecodeString(tok)
+	if err != nil || len(buf) > 8 {
+		d.fail(ErrMalformed, "invalid PDO data %q", tok)
+		return nil
+	}
+	return buf
+}
+
+// quoted returns the next field, unquoted if it is a Go quoted string.
+func (d *decoder) quoted() string {
+	tok := d.next()
+	if s, err := strconv.Unquote(tok); err == nil && strings.HasPrefix(tok, `"`) {
+		return s
+	}
+	return tok
+}
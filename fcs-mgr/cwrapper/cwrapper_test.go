@@ -0,0 +1,171 @@
+package cwrapper
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var commands = []Message{
+	ReadSDO{Node: 0x41, Index: 0x6000, SubIndex: 1},
+	WriteSDO{Node: 0x7f, Index: 0x6040, SubIndex: 0, Size: 2, Data: 0xffffffff},
+	Info{Node: 1},
+	Sync{},
+	NMT{Cmd: NMTStart, Node: Broadcast},
+	NMT{Cmd: NMTStop, Node: 2},
+	NMT{Cmd: NMTPreOperational, Node: 3},
+	NMT{Cmd: NMTResetNode, Node: 4},
+	NMT{Cmd: NMTResetComm, Node: 5},
+	Quit{},
+}
+
+var replies = []Message{
+	ReadSDOReply{Node: 0x41, Index: 0x6000, SubIndex: 1, Abort: 0, Data: 0x1234},
+	ReadSDOReply{Node: 0x41, Index: 0x6000, SubIndex: 1, Abort: 0x06020000},
+	WriteSDOReply{Node: 0x7f, Index: 0x6040, SubIndex: 0, Abort: 0x05040000},
+	InfoReply{Node: 1, DeviceType: 0x20192, Vendor: 0x9a, Product: 0x3, Revision: 0x10001, Serial: 0xdeadbeef},
+	PDO{COBID: 0x181, Data: []byte{0x01, 0x02, 0xff}},
+	PDO{COBID: 0x7ff, Data: []byte{}},
+	SyncReply{N: 2},
+	NMTReply{Cmd: NMTResetNode, Node: 4, Err: 1},
+	Boot{Node: 0x7f},
+	Emcy{Node: 3, Code: 0x8110, Register: 0x11, Data: 0xffffffffff},
+	Error{Code: ErrUnknownNode, Msg: "unknown node 42"},
+	Error{Code: -1, Msg: "negative code"},
+	Error{Code: -0x80000000, Msg: "min code"},
+	Error{Code: 0x7fffffff, Msg: "max code"},
+	Error{Code: 2, Msg: "multi\nline,message\r\nwith \"quotes\""},
+	Error{Code: 2, Msg: ""},
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, want := range commands {
+		line := Encode(want)
+		got, err := DecodeCommand(line)
+		if err != nil {
+			t.Fatalf("could not decode %q: %v", line, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("round-trip failed:\ngot= %#v\nwant=%#v", got, want)
+		}
+	}
+
+	for _, want := range replies {
+		line := Encode(want)
+		if strings.ContainsAny(line, "\r\n") {
+			t.Fatalf("encoding of %#v breaks framing: %q", want, line)
+		}
+		got, err := DecodeReply(line)
+		if err != nil {
+			t.Fatalf("could not decode %q: %v", line, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("round-trip failed:\ngot= %#v\nwant=%#v", got, want)
+		}
+	}
+}
+
+func TestEncodeEmcy(t *testing.T) {
+	line := Encode(Emcy{Node: 1, Code: 0x1000, Data: 1<<48 | 0x12345})
+	got, err := DecodeReply(line)
+	if err != nil {
+		t.Fatalf("could not decode %q: %v", line, err)
+	}
+	if got, want := got.(Emcy).Data, uint64(0x12345); got != want {
+		t.Fatalf("invalid emcy data: got=0x%x, want=0x%x", got, want)
+	}
+}
+
+func TestDecodeUnquotedError(t *testing.T) {
+	got, err := DecodeReply("erro,3,unknown node, really\r\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Error{Code: 3, Msg: "unknown node, really"}
+	if got != want {
+		t.Fatalf("got=%#v, want=%#v", got, want)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	for _, tc := range []struct {
+		line  string
+		reply bool
+		code  int
+	}{
+		{"", false, ErrUnknownCommand},
+		{"nope,1", false, ErrUnknownCommand},
+		{"rsdo,1,6000", false, ErrMalformed},
+		{"rsdo,80,6000,0", false, ErrUnknownNode},
+		{"rsdo,1,10000,0", false, ErrMalformed},
+		{"rsdo,1,0x6000,0", false, ErrMalformed},
+		{"wsdo,1,6000,0,4,100000000", false, ErrMalformed},
+		{"sync,1", false, ErrMalformed},
+		{"rsdo,1,0,0", true, ErrMalformed},
+		{"pdo,800,00", true, ErrMalformed},
+		{"pdo,181,000", true, ErrMalformed},
+		{"pdo,181,000102030405060708", true, ErrMalformed},
+		{"emcy,1,1000,0,10000000000", true, ErrMalformed},
+		{"erro,100000000,msg", true, ErrMalformed},
+		{"erro,1", true, ErrMalformed},
+	} {
+		decode := DecodeCommand
+		if tc.reply {
+			decode = DecodeReply
+		}
+		_, err := decode(tc.line)
+		e, ok := err.(*SyntaxError)
+		if !ok {
+			t.Fatalf("%q: expected a syntax error, got %v", tc.line, err)
+		}
+		if e.Code != tc.code {
+			t.Fatalf("%q: invalid error code: got=%d, want=%d (%v)", tc.line, e.Code, tc.code, e)
+		}
+	}
+}
+
+// fuzzRoundTrip checks that a successfully decoded line is re-encoded into a
+// single line decoding to the same message.
+func fuzzRoundTrip(t *testing.T, line string, decode func(string) (Message, error)) {
+	msg, err := decode(line)
+	if err != nil {
+		if _, ok := err.(*SyntaxError); !ok {
+			t.Fatalf("%q: invalid error type %T: %v", line, err, err)
+		}
+		return
+	}
+	enc := Encode(msg)
+	if strings.ContainsAny(enc, "\r\n") {
+		t.Fatalf("%q: encoding breaks framing: %q", line, enc)
+	}
+	got, err := decode(enc)
+	if err != nil {
+		t.Fatalf("%q: could not decode %q: %v", line, enc, err)
+	}
+	if !reflect.DeepEqual(got, msg) {
+		t.Fatalf("%q: round-trip failed:\ngot= %#v\nwant=%#v", line, got, msg)
+	}
+}
+
+func FuzzDecodeCommand(f *testing.F) {
+	for _, msg := range commands {
+		f.Add(Encode(msg))
+	}
+	f.Add("wsdo,1,6000,0,4")
+	f.Add("rsdo,ff,ffff,ff\r\n")
+	f.Fuzz(func(t *testing.T, line string) {
+		fuzzRoundTrip(t, line, DecodeCommand)
+	})
+}
+
+func FuzzDecodeReply(f *testing.F) {
+	for _, msg := range replies {
+		f.Add(Encode(msg))
+	}
+	f.Add("erro,1,plain message")
+	f.Add(`erro,1,"unterminated`)
+	f.Add("pdo,181,")
+	f.Fuzz(func(t *testing.T, line string) {
+		fuzzRoundTrip(t, line, DecodeReply)
+	})
+}
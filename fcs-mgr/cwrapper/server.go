@@ -0,0 +1,92 @@
+package cwrapper
+
+import (
+	"bufio"
+	"io"
+	"sync"
+)
+
+// Conn is the c-wrapper end of a connection with the FCS subsystem.
+// Conn is safe for concurrent use.
+type Conn struct {
+	mu   sync.Mutex // serializes writes
+	rwc  io.ReadWriteCloser
+	scan *bufio.Scanner
+}
+
+// NewConn returns a Conn communicating with the FCS subsystem over rwc.
+func NewConn(rwc io.ReadWriteCloser) *Conn {
+	return &Conn{rwc: rwc, scan: bufio.NewScanner(rwc)}
+}
+
+// Send sends msg to the FCS subsystem.
+func (c *Conn) Send(msg Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err := io.WriteString(c.rwc, Encode(msg)+"\n")
+	return err
+}
+
+// ReadCommand reads the next command sent by the FCS subsystem.
+// ReadCommand returns a *SyntaxError for malformed commands.
+func (c *Conn) ReadCommand() (Message, error) {
+	for c.scan.Scan() {
+		line := c.scan.Text()
+		if line == "" {
+			continue
+		}
+		return DecodeCommand(line)
+	}
+	err := c.scan.Err()
+	if err == nil {
+		err = io.EOF
+	}
+	return nil, err
+}
+
+// Close closes the connection.
+func (c *Conn) Close() error {
+	return c.rwc.Close()
+}
+
+// Handler answers the commands sent by the FCS subsystem.
+type Handler interface {
+	ServeCommand(c *Conn, cmd Message) error
+}
+
+// HandlerFunc adapts a function into a Handler.
+type HandlerFunc func(c *Conn, cmd Message) error
+
+// ServeCommand calls f(c, cmd).
+func (f HandlerFunc) ServeCommand(c *Conn, cmd Message) error {
+	return f(c, cmd)
+}
+
+// Serve reads commands from c and dispatches them to h, until the connection
+// is closed, a Quit command is received or h returns an error.
+// Malformed commands are answered with an Error message.
+//
+// Serve returns nil when a Quit command was received and io.EOF when the
+// connection was closed by the FCS subsystem.
+func Serve(c *Conn, h Handler) error {
+	for {
+		cmd, err := c.ReadCommand()
+		if err != nil {
+			if e, ok := err.(*SyntaxError); ok {
+				err = c.Send(Error{Code: e.Code, Msg: e.Msg})
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			return err
+		}
+		if _, ok := cmd.(Quit); ok {
+			return nil
+		}
+		err = h.ServeCommand(c, cmd)
+		if err != nil {
+			return err
+		}
+	}
+}
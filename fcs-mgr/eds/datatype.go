@@ -0,0 +1,153 @@
+package eds
+
+import (
+	"fmt"
+	"math"
+)
+
+// DataType is a CANopen data type, as defined by CiA 301.
+type DataType uint16
+
+const (
+	Boolean        DataType = 0x0001
+	Integer8       DataType = 0x0002
+	Integer16      DataType = 0x0003
+	Integer32      DataType = 0x0004
+	Unsigned8      DataType = 0x0005
+	Unsigned16     DataType = 0x0006
+	Unsigned32     DataType = 0x0007
+	Real32         DataType = 0x0008
+	VisibleString  DataType = 0x0009
+	OctetString    DataType = 0x000a
+	UnicodeString  DataType = 0x000b
+	TimeOfDay      DataType = 0x000c
+	TimeDifference DataType = 0x000d
+	Domain         DataType = 0x000f
+	Integer24      DataType = 0x0010
+	Real64         DataType = 0x0011
+	Integer40      DataType = 0x0012
+	Integer48      DataType = 0x0013
+	Integer56      DataType = 0x0014
+	Integer64      DataType = 0x0015
+	Unsigned24     DataType = 0x0016
+	Unsigned40     DataType = 0x0018
+	Unsigned48     DataType = 0x0019
+	Unsigned56     DataType = 0x001a
+	Unsigned64     DataType = 0x001b
+)
+
+var dataTypes = map[DataType]struct {
+	name string
+	size int // in bytes, 0 for variable length types
+}{
+	Boolean:        {"BOOLEAN", 1},
+	Integer8:       {"INTEGER8", 1},
+	Integer16:      {"INTEGER16", 2},
+	Integer32:      {"INTEGER32", 4},
+	Unsigned8:      {"UNSIGNED8", 1},
+	Unsigned16:     {"UNSIGNED16", 2},
+	Unsigned32:     {"UNSIGNED32", 4},
+	Real32:         {"REAL32", 4},
+	VisibleString:  {"VISIBLE_STRING", 0},
+	OctetString:    {"OCTET_STRING", 0},
+	UnicodeString:  {"UNICODE_STRING", 0},
+	TimeOfDay:      {"TIME_OF_DAY", 6},
+	TimeDifference: {"TIME_DIFFERENCE", 6},
+	Domain:         {"DOMAIN", 0},
+	Integer24:      {"INTEGER24", 3},
+	Real64:         {"REAL64", 8},
+	Integer40:      {"INTEGER40", 5},
+	Integer48:      {"INTEGER48", 6},
+	Integer56:      {"INTEGER56", 7},
+	Integer64:      {"INTEGER64", 8},
+	Unsigned24:     {"UNSIGNED24", 3},
+	Unsigned40:     {"UNSIGNED40", 5},
+	Unsigned48:     {"UNSIGNED48", 6},
+	Unsigned56:     {"UNSIGNED56", 7},
+	Unsigned64:     {"UNSIGNED64", 8},
+}
+
+func (dt DataType) String() string {
+	if t, ok := dataTypes[dt]; ok {
+		return t.name
+	}
+	return fmt.Sprintf("DataType(0x%04x)", uint16(dt))
+}
+
+// Size returns the size in bytes of a value of type dt, or 0 for variable
+// length types.
+func (dt DataType) Size() int {
+	return dataTypes[dt].size
+}
+
+// Signed returns whether dt is a signed integer type.
+func (dt DataType) Signed() bool {
+	switch dt {
+	case Integer8, Integer16, Integer24, Integer32, Integer40, Integer48, Integer56, Integer64:
+		return true
+	}
+	return false
+}
+
+// Numeric returns whether dt is a boolean, integer or floating point type.
+func (dt DataType) Numeric() bool {
+	switch dt {
+	case Boolean, Real32, Real64:
+		return true
+	}
+	return dt.Signed() || dt.unsigned()
+}
+
+func (dt DataType) unsigned() bool {
+	switch dt {
+	case Unsigned8, Unsigned16, Unsigned24, Unsigned32, Unsigned40, Unsigned48, Unsigned56, Unsigned64:
+		return true
+	}
+	return false
+}
+
+// Decode decodes the raw little-endian value of an object of type dt, as
+// transferred by a SDO or a PDO.
+//
+// Decode returns a bool, an int64, a uint64 or a float64 depending on dt.
+func (dt DataType) Decode(raw uint64) (interface{}, error) {
+	switch {
+	case dt == Boolean:
+		return raw&1 != 0, nil
+	case dt == Real32:
+		return float64(math.Float32frombits(uint32(raw))), nil
+	case dt == Real64:
+		return math.Float64frombits(raw), nil
+	case dt.Signed():
+		shift := uint(64 - 8*dt.Size())
+		return int64(raw<<shift) >> shift, nil
+	case dt.unsigned():
+		if n := dt.Size(); n < 8 {
+			raw &= 1<<uint(8*n) - 1
+		}
+		return raw, nil
+	}
+	return nil, fmt.Errorf("eds: can not decode values of type %v", dt)
+}
+
+// Float decodes the raw value of an object of numeric type dt as a float64.
+func (dt DataType) Float(raw uint64) (float64, error) {
+	v, err := dt.Decode(raw)
+	if err != nil {
+		return 0, err
+	}
+	switch v := v.(type) {
+	case bool:
+		if v {
+			return 1, nil
+		}
+		return 0, nil
+	case int64:
+		return float64(v), nil
+	case uint64:
+		return float64(v), nil
+	case float64:
+		return v, nil
+	}
+	panic("unreachable")
+}
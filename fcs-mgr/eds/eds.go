@@ -0,0 +1,342 @@
+// Package eds loads CANopen electronic data sheets (EDS) and device
+// configuration files (DCF), as defined by CiA 306.
+//
+// The objects of a Dictionary can be addressed by index and sub-index or by
+// name. Names are the ParameterName of the objects, prefixed with the name of
+// their parent for the sub-objects of arrays and records, and compared after
+// normalization: case is ignored, and runs of spaces, '_' and '-' are
+// equivalent. For example, the first channel of a CiA 401 analog input
+// module can be addressed as:
+//
+//	0x6401:1
+//	6401sub1
+//	Read Analogue Input 16-Bit.Analogue Input 1
+//	read_analogue_input_16_bit.analogue_input_1
+//
+// Scaling of the values into physical units is not part of CiA 306: it is read
+// from the Factor, Offset and Unit keys of the object sections, when present.
+// Sub-objects inherit the scaling of their parent, except the sub-object 0
+// holding their number.
+package eds
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ObjectType is the type of an entry of the object dictionary.
+type ObjectType uint8
+
+const (
+	Null      ObjectType = 0x0
+	DomainObj ObjectType = 0x2
+	DefType   ObjectType = 0x5
+	DefStruct ObjectType = 0x6
+	Var       ObjectType = 0x7
+	Array     ObjectType = 0x8
+	Record    ObjectType = 0x9
+)
+
+func (ot ObjectType) String() string {
+	switch ot {
+	case Null:
+		return "NULL"
+	case DomainObj:
+		return "DOMAIN"
+	case DefType:
+		return "DEFTYPE"
+	case DefStruct:
+		return "DEFSTRUCT"
+	case Var:
+		return "VAR"
+	case Array:
+		return "ARRAY"
+	case Record:
+		return "RECORD"
+	}
+	return fmt.Sprintf("ObjectType(0x%x)", uint8(ot))
+}
+
+// Access is the access type of an object.
+type Access uint8
+
+const (
+	ReadWrite Access = iota
+	ReadOnly
+	WriteOnly
+	Const
+)
+
+var accessNames = map[string]Access{
+	"rw":    ReadWrite,
+	"rwr":   ReadWrite,
+	"rww":   ReadWrite,
+	"ro":    ReadOnly,
+	"wo":    WriteOnly,
+	"const": Const,
+}
+
+func (a Access) String() string {
+	switch a {
+	case ReadWrite:
+		return "rw"
+	case ReadOnly:
+		return "ro"
+	case WriteOnly:
+		return "wo"
+	case Const:
+		return "const"
+	}
+	return fmt.Sprintf("Access(%d)", uint8(a))
+}
+
+// Readable returns whether objects with access type a can be read.
+func (a Access) Readable() bool { return a != WriteOnly }
+
+// Writable returns whether objects with access type a can be written.
+func (a Access) Writable() bool { return a == ReadWrite || a == WriteOnly }
+
+// Scaling converts the values of an object into physical units:
+//
+//	physical = Factor * value + Offset
+type Scaling struct {
+	Factor float64
+	Offset float64
+	Unit   string
+}
+
+// Key identifies an object of the dictionary.
+type Key struct {
+	Index    uint16
+	SubIndex uint8
+}
+
+func (k Key) String() string {
+	return fmt.Sprintf("0x%04x:%x", k.Index, k.SubIndex)
+}
+
+// Object is an entry of the object dictionary.
+type Object struct {
+	Index      uint16
+	SubIndex   uint8
+	Name       string // ParameterName
+	Type       ObjectType
+	DataType   DataType
+	Access     Access
+	PDOMapping bool
+	Default    string // DefaultValue, as written in the file
+	Value      string // ParameterValue of DCF files, as written in the file
+	LowLimit   string
+	HighLimit  string
+	Scaling    Scaling
+
+	Parent *Object   // parent array or record of a sub-object
+	Subs   []*Object // sub-objects of an array or record, sorted by sub-index
+
+	compact int // number of implicit sub-objects of a compact array
+}
+
+// Key returns the index and sub-index of the object.
+func (o *Object) Key() Key {
+	return Key{o.Index, o.SubIndex}
+}
+
+// FullName returns the name of the object, prefixed with the name of its
+// parent for sub-objects.
+func (o *Object) FullName() string {
+	if o.Parent == nil {
+		return o.Name
+	}
+	return o.Parent.Name + "." + o.Name
+}
+
+// Decode decodes the raw value of the object.
+// See DataType.Decode.
+func (o *Object) Decode(raw uint64) (interface{}, error) {
+	return o.DataType.Decode(raw)
+}
+
+// Physical returns the raw value of a numeric object in physical units.
+func (o *Object) Physical(raw uint64) (float64, error) {
+	v, err := o.DataType.Float(raw)
+	if err != nil {
+		return 0, err
+	}
+	factor := o.Scaling.Factor
+	if factor == 0 {
+		factor = 1
+	}
+	return factor*v + o.Scaling.Offset, nil
+}
+
+// InitialValue returns the raw value of the object after the configuration
+// of the node: the ParameterValue of DCF files or the DefaultValue.
+// node is the ID substituted to $NODEID.
+func (o *Object) InitialValue(node uint8) (uint64, error) {
+	s := o.Value
+	if s == "" {
+		s = o.Default
+	}
+	if s == "" {
+		return 0, fmt.Errorf("eds: object %v has no value", o.Key())
+	}
+	return parseValue(s, o.DataType, node)
+}
+
+// DeviceInfo describes the device of an EDS file.
+type DeviceInfo struct {
+	VendorName     string
+	VendorNumber   uint32
+	ProductName    string
+	ProductNumber  uint32
+	RevisionNumber uint32
+	OrderCode      string
+}
+
+// Dictionary is a CANopen object dictionary.
+type Dictionary struct {
+	Device  DeviceInfo
+	NodeID  uint8     // NodeID of DCF files, 0 for EDS files
+	Objects []*Object // top-level objects, sorted by index
+
+	keys  map[Key]*Object
+	names map[string]*Object
+}
+
+// Object returns the object at index:sub.
+// Objects without sub-objects are at sub-index 0.
+func (d *Dictionary) Object(index uint16, sub uint8) (*Object, bool) {
+	o, ok := d.keys[Key{index, sub}]
+	return o, ok
+}
+
+// Lookup returns the object with the given name.
+func (d *Dictionary) Lookup(name string) (*Object, bool) {
+	o, ok := d.names[normalize(name)]
+	return o, ok
+}
+
+// Find returns the object designated by s, either with the "index:sub",
+// "index" or "<index>sub<sub>" notations, or by name.
+// Indices are hexadecimal.
+func (d *Dictionary) Find(s string) (*Object, error) {
+	if key, err := ParseKey(s); err == nil {
+		if o, ok := d.keys[key]; ok {
+			return o, nil
+		}
+		return nil, fmt.Errorf("eds: no object %v", key)
+	}
+	if o, ok := d.Lookup(s); ok {
+		return o, nil
+	}
+	return nil, fmt.Errorf("eds: no object named %q", s)
+}
+
+// Vars returns all the objects holding a value (the variables and the
+// sub-objects of arrays and records), sorted by index and sub-index.
+func (d *Dictionary) Vars() []*Object {
+	var vars []*Object
+	for _, o := range d.Objects {
+		if len(o.Subs) == 0 {
+			vars = append(vars, o)
+			continue
+		}
+		vars = append(vars, o.Subs...)
+	}
+	return vars
+}
+
+// ParseKey parses an object address with the "index:sub", "index" or
+// "<index>sub<sub>" notations. Indices are hexadecimal, with an optional 0x
+// prefix.
+func ParseKey(s string) (Key, error) {
+	var key Key
+	s = strings.ToLower(strings.TrimSpace(s))
+	idx, sub := s, ""
+	switch {
+	case strings.Contains(s, ":"):
+		i := strings.Index(s, ":")
+		idx, sub = s[:i], s[i+1:]
+	case strings.Contains(s, "sub"):
+		i := strings.Index(s, "sub")
+		idx, sub = s[:i], s[i+3:]
+	}
+	v, err := strconv.ParseUint(strings.TrimPrefix(idx, "0x"), 16, 16)
+	if err != nil {
+		return key, fmt.Errorf("eds: invalid object index %q", idx)
+	}
+	key.Index = uint16(v)
+	if sub != "" {
+		v, err = strconv.ParseUint(strings.TrimPrefix(sub, "0x"), 16, 8)
+		if err != nil {
+			return key, fmt.Errorf("eds: invalid object sub-index %q", sub)
+		}
+		key.SubIndex = uint8(v)
+	}
+	return key, nil
+}
+
+// Open loads the EDS or DCF file fname.
+func Open(fname string) (*Dictionary, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	d, err := Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("%v (file %s)", err, fname)
+	}
+	return d, nil
+}
+
+// normalize returns the normalized form of an object name.
+func normalize(name string) string {
+	return strings.Join(strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return r == ' ' || r == '_' || r == '-' || r == '\t'
+	}), "_")
+}
+
+// index builds the lookup tables of the dictionary.
+func (d *Dictionary) index() {
+	sort.Sort(byKey(d.Objects))
+	d.keys = make(map[Key]*Object)
+	d.names = make(map[string]*Object)
+	add := func(o *Object) {
+		d.keys[o.Key()] = o
+		name := normalize(o.FullName())
+		if _, dup := d.names[name]; !dup {
+			d.names[name] = o
+		}
+	}
+	for _, o := range d.Objects {
+		sort.Sort(byKey(o.Subs))
+		if len(o.Subs) == 0 {
+			add(o)
+			continue
+		}
+		for _, sub := range o.Subs {
+			add(sub)
+		}
+		// an array or record can be addressed by name, not by key.
+		name := normalize(o.Name)
+		if _, dup := d.names[name]; !dup {
+			d.names[name] = o
+		}
+	}
+}
+
+type byKey []*Object
+
+func (p byKey) Len() int      { return len(p) }
+func (p byKey) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p byKey) Less(i, j int) bool {
+	if p[i].Index != p[j].Index {
+		return p[i].Index < p[j].Index
+	}
+	return p[i].SubIndex < p[j].SubIndex
+}
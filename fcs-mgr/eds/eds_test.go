@@ -0,0 +1,333 @@
+package eds
+
+import (
+	"math"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func openTest(t *testing.T, name string) *Dictionary {
+	t.Helper()
+	d, err := Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func find(t *testing.T, d *Dictionary, s string) *Object {
+	t.Helper()
+	o, err := d.Find(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return o
+}
+
+func TestParseEDS(t *testing.T) {
+	d := openTest(t, "ai401.eds")
+
+	want := DeviceInfo{
+		VendorName:     "ACME",
+		VendorNumber:   0x123,
+		ProductName:    "AI-8",
+		ProductNumber:  401,
+		RevisionNumber: 0x10002,
+		OrderCode:      "AI-8-16",
+	}
+	if d.Device != want {
+		t.Fatalf("invalid device info:\ngot= %+v\nwant=%+v", d.Device, want)
+	}
+	if d.NodeID != 0 {
+		t.Fatalf("invalid node id: %d", d.NodeID)
+	}
+
+	var idx []uint16
+	for _, o := range d.Objects {
+		idx = append(idx, o.Index)
+	}
+	if got, want := idx, []uint16{0x1000, 0x1200, 0x2000, 0x6401, 0x6411}; len(got) != len(want) {
+		t.Fatalf("invalid objects: got=%x, want=%x", got, want)
+	} else {
+		for i := range got {
+			if got[i] != want[i] {
+				t.Fatalf("invalid objects: got=%x, want=%x", got, want)
+			}
+		}
+	}
+	if n := len(d.Vars()); n != 12 {
+		t.Fatalf("invalid number of variables: %d", n)
+	}
+
+	o := find(t, d, "0x1000")
+	if o.Type != Var || o.DataType != Unsigned32 || o.Access != ReadOnly || o.PDOMapping || len(o.Subs) != 0 {
+		t.Fatalf("invalid object: %+v", o)
+	}
+
+	// sub-objects.
+	rec := find(t, d, "Server SDO Parameter")
+	if rec.Type != Record || len(rec.Subs) != 3 {
+		t.Fatalf("invalid record: %+v", rec)
+	}
+	for i, sub := range rec.Subs {
+		if sub.Parent != rec || int(sub.SubIndex) != i {
+			t.Fatalf("invalid sub-object #%d: %+v", i, sub)
+		}
+	}
+	if sub := rec.Subs[0]; sub.Access != Const || sub.DataType != Unsigned8 {
+		t.Fatalf("invalid sub-object 0: %+v", sub)
+	}
+
+	in := find(t, d, "6401sub1")
+	if in.DataType != Integer16 || !in.PDOMapping || in.FullName() != "Read Analogue Input 16-Bit.Analogue Input 1" {
+		t.Fatalf("invalid sub-object: %+v", in)
+	}
+	_, err := in.InitialValue(0)
+	if err == nil {
+		t.Fatalf("expected an error for an object without value")
+	}
+	gain, err := find(t, d, "gain").InitialValue(0)
+	if err != nil || math.Float32frombits(uint32(gain)) != 1.5 {
+		t.Fatalf("invalid default value: %x (err=%v)", gain, err)
+	}
+}
+
+func TestParseCompact(t *testing.T) {
+	d := openTest(t, "ai401.eds")
+	arr := find(t, d, "Write Analogue Output 16-Bit")
+	if arr.Type != Array || len(arr.Subs) != 4 {
+		t.Fatalf("invalid compact array: %+v", arr)
+	}
+
+	for _, tc := range []struct {
+		sub   uint8
+		name  string
+		dt    DataType
+		acc   Access
+		value string
+	}{
+		{0, "NrOfObjects", Unsigned8, ReadOnly, ""},
+		{1, "Write Analogue Output 16-Bit1", Integer16, ReadWrite, ""},
+		{2, "Heater", Integer16, ReadWrite, ""},
+		{3, "Write Analogue Output 16-Bit3", Integer16, ReadWrite, "100"},
+	} {
+		o, ok := d.Object(0x6411, tc.sub)
+		if !ok {
+			t.Fatalf("missing sub-object %d", tc.sub)
+		}
+		if o.Name != tc.name || o.DataType != tc.dt || o.Access != tc.acc || o.Value != tc.value || o.Parent != arr {
+			t.Fatalf("invalid sub-object %d: %+v", tc.sub, o)
+		}
+	}
+
+	n, err := arr.Subs[0].InitialValue(0)
+	if err != nil || n != 3 {
+		t.Fatalf("invalid number of sub-objects: %d (err=%v)", n, err)
+	}
+	// signed default values are sign-extended on the size of the type.
+	v, err := arr.Subs[1].InitialValue(0)
+	if err != nil || v != 0xfffb {
+		t.Fatalf("invalid default value: %#x (err=%v)", v, err)
+	}
+	if dec, _ := arr.Subs[1].Decode(v); dec != int64(-5) {
+		t.Fatalf("invalid decoded value: %v", dec)
+	}
+	v, err = arr.Subs[3].InitialValue(0)
+	if err != nil || v != 100 {
+		t.Fatalf("invalid value: %d (err=%v)", v, err)
+	}
+}
+
+func TestNodeID(t *testing.T) {
+	d := openTest(t, "ai401.eds")
+	for _, tc := range []struct {
+		key  string
+		node uint8
+		want uint64
+	}{
+		{"1200sub1", 5, 0x605},
+		{"1200sub2", 5, 0x585},
+		{"1200sub1", 0x7f, 0x67f},
+	} {
+		v, err := find(t, d, tc.key).InitialValue(tc.node)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v != tc.want {
+			t.Fatalf("%s with node %d: got=%#x, want=%#x", tc.key, tc.node, v, tc.want)
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	d := openTest(t, "ai401.eds")
+	for _, tc := range []struct {
+		s     string
+		index uint16
+		sub   uint8
+	}{
+		{"0x6401:1", 0x6401, 1},
+		{"6401:1", 0x6401, 1},
+		{"6401sub1", 0x6401, 1},
+		{"0x6401sub2", 0x6401, 2},
+		{"0x1000", 0x1000, 0},
+		{"Read Analogue Input 16-Bit.Analogue Input 1", 0x6401, 1},
+		{"read_analogue_input_16_bit.analogue_input_1", 0x6401, 1},
+		{"READ ANALOGUE INPUT 16 BIT.analogue-input-2", 0x6401, 2},
+		{"Write Analogue Output 16-Bit.Heater", 0x6411, 2},
+		{"device type", 0x1000, 0},
+	} {
+		o := find(t, d, tc.s)
+		if o.Index != tc.index || o.SubIndex != tc.sub {
+			t.Fatalf("%q: got %v, want 0x%04x:%x", tc.s, o.Key(), tc.index, tc.sub)
+		}
+	}
+
+	// arrays and records are addressed by name.
+	arr := find(t, d, "read analogue input 16 bit")
+	if arr.Index != 0x6401 || len(arr.Subs) != 3 {
+		t.Fatalf("invalid array: %+v", arr)
+	}
+
+	for _, s := range []string{"0x7000", "0x6401:9", "no such object"} {
+		_, err := d.Find(s)
+		if err == nil {
+			t.Fatalf("%q: expected an error", s)
+		}
+	}
+}
+
+func TestScaling(t *testing.T) {
+	d := openTest(t, "ai401.eds")
+	for _, tc := range []struct {
+		key  string
+		want Scaling
+	}{
+		{"6401sub0", Scaling{}},
+		{"6401sub1", Scaling{Factor: 0.01, Offset: -10, Unit: "degC"}}, // inherited
+		{"6401sub2", Scaling{Factor: 0.1, Unit: "%"}},
+		{"6411sub0", Scaling{}},
+		{"6411sub1", Scaling{Factor: 2, Unit: "mV"}},
+		{"6411sub3", Scaling{Factor: 2, Unit: "mV"}},
+		{"1000", Scaling{}},
+	} {
+		if got := find(t, d, tc.key).Scaling; got != tc.want {
+			t.Fatalf("%s: invalid scaling: got=%+v, want=%+v", tc.key, got, tc.want)
+		}
+	}
+
+	for _, tc := range []struct {
+		key  string
+		raw  uint64
+		want float64
+	}{
+		{"6401sub1", 2500, 15},
+		{"6401sub1", 0xfc18, -20}, // -1000
+		{"6401sub2", 455, 45.5},
+		{"6401sub0", 2, 2},
+	} {
+		v, err := find(t, d, tc.key).Physical(tc.raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if math.Abs(v-tc.want) > 1e-9 {
+			t.Fatalf("%s: physical value of %#x: got=%v, want=%v", tc.key, tc.raw, v, tc.want)
+		}
+	}
+}
+
+func TestParseDCF(t *testing.T) {
+	d := openTest(t, "ai401.dcf")
+	if d.NodeID != 0x12 {
+		t.Fatalf("invalid node id: %#x", d.NodeID)
+	}
+
+	// ParameterValue overrides DefaultValue.
+	gain := find(t, d, "2000")
+	if gain.Default != "1.5" || gain.Value != "2.25" {
+		t.Fatalf("invalid object: %+v", gain)
+	}
+	v, err := gain.InitialValue(d.NodeID)
+	if err != nil || math.Float32frombits(uint32(v)) != 2.25 {
+		t.Fatalf("invalid value: %#x (err=%v)", v, err)
+	}
+	v, err = find(t, d, "6401sub1").InitialValue(d.NodeID)
+	if err != nil || v != 0xfffe {
+		t.Fatalf("invalid value: %#x (err=%v)", v, err)
+	}
+	v, err = find(t, d, "1200sub1").InitialValue(d.NodeID)
+	if err != nil || v != 0x612 {
+		t.Fatalf("invalid value: %#x (err=%v)", v, err)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	const obj = "[1000]\nParameterName=Device Type\nDataType=0x0007\nAccessType=ro\n"
+	for _, tc := range []struct {
+		name string
+		file string
+		line int
+		msg  string
+	}{
+		{"key outside section", "a=b\n", 1, "outside of any section"},
+		{"invalid header", obj + "[1001\n", 5, "invalid section header"},
+		{"invalid line", obj + "oops\n", 5, "invalid line"},
+		{"missing name", "[1000]\nDataType=0x0007\n", 1, "missing ParameterName"},
+		{"missing data type", "[1000]\nParameterName=X\nAccessType=ro\n", 1, "missing DataType"},
+		{"invalid access", "[1000]\nParameterName=X\nDataType=7\nAccessType=rx\n", 4, "invalid AccessType"},
+		{"invalid number", "[DeviceInfo]\nVendorNumber=0xzz\n", 2, "invalid vendornumber"},
+		{"duplicate", obj + obj, 5, "duplicate object"},
+		{"orphan sub-object", obj + "[1001sub1]\nParameterName=X\nDataType=7\nAccessType=ro\n", 5, "missing object 0x1001"},
+	} {
+		_, err := Parse(strings.NewReader(tc.file))
+		e, ok := err.(*Error)
+		if !ok {
+			t.Fatalf("%s: expected an *Error, got %v", tc.name, err)
+		}
+		if e.Line != tc.line || !strings.Contains(e.Msg, tc.msg) {
+			t.Fatalf("%s: got %v, want line %d: %s", tc.name, err, tc.line, tc.msg)
+		}
+	}
+
+	_, err := Parse(strings.NewReader(
+		"[2000]\nParameterName=A\nObjectType=8\nCompactSubObj=2\nDataType=5\nAccessType=ro\n" +
+			"[2000sub1]\nParameterName=B\nDataType=5\nAccessType=ro\n",
+	))
+	if err == nil || !strings.Contains(err.Error(), "both CompactSubObj and sub-objects") {
+		t.Fatalf("expected an error, got %v", err)
+	}
+}
+
+func TestParseValue(t *testing.T) {
+	for _, tc := range []struct {
+		s    string
+		dt   DataType
+		node uint8
+		want uint64
+		err  bool
+	}{
+		{"$NODEID", Unsigned8, 3, 3, false},
+		{"$NODEID + 0x180", Unsigned32, 3, 0x183, false},
+		{"0x200+$NodeID", Unsigned32, 3, 0x203, false},
+		{"$NODEID+zz", Unsigned32, 3, 0, true},
+		{"-1", Integer8, 0, 0xff, false},
+		{"-2", Integer32, 0, 0xfffffffe, false},
+		{"-1", Integer64, 0, math.MaxUint64, false},
+		{"010", Unsigned8, 0, 8, false},
+		{"1", Boolean, 0, 1, false},
+		{"0.5", Real64, 0, math.Float64bits(0.5), false},
+		{"abc", Real32, 0, 0, true},
+		{"text", VisibleString, 0, 0, true},
+	} {
+		v, err := parseValue(tc.s, tc.dt, tc.node)
+		switch {
+		case tc.err && err == nil:
+			t.Fatalf("%q (%v): expected an error", tc.s, tc.dt)
+		case !tc.err && err != nil:
+			t.Fatalf("%q (%v): %v", tc.s, tc.dt, err)
+		case !tc.err && v != tc.want:
+			t.Fatalf("%q (%v): got=%#x, want=%#x", tc.s, tc.dt, v, tc.want)
+		}
+	}
+}
@@ -0,0 +1,414 @@
+package eds
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Error describes an invalid EDS or DCF file.
+type Error struct {
+	Line int
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("eds: line %d: %s", e.Line, e.Msg)
+}
+
+// section is a section of an INI file.
+// Keys are case-insensitive and stored lower-cased.
+type section struct {
+	name string
+	line int
+	keys map[string]entry
+}
+
+type entry struct {
+	value string
+	line  int
+}
+
+func (s *section) get(key string) (string, bool) {
+	e, ok := s.keys[key]
+	return e.value, ok
+}
+
+func (s *section) errorf(key string, format string, args ...interface{}) error {
+	line := s.line
+	if e, ok := s.keys[key]; ok {
+		line = e.line
+	}
+	return &Error{Line: line, Msg: fmt.Sprintf("[%s]: ", s.name) + fmt.Sprintf(format, args...)}
+}
+
+// uint parses the value of key as an unsigned integer of the given bit size.
+func (s *section) uint(key string, bits int, def uint64) (uint64, error) {
+	v, ok := s.get(key)
+	if !ok || v == "" {
+		return def, nil
+	}
+	n, err := parseUint(v, bits)
+	if err != nil {
+		return 0, s.errorf(key, "invalid %s %q", key, v)
+	}
+	return n, nil
+}
+
+// float parses the value of key as a floating point number.
+func (s *section) float(key string) (float64, error) {
+	v, ok := s.get(key)
+	if !ok || v == "" {
+		return 0, nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, s.errorf(key, "invalid %s %q", key, v)
+	}
+	return f, nil
+}
+
+// readINI reads the sections of an INI file.
+func readINI(r io.Reader) ([]*section, error) {
+	var (
+		secs []*section
+		cur  *section
+		line = 0
+	)
+	scan := bufio.NewScanner(r)
+	for scan.Scan() {
+		line++
+		txt := strings.TrimSpace(scan.Text())
+		if line == 1 {
+			txt = strings.TrimPrefix(txt, "\ufeff") // UTF-8 BOM
+		}
+		switch {
+		case txt == "" || strings.HasPrefix(txt, ";"):
+			continue
+		case strings.HasPrefix(txt, "["):
+			if !strings.HasSuffix(txt, "]") {
+				return nil, &Error{Line: line, Msg: fmt.Sprintf("invalid section header %q", txt)}
+			}
+			cur = &section{
+				name: strings.ToLower(strings.TrimSpace(txt[1 : len(txt)-1])),
+				line: line,
+				keys: make(map[string]entry),
+			}
+			secs = append(secs, cur)
+		default:
+			i := strings.Index(txt, "=")
+			if i < 0 {
+				return nil, &Error{Line: line, Msg: fmt.Sprintf("invalid line %q", txt)}
+			}
+			if cur == nil {
+				return nil, &Error{Line: line, Msg: "key outside of any section"}
+			}
+			key := strings.ToLower(strings.TrimSpace(txt[:i]))
+			cur.keys[key] = entry{value: strings.TrimSpace(txt[i+1:]), line: line}
+		}
+	}
+	return secs, scan.Err()
+}
+
+var (
+	reIndex   = regexp.MustCompile(`^([0-9a-f]{4})$`)
+	reSub     = regexp.MustCompile(`^([0-9a-f]{4})sub([0-9a-f]{1,2})$`)
+	reCompact = regexp.MustCompile(`^([0-9a-f]{4})(name|value)$`)
+)
+
+// Parse parses an EDS or DCF file.
+func Parse(r io.Reader) (*Dictionary, error) {
+	secs, err := readINI(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		d       = &Dictionary{}
+		objs    = make(map[uint16]*Object)
+		subs    []*Object
+		subSecs = make(map[*Object]*section)
+		compact = make(map[string]*section)
+	)
+	for _, sec := range secs {
+		switch {
+		case sec.name == "deviceinfo":
+			err = d.Device.parse(sec)
+		case sec.name == "devicecomissioning", sec.name == "devicecommissioning":
+			var id uint64
+			id, err = sec.uint("nodeid", 7, 0)
+			d.NodeID = uint8(id)
+		case reIndex.MatchString(sec.name):
+			var o *Object
+			o, err = newObject(sec)
+			if err != nil {
+				break
+			}
+			if _, dup := objs[o.Index]; dup {
+				return nil, sec.errorf("", "duplicate object 0x%04x", o.Index)
+			}
+			objs[o.Index] = o
+			d.Objects = append(d.Objects, o)
+		case reSub.MatchString(sec.name):
+			var o *Object
+			o, err = newObject(sec)
+			if err != nil {
+				break
+			}
+			subs = append(subs, o)
+			subSecs[o] = sec
+		case reCompact.MatchString(sec.name):
+			compact[sec.name] = sec
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, sub := range subs {
+		parent, ok := objs[sub.Index]
+		if !ok {
+			return nil, subSecs[sub].errorf("", "sub-object of missing object 0x%04x", sub.Index)
+		}
+		sub.Parent = parent
+		parent.Subs = append(parent.Subs, sub)
+	}
+
+	for _, o := range d.Objects {
+		err = o.expand(compact)
+		if err != nil {
+			return nil, err
+		}
+		for _, sub := range o.Subs {
+			// sub-index 0 holds the number of sub-objects.
+			if sub.SubIndex != 0 && sub.Scaling == (Scaling{}) {
+				sub.Scaling = o.Scaling
+			}
+		}
+	}
+
+	d.index()
+	return d, nil
+}
+
+func (dev *DeviceInfo) parse(sec *section) error {
+	dev.VendorName, _ = sec.get("vendorname")
+	dev.ProductName, _ = sec.get("productname")
+	dev.OrderCode, _ = sec.get("ordercode")
+	for _, v := range []struct {
+		key string
+		ptr *uint32
+	}{
+		{"vendornumber", &dev.VendorNumber},
+		{"productnumber", &dev.ProductNumber},
+		{"revisionnumber", &dev.RevisionNumber},
+	} {
+		n, err := sec.uint(v.key, 32, 0)
+		if err != nil {
+			return err
+		}
+		*v.ptr = uint32(n)
+	}
+	return nil
+}
+
+// newObject creates the object described by an object or sub-object section.
+func newObject(sec *section) (*Object, error) {
+	o := &Object{}
+	if m := reSub.FindStringSubmatch(sec.name); m != nil {
+		idx, _ := strconv.ParseUint(m[1], 16, 16)
+		sub, _ := strconv.ParseUint(m[2], 16, 8)
+		o.Index = uint16(idx)
+		o.SubIndex = uint8(sub)
+	} else {
+		idx, _ := strconv.ParseUint(sec.name, 16, 16)
+		o.Index = uint16(idx)
+	}
+
+	var ok bool
+	o.Name, ok = sec.get("parametername")
+	if !ok {
+		return nil, sec.errorf("", "missing ParameterName")
+	}
+
+	ot, err := sec.uint("objecttype", 8, uint64(Var))
+	if err != nil {
+		return nil, err
+	}
+	o.Type = ObjectType(ot)
+
+	switch o.Type {
+	case Array, Record:
+		// the data type and access of arrays and records are the ones of
+		// their sub-objects, except for compact arrays.
+		if _, ok := sec.get("compactsubobj"); !ok {
+			break
+		}
+		fallthrough
+	default:
+		dt, err := sec.uint("datatype", 16, 0)
+		if err != nil {
+			return nil, err
+		}
+		if dt == 0 && o.Type != DomainObj {
+			return nil, sec.errorf("", "missing DataType")
+		}
+		o.DataType = DataType(dt)
+		if o.Type == DomainObj && dt == 0 {
+			o.DataType = Domain
+		}
+
+		access, _ := sec.get("accesstype")
+		a, ok := accessNames[strings.ToLower(access)]
+		if !ok {
+			return nil, sec.errorf("accesstype", "invalid AccessType %q", access)
+		}
+		o.Access = a
+	}
+
+	pdo, err := sec.uint("pdomapping", 1, 0)
+	if err != nil {
+		return nil, err
+	}
+	o.PDOMapping = pdo == 1
+	o.Default, _ = sec.get("defaultvalue")
+	o.Value, _ = sec.get("parametervalue")
+	o.LowLimit, _ = sec.get("lowlimit")
+	o.HighLimit, _ = sec.get("highlimit")
+
+	o.Scaling.Factor, err = sec.float("factor")
+	if err != nil {
+		return nil, err
+	}
+	o.Scaling.Offset, err = sec.float("offset")
+	if err != nil {
+		return nil, err
+	}
+	o.Scaling.Unit, _ = sec.get("unit")
+
+	if o.Type == Array || o.Type == Record {
+		n, err := sec.uint("compactsubobj", 8, 0)
+		if err != nil {
+			return nil, err
+		}
+		if n > 0 {
+			o.compact = int(n)
+		}
+	}
+	return o, nil
+}
+
+// expand creates the sub-objects of a compact array.
+func (o *Object) expand(compact map[string]*section) error {
+	if o.compact == 0 {
+		return nil
+	}
+	if len(o.Subs) > 0 {
+		return fmt.Errorf("eds: object 0x%04x has both CompactSubObj and sub-objects", o.Index)
+	}
+	prefix := fmt.Sprintf("%04x", o.Index)
+	names := compact[prefix+"name"]
+	values := compact[prefix+"value"]
+
+	o.Subs = append(o.Subs, &Object{
+		Index:    o.Index,
+		Name:     "NrOfObjects",
+		Type:     Var,
+		DataType: Unsigned8,
+		Access:   ReadOnly,
+		Default:  strconv.Itoa(o.compact),
+		Parent:   o,
+	})
+	for i := 1; i <= o.compact; i++ {
+		sub := &Object{
+			Index:      o.Index,
+			SubIndex:   uint8(i),
+			Name:       fmt.Sprintf("%s%d", o.Name, i),
+			Type:       Var,
+			DataType:   o.DataType,
+			Access:     o.Access,
+			PDOMapping: o.PDOMapping,
+			Default:    o.Default,
+			LowLimit:   o.LowLimit,
+			HighLimit:  o.HighLimit,
+			Parent:     o,
+		}
+		key := strconv.Itoa(i)
+		if names != nil {
+			if name, ok := names.get(key); ok {
+				sub.Name = name
+			}
+		}
+		if values != nil {
+			if v, ok := values.get(key); ok {
+				sub.Value = v
+			}
+		}
+		o.Subs = append(o.Subs, sub)
+	}
+	return nil
+}
+
+func parseUint(s string, bits int) (uint64, error) {
+	s = strings.ToLower(s)
+	if strings.HasPrefix(s, "0x") {
+		return strconv.ParseUint(s[2:], 16, bits)
+	}
+	return strconv.ParseUint(s, 0, bits)
+}
+
+// parseValue parses the value s of an object of type dt into its raw
+// representation. $NODEID is replaced by node.
+func parseValue(s string, dt DataType, node uint8) (uint64, error) {
+	orig := s
+	s = strings.ToLower(strings.Replace(s, " ", "", -1))
+	if strings.Contains(s, "$nodeid") {
+		// $NODEID+value or value+$NODEID
+		rest := strings.Trim(strings.Replace(s, "$nodeid", "", 1), "+")
+		v := uint64(0)
+		if rest != "" {
+			var err error
+			v, err = parseUint(rest, 64)
+			if err != nil {
+				return 0, fmt.Errorf("eds: invalid value %q", orig)
+			}
+		}
+		return v + uint64(node), nil
+	}
+
+	switch {
+	case dt == Real32:
+		f, err := strconv.ParseFloat(s, 32)
+		if err != nil {
+			return 0, fmt.Errorf("eds: invalid value %q", orig)
+		}
+		return uint64(math.Float32bits(float32(f))), nil
+	case dt == Real64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return 0, fmt.Errorf("eds: invalid value %q", orig)
+		}
+		return math.Float64bits(f), nil
+	case dt.Signed() && strings.HasPrefix(s, "-"):
+		v, err := strconv.ParseInt(s, 0, 64)
+		if err != nil {
+			return 0, fmt.Errorf("eds: invalid value %q", orig)
+		}
+		raw := uint64(v)
+		if n := dt.Size(); n < 8 {
+			raw &= 1<<uint(8*n) - 1
+		}
+		return raw, nil
+	case dt.Numeric():
+		v, err := parseUint(s, 64)
+		if err != nil {
+			return 0, fmt.Errorf("eds: invalid value %q", orig)
+		}
+		return v, nil
+	}
+	return 0, fmt.Errorf("eds: value %q of type %v is not numeric", orig, dt)
+}
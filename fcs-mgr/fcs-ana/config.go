@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/sbinet/lsst-ccs/fcs-mgr/calib"
+)
+
+// Config describes the channels of an ADC log file.
+type Config struct {
+	Period   Duration  `json:"period"` // sampling period, for files without timestamps
+	Channels []Channel `json:"channels"`
+}
+
+// Channel describes an ADC channel.
+//
+// The physical value of the channel is computed from the ADC count with:
+//
+//	value = adc * scale + offset
+//
+// unless the channel refers to a sensor of the calibration file, in which
+// case the calibration valid at the time of each sample is used.
+type Channel struct {
+	Name   string  `json:"name"`   // short name, used to select channels and name output files
+	Key    string  `json:"key"`    // key of the channel in the log file (default: name)
+	Title  string  `json:"title"`  // title of the plots (default: name)
+	Unit   string  `json:"unit"`   // physical unit
+	Scale  float64 `json:"scale"`  // physical units per ADC count
+	Offset float64 `json:"offset"` // physical value at ADC count 0
+	Calib  string  `json:"calib"`  // name or serial number of the sensor in the calibration file
+
+	cals *calib.File
+	cal  *calib.Calibration // last used calibration
+	beg  time.Time          // validity span of the selection of cal
+	end  time.Time
+}
+
+// Convert returns the physical value corresponding to a given ADC count,
+// sampled at time t.
+func (ch *Channel) Convert(adc int16, t time.Time) (float64, error) {
+	if ch.cals == nil {
+		return calib.Linear{Slope: ch.Scale, Intercept: ch.Offset}.Eval(float64(adc)), nil
+	}
+	if t.IsZero() {
+		cal, err := ch.cals.Lookup(ch.Calib, t)
+		if err != nil {
+			return 0, err
+		}
+		return cal.Eval(float64(adc)), nil
+	}
+	// select the calibration again when t crosses a validity boundary: a
+	// calibration with a higher revision may become valid.
+	if ch.cal == nil ||
+		(!ch.beg.IsZero() && t.Before(ch.beg)) ||
+		(!ch.end.IsZero() && !t.Before(ch.end)) {
+		cal, err := ch.cals.Lookup(ch.Calib, t)
+		if err != nil {
+			return 0, err
+		}
+		ch.cal = cal
+		ch.beg, ch.end = ch.cals.Span(ch.Calib, t)
+	}
+	return ch.cal.Eval(float64(adc)), nil
+}
+
+// Label returns the label of the axis of the channel values.
+func (ch Channel) Label() string {
+	if ch.Unit == "" {
+		return ch.Title
+	}
+	return fmt.Sprintf("%s (%s)", ch.Title, ch.Unit)
+}
+
+// Duration is a time.Duration encoded as a string in JSON (e.g. "1m30s").
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return err
+	}
+	d.Duration, err = time.ParseDuration(s)
+	return err
+}
+
+// loadConfig loads the channels configuration from fname.
+func loadConfig(fname string) (Config, error) {
+	var cfg Config
+	f, err := os.Open(fname)
+	if err != nil {
+		return cfg, err
+	}
+	defer f.Close()
+
+	err = json.NewDecoder(f).Decode(&cfg)
+	if err != nil {
+		return cfg, fmt.Errorf("error decoding config file [%s]: %v", fname, err)
+	}
+	return cfg, cfg.init()
+}
+
+// init validates the configuration and fills the default values.
+func (cfg *Config) init() error {
+	if len(cfg.Channels) == 0 {
+		return fmt.Errorf("no channel configured")
+	}
+	names := make(map[string]bool, len(cfg.Channels))
+	for i := range cfg.Channels {
+		ch := &cfg.Channels[i]
+		if ch.Name == "" {
+			return fmt.Errorf("channel #%d has no name", i)
+		}
+		if names[ch.Name] {
+			return fmt.Errorf("duplicate channel %q", ch.Name)
+		}
+		names[ch.Name] = true
+		if ch.Key == "" {
+			ch.Key = ch.Name
+		}
+		if ch.Title == "" {
+			ch.Title = ch.Name
+		}
+		if ch.Scale == 0 {
+			ch.Scale = 1
+		}
+	}
+	if cfg.Period.Duration <= 0 {
+		cfg.Period.Duration = 3 * time.Second
+	}
+	return nil
+}
+
+// setCalibrations attaches the calibrations of cals to the channels which
+// refer to a sensor.
+func (cfg *Config) setCalibrations(cals *calib.File) error {
+	for i := range cfg.Channels {
+		ch := &cfg.Channels[i]
+		if ch.Calib == "" {
+			continue
+		}
+		if cals == nil {
+			return fmt.Errorf("channel %q refers to sensor %q but no calibration file was given", ch.Name, ch.Calib)
+		}
+		cal, err := cals.Lookup(ch.Calib, time.Time{})
+		if err != nil {
+			return fmt.Errorf("channel %q: %v", ch.Name, err)
+		}
+		if ch.Unit == "" {
+			ch.Unit = cal.Unit
+		}
+		ch.cals = cals
+	}
+	return nil
+}
+
+// selectChannels keeps only the channels whose names are listed in names.
+func (cfg *Config) selectChannels(names []string) error {
+	chans := make([]Channel, 0, len(names))
+loop:
+	for _, name := range names {
+		for _, ch := range cfg.Channels {
+			if ch.Name == name {
+				chans = append(chans, ch)
+				continue loop
+			}
+		}
+		return fmt.Errorf("unknown channel %q", name)
+	}
+	cfg.Channels = chans
+	return nil
+}
+
+// defaultConfig returns the configuration of the LPC test bench: an ADC
+// module reading temperature, pressure and hygrometry, snapshot every 3s.
+func defaultConfig() Config {
+	return Config{
+		Period: Duration{3 * time.Second},
+		Channels: []Channel{
+			{
+				// ADC: [0; 0xFFFF) -> -10.24V;10.24V -> -20C; 80C;
+				Name:   "temp",
+				Key:    "temp",
+				Title:  "Temperature",
+				Unit:   "C",
+				Scale:  0.3125e-3 * 10.0,
+				Offset: -20.0,
+			},
+			{
+				// ADC: [0; 0xFFFF) -> -10.24V;10.24V -> 600mbar; 1100mbar;
+				Name:   "press",
+				Key:    "pressure",
+				Title:  "Pressure",
+				Unit:   "mbar",
+				Scale:  0.3125e-3 * 50.0,
+				Offset: 600.0,
+			},
+			{
+				// ADC: [0; 0xFFFF) -> -10.24V;10.24V -> 0%; 100%;
+				Name:   "hygro",
+				Key:    "hygrometry",
+				Title:  "Hygrometry",
+				Unit:   "%",
+				Scale:  0.3125e-3 * 10.0,
+				Offset: 0,
+			},
+		},
+	}
+}
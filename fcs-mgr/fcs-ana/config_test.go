@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sbinet/lsst-ccs/fcs-mgr/calib"
+)
+
+func TestChannelConvert(t *testing.T) {
+	day := func(d int) time.Time {
+		return time.Date(2016, 3, d, 0, 0, 0, 0, time.UTC)
+	}
+	cals := &calib.File{
+		Version: calib.Version,
+		Calibrations: []calib.Calibration{
+			{Sensor: "temp", Revision: 1, Model: calib.Linear{Slope: 1}},
+			{Sensor: "temp", Revision: 2, ValidFrom: day(10), ValidTo: day(20), Model: calib.Linear{Slope: 2}},
+		},
+	}
+	cfg := Config{Channels: []Channel{{Name: "temp", Calib: "temp"}}}
+	err := cfg.init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cfg.setCalibrations(cals)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ch := &cfg.Channels[0]
+	for _, tc := range []struct {
+		t    time.Time
+		want float64
+	}{
+		{day(1), 10},
+		{day(9), 10},
+		{day(10), 20}, // revision 2 becomes valid.
+		{day(19), 20},
+		{day(20), 10}, // revision 2 expired.
+		{day(5), 10},
+		{day(15), 20},
+	} {
+		got, err := ch.Convert(10, tc.t)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tc.want {
+			t.Fatalf("%v: got=%v, want=%v", tc.t, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,567 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sbinet/lsst-ccs/fcs-mgr/msr"
+)
+
+// Event is a snapshot of the ADC channels.
+type Event struct {
+	Time time.Time          // zero if the log file has no timestamps
+	Data map[string]Data    // indexed by channel key
+	Phys map[string]float64 // physical values, for formats recording them directly (MSR)
+
+	// Delay holds the delays of the Phys values w.r.t. Time (MSR).
+	Delay map[string]time.Duration
+
+	seq int // index of the sample in the file, including malformed ones
+}
+
+type Data struct {
+	Acc    uint8 `json:"acc"`
+	Avg    uint8 `json:"avg"`
+	Offset int32 `json:"offset"`
+	Gain   int32 `json:"gain"` // FIXME: doc says int16
+	Raw    int16 `json:"raw"`
+	Value  int16 `json:"value"`
+}
+
+// UnmarshalJSON decodes a JSON representation of Data.
+//
+// The official JSON format does not support hexadecimal literals, so Data is
+// usually encoded as a string of 6 integers (acc, avg, offset, gain, raw and
+// value), in hexadecimal or decimal:
+//
+//	"0x3 0x4 0x5b0000 0xfdae 0x3446 0x3482"
+//
+// Data may also be encoded as an object of JSON numbers, an array of 6 JSON
+// numbers, or a single JSON number (the ADC count, used as raw and corrected
+// value.)
+func (d *Data) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return fmt.Errorf("empty data")
+	}
+	switch data[0] {
+	case '"':
+		var s string
+		err := json.Unmarshal(data, &s)
+		if err != nil {
+			return err
+		}
+		return d.decode(strings.Fields(s))
+	case '{':
+		type raw Data
+		var v raw
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err := dec.Decode(&v)
+		if err != nil {
+			return err
+		}
+		*d = Data(v)
+		return nil
+	case '[':
+		var vs []json.Number
+		err := json.Unmarshal(data, &vs)
+		if err != nil {
+			return err
+		}
+		toks := make([]string, len(vs))
+		for i, v := range vs {
+			toks[i] = v.String()
+		}
+		return d.decode(toks)
+	default:
+		var v json.Number
+		err := json.Unmarshal(data, &v)
+		if err != nil {
+			return err
+		}
+		adc, err := parseInt(v.String(), 16)
+		if err != nil {
+			return err
+		}
+		*d = Data{Raw: int16(adc), Value: int16(adc)}
+		return nil
+	}
+}
+
+// decode decodes the acc, avg, offset, gain, raw and value fields of Data
+// from their textual representation.
+func (d *Data) decode(toks []string) error {
+	if len(toks) != 6 {
+		return fmt.Errorf("invalid number of fields (got=%d, want=6)", len(toks))
+	}
+	var (
+		vs   [6]int64
+		bits = [6]int{8, 8, 32, 32, 16, 16}
+	)
+	for i, tok := range toks {
+		v, err := parseInt(tok, bits[i])
+		if err != nil {
+			return err
+		}
+		vs[i] = v
+	}
+	*d = Data{
+		Acc:    uint8(vs[0]),
+		Avg:    uint8(vs[1]),
+		Offset: int32(vs[2]),
+		Gain:   int32(vs[3]),
+		Raw:    int16(vs[4]),
+		Value:  int16(vs[5]),
+	}
+	return nil
+}
+
+// parseInt parses a decimal or hexadecimal ("0x" prefix) integer of the given
+// size in bits.
+// Registers may be logged as unsigned values: values up to 2^bits-1 are
+// accepted and interpreted as two's complement by the caller.
+func parseInt(s string, bits int) (int64, error) {
+	v, err := strconv.ParseInt(s, 0, 64)
+	if err != nil {
+		f, ferr := strconv.ParseFloat(s, 64)
+		if ferr != nil || f != float64(int64(f)) {
+			return 0, fmt.Errorf("invalid integer %q", s)
+		}
+		v = int64(f)
+	}
+	if v < -(1<<uint(bits-1)) || v >= 1<<uint(bits) {
+		return 0, fmt.Errorf("integer %q overflows %d bits", s, bits)
+	}
+	return v, nil
+}
+
+// Formats of log files.
+const (
+	fmtAuto = "auto" // guessed from the first line
+	fmtJSON = "json" // one JSON object per line (fcs-can, FCS subsystem)
+	fmtCSV  = "csv"  // CSV with a header line
+	fmtMSR  = "msr"  // CSV exported from MSR data loggers
+)
+
+// LineError is an error decoding a line of a log file.
+type LineError struct {
+	Line int    // line number, starting at 1
+	Text string // content of the line
+	Err  error
+}
+
+func (e *LineError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+// lineDecoder decodes the lines of a log file.
+type lineDecoder interface {
+	// decode decodes a line, returning whether it holds an event.
+	decode(line string) (Event, bool, error)
+}
+
+// readEvents reads the events of a log file, decoding the given channels.
+//
+// Malformed lines are skipped and returned as errors, along with the
+// events of the valid lines.
+// In strict mode, reading stops at the first malformed line.
+// Errors which prevent decoding the rest of the file (e.g. an invalid CSV
+// header) are returned as err.
+func readEvents(r io.Reader, format string, chans []Channel, strict bool) ([]Event, []*LineError, error) {
+	var (
+		evts []Event
+		errs []*LineError
+		dec  lineDecoder
+		n    = 0 // line number
+		seq  = 0 // number of samples
+	)
+	scan := bufio.NewScanner(r)
+	scan.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	for scan.Scan() {
+		n++
+		line := strings.TrimSpace(scan.Text())
+		if n == 1 {
+			line = strings.TrimPrefix(line, "\ufeff")
+		}
+		if strings.HasPrefix(line, "#") || len(line) == 0 {
+			continue
+		}
+
+		if dec == nil {
+			format = detectFormat(format, line)
+			if format == fmtMSR {
+				// MSR files are decoded as a whole, from their first section.
+				r := io.MultiReader(strings.NewReader(line+"\n"), &lineReader{scan: scan})
+				return readMSR(r, n-1, chans, strict)
+			}
+			var err error
+			dec, err = newLineDecoder(format, chans)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+
+		evt, ok, err := dec.decode(line)
+		if err != nil {
+			lerr := &LineError{Line: n, Text: line, Err: err}
+			if _, bad := err.(fatalError); bad || strict {
+				return evts, errs, lerr
+			}
+			errs = append(errs, lerr)
+			seq++
+			continue
+		}
+		if ok {
+			evt.seq = seq
+			evts = append(evts, evt)
+			seq++
+		}
+	}
+	return evts, errs, scan.Err()
+}
+
+// fatalError is a decoding error preventing the decoding of the rest of a
+// log file.
+type fatalError struct {
+	error
+}
+
+// detectFormat returns the format of a log file.
+// The first non-comment line is used to guess the format in auto mode.
+func detectFormat(format, line string) string {
+	if format != fmtAuto {
+		return format
+	}
+	switch {
+	case strings.HasPrefix(line, "{"):
+		return fmtJSON
+	case strings.HasPrefix(line, "*"):
+		return fmtMSR
+	default:
+		return fmtCSV
+	}
+}
+
+// newLineDecoder returns the decoder of a log file of the given line-based
+// format.
+func newLineDecoder(format string, chans []Channel) (lineDecoder, error) {
+	switch format {
+	case fmtJSON:
+		return &jsonDecoder{chans: chans}, nil
+	case fmtCSV:
+		return &csvDecoder{chans: chans}, nil
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+// jsonDecoder decodes log files with one JSON object per line:
+//
+//	{"time": "2015-05-26T12:00:00Z", "temp": "0x3 0x4 0x5b0000 0xfdae 0x3446 0x3482", ...}
+type jsonDecoder struct {
+	chans []Channel
+}
+
+func (dec *jsonDecoder) decode(line string) (Event, bool, error) {
+	var (
+		evt  = Event{Data: make(map[string]Data, len(dec.chans))}
+		raws map[string]json.RawMessage
+	)
+	err := json.Unmarshal([]byte(line), &raws)
+	if err != nil {
+		return evt, false, err
+	}
+
+	if raw, ok := raws["time"]; ok {
+		err = json.Unmarshal(raw, &evt.Time)
+		if err != nil {
+			return evt, false, fmt.Errorf("invalid timestamp: %v", err)
+		}
+	}
+
+	for _, ch := range dec.chans {
+		raw, ok := raws[ch.Key]
+		if !ok {
+			return evt, false, fmt.Errorf("missing channel %q", ch.Key)
+		}
+		var data Data
+		err = json.Unmarshal(raw, &data)
+		if err != nil {
+			return evt, false, fmt.Errorf("invalid channel %q: %v", ch.Key, err)
+		}
+		evt.Data[ch.Key] = data
+	}
+	return evt, true, nil
+}
+
+// csvDecoder decodes CSV log files, with ',' or ';' separated fields.
+//
+// The first line is a header naming the columns:
+//   - "time": timestamp of the sample (RFC 3339, optional),
+//   - "<key>": ADC count of the channel <key>, used as raw and corrected value,
+//   - "<key>.<field>": field of the ADC objects of the channel <key>, with
+//     field one of acc, avg, offset, gain, raw or value.
+//
+// ex:
+//
+//	time,temp.raw,temp.value,pressure,hygrometry
+//	2015-05-26T12:00:00Z,0x3446,0x3482,22608,9137
+type csvDecoder struct {
+	chans []Channel
+	comma rune
+	cols  []csvColumn
+	time  int // index of the time column, -1 if none
+}
+
+type csvColumn struct {
+	key   string
+	field string
+}
+
+func (dec *csvDecoder) decode(line string) (Event, bool, error) {
+	if dec.cols == nil {
+		return Event{}, false, dec.header(line)
+	}
+
+	evt := Event{Data: make(map[string]Data, len(dec.chans))}
+	toks, err := dec.split(line)
+	if err != nil {
+		return evt, false, err
+	}
+	if len(toks) != len(dec.cols) {
+		return evt, false, fmt.Errorf("invalid number of fields (got=%d, want=%d)", len(toks), len(dec.cols))
+	}
+
+	if dec.time >= 0 {
+		evt.Time, err = time.Parse(time.RFC3339Nano, toks[dec.time])
+		if err != nil {
+			return evt, false, fmt.Errorf("invalid timestamp: %v", err)
+		}
+	}
+
+	for i, col := range dec.cols {
+		if col.key == "" {
+			continue
+		}
+		tok := toks[i]
+		data := evt.Data[col.key]
+		switch col.field {
+		case "acc", "avg":
+			v, err := parseInt(tok, 8)
+			if err != nil {
+				return evt, false, fmt.Errorf("invalid column %q: %v", col.key+"."+col.field, err)
+			}
+			if col.field == "acc" {
+				data.Acc = uint8(v)
+			} else {
+				data.Avg = uint8(v)
+			}
+		case "offset", "gain":
+			v, err := parseInt(tok, 32)
+			if err != nil {
+				return evt, false, fmt.Errorf("invalid column %q: %v", col.key+"."+col.field, err)
+			}
+			if col.field == "offset" {
+				data.Offset = int32(v)
+			} else {
+				data.Gain = int32(v)
+			}
+		default:
+			v, err := parseInt(tok, 16)
+			if err != nil {
+				name := col.key
+				if col.field != "" {
+					name += "." + col.field
+				}
+				return evt, false, fmt.Errorf("invalid column %q: %v", name, err)
+			}
+			switch col.field {
+			case "raw":
+				data.Raw = int16(v)
+			case "value":
+				data.Value = int16(v)
+			default:
+				data.Raw = int16(v)
+				data.Value = int16(v)
+			}
+		}
+		evt.Data[col.key] = data
+	}
+	return evt, true, nil
+}
+
+// header decodes the header line of a CSV log file.
+func (dec *csvDecoder) header(line string) error {
+	dec.comma = ','
+	if !strings.Contains(line, ",") && strings.Contains(line, ";") {
+		dec.comma = ';'
+	}
+	toks, err := dec.split(line)
+	if err != nil {
+		return fatalError{fmt.Errorf("invalid CSV header: %v", err)}
+	}
+
+	keys := make(map[string]bool, len(dec.chans))
+	for _, ch := range dec.chans {
+		keys[ch.Key] = true
+	}
+	found := make(map[string]bool, len(dec.chans))
+
+	dec.time = -1
+	cols := make([]csvColumn, len(toks))
+	for i, tok := range toks {
+		if tok == "time" {
+			dec.time = i
+			continue
+		}
+		key, field := tok, ""
+		if j := strings.LastIndex(tok, "."); j >= 0 {
+			switch tok[j+1:] {
+			case "acc", "avg", "offset", "gain", "raw", "value":
+				key, field = tok[:j], tok[j+1:]
+			}
+		}
+		if !keys[key] {
+			// column of a channel not analyzed.
+			continue
+		}
+		if field == "" || field == "value" {
+			found[key] = true
+		}
+		cols[i] = csvColumn{key: key, field: field}
+	}
+	for _, ch := range dec.chans {
+		if !found[ch.Key] {
+			return fatalError{fmt.Errorf("missing column of channel %q in CSV header", ch.Key)}
+		}
+	}
+	dec.cols = cols
+	return nil
+}
+
+func (dec *csvDecoder) split(line string) ([]string, error) {
+	r := csv.NewReader(strings.NewReader(line))
+	r.Comma = dec.comma
+	r.TrimLeadingSpace = true
+	toks, err := r.Read()
+	if err != nil {
+		return nil, err
+	}
+	for i, tok := range toks {
+		toks[i] = strings.TrimSpace(tok)
+	}
+	return toks, nil
+}
+
+// readMSR reads the events of a CSV file exported from an MSR data logger,
+// decoding the given channels.
+// The lines of r are numbered from offset+1.
+//
+// Channels are sampled at different rates: the channels with no sample in a
+// row (empty cells) are missing from the Phys values of its event.
+// The samples of a channel are delayed by its time delay, if any.
+func readMSR(r io.Reader, offset int, chans []Channel, strict bool) ([]Event, []*LineError, error) {
+	var (
+		evts []Event
+		errs []*LineError
+		seq  = 0 // number of samples
+	)
+	lineError := func(err error) (*LineError, bool) {
+		e, ok := err.(*msr.Error)
+		if !ok {
+			return nil, false
+		}
+		return &LineError{Line: offset + e.Line, Err: fmt.Errorf("%s", e.Msg)}, true
+	}
+
+	rr, err := msr.NewReader(r)
+	if err != nil {
+		if lerr, ok := lineError(err); ok {
+			return nil, nil, lerr
+		}
+		return nil, nil, err
+	}
+	for {
+		row, err := rr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			lerr, ok := lineError(err)
+			if !ok {
+				return evts, errs, err
+			}
+			if strict {
+				return evts, errs, lerr
+			}
+			errs = append(errs, lerr)
+			seq++
+			continue
+		}
+
+		evt := Event{
+			Time: row.Time,
+			Phys: make(map[string]float64, len(chans)),
+			seq:  seq,
+		}
+		for _, ch := range chans {
+			i := msrColumn(rr.Columns, ch.Key)
+			if i < 0 {
+				return evts, errs, fmt.Errorf("missing column of channel %q in MSR channel section", ch.Key)
+			}
+			v := row.Data[i]
+			if math.IsNaN(v) {
+				continue
+			}
+			evt.Phys[ch.Key] = v
+			if delay := rr.Columns[i].TimeDelay; delay != 0 {
+				if evt.Delay == nil {
+					evt.Delay = make(map[string]time.Duration)
+				}
+				evt.Delay[ch.Key] = delay
+			}
+		}
+		evts = append(evts, evt)
+		seq++
+	}
+	return evts, errs, nil
+}
+
+// msrColumn returns the index of the column named key, or -1.
+func msrColumn(cols []msr.Column, key string) int {
+	for i, col := range cols {
+		if col.Name == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// lineReader reads the remaining lines of a scanner.
+type lineReader struct {
+	scan *bufio.Scanner
+	buf  []byte
+}
+
+func (r *lineReader) Read(p []byte) (int, error) {
+	if len(r.buf) == 0 {
+		if !r.scan.Scan() {
+			if err := r.scan.Err(); err != nil {
+				return 0, err
+			}
+			return 0, io.EOF
+		}
+		r.buf = append(r.scan.Bytes(), '\n')
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
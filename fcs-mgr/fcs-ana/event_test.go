@@ -0,0 +1,101 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const msrRecording = `# recorded on the LPC test bench
+*CREATOR
+MSR Electronics GmbH;MSR PC-Software;5.12.04;
+*STARTTIME
+2015-07-29;12:00:00;
+*TIMEDELAY
+s;0;2;
+*CHANNEL
+TIME;T;RH;
+*UNIT
+;°C;%;
+*DATA
+2015-07-29 12:00:00.000;21.50;;
+2015-07-29 12:00:01.000;21.60;;
+2015-07-29 12:00:02.000;;45.20;
+2015-07-29 12:00:03.000;21.70;45.30;
+`
+
+func TestReadEventsMSR(t *testing.T) {
+	chans := []Channel{{Name: "T", Key: "T"}, {Name: "RH", Key: "RH"}}
+	for _, format := range []string{fmtAuto, fmtMSR} {
+		evts, errs, err := readEvents(strings.NewReader(msrRecording), format, chans, true)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if len(errs) != 0 {
+			t.Fatalf("%s: unexpected errors: %v", format, errs)
+		}
+		if len(evts) != 4 {
+			t.Fatalf("%s: invalid number of events: %d", format, len(evts))
+		}
+
+		tl, err := newTimeline(evts, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		run := &Run{Events: evts, tl: tl}
+
+		for _, tc := range []struct {
+			ch     *Channel
+			times  []time.Duration
+			values []float64
+		}{
+			{&chans[0], []time.Duration{0, 1 * time.Second, 3 * time.Second}, []float64{21.5, 21.6, 21.7}},
+			{&chans[1], []time.Duration{4 * time.Second, 5 * time.Second}, []float64{45.2, 45.3}},
+		} {
+			s, err := run.series(tc.ch)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(s.Times) != len(tc.times) || len(s.Values) != len(tc.values) {
+				t.Fatalf("%s: channel %q: invalid series: %v %v", format, tc.ch.Key, s.Times, s.Values)
+			}
+			for i := range tc.times {
+				if s.Times[i] != tc.times[i] || s.Values[i] != tc.values[i] {
+					t.Fatalf("%s: channel %q: sample #%d: got=(%v, %v), want=(%v, %v)",
+						format, tc.ch.Key, i, s.Times[i], s.Values[i], tc.times[i], tc.values[i],
+					)
+				}
+			}
+		}
+	}
+}
+
+func TestReadEventsMSRErrors(t *testing.T) {
+	chans := []Channel{{Name: "T", Key: "T"}}
+	rec := strings.Replace(msrRecording,
+		"2015-07-29 12:00:01.000;21.60;;",
+		"2015-07-29 12:00:01.000;bad;;", 1,
+	)
+
+	evts, errs, err := readEvents(strings.NewReader(rec), fmtAuto, chans, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(evts) != 3 || len(errs) != 1 {
+		t.Fatalf("invalid events (%d) or errors (%v)", len(evts), errs)
+	}
+	if got, want := errs[0].Line, 14; got != want {
+		t.Fatalf("invalid error line: got=%d, want=%d", got, want)
+	}
+
+	_, _, err = readEvents(strings.NewReader(rec), fmtAuto, chans, true)
+	lerr, ok := err.(*LineError)
+	if !ok || lerr.Line != 14 {
+		t.Fatalf("expected an error at line 14, got %v", err)
+	}
+
+	_, _, err = readEvents(strings.NewReader(msrRecording), fmtAuto, []Channel{{Name: "P", Key: "P"}}, false)
+	if err == nil {
+		t.Fatalf("expected an error for a missing channel")
+	}
+}
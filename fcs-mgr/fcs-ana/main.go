@@ -1,164 +1,472 @@
-// fcs-ana analyzes a fcs-mgr <some-command> output file
+// fcs-ana analyzes ADC log files, as written by fcs-can or the FCS subsystem.
+//
+// Each non-comment line of a log file is a JSON object holding a snapshot of
+// the ADC channels, with an optional "time" timestamp (RFC 3339.)
+// fcs-ana also reads CSV files, with a header line naming the columns, and
+// CSV files exported from MSR data loggers (see -input.)
+// Malformed lines are reported with their line number and skipped, unless
+// -strict is given.
+// The channels, their conversion into physical units and the sampling period
+// of files without timestamps are described by a JSON configuration file:
+//
+//	{
+//	  "period": "3s",
+//	  "channels": [
+//	    {"name": "temp", "key": "temp", "title": "Temperature", "unit": "C", "scale": 0.003125, "offset": -20}
+//	  ]
+//	}
+//
+// Channels may also refer by name or serial number to a sensor of a
+// calibration file (see package calib), with "calib": "<sensor>".
+//
+// Without configuration file, fcs-ana analyzes the temperature, pressure and
+// hygrometry channels of the LPC test bench.
+//
+// Several log files (runs) can be compared: the values of each channel are
+// overlaid, aligned on the time elapsed since the start of each run or on
+// absolute time. The raw values can be overlaid with the corrected ones, the
+// residuals of the runs w.r.t. the first one can be plotted, as well as the
+// settings of the ADC channels (accuracy, averaging, offset and gain) so
+// configuration changes are visible next to the values.
+//
+// Besides plots, fcs-ana computes the statistics of each channel, over the
+// whole time window and over sub-windows, estimates their linear drift and
+// detects spikes, stuck values and gaps. The results are summarized in a
+// text (on stdout), JSON (report.json) and/or HTML (report.html) report.
+//
+// ex:
+//
+//	$ fcs-ana adc.txt
+//	$ fcs-ana -channels=temp,hygro -from=10m -to=1h -format=svg -o=plots adc.txt
+//	$ fcs-ana -raw -settings adc.txt
+//	$ fcs-ana -align=elapsed -residuals run1.txt run2.txt run3.txt
+//	$ fcs-ana -report=text,html -window=1h -percentiles=5,50,95 adc.txt
+//	$ fcs-ana -strict -input=csv adc.csv
+//	$ fcs-ana -config=bench.json -calib=calib.json -from=2015-05-27T01:00:00Z adc.txt
 package main
 
 import (
-	"bufio"
-	"bytes"
-	"encoding/json"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
-	"gonum.org/v1/plot"
-	"gonum.org/v1/plot/plotter"
-	"gonum.org/v1/plot/plotutil"
-	"gonum.org/v1/plot/vg"
+	"github.com/sbinet/lsst-ccs/fcs-mgr/calib"
+)
+
+var (
+	config   = flag.String("config", "", "path to the JSON channels configuration (default: LPC test bench)")
+	calibs   = flag.String("calib", "", "path to the JSON calibration file of the sensors")
+	channels = flag.String("channels", "", "comma-separated list of channels to analyze (default: all)")
+	period   = flag.Duration("period", 0, "sampling period for files without timestamps (default: from configuration)")
+	from     = flag.String("from", "", "start of the time window (duration since the first sample, or RFC 3339 time)")
+	to       = flag.String("to", "", "end of the time window (duration since the first sample, or RFC 3339 time)")
+	odir     = flag.String("o", ".", "output directory")
+	format   = flag.String("format", "png", "image format of the plots (png, svg, pdf, eps, jpg or tiff)")
+	input    = flag.String("input", "auto", "format of the log files (json, csv, msr or auto)")
+	strict   = flag.Bool("strict", false, "fail on malformed lines instead of skipping them")
+
+	align     = flag.String("align", "auto", "alignment of the runs on the time axis (elapsed, absolute or auto)")
+	raw       = flag.Bool("raw", false, "overlay the raw values (0x6404) with the corrected values (0x6401)")
+	residuals = flag.Bool("residuals", false, "plot the residuals of the runs w.r.t. the first one (or corrected-raw values for a single run)")
+	settings  = flag.Bool("settings", false, "plot the acc/avg/offset/gain settings of the channels")
+
+	report  = flag.String("report", "", "comma-separated list of report formats (text, json or html)")
+	window  = flag.Duration("window", 0, "duration of the windows of the statistics (0: whole time window only)")
+	pcts    = flag.String("percentiles", "5,50,95", "comma-separated list of percentiles to compute")
+	spike   = flag.Float64("spike", 6, "spike threshold, in robust standard deviations (0: disable)")
+	spikeW  = flag.Int("spike-width", 5, "number of neighbours on each side of a sample used to detect spikes")
+	stuckD  = flag.Duration("stuck", 10*time.Minute, "minimum duration of stuck values (0: disable)")
+	gapSize = flag.Float64("gap", 3, "gap threshold, in median sampling intervals (0: disable)")
 )
 
 func main() {
-	fname := os.Args[1]
-	f, err := os.Open(fname)
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: fcs-ana [options] <log-file> [<log-file>...]\n\noptions:\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	switch *input {
+	case fmtAuto, fmtJSON, fmtCSV, fmtMSR:
+	default:
+		log.Fatalf("invalid input format %q\n", *input)
+	}
+
+	switch *format {
+	case "png", "svg", "pdf", "eps", "jpg", "jpeg", "tif", "tiff":
+	default:
+		log.Fatalf("invalid image format %q\n", *format)
+	}
+
+	reports := make(map[string]bool)
+	if *report != "" {
+		for _, r := range strings.Split(*report, ",") {
+			switch r {
+			case "text", "json", "html":
+				reports[r] = true
+			default:
+				log.Fatalf("invalid report format %q\n", r)
+			}
+		}
+	}
+
+	var percentiles []float64
+	if *pcts != "" {
+		for _, v := range strings.Split(*pcts, ",") {
+			p, err := strconv.ParseFloat(v, 64)
+			if err != nil || p < 0 || p > 100 {
+				log.Fatalf("invalid percentile %q\n", v)
+			}
+			percentiles = append(percentiles, p)
+		}
+	}
+
+	cfg := defaultConfig()
+	if *config != "" {
+		var err error
+		cfg, err = loadConfig(*config)
+		if err != nil {
+			log.Fatalf("could not load configuration: %v\n", err)
+		}
+	}
+	if *channels != "" {
+		err := cfg.selectChannels(strings.Split(*channels, ","))
+		if err != nil {
+			log.Fatalf("invalid channels selection: %v\n", err)
+		}
+	}
+	var cals *calib.File
+	if *calibs != "" {
+		var err error
+		cals, err = calib.Open(*calibs)
+		if err != nil {
+			log.Fatalf("could not load calibrations: %v\n", err)
+		}
+	}
+	err := cfg.setCalibrations(cals)
 	if err != nil {
-		log.Fatalf("could not open [%s]: %v\n", fname, err)
+		log.Fatalf("invalid calibrations: %v\n", err)
+	}
+
+	if *period > 0 {
+		cfg.Period.Duration = *period
+	}
+
+	var runs []*Run
+	for _, fname := range flag.Args() {
+		run, err := loadRun(fname, cfg)
+		if err != nil {
+			log.Fatalf("%v\n", err)
+		}
+		runs = append(runs, run)
 	}
-	defer f.Close()
 
-	raws := make(map[string]plotter.XYs, 3)
-	vals := make(map[string]plotter.XYs, 3)
+	ax, err := newAxis(runs, *align)
+	if err != nil {
+		log.Fatalf("invalid time axis: %v\n", err)
+	}
 
-	keys := []string{"temp", "press", "hygro"}
-	for _, k := range keys {
-		raws[k] = make(plotter.XYs, 0, 1024)
-		vals[k] = make(plotter.XYs, 0, 1024)
+	err = os.MkdirAll(*odir, 0755)
+	if err != nil {
+		log.Fatalf("could not create output directory: %v\n", err)
 	}
 
-	i := 0
-	scan := bufio.NewScanner(f)
-	for scan.Scan() {
-		line := scan.Bytes()
-		if bytes.HasPrefix(line, []byte("#")) {
+	var (
+		anas  []Analysis
+		plots = make(map[string]string)
+		th    = Thresholds{
+			Spike:      *spike,
+			SpikeWidth: *spikeW,
+			Stuck:      *stuckD,
+			Gap:        *gapSize,
+		}
+	)
+	for i := range cfg.Channels {
+		ch := &cfg.Channels[i]
+		var series []Series
+		for _, run := range runs {
+			s, err := run.series(ch)
+			if err != nil {
+				log.Fatalf("%v\n", err)
+			}
+			if len(s.Values) == 0 {
+				log.Printf("no data for channel %q in time window of [%s]\n", ch.Name, run.File)
+				continue
+			}
+			series = append(series, s)
+			if len(reports) > 0 {
+				anas = append(anas, analyze(s, *window, percentiles, th))
+			}
+		}
+		if len(series) == 0 {
 			continue
 		}
 
-		r := bytes.NewReader(line)
-		var evt Event
-		err = json.NewDecoder(r).Decode(&evt)
-		if err != nil {
-			log.Fatalf("error decoding line %q: %v\n", string(line), err)
-		}
-		raws["temp"] = append(raws["temp"],
-			XY{
-				X: float64(i * 3), // data is snapshot every 3s
-				Y: adcToTemperature(evt.Temp.Raw),
-			},
-		)
-		vals["temp"] = append(vals["temp"],
-			XY{
-				X: float64(i * 3), // data is snapshot every 3s
-				Y: adcToTemperature(evt.Temp.Value),
-			},
-		)
-		raws["press"] = append(raws["press"],
-			XY{
-				X: float64(i * 3), // data is snapshot every 3s
-				Y: adcToPressure(evt.Pressure.Raw),
-			},
-		)
-		vals["press"] = append(vals["press"],
-			XY{
-				X: float64(i * 3), // data is snapshot every 3s
-				Y: adcToPressure(evt.Pressure.Value),
-			},
-		)
-		raws["hygro"] = append(raws["hygro"],
-			XY{
-				X: float64(i * 3), // data is snapshot every 3s
-				Y: adcToHygrometry(evt.Hygrometry.Raw),
-			},
-		)
-		vals["hygro"] = append(vals["hygro"],
-			XY{
-				X: float64(i * 3), // data is snapshot every 3s
-				Y: adcToHygrometry(evt.Hygrometry.Value),
-			},
-		)
-		i++
-	}
-
-	for _, k := range keys {
-		p, err := plot.New()
+		oname := "data-" + ch.Name + "." + *format
+		err = plotValues(filepath.Join(*odir, oname), ax, series, *raw)
 		if err != nil {
-			panic(err)
+			log.Fatalf("error plotting channel %q: %v\n", ch.Name, err)
 		}
+		plots[ch.Name] = oname
 
-		p.Title.Text = k // "Temperatures"
-		p.X.Label.Text = "Time (s)"
-		p.Y.Label.Text = k // "Temperature (C)"
-		p.Legend.Top = true
+		if *residuals {
+			oname := "data-" + ch.Name + "-residuals." + *format
+			err = plotResiduals(filepath.Join(*odir, oname), ax, series)
+			if err != nil {
+				log.Fatalf("error plotting residuals of channel %q: %v\n", ch.Name, err)
+			}
+		}
 
-		p.Add(plotter.NewGrid())
+		if *settings {
+			oname := "data-" + ch.Name + "-settings." + *format
+			err = plotSettings(filepath.Join(*odir, oname), ax, series)
+			if err != nil {
+				log.Fatalf("error plotting settings of channel %q: %v\n", ch.Name, err)
+			}
+		}
+	}
 
-		err = plotutil.AddLinePoints(p,
-			//	"0x6404 (raw)", raws,
-			"0x6401 (val)", vals[k],
-		)
+	if len(reports) == 0 {
+		return
+	}
+	r := newReport(runs, anas, plots)
+	if reports["text"] {
+		r.writeText(os.Stdout)
+	}
+	if reports["json"] {
+		err = writeReport(filepath.Join(*odir, "report.json"), r.writeJSON)
 		if err != nil {
-			log.Fatalf("error adding line-points: %v\n", err)
+			log.Fatalf("error writing JSON report: %v\n", err)
+		}
+	}
+	if reports["html"] {
+		err = writeReport(filepath.Join(*odir, "report.html"), r.writeHTML)
+		if err != nil {
+			log.Fatalf("error writing HTML report: %v\n", err)
+		}
+	}
+}
+
+// writeReport creates the file oname and writes a report into it.
+func writeReport(oname string, write func(w io.Writer) error) error {
+	f, err := os.Create(oname)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	err = write(f)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// Run holds the events of a log file, within the time window.
+type Run struct {
+	Name   string // name of the run, in plot legends and reports
+	File   string
+	Events []Event
+	tl     *timeline
+}
+
+// loadRun loads the events of the log file fname, keeping the ones within
+// the time window.
+func loadRun(fname string, cfg Config) (*Run, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, fmt.Errorf("could not open [%s]: %v", fname, err)
+	}
+	defer f.Close()
+
+	evts, errs, err := readEvents(f, *input, cfg.Channels, *strict)
+	for i, lerr := range errs {
+		if i == maxLineErrors {
+			log.Printf("[%s]: %d more malformed lines skipped\n", fname, len(errs)-i)
+			break
 		}
+		if lerr.Text == "" {
+			log.Printf("[%s]: %v (line skipped)\n", fname, lerr)
+			continue
+		}
+		log.Printf("[%s]: %v (line skipped: %q)\n", fname, lerr, trunc(lerr.Text, 80))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read [%s]: %v", fname, err)
+	}
+	if len(evts) == 0 {
+		return nil, fmt.Errorf("no data in [%s]", fname)
+	}
+
+	tl, err := newTimeline(evts, cfg.Period.Duration)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time axis of [%s]: %v", fname, err)
+	}
+	beg, end, err := tl.window(*from, *to)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time window for [%s]: %v", fname, err)
+	}
 
-		// Save the plot to a PNG file.
-		if err := p.Save(14*vg.Inch, 8*vg.Inch, "data-"+k+".png"); err != nil {
-			log.Fatalf("error saving plot: %v\n", err)
+	run := &Run{
+		Name: strings.TrimSuffix(filepath.Base(fname), filepath.Ext(fname)),
+		File: fname,
+		tl:   &timeline{stamped: tl.stamped, t0: tl.t0},
+	}
+	for i, evt := range evts {
+		if tl.dts[i] < beg || tl.dts[i] > end {
+			continue
 		}
+		run.Events = append(run.Events, evt)
+		run.tl.dts = append(run.tl.dts, tl.dts[i])
+	}
+	if len(run.Events) == 0 {
+		return nil, fmt.Errorf("no data in time window of [%s]", fname)
 	}
+	return run, nil
 }
 
-type Event struct {
-	Temp       Data `json:"temp"`
-	Pressure   Data `json:"pressure"`
-	Hygrometry Data `json:"hygrometry"`
+// maxLineErrors is the maximum number of malformed lines reported per file.
+const maxLineErrors = 20
+
+// trunc truncates s to n bytes.
+func trunc(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
 }
 
-type Data struct {
-	Acc    uint8 `json:"acc"`
-	Avg    uint8 `json:"avg"`
-	Offset int32 `json:"offset"`
-	Gain   int32 `json:"gain"` // FIXME: doc says int16
-	Raw    int16 `json:"raw"`
-	Value  int16 `json:"value"`
+// series returns the time series of the channel ch.
+// Events of formats recording physical values (MSR) without a sample of the
+// channel are skipped.
+func (run *Run) series(ch *Channel) (Series, error) {
+	s := Series{
+		Channel: ch,
+		Run:     run,
+		Times:   run.tl.dts,
+		Values:  make([]float64, len(run.Events)),
+		Raws:    make([]float64, len(run.Events)),
+		Data:    make([]Data, len(run.Events)),
+	}
+	if len(run.Events) > 0 && run.Events[0].Phys != nil {
+		s.Times = nil
+		s.Values = s.Values[:0]
+		s.Raws = s.Raws[:0]
+		s.Data = s.Data[:0]
+		for i, evt := range run.Events {
+			v, ok := evt.Phys[ch.Key]
+			if !ok {
+				continue
+			}
+			s.Times = append(s.Times, run.tl.dts[i]+evt.Delay[ch.Key])
+			s.Values = append(s.Values, v)
+			s.Raws = append(s.Raws, v)
+			s.Data = append(s.Data, Data{})
+		}
+		return s, nil
+	}
+	for i, evt := range run.Events {
+		data := evt.Data[ch.Key]
+		s.Data[i] = data
+		v, err := ch.Convert(data.Value, evt.Time)
+		if err != nil {
+			return s, fmt.Errorf("[%s]: channel %q, sample #%d: %v", run.File, ch.Name, i, err)
+		}
+		raw, err := ch.Convert(data.Raw, evt.Time)
+		if err != nil {
+			return s, fmt.Errorf("[%s]: channel %q, sample #%d: %v", run.File, ch.Name, i, err)
+		}
+		s.Values[i] = v
+		s.Raws[i] = raw
+	}
+	return s, nil
 }
 
-// UnmarshalJSON decodes a JSON representation of Data.
-// The official JSON format does not support hexadecimal literals.
-func (d *Data) UnmarshalJSON(data []byte) error {
-	r := bytes.NewReader(data[1 : len(data)-1])
-	_, err := fmt.Fscanf(
-		r,
-		"0x%x 0x%x 0x%x 0x%x 0x%x 0x%x",
-		&d.Acc, &d.Avg, &d.Offset, &d.Gain, &d.Raw, &d.Value,
-	)
-	return err
+// timeline is the time axis of a log file.
+// Samples are timestamped with their recorded time, when present, or
+// assuming a constant sampling period otherwise (malformed lines still
+// count as samples.)
+type timeline struct {
+	stamped bool            // whether samples carry a timestamp
+	t0      time.Time       // time of the first sample
+	dts     []time.Duration // time of each sample, since the first one
 }
 
-type XY struct {
-	X float64
-	Y float64
+func newTimeline(evts []Event, period time.Duration) (*timeline, error) {
+	tl := &timeline{
+		stamped: !evts[0].Time.IsZero(),
+		t0:      evts[0].Time,
+		dts:     make([]time.Duration, len(evts)),
+	}
+	for i, evt := range evts {
+		if evt.Time.IsZero() == tl.stamped {
+			return nil, fmt.Errorf("sample #%d: mixed samples with and without timestamps", i)
+		}
+		switch {
+		case tl.stamped:
+			tl.dts[i] = evt.Time.Sub(tl.t0)
+		default:
+			tl.dts[i] = time.Duration(evt.seq-evts[0].seq) * period
+		}
+	}
+	return tl, nil
 }
 
-// adcToTemperature returns the temperature corresponding to a given ADC count.
-//  ADC: [0; 0xFFFF) -> -10.24V;10.24V -> -20C; 80C;
-func adcToTemperature(adc int16) float64 {
-	return float64(adc)*0.3125e-3*10.0 - 20.0
+// window returns the time window described by the -from and -to flags.
+func (tl *timeline) window(from, to string) (time.Duration, time.Duration, error) {
+	beg := time.Duration(0)
+	end := tl.dts[len(tl.dts)-1]
+	var err error
+	if from != "" {
+		beg, err = tl.parse(from)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	if to != "" {
+		end, err = tl.parse(to)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	if end < beg {
+		return 0, 0, fmt.Errorf("end of window (%v) before its start (%v)", end, beg)
+	}
+	return beg, end, nil
 }
 
-// adcToPressure returns the pressure corresponding to a given ADC count.
-//  ADC: [0; 0xFFFF) -> -10.24V;10.24V -> 600mbar; 1100mbar;
-func adcToPressure(adc int16) float64 {
-	return float64(adc)*0.3125e-3*50.0 + 600.0
+// parse parses a time, either as a duration since the first sample or as
+// an absolute RFC 3339 time.
+func (tl *timeline) parse(s string) (time.Duration, error) {
+	if dt, err := time.ParseDuration(s); err == nil {
+		return dt, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	if !tl.stamped {
+		return 0, fmt.Errorf("absolute time %q requires timestamped samples", s)
+	}
+	return t.Sub(tl.t0), nil
 }
 
-// adcToHygrometry returns the hygrometry corresponding to a given ADC count.
-//  ADC: [0; 0xFFFF) -> -10.24V;10.24V -> 0%; 100%;
-func adcToHygrometry(adc int16) float64 {
-	return float64(adc) * 0.3125e-3 * 10.0
+// format formats the time dt since the first sample, as an absolute time
+// for timestamped samples.
+func (tl *timeline) format(dt time.Duration) string {
+	if tl.stamped {
+		return tl.t0.Add(dt).UTC().Format(time.RFC3339)
+	}
+	return dt.String()
 }
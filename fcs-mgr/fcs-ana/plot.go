@@ -0,0 +1,298 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/plotutil"
+	"gonum.org/v1/plot/vg"
+	"gonum.org/v1/plot/vg/draw"
+)
+
+type XY struct {
+	X float64
+	Y float64
+}
+
+// axis is the time axis shared by the plots of all the runs.
+type axis struct {
+	absolute bool          // whether runs are aligned on absolute time or on elapsed time
+	span     time.Duration // time span of the axis
+}
+
+// newAxis returns the time axis aligning runs according to mode:
+// "elapsed", "absolute" or "auto" (absolute for a single timestamped run.)
+func newAxis(runs []*Run, mode string) (axis, error) {
+	stamped := true
+	for _, run := range runs {
+		stamped = stamped && run.tl.stamped
+	}
+
+	var ax axis
+	switch mode {
+	case "auto":
+		ax.absolute = stamped && len(runs) == 1
+	case "absolute":
+		if !stamped {
+			return ax, fmt.Errorf("alignment on absolute time requires timestamped samples")
+		}
+		ax.absolute = true
+	case "elapsed":
+	default:
+		return ax, fmt.Errorf("invalid alignment %q", mode)
+	}
+
+	switch {
+	case ax.absolute:
+		var beg, end time.Time
+		for i, run := range runs {
+			t0 := run.tl.t0.Add(run.tl.dts[0])
+			t1 := run.tl.t0.Add(run.tl.dts[len(run.tl.dts)-1])
+			if i == 0 || t0.Before(beg) {
+				beg = t0
+			}
+			if i == 0 || t1.After(end) {
+				end = t1
+			}
+		}
+		ax.span = end.Sub(beg)
+	default:
+		for _, run := range runs {
+			if dt := run.tl.dts[len(run.tl.dts)-1]; dt > ax.span {
+				ax.span = dt
+			}
+		}
+	}
+	return ax, nil
+}
+
+// x returns the abscissa of the i-th sample of a series.
+func (ax axis) x(s Series, i int) float64 {
+	if ax.absolute {
+		return float64(s.Run.tl.t0.Add(s.Times[i]).UnixNano()) * 1e-9
+	}
+	return s.Times[i].Seconds()
+}
+
+// configure configures the time axis of p.
+func (ax axis) configure(p *plot.Plot) {
+	if !ax.absolute {
+		p.X.Label.Text = "Time (s)"
+		return
+	}
+	p.X.Label.Text = "Time (UTC)"
+	layout := "15:04:05"
+	if ax.span > 24*time.Hour {
+		layout = "2006-01-02 15:04"
+	}
+	p.X.Tick.Marker = plot.TimeTicks{Format: layout}
+}
+
+// label returns the legend of a line of a series, prefixed with the name of
+// its run when several runs are compared.
+func label(series []Series, s Series, name string) string {
+	if len(series) == 1 {
+		return name
+	}
+	return s.Run.Name + " " + name
+}
+
+func newPlot(ax axis, title, ylabel string) (*plot.Plot, error) {
+	p, err := plot.New()
+	if err != nil {
+		return nil, err
+	}
+
+	p.Title.Text = title
+	p.Y.Label.Text = ylabel
+	p.Legend.Top = true
+	ax.configure(p)
+
+	p.Add(plotter.NewGrid())
+	return p, nil
+}
+
+// plotValues plots the values of the series of a channel into the file
+// oname, overlaid with their raw values if raw is true.
+func plotValues(oname string, ax axis, series []Series, raw bool) error {
+	ch := series[0].Channel
+	p, err := newPlot(ax, ch.Title, ch.Label())
+	if err != nil {
+		return err
+	}
+
+	var lines []interface{}
+	for _, s := range series {
+		vals := make(plotter.XYs, len(s.Values))
+		for i, v := range s.Values {
+			vals[i] = XY{X: ax.x(s, i), Y: v}
+		}
+		lines = append(lines, label(series, s, "0x6401 (val)"), vals)
+		if !raw {
+			continue
+		}
+		raws := make(plotter.XYs, len(s.Raws))
+		for i, v := range s.Raws {
+			raws[i] = XY{X: ax.x(s, i), Y: v}
+		}
+		lines = append(lines, label(series, s, "0x6404 (raw)"), raws)
+	}
+
+	err = plotutil.AddLinePoints(p, lines...)
+	if err != nil {
+		return err
+	}
+
+	return p.Save(14*vg.Inch, 8*vg.Inch, oname)
+}
+
+// plotResiduals plots the residuals of the series of a channel w.r.t. the
+// first series (interpolated linearly), or the difference between the
+// corrected and raw values for a single series.
+func plotResiduals(oname string, ax axis, series []Series) error {
+	ch := series[0].Channel
+	p, err := newPlot(ax, ch.Title+" residuals", ch.Label())
+	if err != nil {
+		return err
+	}
+
+	var lines []interface{}
+	switch len(series) {
+	case 1:
+		s := series[0]
+		res := make(plotter.XYs, len(s.Values))
+		for i, v := range s.Values {
+			res[i] = XY{X: ax.x(s, i), Y: v - s.Raws[i]}
+		}
+		lines = append(lines, "0x6401 (val) - 0x6404 (raw)", res)
+	default:
+		ref := series[0]
+		xs := make([]float64, len(ref.Values))
+		for i := range xs {
+			xs[i] = ax.x(ref, i)
+		}
+		for _, s := range series[1:] {
+			res := make(plotter.XYs, 0, len(s.Values))
+			for i, v := range s.Values {
+				x := ax.x(s, i)
+				y, ok := interpolate(xs, ref.Values, x)
+				if !ok {
+					continue
+				}
+				res = append(res, XY{X: x, Y: v - y})
+			}
+			if len(res) == 0 {
+				continue
+			}
+			lines = append(lines, s.Run.Name+" - "+ref.Run.Name, res)
+		}
+	}
+	if len(lines) == 0 {
+		return fmt.Errorf("runs do not overlap")
+	}
+
+	err = plotutil.AddLines(p, lines...)
+	if err != nil {
+		return err
+	}
+
+	return p.Save(14*vg.Inch, 8*vg.Inch, oname)
+}
+
+// interpolate returns the value at x of the function sampled at (xs, ys),
+// interpolated linearly. xs are sorted in increasing order.
+func interpolate(xs, ys []float64, x float64) (float64, bool) {
+	if len(xs) == 0 || x < xs[0] || x > xs[len(xs)-1] {
+		return 0, false
+	}
+	i := sort.SearchFloat64s(xs, x)
+	if xs[i] == x {
+		return ys[i], true
+	}
+	x0, x1 := xs[i-1], xs[i]
+	return ys[i-1] + (x-x0)*(ys[i]-ys[i-1])/(x1-x0), true
+}
+
+// plotSettings plots the accuracy, averaging, offset and gain settings of the
+// series of a channel as step plots, stacked into the file oname.
+func plotSettings(oname string, ax axis, series []Series) error {
+	ch := series[0].Channel
+	settings := []struct {
+		name string
+		get  func(d Data) float64
+	}{
+		{"0x2100 (acc)", func(d Data) float64 { return float64(d.Acc) }},
+		{"0x2101 (avg)", func(d Data) float64 { return float64(d.Avg) }},
+		{"0x6431 (offset)", func(d Data) float64 { return float64(d.Offset) }},
+		{"0x6432 (gain)", func(d Data) float64 { return float64(d.Gain) }},
+	}
+
+	plots := make([][]*plot.Plot, len(settings))
+	for j, setting := range settings {
+		p, err := newPlot(ax, "", setting.name)
+		if err != nil {
+			return err
+		}
+		if j == 0 {
+			p.Title.Text = ch.Title + " settings"
+		}
+
+		var lines []interface{}
+		for _, s := range series {
+			lines = append(lines, label(series, s, setting.name), steps(ax, s, setting.get))
+		}
+		err = plotutil.AddLines(p, lines...)
+		if err != nil {
+			return err
+		}
+		plots[j] = []*plot.Plot{p}
+	}
+
+	format := strings.ToLower(strings.TrimPrefix(filepath.Ext(oname), "."))
+	c, err := draw.NewFormattedCanvas(14*vg.Inch, 16*vg.Inch, format)
+	if err != nil {
+		return err
+	}
+	tiles := draw.Tiles{
+		Rows: len(plots),
+		Cols: 1,
+		PadY: vg.Centimeter,
+	}
+	canvases := plot.Align(plots, tiles, draw.New(c))
+	for j := range plots {
+		plots[j][0].Draw(canvases[j][0])
+	}
+
+	f, err := os.Create(oname)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = c.WriteTo(f)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// steps returns the points of a step plot of the values of a series: each
+// value holds until the next sample.
+func steps(ax axis, s Series, get func(d Data) float64) plotter.XYs {
+	pts := make(plotter.XYs, 0, 2*len(s.Data))
+	for i, d := range s.Data {
+		y := get(d)
+		x := ax.x(s, i)
+		if i > 0 {
+			pts = append(pts, XY{X: x, Y: pts[len(pts)-1].Y})
+		}
+		pts = append(pts, XY{X: x, Y: y})
+	}
+	return pts
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+	"time"
+)
+
+// Report summarizes the analysis of log files.
+type Report struct {
+	Runs     []RunReport     `json:"runs"`
+	Channels []ChannelReport `json:"channels"`
+}
+
+// RunReport describes an analyzed log file.
+type RunReport struct {
+	Name  string `json:"name"`
+	File  string `json:"file"`
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+// ChannelReport summarizes the analysis of a channel.
+type ChannelReport struct {
+	Run       string          `json:"run,omitempty"` // name of the run, when several runs are analyzed
+	Name      string          `json:"name"`
+	Title     string          `json:"title"`
+	Unit      string          `json:"unit"`
+	Plot      string          `json:"plot,omitempty"` // path of the plot, relative to the report
+	Overall   StatsReport     `json:"overall"`
+	Windows   []StatsReport   `json:"windows,omitempty"`
+	Drift     DriftReport     `json:"drift"`
+	Anomalies []AnomalyReport `json:"anomalies"`
+}
+
+type StatsReport struct {
+	Start       string             `json:"start"`
+	End         string             `json:"end"`
+	N           int                `json:"n"`
+	Min         float64            `json:"min"`
+	Max         float64            `json:"max"`
+	Mean        float64            `json:"mean"`
+	StdDev      float64            `json:"stddev"`
+	Percentiles map[string]float64 `json:"percentiles,omitempty"`
+
+	pcts []Percentile
+}
+
+type DriftReport struct {
+	Slope     float64 `json:"slope"` // units per hour
+	Intercept float64 `json:"intercept"`
+	R2        float64 `json:"r2"`
+}
+
+type AnomalyReport struct {
+	Kind     AnomalyKind `json:"kind"`
+	Start    string      `json:"start"`
+	End      string      `json:"end"`
+	Duration string      `json:"duration,omitempty"`
+	Value    *float64    `json:"value,omitempty"`
+	Dev      float64     `json:"dev,omitempty"`
+}
+
+// newReport creates the report of the analyses of runs.
+// plots holds the path of the plot of each channel, by channel name.
+func newReport(runs []*Run, anas []Analysis, plots map[string]string) Report {
+	var r Report
+	for _, run := range runs {
+		tl := run.tl
+		r.Runs = append(r.Runs, RunReport{
+			Name:  run.Name,
+			File:  run.File,
+			Start: tl.format(tl.dts[0]),
+			End:   tl.format(tl.dts[len(tl.dts)-1]),
+		})
+	}
+	for _, ana := range anas {
+		ch := ana.Channel
+		tl := ana.Run.tl
+		cr := ChannelReport{
+			Name:    ch.Name,
+			Title:   ch.Title,
+			Unit:    ch.Unit,
+			Plot:    plots[ch.Name],
+			Overall: newStatsReport(tl, ana.Overall),
+			Drift: DriftReport{
+				Slope:     ana.Drift.Slope,
+				Intercept: ana.Drift.Intercept,
+				R2:        ana.Drift.R2,
+			},
+			Anomalies: make([]AnomalyReport, 0, len(ana.Anomalies)),
+		}
+		if len(runs) > 1 {
+			cr.Run = ana.Run.Name
+		}
+		for _, st := range ana.Windows {
+			cr.Windows = append(cr.Windows, newStatsReport(tl, st))
+		}
+		for _, a := range ana.Anomalies {
+			ar := AnomalyReport{
+				Kind:  a.Kind,
+				Start: tl.format(a.Start),
+				End:   tl.format(a.End),
+				Dev:   a.Dev,
+			}
+			if a.End > a.Start {
+				ar.Duration = (a.End - a.Start).String()
+			}
+			if a.Kind != Gap {
+				v := a.Value
+				ar.Value = &v
+			}
+			cr.Anomalies = append(cr.Anomalies, ar)
+		}
+		r.Channels = append(r.Channels, cr)
+	}
+	return r
+}
+
+func newStatsReport(tl *timeline, st Stats) StatsReport {
+	sr := StatsReport{
+		Start:  tl.format(st.Start),
+		End:    tl.format(st.End),
+		N:      st.N,
+		Min:    st.Min,
+		Max:    st.Max,
+		Mean:   st.Mean,
+		StdDev: st.StdDev,
+		pcts:   st.Percentiles,
+	}
+	if st.N == 0 {
+		sr.Min, sr.Max = 0, 0
+		return sr
+	}
+	if len(st.Percentiles) > 0 {
+		sr.Percentiles = make(map[string]float64, len(st.Percentiles))
+		for _, p := range st.Percentiles {
+			sr.Percentiles[pname(p.P)] = p.Value
+		}
+	}
+	return sr
+}
+
+// pname returns the name of the p-th percentile (e.g. "p95".)
+func pname(p float64) string {
+	return "p" + strings.TrimRight(strings.TrimRight(fmt.Sprintf("%.2f", p), "0"), ".")
+}
+
+// PercentilesText returns the percentiles formatted as "p5=... p95=...".
+func (sr StatsReport) PercentilesText() string {
+	var parts []string
+	for _, p := range sr.pcts {
+		parts = append(parts, fmt.Sprintf("%s=%.4g", pname(p.P), p.Value))
+	}
+	return strings.Join(parts, " ")
+}
+
+// writeJSON writes the report as JSON to w.
+func (r Report) writeJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// writeText writes the report as plain text to w.
+func (r Report) writeText(w io.Writer) error {
+	for _, run := range r.Runs {
+		fmt.Fprintf(w, "file:   %s\n", run.File)
+		fmt.Fprintf(w, "period: %s -> %s\n", run.Start, run.End)
+	}
+	for _, ch := range r.Channels {
+		fmt.Fprintf(w, "\n== ")
+		if ch.Run != "" {
+			fmt.Fprintf(w, "[%s] ", ch.Run)
+		}
+		fmt.Fprintf(w, "%s: %s", ch.Name, ch.Title)
+		if ch.Unit != "" {
+			fmt.Fprintf(w, " (%s)", ch.Unit)
+		}
+		fmt.Fprintf(w, " ==\n")
+		st := ch.Overall
+		fmt.Fprintf(w, "samples:     %d\n", st.N)
+		fmt.Fprintf(w, "min/max:     %.4g / %.4g\n", st.Min, st.Max)
+		fmt.Fprintf(w, "mean/stddev: %.4g / %.4g\n", st.Mean, st.StdDev)
+		if len(st.pcts) > 0 {
+			fmt.Fprintf(w, "percentiles: %s\n", st.PercentilesText())
+		}
+		fmt.Fprintf(w, "drift:       %+.4g %s/h (r2=%.3f)\n", ch.Drift.Slope, ch.Unit, ch.Drift.R2)
+		if len(ch.Windows) > 0 {
+			fmt.Fprintf(w, "windows:\n")
+			for _, win := range ch.Windows {
+				fmt.Fprintf(
+					w, "  %s -> %s  n=%-5d min=%-10.4g max=%-10.4g mean=%-10.4g stddev=%-10.4g %s\n",
+					win.Start, win.End, win.N, win.Min, win.Max, win.Mean, win.StdDev, win.PercentilesText(),
+				)
+			}
+		}
+		fmt.Fprintf(w, "anomalies:   %d\n", len(ch.Anomalies))
+		for _, a := range ch.Anomalies {
+			fmt.Fprintf(w, "  %-5s %s", a.Kind, a.Start)
+			if a.Duration != "" {
+				fmt.Fprintf(w, " -> %s (%s)", a.End, a.Duration)
+			}
+			if a.Value != nil {
+				fmt.Fprintf(w, " value=%.4g", *a.Value)
+			}
+			if a.Dev != 0 {
+				fmt.Fprintf(w, " (%.1f sigma)", a.Dev)
+			}
+			fmt.Fprintf(w, "\n")
+		}
+	}
+	return nil
+}
+
+// writeHTML writes the report as a HTML page to w.
+func (r Report) writeHTML(w io.Writer) error {
+	return reportTmpl.Execute(w, r)
+}
+
+var reportTmpl = template.Must(template.New("report").Funcs(template.FuncMap{
+	"num": func(v float64) string { return fmt.Sprintf("%.4g", v) },
+	"deref": func(v *float64) string {
+		if v == nil {
+			return ""
+		}
+		return fmt.Sprintf("%.4g", *v)
+	},
+	"now": func() string { return time.Now().UTC().Format(time.RFC3339) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>fcs-ana report</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { border: 1px solid #ccc; padding: 2px 8px; text-align: right; }
+th { background: #eee; }
+td.kind { text-align: left; }
+img { max-width: 100%; }
+</style>
+</head>
+<body>
+<h1>fcs-ana report</h1>
+<p>Generated: {{now}}</p>
+<table>
+<tr><th>run</th><th>file</th><th>start</th><th>end</th></tr>
+{{range .Runs}}<tr><td class="kind">{{.Name}}</td><td class="kind"><code>{{.File}}</code></td><td>{{.Start}}</td><td>{{.End}}</td></tr>
+{{end}}</table>
+{{range .Channels}}
+<h2>{{if .Run}}[{{.Run}}] {{end}}{{.Title}}{{if .Unit}} ({{.Unit}}){{end}}</h2>
+{{if .Plot}}<img src="{{.Plot}}" alt="{{.Name}}">{{end}}
+<table>
+<tr><th>start</th><th>end</th><th>n</th><th>min</th><th>max</th><th>mean</th><th>stddev</th><th>percentiles</th></tr>
+{{with .Overall}}<tr><td>{{.Start}}</td><td>{{.End}}</td><td>{{.N}}</td><td>{{num .Min}}</td><td>{{num .Max}}</td><td>{{num .Mean}}</td><td>{{num .StdDev}}</td><td>{{.PercentilesText}}</td></tr>{{end}}
+{{range .Windows}}<tr><td>{{.Start}}</td><td>{{.End}}</td><td>{{.N}}</td><td>{{num .Min}}</td><td>{{num .Max}}</td><td>{{num .Mean}}</td><td>{{num .StdDev}}</td><td>{{.PercentilesText}}</td></tr>
+{{end}}</table>
+<p>Drift: {{num .Drift.Slope}} {{.Unit}}/h (r<sup>2</sup>={{num .Drift.R2}})</p>
+{{if .Anomalies}}
+<table>
+<tr><th>kind</th><th>start</th><th>end</th><th>duration</th><th>value</th><th>deviation (&sigma;)</th></tr>
+{{range .Anomalies}}<tr><td class="kind">{{.Kind}}</td><td>{{.Start}}</td><td>{{.End}}</td><td>{{.Duration}}</td><td>{{deref .Value}}</td><td>{{if .Dev}}{{num .Dev}}{{end}}</td></tr>
+{{end}}</table>
+{{else}}
+<p>No anomaly.</p>
+{{end}}
+{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// Series is the time series of the physical values of a channel, in a run.
+type Series struct {
+	Channel *Channel
+	Run     *Run
+	Times   []time.Duration // time of each sample, since the first sample of the file
+	Values  []float64       // corrected values (0x6401)
+	Raws    []float64       // raw values (0x6404)
+	Data    []Data          // ADC objects of the channel
+}
+
+// Stats are the statistics of a series over a time window.
+type Stats struct {
+	Start       time.Duration
+	End         time.Duration
+	N           int
+	Min         float64
+	Max         float64
+	Mean        float64
+	StdDev      float64
+	Percentiles []Percentile
+}
+
+// Percentile is the value below which P percent of the samples fall.
+type Percentile struct {
+	P     float64
+	Value float64
+}
+
+// Drift is the linear drift of a series, estimated by least squares.
+type Drift struct {
+	Slope     float64 // in units per hour
+	Intercept float64 // value at the start of the series
+	R2        float64 // coefficient of determination
+}
+
+// AnomalyKind is the kind of an anomaly detected in a series.
+type AnomalyKind string
+
+const (
+	Spike AnomalyKind = "spike" // sample far from its neighbours
+	Stuck AnomalyKind = "stuck" // value not changing for a long time
+	Gap   AnomalyKind = "gap"   // missing samples
+)
+
+// Anomaly is an anomaly detected in a series.
+type Anomaly struct {
+	Kind  AnomalyKind
+	Start time.Duration
+	End   time.Duration
+	Value float64 // value of the spike or of the stuck samples
+	Dev   float64 // deviation of a spike from its neighbours, in robust standard deviations
+}
+
+// Analysis holds the results of the analysis of a series.
+type Analysis struct {
+	Channel   *Channel
+	Run       *Run
+	Overall   Stats
+	Windows   []Stats
+	Drift     Drift
+	Anomalies []Anomaly
+}
+
+// Thresholds configures the detection of anomalies.
+type Thresholds struct {
+	Spike      float64       // spikes deviate by more than Spike robust standard deviations
+	SpikeWidth int           // number of neighbours on each side of a sample to estimate its deviation
+	Stuck      time.Duration // minimum duration of stuck values
+	Gap        float64       // gaps are longer than Gap times the median sampling interval
+}
+
+// analyze computes the statistics of a series and detects its anomalies.
+func analyze(s Series, window time.Duration, ps []float64, th Thresholds) Analysis {
+	ana := Analysis{
+		Channel: s.Channel,
+		Run:     s.Run,
+		Overall: stats(s.Times, s.Values, ps),
+		Drift:   drift(s.Times, s.Values),
+	}
+	if window > 0 && len(s.Times) > 0 {
+		beg := 0
+		for beg < len(s.Times) {
+			start := s.Times[beg]
+			end := beg
+			for end < len(s.Times) && s.Times[end] < start+window {
+				end++
+			}
+			st := stats(s.Times[beg:end], s.Values[beg:end], ps)
+			st.Start = start
+			st.End = start + window
+			ana.Windows = append(ana.Windows, st)
+			beg = end
+		}
+	}
+	ana.Anomalies = append(ana.Anomalies, spikes(s, th)...)
+	ana.Anomalies = append(ana.Anomalies, stuck(s, th)...)
+	ana.Anomalies = append(ana.Anomalies, gaps(s, th)...)
+	sort.Stable(byStart(ana.Anomalies))
+	return ana
+}
+
+func stats(ts []time.Duration, vs []float64, ps []float64) Stats {
+	st := Stats{N: len(vs)}
+	if len(vs) == 0 {
+		return st
+	}
+	st.Start = ts[0]
+	st.End = ts[len(ts)-1]
+	st.Min = math.Inf(+1)
+	st.Max = math.Inf(-1)
+	sum := 0.0
+	for _, v := range vs {
+		st.Min = math.Min(st.Min, v)
+		st.Max = math.Max(st.Max, v)
+		sum += v
+	}
+	st.Mean = sum / float64(len(vs))
+	if len(vs) > 1 {
+		sum2 := 0.0
+		for _, v := range vs {
+			sum2 += (v - st.Mean) * (v - st.Mean)
+		}
+		st.StdDev = math.Sqrt(sum2 / float64(len(vs)-1))
+	}
+
+	sorted := append([]float64(nil), vs...)
+	sort.Float64s(sorted)
+	for _, p := range ps {
+		st.Percentiles = append(st.Percentiles, Percentile{P: p, Value: quantile(sorted, p/100)})
+	}
+	return st
+}
+
+// quantile returns the q-quantile of sorted values, interpolating linearly
+// between closest ranks.
+func quantile(sorted []float64, q float64) float64 {
+	if len(sorted) == 0 {
+		return math.NaN()
+	}
+	pos := q * float64(len(sorted)-1)
+	i := int(math.Floor(pos))
+	if i >= len(sorted)-1 {
+		return sorted[len(sorted)-1]
+	}
+	frac := pos - float64(i)
+	return sorted[i] + frac*(sorted[i+1]-sorted[i])
+}
+
+func drift(ts []time.Duration, vs []float64) Drift {
+	var d Drift
+	n := float64(len(vs))
+	if len(vs) < 2 {
+		return d
+	}
+	var sx, sy, sxx, sxy float64
+	for i, v := range vs {
+		x := (ts[i] - ts[0]).Hours()
+		sx += x
+		sy += v
+		sxx += x * x
+		sxy += x * v
+	}
+	den := n*sxx - sx*sx
+	if den == 0 {
+		return d
+	}
+	d.Slope = (n*sxy - sx*sy) / den
+	d.Intercept = (sy - d.Slope*sx) / n
+
+	mean := sy / n
+	var ssres, sstot float64
+	for i, v := range vs {
+		x := (ts[i] - ts[0]).Hours()
+		r := v - (d.Slope*x + d.Intercept)
+		ssres += r * r
+		sstot += (v - mean) * (v - mean)
+	}
+	if sstot > 0 {
+		d.R2 = 1 - ssres/sstot
+	}
+	return d
+}
+
+// spikes detects the samples deviating from the medians of both their left
+// and right neighbours, in the same direction, by more than th.Spike robust
+// standard deviations (estimated from the median absolute deviation of the
+// neighbours.) Requiring a deviation on both sides keeps steps from being
+// reported as spikes.
+// The standard deviation is never considered smaller than the resolution of
+// the series, to cope with quantized values.
+func spikes(s Series, th Thresholds) []Anomaly {
+	w := th.SpikeWidth
+	if th.Spike <= 0 || w <= 0 || len(s.Values) < 2*w+1 {
+		return nil
+	}
+	res := resolution(s.Values)
+	var (
+		out   []Anomaly
+		left  = make([]float64, 0, w)
+		right = make([]float64, 0, w)
+		dev   = make([]float64, 0, 2*w)
+	)
+	for i := w; i < len(s.Values)-w; i++ {
+		v := s.Values[i]
+		left = append(left[:0], s.Values[i-w:i]...)
+		right = append(right[:0], s.Values[i+1:i+w+1]...)
+		sort.Float64s(left)
+		sort.Float64s(right)
+		lmed := quantile(left, 0.5)
+		rmed := quantile(right, 0.5)
+
+		dev = dev[:0]
+		for _, x := range left {
+			dev = append(dev, math.Abs(x-lmed))
+		}
+		for _, x := range right {
+			dev = append(dev, math.Abs(x-rmed))
+		}
+		sort.Float64s(dev)
+		sigma := math.Max(1.4826*quantile(dev, 0.5), res)
+		if sigma == 0 {
+			continue
+		}
+		dl := (v - lmed) / sigma
+		dr := (v - rmed) / sigma
+		if dl*dr <= 0 {
+			continue
+		}
+		n := math.Min(math.Abs(dl), math.Abs(dr))
+		if n > th.Spike {
+			out = append(out, Anomaly{
+				Kind:  Spike,
+				Start: s.Times[i],
+				End:   s.Times[i],
+				Value: v,
+				Dev:   n,
+			})
+		}
+	}
+	return out
+}
+
+// resolution returns the smallest non-zero difference between consecutive
+// values.
+func resolution(vs []float64) float64 {
+	res := math.Inf(+1)
+	for i := 1; i < len(vs); i++ {
+		if d := math.Abs(vs[i] - vs[i-1]); d > 0 && d < res {
+			res = d
+		}
+	}
+	if math.IsInf(res, +1) {
+		return 0
+	}
+	return res
+}
+
+// stuck detects the runs of identical values lasting at least th.Stuck.
+func stuck(s Series, th Thresholds) []Anomaly {
+	if th.Stuck <= 0 {
+		return nil
+	}
+	var out []Anomaly
+	beg := 0
+	for i := 1; i <= len(s.Values); i++ {
+		if i < len(s.Values) && s.Values[i] == s.Values[beg] {
+			continue
+		}
+		if i-beg > 2 && s.Times[i-1]-s.Times[beg] >= th.Stuck {
+			out = append(out, Anomaly{
+				Kind:  Stuck,
+				Start: s.Times[beg],
+				End:   s.Times[i-1],
+				Value: s.Values[beg],
+			})
+		}
+		beg = i
+	}
+	return out
+}
+
+// gaps detects the intervals between consecutive samples longer than th.Gap
+// times the median sampling interval.
+func gaps(s Series, th Thresholds) []Anomaly {
+	if th.Gap <= 0 || len(s.Times) < 3 {
+		return nil
+	}
+	dts := make([]float64, len(s.Times)-1)
+	for i := range dts {
+		dts[i] = float64(s.Times[i+1] - s.Times[i])
+	}
+	sorted := append([]float64(nil), dts...)
+	sort.Float64s(sorted)
+	limit := th.Gap * quantile(sorted, 0.5)
+
+	var out []Anomaly
+	for i, dt := range dts {
+		if limit > 0 && dt > limit {
+			out = append(out, Anomaly{
+				Kind:  Gap,
+				Start: s.Times[i],
+				End:   s.Times[i+1],
+			})
+		}
+	}
+	return out
+}
+
+type byStart []Anomaly
+
+func (p byStart) Len() int           { return len(p) }
+func (p byStart) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+func (p byStart) Less(i, j int) bool { return p[i].Start < p[j].Start }
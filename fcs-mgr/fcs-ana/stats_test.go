@@ -0,0 +1,254 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newSeries returns a series of n samples, one every dt, with values f(i).
+func newSeries(n int, dt time.Duration, f func(i int) float64) Series {
+	var s Series
+	for i := 0; i < n; i++ {
+		s.Times = append(s.Times, time.Duration(i)*dt)
+		s.Values = append(s.Values, f(i))
+	}
+	return s
+}
+
+// baseline returns a quantized, noisy value around 20 which never repeats
+// from a sample to the next.
+func baseline(i int) float64 {
+	return 20 + 0.01*float64(i*7%5)
+}
+
+func kinds(as []Anomaly) []AnomalyKind {
+	var out []AnomalyKind
+	for _, a := range as {
+		out = append(out, a.Kind)
+	}
+	return out
+}
+
+func TestSpikes(t *testing.T) {
+	th := Thresholds{Spike: 5, SpikeWidth: 3}
+	s := newSeries(200, time.Second, func(i int) float64 {
+		v := baseline(i)
+		switch {
+		case i == 50:
+			v += 5
+		case i == 80:
+			v -= 2
+		case i >= 120:
+			v += 5 // step: not a spike
+		}
+		return v
+	})
+	// spikes within SpikeWidth samples of the ends are not detected.
+	s.Values[1] += 10
+	s.Values[198] += 10
+
+	got := spikes(s, th)
+	if len(got) != 2 {
+		t.Fatalf("invalid number of spikes: got=%d, want=2: %+v", len(got), got)
+	}
+	for i, want := range []struct {
+		at    time.Duration
+		value float64
+	}{
+		{50 * time.Second, baseline(50) + 5},
+		{80 * time.Second, baseline(80) - 2},
+	} {
+		a := got[i]
+		if a.Kind != Spike || a.Start != want.at || a.End != want.at || a.Value != want.value || a.Dev <= th.Spike {
+			t.Fatalf("spike #%d: got=%+v, want at=%v, value=%v", i, a, want.at, want.value)
+		}
+	}
+
+	// small deviations are below the threshold.
+	if got := spikes(s, Thresholds{Spike: 1000, SpikeWidth: 3}); len(got) != 0 {
+		t.Fatalf("unexpected spikes: %+v", got)
+	}
+	// disabled detection, or too short series.
+	if got := spikes(s, Thresholds{SpikeWidth: 3}); got != nil {
+		t.Fatalf("unexpected spikes: %+v", got)
+	}
+	short := newSeries(6, time.Second, baseline)
+	if got := spikes(short, th); got != nil {
+		t.Fatalf("unexpected spikes: %+v", got)
+	}
+	// constant series have no resolution: no spike.
+	flat := newSeries(20, time.Second, func(int) float64 { return 1 })
+	if got := spikes(flat, th); len(got) != 0 {
+		t.Fatalf("unexpected spikes: %+v", got)
+	}
+}
+
+func TestStuck(t *testing.T) {
+	th := Thresholds{Stuck: 5 * time.Second}
+	s := newSeries(60, time.Second, func(i int) float64 {
+		switch {
+		case 10 <= i && i < 20: // 9s
+			return 42
+		case 30 <= i && i < 34: // 3s: too short
+			return 43
+		case 40 <= i && i < 42: // 2 samples only
+			return 44
+		case i >= 50: // stuck until the end
+			return 45
+		}
+		return baseline(i)
+	})
+
+	got := stuck(s, th)
+	want := []Anomaly{
+		{Kind: Stuck, Start: 10 * time.Second, End: 19 * time.Second, Value: 42},
+		{Kind: Stuck, Start: 50 * time.Second, End: 59 * time.Second, Value: 45},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid stuck values:\ngot= %+v\nwant=%+v", got, want)
+	}
+
+	if got := stuck(s, Thresholds{Stuck: 3 * time.Second}); len(got) != 3 {
+		t.Fatalf("invalid number of stuck values: %+v", got)
+	}
+	if got := stuck(s, Thresholds{}); got != nil {
+		t.Fatalf("unexpected stuck values: %+v", got)
+	}
+}
+
+func TestGaps(t *testing.T) {
+	th := Thresholds{Gap: 3}
+	s := newSeries(30, time.Second, baseline)
+	for i := 10; i < len(s.Times); i++ {
+		s.Times[i] += 9 * time.Second
+	}
+	for i := 20; i < len(s.Times); i++ {
+		s.Times[i] += 2 * time.Second // 3s: not a gap
+	}
+
+	got := gaps(s, th)
+	want := []Anomaly{{Kind: Gap, Start: 9 * time.Second, End: 19 * time.Second}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid gaps:\ngot= %+v\nwant=%+v", got, want)
+	}
+
+	if got := gaps(s, Thresholds{Gap: 2}); len(got) != 2 {
+		t.Fatalf("invalid number of gaps: %+v", got)
+	}
+	if got := gaps(s, Thresholds{}); got != nil {
+		t.Fatalf("unexpected gaps: %+v", got)
+	}
+	if got := gaps(newSeries(2, time.Hour, baseline), th); got != nil {
+		t.Fatalf("unexpected gaps: %+v", got)
+	}
+}
+
+func TestDrift(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		s    Series
+		want Drift
+		r2   float64 // minimum R2, if not exact
+	}{
+		{
+			name: "linear",
+			s:    newSeries(61, time.Minute, func(i int) float64 { return 5 + 2*float64(i)/60 }),
+			want: Drift{Slope: 2, Intercept: 5, R2: 1},
+		},
+		{
+			name: "decreasing",
+			s:    newSeries(25, time.Hour, func(i int) float64 { return 100 - 0.5*float64(i) }),
+			want: Drift{Slope: -0.5, Intercept: 100, R2: 1},
+		},
+		{
+			name: "noisy",
+			s: newSeries(121, time.Minute, func(i int) float64 {
+				return 5 + 2*float64(i)/60 + 0.1*float64(i%2*2-1)
+			}),
+			want: Drift{Slope: 2, Intercept: 5},
+			r2:   0.99,
+		},
+		{
+			name: "constant",
+			s:    newSeries(10, time.Minute, func(int) float64 { return 3 }),
+			want: Drift{Slope: 0, Intercept: 3, R2: 0},
+		},
+		{
+			name: "single sample",
+			s:    newSeries(1, time.Minute, func(int) float64 { return 3 }),
+		},
+		{
+			name: "same time",
+			s:    newSeries(3, 0, func(i int) float64 { return float64(i) }),
+		},
+	} {
+		got := drift(tc.s.Times, tc.s.Values)
+		const eps = 0.01
+		switch {
+		case math.Abs(got.Slope-tc.want.Slope) > eps,
+			math.Abs(got.Intercept-tc.want.Intercept) > eps:
+			t.Fatalf("%s: invalid drift: got=%+v, want=%+v", tc.name, got, tc.want)
+		case tc.r2 > 0 && (got.R2 < tc.r2 || got.R2 >= 1):
+			t.Fatalf("%s: invalid R2: got=%v, want>=%v", tc.name, got.R2, tc.r2)
+		case tc.r2 == 0 && math.Abs(got.R2-tc.want.R2) > 1e-9:
+			t.Fatalf("%s: invalid R2: got=%v, want=%v", tc.name, got.R2, tc.want.R2)
+		}
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	s := newSeries(100, time.Second, func(i int) float64 {
+		switch {
+		case i == 30:
+			return 30
+		case 60 <= i && i < 70:
+			return 21
+		}
+		return baseline(i)
+	})
+	s.Times[90] += 20 * time.Second
+
+	ana := analyze(s, 30*time.Second, []float64{50, 100}, Thresholds{
+		Spike: 5, SpikeWidth: 3, Stuck: 5 * time.Second, Gap: 3,
+	})
+	if got, want := kinds(ana.Anomalies), []AnomalyKind{Spike, Stuck, Gap}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid anomalies: got=%v, want=%v", got, want)
+	}
+	if ana.Overall.N != 100 || ana.Overall.Max != 30 || ana.Overall.Min != 20 {
+		t.Fatalf("invalid overall stats: %+v", ana.Overall)
+	}
+	if p := ana.Overall.Percentiles; len(p) != 2 || p[1].Value != 30 {
+		t.Fatalf("invalid percentiles: %+v", p)
+	}
+	// windows of 30s: [0,30), [30,60), [60,90), [90+20,...)
+	var ns []int
+	for _, w := range ana.Windows {
+		ns = append(ns, w.N)
+	}
+	if want := []int{30, 30, 30, 10}; !reflect.DeepEqual(ns, want) {
+		t.Fatalf("invalid windows: got=%v, want=%v", ns, want)
+	}
+}
+
+func TestQuantile(t *testing.T) {
+	sorted := []float64{1, 2, 3, 4}
+	for _, tc := range []struct {
+		q    float64
+		want float64
+	}{
+		{0, 1},
+		{0.5, 2.5},
+		{1, 4},
+		{1.5, 4},
+		{1.0 / 3, 2},
+	} {
+		if got := quantile(sorted, tc.q); math.Abs(got-tc.want) > 1e-12 {
+			t.Fatalf("quantile(%v): got=%v, want=%v", tc.q, got, tc.want)
+		}
+	}
+	if got := quantile(nil, 0.5); !math.IsNaN(got) {
+		t.Fatalf("quantile of empty values: got=%v, want=NaN", got)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+// logger writes the fcs-can log file.
+//
+// The log file follows the format read by fcs-ana: lines starting with '#'
+// are comments, the other lines are JSON encoded ADC snapshots (Event.)
+type logger struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func newLogger(w io.Writer) *logger {
+	return &logger{w: w}
+}
+
+// Printf writes a timestamped comment line.
+func (l *logger) Printf(format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fmt.Fprintf(l.w, "# %s %s\n", now(), fmt.Sprintf(format, args...))
+}
+
+// Event writes an ADC snapshot.
+func (l *logger) Event(evt Event) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return json.NewEncoder(l.w).Encode(evt)
+}
+
+// Event is a snapshot of the temperature, pressure and hygrometry channels of
+// an ADC module, as read by fcs-ana.
+type Event struct {
+	Time       string `json:"time"`
+	Temp       Data   `json:"temp"`
+	Pressure   Data   `json:"pressure"`
+	Hygrometry Data   `json:"hygrometry"`
+}
+
+// Data holds the objects of an ADC channel.
+type Data struct {
+	Acc    uint8 // 0x2100
+	Avg    uint8 // 0x2101
+	Offset int32 // 0x6431
+	Gain   int32 // 0x6432
+	Raw    int16 // 0x6404
+	Value  int16 // 0x6401
+}
+
+// MarshalJSON encodes Data as a string of hexadecimal values, as fcs-ana
+// expects.
+// Signed registers are encoded as their unsigned two's complement values.
+func (d Data) MarshalJSON() ([]byte, error) {
+	return json.Marshal(fmt.Sprintf(
+		"0x%x 0x%x 0x%x 0x%x 0x%x 0x%x",
+		d.Acc, d.Avg,
+		uint32(d.Offset), uint32(d.Gain),
+		uint16(d.Raw), uint16(d.Value),
+	))
+}
+
+// tracer logs the lines exchanged with the c-wrapper.
+type tracer struct {
+	net.Conn
+	log *logger
+	in  lineBuffer
+	out lineBuffer
+}
+
+func newTracer(conn net.Conn, log *logger) *tracer {
+	return &tracer{
+		Conn: conn,
+		log:  log,
+		in:   lineBuffer{dir: "<"},
+		out:  lineBuffer{dir: ">"},
+	}
+}
+
+func (t *tracer) Read(p []byte) (int, error) {
+	n, err := t.Conn.Read(p)
+	t.in.write(t.log, p[:n])
+	return n, err
+}
+
+func (t *tracer) Write(p []byte) (int, error) {
+	t.out.write(t.log, p)
+	return t.Conn.Write(p)
+}
+
+// lineBuffer accumulates bytes until complete lines can be logged.
+type lineBuffer struct {
+	mu  sync.Mutex
+	dir string
+	buf []byte
+}
+
+func (lb *lineBuffer) write(log *logger, p []byte) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	lb.buf = append(lb.buf, p...)
+	for {
+		i := bytes.IndexByte(lb.buf, '\n')
+		if i < 0 {
+			return
+		}
+		line := bytes.TrimRight(lb.buf[:i], "\r")
+		if len(line) > 0 {
+			log.Printf("%s %s", lb.dir, line)
+		}
+		lb.buf = lb.buf[i+1:]
+	}
+}
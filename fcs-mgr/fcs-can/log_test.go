@@ -0,0 +1,30 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestDataMarshalJSON(t *testing.T) {
+	for _, tc := range []struct {
+		data Data
+		want string
+	}{
+		{
+			data: Data{Acc: 1, Avg: 2, Offset: 3, Gain: 4, Raw: 5, Value: 6},
+			want: `"0x1 0x2 0x3 0x4 0x5 0x6"`,
+		},
+		{
+			data: Data{Acc: 0xff, Avg: 0x80, Offset: -300, Gain: -1, Raw: -5, Value: -0x8000},
+			want: `"0xff 0x80 0xfffffed4 0xffffffff 0xfffb 0x8000"`,
+		},
+	} {
+		got, err := json.Marshal(tc.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tc.want {
+			t.Fatalf("invalid encoding:\ngot= %s\nwant=%s", got, tc.want)
+		}
+	}
+}
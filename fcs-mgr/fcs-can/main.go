@@ -0,0 +1,170 @@
+// fcs-can is an interactive client for the PC-104 c-wrapper.
+//
+// fcs-can talks to the c-wrapper directly, independently of the FCS
+// subsystem, to inspect and drive the CANopen nodes: scan the bus, read and
+// write SDO objects, watch PDOs and issue NMT commands.
+// With an EDS or DCF file, objects can be addressed by name and their values
+// are decoded according to their data type.
+// Commands are read from the terminal or from a script file.
+//
+// All the traffic with the c-wrapper is logged into a file which can be
+// analyzed with fcs-ana: protocol lines are recorded as comments and the ADC
+// snapshots taken with the 'adc' command as fcs-ana events.
+//
+// ex:
+//
+//	$ fcs-can -addr=pc104:50000
+//	$ fcs-can -listen=:50000 -o=bench.log
+//	$ fcs-can -addr=pc104:50000 -eds=adc.eds
+//	$ fcs-can -addr=pc104:50000 script.txt
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"time"
+
+	"github.com/sbinet/lsst-ccs/fcs-mgr/cwrapper"
+	"github.com/sbinet/lsst-ccs/fcs-mgr/eds"
+)
+
+var (
+	addr    = flag.String("addr", "127.0.0.1:50000", "<ip>:<port> of the c-wrapper to connect to")
+	listen  = flag.String("listen", "", "<ip>:<port> to listen to, waiting for the c-wrapper to connect")
+	oname   = flag.String("o", "fcs-can.log", "path to the log file (empty to disable logging)")
+	edsName = flag.String("eds", "", "path to the EDS/DCF file describing the objects of the nodes")
+	timeout = flag.Duration("timeout", cwrapper.DefaultTimeout, "timeout waiting for a reply of the c-wrapper")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: fcs-can [options] [script-file]\n\nex:\n")
+		fmt.Fprintf(os.Stderr, " $ fcs-can -addr=pc104:50000\n")
+		fmt.Fprintf(os.Stderr, " $ fcs-can -listen=:50000 script.txt\n\noptions:\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	log.SetPrefix("fcs-can: ")
+	log.SetFlags(0)
+
+	var (
+		in     io.Reader = os.Stdin
+		script           = false
+	)
+	switch flag.NArg() {
+	case 0:
+	case 1:
+		f, err := os.Open(flag.Arg(0))
+		if err != nil {
+			log.Fatalf("could not open script: %v\n", err)
+		}
+		defer f.Close()
+		in = f
+		script = true
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	logw := newLogger(ioutil.Discard)
+	if *oname != "" {
+		f, err := os.OpenFile(*oname, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			log.Fatalf("could not create log file: %v\n", err)
+		}
+		defer f.Close()
+		logw = newLogger(f)
+	}
+
+	var dict *eds.Dictionary
+	if *edsName != "" {
+		var err error
+		dict, err = eds.Open(*edsName)
+		if err != nil {
+			log.Fatalf("could not load object dictionary: %v\n", err)
+		}
+	}
+
+	conn, err := connect()
+	if err != nil {
+		log.Fatalf("could not connect to c-wrapper: %v\n", err)
+	}
+	logw.Printf("session started (c-wrapper=%s)", conn.RemoteAddr())
+	defer logw.Printf("session ended")
+
+	events := make(chan cwrapper.Message, 16)
+	client := cwrapper.NewClient(newTracer(conn, logw), events)
+	client.Timeout = *timeout
+	defer client.Close()
+
+	go func() {
+		for evt := range events {
+			fmt.Printf("\n%s\n", describe(evt))
+		}
+	}()
+
+	sh := &shell{
+		client: client,
+		dict:   dict,
+		log:    logw,
+		out:    os.Stdout,
+		prompt: !script && isTerminal(os.Stdin),
+	}
+	err = sh.run(bufio.NewScanner(in), script)
+	if err != nil {
+		client.Close()
+		logw.Printf("session aborted: %v", err)
+		log.Fatalf("%v\n", err)
+	}
+}
+
+// connect connects to the c-wrapper, or waits for the c-wrapper to connect
+// when -listen is set.
+func connect() (net.Conn, error) {
+	if *listen == "" {
+		return net.Dial("tcp", *addr)
+	}
+
+	ln, err := net.Listen("tcp", *listen)
+	if err != nil {
+		return nil, err
+	}
+	defer ln.Close()
+	log.Printf("waiting for c-wrapper on %s...\n", ln.Addr())
+	return ln.Accept()
+}
+
+// describe returns a human readable description of an unsolicited message.
+func describe(msg cwrapper.Message) string {
+	switch msg := msg.(type) {
+	case cwrapper.Boot:
+		return fmt.Sprintf("boot-up: node=0x%x", msg.Node)
+	case cwrapper.Emcy:
+		return fmt.Sprintf(
+			"emergency: node=0x%x code=0x%04x register=0x%02x data=0x%x",
+			msg.Node, msg.Code, msg.Register, msg.Data,
+		)
+	}
+	return cwrapper.Encode(msg)
+}
+
+// isTerminal returns whether f is attached to a terminal.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+// now returns the current time, as recorded in the log file.
+func now() string {
+	return time.Now().UTC().Format(time.RFC3339Nano)
+}
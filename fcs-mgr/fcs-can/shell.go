@@ -0,0 +1,491 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sbinet/lsst-ccs/fcs-mgr/cwrapper"
+	"github.com/sbinet/lsst-ccs/fcs-mgr/eds"
+)
+
+// shell runs the fcs-can commands.
+type shell struct {
+	client *cwrapper.Client
+	dict   *eds.Dictionary // object dictionary of the nodes, may be nil
+	log    *logger
+	out    io.Writer
+	prompt bool // whether to display a prompt
+}
+
+// command is a fcs-can command.
+type command struct {
+	Name  string
+	Args  string
+	Help  string
+	Run   func(sh *shell, args []string) error
+	NArgs [2]int // min and max number of arguments
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"help", "", "display this help", (*shell).help, [2]int{0, 0}},
+		{"scan", "[first [last]]", "list the nodes answering on the bus", (*shell).scan, [2]int{0, 2}},
+		{"info", "node", "display the identity of a node", (*shell).info, [2]int{1, 1}},
+		{"read", "node index [subindex] | node name", "read a SDO object", (*shell).read, [2]int{2, 3}},
+		{"write", "node index subindex size value | node name value", "write a SDO object of size bytes", (*shell).write, [2]int{3, 5}},
+		{"sync", "", "send a SYNC and display the PDOs", (*shell).sync, [2]int{0, 0}},
+		{"watch", "[period [count]]", "send a SYNC every period and display the PDOs", (*shell).watch, [2]int{0, 2}},
+		{"nmt", "start|stop|preop|reset|resetcomm node|all", "send a NMT command", (*shell).nmt, [2]int{2, 2}},
+		{"adc", "node [period [count]]", "log snapshots of the ADC channels in fcs-ana format", (*shell).adc, [2]int{1, 3}},
+		{"sleep", "duration", "pause the execution of a script", (*shell).sleep, [2]int{1, 1}},
+		{"quit", "", "close the connection and exit", nil, [2]int{0, 0}},
+	}
+}
+
+// run executes the commands read from scan.
+// In script mode, run stops at the first error.
+func (sh *shell) run(scan *bufio.Scanner, script bool) error {
+	lineno := 0
+	for {
+		if sh.prompt {
+			fmt.Fprintf(sh.out, "fcs-can> ")
+		}
+		if !scan.Scan() {
+			if sh.prompt {
+				fmt.Fprintf(sh.out, "\n")
+			}
+			return scan.Err()
+		}
+		lineno++
+		line := scan.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		args := strings.Fields(line)
+		if len(args) == 0 {
+			continue
+		}
+		if args[0] == "quit" || args[0] == "exit" {
+			return nil
+		}
+
+		sh.log.Printf("$ %s", strings.Join(args, " "))
+		err := sh.exec(args)
+		if err == nil {
+			continue
+		}
+		sh.log.Printf("error: %v", err)
+		if script {
+			return fmt.Errorf("line %d: %v", lineno, err)
+		}
+		fmt.Fprintf(sh.out, "error: %v\n", err)
+	}
+}
+
+// exec executes a command.
+func (sh *shell) exec(args []string) error {
+	for _, cmd := range commands {
+		if cmd.Name != args[0] {
+			continue
+		}
+		n := len(args) - 1
+		if n < cmd.NArgs[0] || n > cmd.NArgs[1] {
+			return fmt.Errorf("usage: %s %s", cmd.Name, cmd.Args)
+		}
+		return cmd.Run(sh, args[1:])
+	}
+	return fmt.Errorf("unknown command %q (try 'help')", args[0])
+}
+
+func (sh *shell) help(args []string) error {
+	fmt.Fprintf(sh.out, "commands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(sh.out, "  %-10s %-50s %s\n", cmd.Name, cmd.Args, cmd.Help)
+	}
+	fmt.Fprintf(sh.out, "\nnumbers are decimal, or hexadecimal when prefixed with 0x.\n")
+	fmt.Fprintf(sh.out, "object indices and sub-indices are always hexadecimal, as in EDS files.\n")
+	if sh.dict != nil {
+		fmt.Fprintf(sh.out, "objects can be addressed by name, or as index:subindex (hexadecimal.)\n")
+	}
+	return nil
+}
+
+func (sh *shell) scan(args []string) error {
+	first, last := uint64(1), uint64(127)
+	var err error
+	if len(args) > 0 {
+		first, err = parseUint(args[0], 7)
+		if err != nil {
+			return err
+		}
+		last = first
+	}
+	if len(args) > 1 {
+		last, err = parseUint(args[1], 7)
+		if err != nil {
+			return err
+		}
+	}
+
+	// do not wait for too long for absent nodes.
+	timeout := sh.client.Timeout
+	defer func() { sh.client.Timeout = timeout }()
+	if sh.client.Timeout > 200*time.Millisecond {
+		sh.client.Timeout = 200 * time.Millisecond
+	}
+
+	found := 0
+	for id := first; id <= last; id++ {
+		info, err := sh.client.Info(uint8(id))
+		if err != nil {
+			continue
+		}
+		found++
+		sh.printInfo(info)
+	}
+	fmt.Fprintf(sh.out, "found %d node(s)\n", found)
+	return nil
+}
+
+func (sh *shell) info(args []string) error {
+	node, err := parseNode(args[0])
+	if err != nil {
+		return err
+	}
+	info, err := sh.client.Info(node)
+	if err != nil {
+		return err
+	}
+	sh.printInfo(info)
+	return nil
+}
+
+func (sh *shell) printInfo(info cwrapper.InfoReply) {
+	fmt.Fprintf(
+		sh.out,
+		"node=0x%02x type=0x%08x vendor=0x%08x product=0x%08x revision=0x%08x serial=0x%08x\n",
+		info.Node, info.DeviceType, info.Vendor, info.Product, info.Revision, info.Serial,
+	)
+}
+
+func (sh *shell) read(args []string) error {
+	node, key, obj, err := sh.object(args)
+	if err != nil {
+		return err
+	}
+	v, err := sh.client.ReadSDO(node, key.Index, key.SubIndex)
+	if err != nil {
+		return err
+	}
+	if obj == nil {
+		fmt.Fprintf(sh.out, "%v = 0x%x (%d)\n", key, v, v)
+		return nil
+	}
+	fmt.Fprintf(sh.out, "%s (%v) = 0x%x", obj.FullName(), key, v)
+	if val, err := obj.Decode(uint64(v)); err == nil {
+		fmt.Fprintf(sh.out, " (%v %v)", obj.DataType, val)
+	}
+	if obj.Scaling != (eds.Scaling{}) {
+		if phys, err := obj.Physical(uint64(v)); err == nil {
+			fmt.Fprintf(sh.out, " = %.6g %s", phys, obj.Scaling.Unit)
+		}
+	}
+	fmt.Fprintf(sh.out, "\n")
+	return nil
+}
+
+func (sh *shell) write(args []string) error {
+	var (
+		node uint8
+		key  eds.Key
+		size uint64
+		obj  *eds.Object
+		err  error
+	)
+	switch len(args) {
+	case 3:
+		node, key, obj, err = sh.object(args[:2])
+		if err != nil {
+			return err
+		}
+		if obj == nil {
+			return fmt.Errorf("usage: write node index subindex size value")
+		}
+		size = uint64(obj.DataType.Size())
+	case 5:
+		node, key, obj, err = sh.object(args[:3])
+		if err != nil {
+			return err
+		}
+		size, err = parseUint(args[3], 8)
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("usage: write node index subindex size value")
+	}
+	switch size {
+	case 1, 2, 4:
+	default:
+		return fmt.Errorf("invalid object size %d (1, 2 or 4)", size)
+	}
+	if obj != nil && !obj.Access.Writable() {
+		return fmt.Errorf("object %s (%v) is not writable (%v)", obj.FullName(), key, obj.Access)
+	}
+	v, err := parseValue(args[len(args)-1])
+	if err != nil {
+		return err
+	}
+	return sh.client.WriteSDO(node, key.Index, key.SubIndex, uint8(size), v)
+}
+
+func (sh *shell) sync(args []string) error {
+	pdos, err := sh.client.Sync()
+	sh.printPDOs(pdos)
+	return err
+}
+
+func (sh *shell) printPDOs(pdos []cwrapper.PDO) {
+	for _, pdo := range pdos {
+		fmt.Fprintf(sh.out, "pdo: node=0x%02x cobid=0x%03x data=[% x]\n", pdo.Node(), pdo.COBID, pdo.Data)
+	}
+}
+
+func (sh *shell) watch(args []string) error {
+	period, count, err := parsePeriod(args)
+	if err != nil {
+		return err
+	}
+	return sh.repeat(period, count, func() error {
+		pdos, err := sh.client.Sync()
+		fmt.Fprintf(sh.out, "--- %s\n", time.Now().Format("15:04:05.000"))
+		sh.printPDOs(pdos)
+		return err
+	})
+}
+
+var nmtCommands = map[string]cwrapper.NMTCommand{
+	"start":     cwrapper.NMTStart,
+	"stop":      cwrapper.NMTStop,
+	"preop":     cwrapper.NMTPreOperational,
+	"reset":     cwrapper.NMTResetNode,
+	"resetcomm": cwrapper.NMTResetComm,
+}
+
+func (sh *shell) nmt(args []string) error {
+	cmd, ok := nmtCommands[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown NMT command %q", args[0])
+	}
+	node := uint8(cwrapper.Broadcast)
+	if args[1] != "all" {
+		var err error
+		node, err = parseNode(args[1])
+		if err != nil {
+			return err
+		}
+	}
+	return sh.client.NMT(cmd, node)
+}
+
+// adcChannels are the sub-indices of the temperature, pressure and
+// hygrometry channels of the ADC module.
+var adcChannels = [3]uint8{1, 2, 3}
+
+func (sh *shell) adc(args []string) error {
+	node, err := parseNode(args[0])
+	if err != nil {
+		return err
+	}
+	period, count, err := parsePeriod(args[1:])
+	if err != nil {
+		return err
+	}
+	if len(args) == 1 {
+		count = 1
+	}
+	return sh.repeat(period, count, func() error {
+		var evt Event
+		evt.Time = now()
+		for i, data := range []*Data{&evt.Temp, &evt.Pressure, &evt.Hygrometry} {
+			err := sh.readADC(node, adcChannels[i], data)
+			if err != nil {
+				return err
+			}
+		}
+		fmt.Fprintf(
+			sh.out, "temp=0x%04x pressure=0x%04x hygrometry=0x%04x\n",
+			uint16(evt.Temp.Value), uint16(evt.Pressure.Value), uint16(evt.Hygrometry.Value),
+		)
+		return sh.log.Event(evt)
+	})
+}
+
+// readADC reads the objects of the ADC channel sub of node.
+func (sh *shell) readADC(node, sub uint8, data *Data) error {
+	for _, obj := range []struct {
+		index uint16
+		set   func(v uint32)
+	}{
+		{0x2100, func(v uint32) { data.Acc = uint8(v) }},
+		{0x2101, func(v uint32) { data.Avg = uint8(v) }},
+		{0x6431, func(v uint32) { data.Offset = int32(v) }},
+		{0x6432, func(v uint32) { data.Gain = int32(v) }},
+		{0x6404, func(v uint32) { data.Raw = int16(v) }},
+		{0x6401, func(v uint32) { data.Value = int16(v) }},
+	} {
+		v, err := sh.client.ReadSDO(node, obj.index, sub)
+		if err != nil {
+			return err
+		}
+		obj.set(v)
+	}
+	return nil
+}
+
+func (sh *shell) sleep(args []string) error {
+	d, err := time.ParseDuration(args[0])
+	if err != nil {
+		return err
+	}
+	time.Sleep(d)
+	return nil
+}
+
+// repeat runs f every period, count times (or until interrupted if count
+// is zero.)
+func (sh *shell) repeat(period time.Duration, count int, f func() error) error {
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, os.Interrupt)
+	defer signal.Stop(sigc)
+
+	tick := time.NewTicker(period)
+	defer tick.Stop()
+
+	for i := 0; count == 0 || i < count; i++ {
+		if i > 0 {
+			select {
+			case <-tick.C:
+			case <-sigc:
+				return nil
+			}
+		}
+		err := f()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parsePeriod parses the optional [period [count]] arguments.
+func parsePeriod(args []string) (time.Duration, int, error) {
+	period := time.Second
+	count := 0
+	var err error
+	if len(args) > 0 {
+		period, err = time.ParseDuration(args[0])
+		if err != nil {
+			return 0, 0, err
+		}
+		if period <= 0 {
+			return 0, 0, fmt.Errorf("invalid period %v", period)
+		}
+	}
+	if len(args) > 1 {
+		count, err = strconv.Atoi(args[1])
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid count %q", args[1])
+		}
+	}
+	return period, count, nil
+}
+
+// object parses the "node index [subindex]" or "node name" arguments.
+// The returned object is nil if no object dictionary was loaded.
+func (sh *shell) object(args []string) (uint8, eds.Key, *eds.Object, error) {
+	var key eds.Key
+	node, err := parseNode(args[0])
+	if err != nil {
+		return 0, key, nil, err
+	}
+
+	index, err := parseHex(args[1], 16)
+	switch {
+	case err == nil:
+		key.Index = uint16(index)
+		if len(args) > 2 {
+			sub, err := parseHex(args[2], 8)
+			if err != nil {
+				return 0, key, nil, err
+			}
+			key.SubIndex = uint8(sub)
+		}
+	case sh.dict == nil:
+		return 0, key, nil, err
+	case len(args) > 2:
+		return 0, key, nil, fmt.Errorf("invalid object index %q", args[1])
+	default:
+		obj, err := sh.dict.Find(args[1])
+		if err != nil {
+			return 0, key, nil, err
+		}
+		key = obj.Key()
+	}
+
+	if sh.dict == nil {
+		return node, key, nil, nil
+	}
+	obj, _ := sh.dict.Object(key.Index, key.SubIndex)
+	return node, key, obj, nil
+}
+
+func parseNode(s string) (uint8, error) {
+	v, err := parseUint(s, 7)
+	if err != nil {
+		return 0, err
+	}
+	if v == 0 {
+		return 0, fmt.Errorf("invalid node ID 0")
+	}
+	return uint8(v), nil
+}
+
+func parseUint(s string, bits int) (uint64, error) {
+	v, err := strconv.ParseUint(s, 0, bits)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q (%d bits)", s, bits)
+	}
+	return v, nil
+}
+
+// parseHex parses a hexadecimal number, with an optional 0x prefix, like
+// the object indices and sub-indices of EDS files.
+func parseHex(s string, bits int) (uint64, error) {
+	v, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(s), "0x"), 16, bits)
+	if err != nil {
+		return 0, fmt.Errorf("invalid hexadecimal number %q (%d bits)", s, bits)
+	}
+	return v, nil
+}
+
+// parseValue parses a 32b value, which may be negative.
+func parseValue(s string) (uint32, error) {
+	if strings.HasPrefix(s, "-") {
+		v, err := strconv.ParseInt(s, 0, 32)
+		if err != nil {
+			return 0, fmt.Errorf("invalid value %q", s)
+		}
+		return uint32(int32(v)), nil
+	}
+	v, err := parseUint(s, 32)
+	return uint32(v), err
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestShellObject(t *testing.T) {
+	sh := &shell{}
+	for _, tc := range []struct {
+		args  []string
+		node  uint8
+		index uint16
+		sub   uint8
+	}{
+		{[]string{"1", "6401"}, 1, 0x6401, 0},
+		{[]string{"0x41", "6401", "2"}, 0x41, 0x6401, 2},
+		{[]string{"2", "0x6401", "0x10"}, 2, 0x6401, 0x10},
+		{[]string{"3", "1A00", "a"}, 3, 0x1a00, 0xa},
+	} {
+		node, key, _, err := sh.object(tc.args)
+		if err != nil {
+			t.Fatalf("%q: %v", tc.args, err)
+		}
+		if node != tc.node || key.Index != tc.index || key.SubIndex != tc.sub {
+			t.Fatalf("%q: got node=0x%x key=%v", tc.args, node, key)
+		}
+	}
+
+	for _, args := range [][]string{
+		{"1", "10000"},
+		{"1", "6401", "100"},
+		{"1", "name"},
+		{"0", "6401"},
+	} {
+		_, _, _, err := sh.object(args)
+		if err == nil {
+			t.Fatalf("%q: expected an error", args)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+// fcs-msr-daq acquires the records of MSR data loggers in real time, over a
+// serial device, and publishes them to the CCS trending database (localdb)
+// and/or to a local file.
+//
+// The logger is expected to stream its records in the format of the CSV
+// files exported from the MSR loggers (see package msr): a header describing
+// the channels, followed by data lines. Lines received before the start of
+// the first header (its "*CREATOR" line) are discarded, and headers may be
+// repeated. Data lines following an invalid header are skipped up to the next
+// valid header. The proprietary binary protocol of the MSR loggers is not
+// supported.
+//
+// The samples are decoded into the channels of the header, and published:
+//   - to the file given with -o, as JSON lines (appended),
+//   - to the trending database when -user is given, with the same datadesc
+//     entries as fcs-msr-ingest.
+//
+// The device is reopened after read errors (e.g. when the logger is
+// unplugged.) fcs-msr-sim simulates a logger on a pseudo-terminal.
+//
+// ex:
+//
+//	$ fcs-msr-daq -dev=/dev/ttyUSB0 -baud=9600 -o=msr.jsonl
+//	$ fcs-msr-daq -dev=/dev/ttyUSB0 -user=ccs -password=xxx -tz=Europe/Paris
+package main
+
+import (
+	"bufio"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sbinet/lsst-ccs/fcs-mgr/localdb"
+	"github.com/sbinet/lsst-ccs/fcs-mgr/msr"
+	"github.com/sbinet/lsst-ccs/fcs-mgr/serial"
+)
+
+var (
+	dev    = flag.String("dev", "", "path to the serial device of the MSR logger")
+	baud   = flag.Int("baud", 9600, "baud rate of the serial device")
+	retry  = flag.Duration("retry", 5*time.Second, "delay before reopening the device after an error")
+	tz     = flag.String("tz", "UTC", "time zone of the clock of the MSR logger")
+	oname  = flag.String("o", "", "path to a file where to append the samples as JSON lines")
+	user   = flag.String("user", "", "db user name (empty: do not publish to the trending database)")
+	pass   = flag.String("password", "", "db user password")
+	dbname = flag.String("db", "ccs", "db name")
+)
+
+// sink publishes the rows of a recording.
+type sink interface {
+	publish(cols []msr.Column, row msr.Row) error
+}
+
+func main() {
+	flag.Parse()
+
+	if *dev == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	loc, err := time.LoadLocation(*tz)
+	if err != nil {
+		log.Fatalf("invalid time zone %q: %v\n", *tz, err)
+	}
+
+	var sinks []sink
+	if *oname != "" {
+		f, err := os.OpenFile(*oname, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			log.Fatalf("error opening output file: %v\n", err)
+		}
+		defer f.Close()
+		sinks = append(sinks, fileSink{f})
+	}
+	if *user != "" {
+		log.Printf("connect to mysql db...\n")
+		db, err := localdb.Open(*user + ":" + *pass + "@/" + *dbname)
+		if err != nil {
+			log.Fatalf("error opening db connection: %v\n", err)
+		}
+		defer db.Close()
+		sinks = append(sinks, &dbSink{db: db, ids: make(map[string]int64)})
+	}
+	if len(sinks) == 0 {
+		log.Printf("no output file nor database: samples are only logged\n")
+	}
+
+	for {
+		err := acquire(*dev, loc, sinks)
+		log.Printf("error acquiring from [%s]: %v\n", *dev, err)
+		time.Sleep(*retry)
+	}
+}
+
+// acquire reads the records of the logger on the device dev and publishes
+// them, until an error occurs.
+func acquire(dev string, loc *time.Location, sinks []sink) error {
+	f, err := serial.Open(dev, *baud)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	log.Printf("reading MSR logger on [%s]...\n", dev)
+
+	// discard lines up to the start of the first header: the device may
+	// be opened in the middle of a header.
+	br := bufio.NewReader(f)
+	var line string
+	for line != "*CREATOR" {
+		line, err = br.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		line = strings.TrimSpace(line)
+	}
+
+	r, err := msr.NewReaderIn(io.MultiReader(strings.NewReader(line+"\n"), br), loc)
+	if err != nil {
+		return err
+	}
+	log.Printf("logger: %s (%d channels)\n", r.Creator, len(r.Columns))
+
+	for {
+		row, err := r.Read()
+		if err != nil {
+			if _, ok := err.(*msr.Error); ok {
+				log.Printf("skipping record: %v\n", err)
+				continue
+			}
+			return err
+		}
+		if len(sinks) == 0 {
+			log.Printf("data: %v %v\n", row.Time, row.Data)
+		}
+		for _, s := range sinks {
+			err = s.publish(r.Columns, row)
+			if err != nil {
+				log.Printf("error publishing record: %v\n", err)
+			}
+		}
+	}
+}
+
+// fileSink appends the samples to a file, as JSON lines.
+type fileSink struct {
+	w io.Writer
+}
+
+func (s fileSink) publish(cols []msr.Column, row msr.Row) error {
+	return msr.WriteJSON(s.w, &msr.File{Columns: cols, Rows: []msr.Row{row}})
+}
+
+// dbSink inserts the samples into the trending database.
+type dbSink struct {
+	db  *localdb.DB
+	ids map[string]int64 // datadesc ids, by channel name
+}
+
+func (s *dbSink) publish(cols []msr.Column, row msr.Row) error {
+	f := &msr.File{Columns: cols, Rows: []msr.Row{row}}
+	samples := make(map[int64][]localdb.Sample, len(cols))
+	for i, col := range cols {
+		ser := f.Series(i)
+		if len(ser.Values) == 0 {
+			continue
+		}
+		name := col.Source() + "/" + col.Name
+		id, ok := s.ids[name]
+		if !ok {
+			var created bool
+			var err error
+			id, created, err = s.db.DataDesc(name, col.Source(), "msr")
+			if err != nil {
+				return err
+			}
+			if created {
+				log.Printf("created datadesc %q\n", name)
+			}
+			s.ids[name] = id
+		}
+		samples[id] = append(samples[id], localdb.Sample{Time: ser.Times[0], Value: ser.Values[0]})
+	}
+	if len(samples) == 0 {
+		return nil
+	}
+	// insert the samples of the row at once.
+	return s.db.InsertAll(samples)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sbinet/lsst-ccs/fcs-mgr/msr"
+	"github.com/sbinet/lsst-ccs/fcs-mgr/serial"
+)
+
+// chanSink sends the published samples, as "<channel>=<value>" strings.
+type chanSink chan string
+
+func (s chanSink) publish(cols []msr.Column, row msr.Row) error {
+	var vs []string
+	for i, col := range cols {
+		vs = append(vs, fmt.Sprintf("%s=%v", col.Name, row.Data[i]))
+	}
+	s <- strings.Join(vs, " ")
+	return nil
+}
+
+func mkHeader(channels string) string {
+	return strings.Join([]string{
+		"*CREATOR",
+		"fake logger;",
+		"*STARTTIME",
+		"2015-07-29;12:00:00;",
+		"*CHANNEL",
+		channels,
+		"*UNIT",
+		";°C;%;",
+		"*LIMITS",
+		"Limit1;18;20;",
+		"Limit2;24;60;",
+		"*DATA",
+		"",
+	}, "\r\n")
+}
+
+func TestAcquire(t *testing.T) {
+	master, slave, err := serial.OpenPTY()
+	if err != nil {
+		t.Skipf("could not open pty: %v", err)
+	}
+	defer master.Close()
+
+	// the slave side is held open: the data is buffered until acquire
+	// opens the device.
+	_, err = fmt.Fprint(master,
+		// logger opened in the middle of a header.
+		"Limit2;24;60;\r\n*DATA\r\n2015-07-29 12:00:00.000;1;2;\r\n",
+		mkHeader("TIME;T;RH;"),
+		"2015-07-29 12:00:01.000;21.5;45;\r\n",
+		// header with an unnamed channel.
+		mkHeader("TIME;;RH;"),
+		"2015-07-29 12:00:02.000;3;4;\r\n",
+		mkHeader("TIME;T;RH;"),
+		"2015-07-29 12:00:03.000;abc;46;\r\n", // invalid line
+		"2015-07-29 12:00:04.000;21.7;46;\r\n",
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		rows = make(chanSink, 16)
+		errc = make(chan error, 1)
+	)
+	go func() {
+		errc <- acquire(slave.Name(), time.UTC, []sink{rows})
+	}()
+
+	for _, want := range []string{"T=21.5 RH=45", "T=21.7 RH=46"} {
+		select {
+		case got := <-rows:
+			if got != want {
+				t.Fatalf("invalid samples: got=%q, want=%q", got, want)
+			}
+		case err := <-errc:
+			t.Fatalf("acquisition stopped: %v", err)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting for samples %q", want)
+		}
+	}
+
+	// unplugging the logger stops the acquisition.
+	slave.Close()
+	master.Close()
+	select {
+	case err := <-errc:
+		if err == nil {
+			t.Fatalf("acquisition stopped without error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("acquisition not stopped")
+	}
+	select {
+	case got := <-rows:
+		t.Fatalf("unexpected samples %q", got)
+	default:
+	}
+}
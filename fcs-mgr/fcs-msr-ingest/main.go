@@ -0,0 +1,143 @@
+// fcs-msr-ingest inserts the recordings of MSR data loggers into the CCS
+// trending database (localdb), next to the FCS trending data.
+//
+// Each channel of an MSR file is described by a datadesc entry named
+// "<module>_<id>/<channel>" (e.g. "MSR145_453196/T"), with "msr" as source
+// subsystem, created on first import. Samples are inserted into the rawdata
+// table with millisecond timestamps. Samples already in the database (same
+// channel and timestamp) are skipped, so files can safely be imported again.
+//
+// MSR loggers record their local time: the time zone of their clock is given
+// with -tz.
+//
+// ex:
+//
+//	$ fcs-msr-ingest -user=ccs -password=xxx MSR453196_150729_150928.csv
+//	$ fcs-msr-ingest -user=ccs -password=xxx -tz=Europe/Paris *.csv
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"time"
+
+	"github.com/sbinet/lsst-ccs/fcs-mgr/localdb"
+	"github.com/sbinet/lsst-ccs/fcs-mgr/msr"
+)
+
+var (
+	user   = flag.String("user", "", "db user name")
+	pass   = flag.String("password", "", "db user password")
+	dbname = flag.String("db", "ccs", "db name")
+	tz     = flag.String("tz", "UTC", "time zone of the clock of the MSR loggers")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: fcs-msr-ingest [options] <msr-file> [<msr-file>...]\n\noptions:\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	loc, err := time.LoadLocation(*tz)
+	if err != nil {
+		log.Fatalf("invalid time zone %q: %v\n", *tz, err)
+	}
+
+	log.Printf("connect to mysql db...\n")
+	db, err := localdb.Open(*user + ":" + *pass + "@/" + *dbname)
+	if err != nil {
+		log.Fatalf("error opening db connection: %v\n", err)
+	}
+	defer db.Close()
+
+	for _, fname := range flag.Args() {
+		f, err := open(fname, loc)
+		if err != nil {
+			log.Fatalf("error reading MSR file [%s]: %v\n", fname, err)
+		}
+
+		for i, col := range f.Columns {
+			n, skip, err := ingest(db, f, i)
+			if err != nil {
+				log.Fatalf("error ingesting channel %q of [%s]: %v\n", col.Name, fname, err)
+			}
+			log.Printf(
+				"[%s]: channel %q: %d samples inserted, %d already present\n",
+				fname, col.Name, n, skip,
+			)
+		}
+	}
+}
+
+// open loads the MSR file fname, recorded by a logger whose clock is in the
+// time zone loc.
+func open(fname string, loc *time.Location) (*msr.File, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r, err := msr.NewReaderIn(f, loc)
+	if err != nil {
+		return nil, err
+	}
+	return r.ReadAll()
+}
+
+// ingest inserts the samples of the i-th channel of f into the rawdata table,
+// at their actual time (see msr.File.Series), skipping the samples already
+// present.
+// ingest returns the number of inserted and skipped samples.
+func ingest(db *localdb.DB, f *msr.File, i int) (int, int, error) {
+	col := f.Columns[i]
+	name := col.Source() + "/" + col.Name
+	id, created, err := db.DataDesc(name, col.Source(), "msr")
+	if err != nil {
+		return 0, 0, err
+	}
+	if created {
+		log.Printf("created datadesc %q\n", name)
+	}
+
+	s := f.Series(i)
+	if len(s.Times) == 0 {
+		return 0, 0, nil
+	}
+	known, err := db.Timestamps(id, s.Times[0], s.Times[len(s.Times)-1])
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var (
+		samples = make([]localdb.Sample, 0, len(s.Values))
+		skip    = 0
+	)
+	for j, v := range s.Values {
+		if math.IsInf(v, 0) {
+			continue
+		}
+		ms := localdb.Millis(s.Times[j])
+		if known[ms] {
+			skip++
+			continue
+		}
+		known[ms] = true
+		samples = append(samples, localdb.Sample{Time: s.Times[j], Value: v})
+	}
+
+	err = db.Insert(id, samples)
+	if err != nil {
+		return 0, 0, err
+	}
+	return len(samples), skip, nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"database/sql"
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/sbinet/lsst-ccs/fcs-mgr/localdb"
+	"github.com/sbinet/lsst-ccs/fcs-mgr/msr"
+)
+
+// openTestDB returns a localdb backed by a temporary SQLite file holding the
+// datadesc and rawdata tables, and its underlying connection.
+func openTestDB(t *testing.T) (*localdb.DB, *sql.DB) {
+	t.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "localdb.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	for _, q := range []string{
+		"create table datadesc (id integer primary key autoincrement, dataType varchar(1), maxSamplingMillis int default 0, name varchar(255) unique, preservationDelay int default 0, srcName varchar(255), srcSubsystem varchar(255))",
+		"create table rawdata (id integer primary key autoincrement, doubleData double, stringData varchar(255), tstampmills bigint, descr_id bigint)",
+	} {
+		_, err = db.Exec(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return localdb.New(db), db
+}
+
+func TestIngest(t *testing.T) {
+	db, sqldb := openTestDB(t)
+	f, err := open(filepath.Join("..", "msr", "testdata", "multirate.csv"), time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ingestAll := func(step string, want [][2]int) {
+		t.Helper()
+		for i, col := range f.Columns {
+			n, skip, err := ingest(db, f, i)
+			if err != nil {
+				t.Fatalf("%s: channel %q: %v", step, col.Name, err)
+			}
+			if n != want[i][0] || skip != want[i][1] {
+				t.Fatalf("%s: channel %q: got %d inserted and %d skipped, want %d and %d",
+					step, col.Name, n, skip, want[i][0], want[i][1],
+				)
+			}
+		}
+	}
+
+	ingestAll("first import", [][2]int{{5, 0}, {3, 0}, {2, 0}})
+	ingestAll("second import", [][2]int{{0, 5}, {0, 3}, {0, 2}})
+
+	// a longer recording of the same logger: only the new samples are
+	// inserted.
+	nan := math.NaN()
+	f.Rows = append(f.Rows,
+		msr.Row{Time: f.Rows[len(f.Rows)-1].Time.Add(time.Second), Data: []float64{21.8, nan, 1012}},
+	)
+	ingestAll("longer recording", [][2]int{{1, 5}, {0, 3}, {1, 2}})
+
+	rows, err := sqldb.Query("select d.name, d.srcSubsystem, count(*), min(r.tstampmills) from rawdata r join datadesc d on r.descr_id = d.id group by d.name order by d.id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	t0 := time.Date(2015, 7, 29, 12, 0, 0, 0, time.UTC)
+	want := []struct {
+		name  string
+		n     int
+		first time.Time
+	}{
+		{"MSR145_453196/T", 6, t0},
+		{"MSR145_453196/RH", 3, t0.Add(time.Second)}, // time delay of 1s
+		{"MSR145_453196/P", 3, t0},
+	}
+	i := 0
+	for rows.Next() {
+		var (
+			name, sub string
+			n         int
+			first     int64
+		)
+		err = rows.Scan(&name, &sub, &n, &first)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i >= len(want) {
+			t.Fatalf("unexpected channel %q", name)
+		}
+		if name != want[i].name || sub != "msr" || n != want[i].n || first != localdb.Millis(want[i].first) {
+			t.Fatalf("channel #%d: got (%q, %q, %d, %d), want (%q, msr, %d, %d)",
+				i, name, sub, n, first, want[i].name, want[i].n, localdb.Millis(want[i].first),
+			)
+		}
+		i++
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if i != len(want) {
+		t.Fatalf("invalid number of channels: got=%d, want=%d", i, len(want))
+	}
+}
@@ -0,0 +1,129 @@
+// fcs-msr-sim simulates an MSR data logger streaming its records over a
+// serial line, on a pseudo-terminal.
+//
+// The records are written in the format of the CSV files exported from the
+// MSR loggers (see package msr): a header describing the temperature (T),
+// hygrometry (RH) and pressure (P) channels, followed by one data line per
+// period. The hygrometry is only sampled every other period.
+// The header is repeated every -header data lines, so readers opening the
+// device at any time can synchronize.
+//
+// ex:
+//
+//	$ fcs-msr-sim -link=/tmp/msr0 &
+//	$ fcs-msr-daq -dev=/tmp/msr0 -o=msr.jsonl
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"time"
+
+	"github.com/sbinet/lsst-ccs/fcs-mgr/serial"
+)
+
+var (
+	period = flag.Duration("period", 1*time.Second, "sampling period")
+	header = flag.Int("header", 60, "number of data lines between headers (0: header only at start)")
+	link   = flag.String("link", "", "path of a symbolic link to the pseudo-terminal device")
+)
+
+const (
+	timeLayout = "2006-01-02 15:04:05.000"
+	eol        = "\r\n"
+)
+
+func main() {
+	flag.Parse()
+
+	master, slave, err := serial.OpenPTY()
+	if err != nil {
+		log.Fatalf("error opening pty: %v\n", err)
+	}
+	defer master.Close()
+	defer slave.Close()
+
+	dev := slave.Name()
+	if *link != "" {
+		os.Remove(*link)
+		err = os.Symlink(dev, *link)
+		if err != nil {
+			log.Fatalf("error creating link to pty: %v\n", err)
+		}
+		defer os.Remove(*link)
+		dev = *link
+	}
+	log.Printf("fake MSR logger on [%s]\n", dev)
+
+	// discard what readers send to the logger.
+	go io.Copy(ioutil.Discard, master)
+
+	start := time.Now().UTC()
+	err = writeHeader(master, start)
+	if err != nil {
+		log.Fatalf("error writing header: %v\n", err)
+	}
+
+	tick := time.NewTicker(*period)
+	defer tick.Stop()
+	for i := 0; ; i++ {
+		now := <-tick.C
+		if *header > 0 && i > 0 && i%*header == 0 {
+			err = writeHeader(master, start)
+			if err != nil {
+				log.Fatalf("error writing header: %v\n", err)
+			}
+		}
+		err = writeData(master, i, now.UTC(), now.Sub(start))
+		if err != nil {
+			log.Fatalf("error writing data: %v\n", err)
+		}
+	}
+}
+
+func writeHeader(w io.Writer, start time.Time) error {
+	_, err := fmt.Fprint(w,
+		"*CREATOR"+eol,
+		"fcs-msr-sim;"+eol,
+		"*STARTTIME"+eol,
+		start.Format("2006-01-02;15:04:05;")+eol,
+		"*MODUL"+eol,
+		";MSR145;MSR145;MSR145;"+eol,
+		"*NAME"+eol,
+		";999999;999999;999999;"+eol,
+		"*TIMEDELAY"+eol,
+		"ms;0;0;0;"+eol,
+		"*CHANNEL"+eol,
+		"TIME;T;RH;P;"+eol,
+		"*UNIT"+eol,
+		";°C;%;mbar;"+eol,
+		"*LIMITS"+eol,
+		"Alarm;1;1;0;"+eol,
+		"Recorded;1;1;0;"+eol,
+		"Limit1;18;20;;"+eol,
+		"Limit2;24;60;;"+eol,
+		"*DATA"+eol,
+	)
+	return err
+}
+
+// writeData writes the i-th data line, sampled at time now, dt after the start
+// of the recording.
+func writeData(w io.Writer, i int, now time.Time, dt time.Duration) error {
+	phase := 2 * math.Pi * dt.Seconds() / 600
+	temp := 21 + 2*math.Sin(phase) + 0.05*rand.NormFloat64()
+	press := 1013 + 0.5*math.Cos(phase) + 0.1*rand.NormFloat64()
+
+	hygro := ""
+	if i%2 == 0 {
+		hygro = fmt.Sprintf("%.1f", 45+10*math.Sin(phase)+0.5*rand.NormFloat64())
+	}
+	_, err := fmt.Fprintf(w, "%s;%.2f;%s;%.1f;%s", now.Format(timeLayout), temp, hygro, press, eol)
+	return err
+}
@@ -1,238 +1,203 @@
 // fcs-parse-msr-sensor parses CSV files recorded from the MSR sensor
+//
+// Samples outside of the limits configured for their channel are reported,
+// and flagged in the exported samples.
+// The recording can be exported as:
+//   - csv:  tidy CSV (time, channel, value, unit), into <name>.csv
+//   - json: JSON lines, one object per sample, into <name>.jsonl
+//   - bin:  compact binary columnar format, into <name>.msrc
+//   - plot: one plot per channel, with its limits, into <name>-<channel>.<format>
+//
+// Files in the binary columnar format (.msrc) can be read back.
+// With -resample, the channels are resampled on a common time grid before
+// being exported, applying their time delays.
+// With -calib, the two-point calibrations of the *CALIBRATION section are
+// applied to the samples of the channels.
+//
+// ex:
+//
+//	$ fcs-parse-msr-sensor -f MSR453196_150729_150928.csv
+//	$ fcs-parse-msr-sensor -f MSR453196_150729_150928.csv -export=csv,bin,plot -o out
+//	$ fcs-parse-msr-sensor -f out/MSR453196_150729_150928.msrc -export=plot -format=svg
+//	$ fcs-parse-msr-sensor -f MSR453196_150729_150928.csv -export=csv -resample=1m -method=mean
 package main
 
 import (
-	"bufio"
 	"flag"
 	"io"
 	"log"
 	"os"
-	"strconv"
+	"path/filepath"
 	"strings"
-	"time"
+
+	"github.com/sbinet/lsst-ccs/fcs-mgr/msr"
 )
 
 var (
-	fname = flag.String("f", "MSR453196_150729_150928.csv", "path to MSR sensor data file")
+	fname   = flag.String("f", "MSR453196_150729_150928.csv", "path to MSR sensor data file")
+	verbose = flag.Bool("v", false, "print the samples")
+	export  = flag.String("export", "", "comma-separated list of export formats (csv, json, bin or plot)")
+	odir    = flag.String("o", ".", "output directory")
+	format  = flag.String("format", "png", "image format of the plots (png, svg, pdf, eps, jpg or tiff)")
+
+	resample = flag.Duration("resample", 0, "resample the channels on a common time grid with the given step before exporting (0: disable)")
+	method   = flag.String("method", "last", "resampling method (last, linear or mean)")
+	calibf   = flag.Bool("calib", false, "apply the calibration section of the file to the samples")
 )
 
-type Column struct {
-	Name      string // title of the associated data
-	Unit      string // units of the associated data
-	Sensor    string // name of the sensor collecting the data
-	SensorID  string // id of the sensor collecting the data
-	TimeDelay time.Duration
-	Limits    Limits
-	CalibData CalibData
-	Data      interface{}
-}
-
-type Row struct {
-	Time time.Time
-	Data []float64
-}
-
-type Limits struct {
-	Alarm    float64
-	Recorded float64
-	Limit1   float64
-	Limit2   float64
-}
-
-type CalibData struct {
-	Info string
-	Date time.Time
-	X0   float64
-	Y0   float64
-	X1   float64
-	Y1   float64
-}
-
 func main() {
 	flag.Parse()
 
-	f, err := os.Open(*fname)
+	exports := make(map[string]bool)
+	if *export != "" {
+		for _, e := range strings.Split(*export, ",") {
+			switch e {
+			case "csv", "json", "bin", "plot":
+				exports[e] = true
+			default:
+				log.Fatalf("invalid export format %q\n", e)
+			}
+		}
+	}
+
+	meth, err := msr.ParseMethod(*method)
 	if err != nil {
-		log.Fatalf("error opening MSR data file [%s]: %v\n", *fname, err)
+		log.Fatalf("invalid resampling method: %v\n", err)
 	}
-	defer f.Close()
 
-	var cols []Column
-	var rows []Row
+	var data *msr.File
+	switch filepath.Ext(*fname) {
+	case ".msrc":
+		data, err = readColumnar(*fname)
+	default:
+		data, err = msr.Open(*fname)
+	}
+	if err != nil {
+		log.Fatalf("error reading MSR data file [%s]: %v\n", *fname, err)
+	}
 
-	var section Section
-	scan := bufio.NewScanner(f)
-	for scan.Scan() {
-		line := scan.Text()
-		line = strings.TrimSpace(line)
-		if len(line) == 0 {
-			continue
-		}
-		if line[0] == '*' {
-			switch line {
-			case "*CREATOR":
-				log.Printf(">>> creator section\n")
-				section = CreatorSection
-
-			case "*STARTTIME":
-				log.Printf(">>> start-time section\n")
-				section = StartTimeSection
-
-			case "*MODUL":
-				log.Printf(">>> module section\n")
-				section = ModuleSection
-
-			case "*NAME":
-				log.Printf(">>> name section\n")
-				section = NameSection
-
-			case "*TIMEDELAY":
-				log.Printf(">>> time-delay section\n")
-				section = TimeDelaySection
-
-			case "*CHANNEL":
-				log.Printf(">>> channel section\n")
-				section = ChannelSection
-
-			case "*UNIT":
-				log.Printf(">>> unit section\n")
-				section = UnitSection
-
-			case "*LIMITS":
-				log.Printf(">>> limits section\n")
-				section = LimitsSection
-
-			case "*CALIBRATION":
-				log.Printf(">>> calibration section\n")
-				section = CalibrationSection
-
-			case "*DATA":
-				log.Printf(">>> data section\n")
-				section = DataSection
-			}
-			continue
+	if *calibf {
+		err = data.Calibrate()
+		if err != nil {
+			log.Fatalf("error calibrating samples: %v\n", err)
 		}
+	}
 
-		tokens := strings.Split(line, ";")
+	log.Printf("creator:    %s\n", data.Creator)
+	log.Printf("start-time: %v\n", data.Start)
+	for i, col := range data.Columns {
+		log.Printf("col[%d] = %#v\n", i, col)
+	}
 
-		switch section {
-		case CreatorSection:
-		case StartTimeSection:
-			start, err := time.Parse("2006-01-02;15:04:05;", line)
-			if err != nil {
-				log.Fatalf("error parsing start-time: %v\nline=%q\n", err, line)
-			}
-			log.Printf("start-time: %v\n", start)
-
-		case ModuleSection:
-			cols = make([]Column, len(tokens))
-			for i, tok := range tokens {
-				log.Printf("    module: %q\n", tok)
-				cols[i].Sensor = tok
-				switch i {
-				case 0:
-					cols[i].Data = []time.Time{}
-				default:
-					cols[i].Data = []float64{}
-				}
+	out := make([]int, len(data.Columns)) // number of out-of-range samples per channel
+	for _, row := range data.Rows {
+		if *verbose {
+			log.Printf("data: %v %v\n", row.Time, row.Data)
+		}
+		for i, v := range row.Data {
+			col := data.Columns[i]
+			if !col.OutOfLimits(v) {
+				continue
 			}
+			min, max := col.Limits.Range()
+			log.Printf(
+				"data: %v %s=%v%s out of limits [%v, %v]\n",
+				row.Time, col.Name, v, col.Unit, min, max,
+			)
+			out[i]++
+		}
+	}
+	log.Printf("rows: %d\n", len(data.Rows))
+	for i, col := range data.Columns {
+		if out[i] > 0 {
+			log.Printf("channel %q: %d samples out of limits\n", col.Name, out[i])
+		}
+	}
 
-		case NameSection:
-
-		case TimeDelaySection:
-			for i, tok := range tokens {
-				if i > 0 {
-					delay, err := time.ParseDuration(tok + tokens[0])
-					if err != nil {
-						log.Fatalf(
-							"error parsing time-delay: %v\nline=%q\n",
-							err, line,
-						)
-					}
-					cols[i].TimeDelay = delay
-				}
-			}
+	if len(exports) == 0 {
+		return
+	}
 
-		case ChannelSection:
-			for i, tok := range tokens {
-				log.Printf("    channel: %q\n", tok)
-				cols[i].Name = tok
-			}
+	if *resample > 0 {
+		data, err = data.Resample(*resample, meth)
+		if err != nil {
+			log.Fatalf("error resampling: %v\n", err)
+		}
+		log.Printf("resampled rows: %d (step=%v, method=%v)\n", len(data.Rows), *resample, meth)
+	}
 
-		case UnitSection:
-			for i, tok := range tokens {
-				if i == 0 {
-					continue
-				}
-				cols[i].Unit = tok
-			}
+	err = os.MkdirAll(*odir, 0755)
+	if err != nil {
+		log.Fatalf("could not create output directory: %v\n", err)
+	}
+	name := strings.TrimSuffix(filepath.Base(*fname), filepath.Ext(*fname))
+	oname := filepath.Join(*odir, name)
 
-		case LimitsSection:
-			// TODO(sbinet)
-		case CalibrationSection:
-			// TODO(sbinet)
-		case DataSection:
-			var row Row
-			row.Time, err = time.Parse("2006-01-02 15:04:05.999", tokens[0])
-			if err != nil {
-				log.Fatalf("error parsing data: %v\nline=%q\n", err, line)
+	if exports["csv"] {
+		err = writeFile(oname+".csv", data, msr.WriteCSV)
+		if err != nil {
+			log.Fatalf("error exporting CSV: %v\n", err)
+		}
+	}
+	if exports["json"] {
+		err = writeFile(oname+".jsonl", data, msr.WriteJSON)
+		if err != nil {
+			log.Fatalf("error exporting JSON: %v\n", err)
+		}
+	}
+	if exports["bin"] {
+		err = writeFile(oname+".msrc", data, msr.WriteColumnar)
+		if err != nil {
+			log.Fatalf("error exporting columnar file: %v\n", err)
+		}
+	}
+	if exports["plot"] {
+		for i, col := range data.Columns {
+			err = plotChannel(oname+"-"+fileName(col.Name)+"."+*format, data, i)
+			if err == errNoData {
+				log.Printf("skipping plot of channel %q: no data\n", col.Name)
+				continue
 			}
-			cols[0].Data = append(cols[0].Data.([]time.Time), row.Time)
-			row.Data = make([]float64, len(tokens)-1)
-			for i, tok := range tokens[1:] {
-				switch tok {
-				case "":
-					row.Data[i] = getLastOrDefault(rows, i)
-				default:
-					val, err := strconv.ParseFloat(tok, 64)
-					if err != nil {
-						log.Fatalf(
-							"error parsing float data: %v\nline=%q\n",
-							err,
-							line,
-						)
-					}
-					row.Data[i] = val
-				}
-				cols[i+1].Data = append(cols[i+1].Data.([]float64), row.Data[i])
+			if err != nil {
+				log.Fatalf("error plotting channel %q: %v\n", col.Name, err)
 			}
-			log.Printf("data: %v %v\n", row.Time, row.Data)
-			rows = append(rows, row)
 		}
-
 	}
-	err = scan.Err()
-	if err == io.EOF {
-		err = nil
+}
+
+// readColumnar reads a file in the binary columnar format.
+func readColumnar(fname string) (*msr.File, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, err
 	}
+	defer f.Close()
+	return msr.ReadColumnar(f)
+}
+
+// writeFile creates the file oname and writes data into it.
+func writeFile(oname string, data *msr.File, write func(w io.Writer, f *msr.File) error) error {
+	f, err := os.Create(oname)
 	if err != nil {
-		log.Fatalf("error scanning MSR data file [%s]: %v\n", *fname, err)
+		return err
 	}
+	defer f.Close()
 
-	for i, col := range cols {
-		log.Printf("col[%d] = %#v\n", i, col)
+	err = write(f, data)
+	if err != nil {
+		return err
 	}
+	return f.Close()
 }
 
-type Section int
-
-const (
-	UndefinedSection Section = iota
-	CreatorSection
-	StartTimeSection
-	ModuleSection
-	NameSection
-	TimeDelaySection
-	ChannelSection
-	UnitSection
-	LimitsSection
-	CalibrationSection
-	DataSection
-)
-
-func getLastOrDefault(rows []Row, icol int) float64 {
-	if len(rows) == 0 {
-		return 0.0
-	}
-	return rows[len(rows)-1].Data[icol]
+// fileName returns a file name for a channel name, replacing characters
+// other than letters, digits, '-' and '_' with '_'.
+func fileName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9', r == '-', r == '_':
+			return r
+		}
+		return '_'
+	}, name)
 }
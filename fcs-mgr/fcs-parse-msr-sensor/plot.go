@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/sbinet/lsst-ccs/fcs-mgr/msr"
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/plotutil"
+	"gonum.org/v1/plot/vg"
+)
+
+type XY struct {
+	X float64
+	Y float64
+}
+
+// errNoData is returned when plotting a channel without any sample.
+var errNoData = errors.New("no data")
+
+// plotChannel plots the values of the i-th channel of a recording into the
+// file oname, with its limits drawn as horizontal lines.
+// Missing samples are not drawn, and errNoData is returned for channels
+// without any sample.
+func plotChannel(oname string, data *msr.File, i int) error {
+	col := data.Columns[i]
+	s := data.Series(i)
+	if len(s.Values) == 0 {
+		return errNoData
+	}
+
+	p, err := plot.New()
+	if err != nil {
+		return err
+	}
+
+	p.Title.Text = col.Name
+	if col.Sensor != "" || col.SensorID != "" {
+		p.Title.Text = fmt.Sprintf("%s (%s %s)", col.Name, col.Sensor, col.SensorID)
+	}
+	p.X.Label.Text = "Time (UTC)"
+	p.Y.Label.Text = col.Name
+	if col.Unit != "" {
+		p.Y.Label.Text += " (" + col.Unit + ")"
+	}
+	p.Legend.Top = true
+
+	layout := "15:04:05"
+	if n := len(s.Times); s.Times[n-1].Sub(s.Times[0]) > 24*time.Hour {
+		layout = "2006-01-02 15:04"
+	}
+	p.X.Tick.Marker = plot.TimeTicks{Format: layout}
+
+	p.Add(plotter.NewGrid())
+
+	vals := make(plotter.XYs, len(s.Values))
+	for j, v := range s.Values {
+		vals[j] = XY{X: float64(s.Times[j].UnixNano()) * 1e-9, Y: v}
+	}
+	xmin, xmax := vals[0].X, vals[len(vals)-1].X
+
+	err = plotutil.AddLinePoints(p, col.Name, vals)
+	if err != nil {
+		return err
+	}
+
+	for j, lim := range []struct {
+		name string
+		v    float64
+	}{
+		{"limit1", col.Limits.Limit1},
+		{"limit2", col.Limits.Limit2},
+	} {
+		if math.IsNaN(lim.v) {
+			continue
+		}
+		l, err := plotter.NewLine(plotter.XYs{
+			{X: xmin, Y: lim.v},
+			{X: xmax, Y: lim.v},
+		})
+		if err != nil {
+			return err
+		}
+		l.LineStyle.Color = plotutil.Color(j + 1)
+		l.LineStyle.Dashes = plotutil.Dashes(1)
+		p.Add(l)
+		p.Legend.Add(fmt.Sprintf("%s (%v)", lim.name, lim.v), l)
+	}
+
+	return p.Save(14*vg.Inch, 8*vg.Inch, oname)
+}
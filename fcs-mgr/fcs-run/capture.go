@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Peer identifies an end of a connection between the FCS subsystem and the
+// c-wrapper.
+type Peer string
+
+const (
+	PeerFCS      Peer = "fcs"
+	PeerCWrapper Peer = "cwrapper"
+)
+
+// RecordType describes the kind of event held by a Record.
+type RecordType string
+
+const (
+	RecordOpen  RecordType = "open"  // a new connection was established
+	RecordData  RecordType = "data"  // a chunk of data was sent
+	RecordClose RecordType = "close" // a peer closed its end of a connection
+)
+
+// Record is an entry of a capture file.
+//
+// A capture file is a stream of JSON-encoded records, one per line, holding
+// every chunk of data exchanged between the FCS subsystem and the c-wrapper.
+// Data is stored as raw bytes (base64-encoded in JSON), so a capture is a
+// faithful record even of bytes which are not valid UTF-8.
+type Record struct {
+	Time time.Time  `json:"time"`
+	Conn int        `json:"conn"` // connection number
+	From Peer       `json:"from"` // sender of the data
+	Type RecordType `json:"type"`
+	Data []byte     `json:"data,omitempty"`
+}
+
+// CaptureWriter writes records to a capture file.
+// CaptureWriter is safe for concurrent use.
+type CaptureWriter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewCaptureWriter returns a CaptureWriter writing to w.
+func NewCaptureWriter(w io.Writer) *CaptureWriter {
+	return &CaptureWriter{enc: json.NewEncoder(w)}
+}
+
+// Write writes rec to the underlying capture file.
+func (w *CaptureWriter) Write(rec Record) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.enc.Encode(rec)
+}
+
+// CaptureReader reads records from a capture file.
+type CaptureReader struct {
+	scan *bufio.Scanner
+	line int
+}
+
+// NewCaptureReader returns a CaptureReader reading from r.
+func NewCaptureReader(r io.Reader) *CaptureReader {
+	scan := bufio.NewScanner(r)
+	scan.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	return &CaptureReader{scan: scan}
+}
+
+// Read reads the next record from the capture file.
+// Read returns io.EOF when no more records are available.
+func (r *CaptureReader) Read() (Record, error) {
+	var rec Record
+	for r.scan.Scan() {
+		r.line++
+		line := r.scan.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		err := json.Unmarshal(line, &rec)
+		if err != nil {
+			return rec, &CaptureError{Line: r.line, Err: err}
+		}
+		return rec, nil
+	}
+	err := r.scan.Err()
+	if err == nil {
+		err = io.EOF
+	}
+	return rec, err
+}
+
+// ReadAll reads all the remaining records from the capture file.
+func (r *CaptureReader) ReadAll() ([]Record, error) {
+	var recs []Record
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			return recs, nil
+		}
+		if err != nil {
+			return recs, err
+		}
+		recs = append(recs, rec)
+	}
+}
+
+// CaptureError describes a malformed record in a capture file.
+type CaptureError struct {
+	Line int
+	Err  error
+}
+
+func (e *CaptureError) Error() string {
+	return fmt.Sprintf("capture: line %d: %v", e.Line, e.Err)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCaptureRoundTrip(t *testing.T) {
+	now := time.Date(2018, 9, 5, 8, 4, 58, 0, time.UTC)
+	want := []Record{
+		{Time: now, Conn: 1, From: PeerCWrapper, Type: RecordOpen},
+		{Time: now, Conn: 1, From: PeerFCS, Type: RecordData, Data: []byte("rsdo,1,6000,0\n")},
+		{Time: now, Conn: 1, From: PeerCWrapper, Type: RecordData, Data: []byte{0xff, 0xfe, 'a', 0x80, '\n'}},
+		{Time: now, Conn: 1, From: PeerFCS, Type: RecordClose},
+	}
+
+	buf := new(bytes.Buffer)
+	w := NewCaptureWriter(buf)
+	for _, rec := range want {
+		err := w.Write(rec)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := NewCaptureReader(buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid records:\ngot= %+v\nwant=%+v", got, want)
+	}
+
+	msgs := Messages(got, 1)
+	if len(msgs) != 2 || msgs[1].Line != "\xff\xfea\x80" {
+		t.Fatalf("invalid messages: %q", msgs)
+	}
+}
@@ -15,6 +15,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 )
@@ -22,11 +23,19 @@ import (
 var (
 	haddr    = flag.String("addr", "", "<ip>[:<port>] PC-104 will listen to")
 	cwrapper = flag.Bool("cwrapper", false, "start c-wrapper")
+	sim      = flag.Bool("sim", false, "start the fcs-sim c-wrapper simulator instead of the PC-104 c-wrapper")
+	simcfg   = flag.String("sim-config", "", "path to the fcs-sim configuration file (default: LPC test bench)")
+	fcsAddr  = flag.String("fcs", "", "<ip>:<port> of the FCS subsystem the proxy and replay connect to")
+	capture  = flag.String("capture", "cwrapper-capture.json", "path to the capture file recorded by the proxy and played by replay")
+	timeout  = flag.Duration("timeout", 30*time.Second, "time to wait for an expected message from the FCS subsystem during a replay")
+	grace    = flag.Duration("grace", 10*time.Second, "grace period between SIGTERM and SIGKILL when stopping processes")
 
 	host = ""
 	port = 50000
 
 	exitFuncs  = []func(){}
+	exitMu     sync.Mutex
+	exitOnce   sync.Once
 	wdir       = "."
 	fcsVersion = "dev-SNAPSHOT"
 	dbVersion  = "dev-SNAPSHOT"
@@ -67,21 +76,18 @@ func main() {
 	}
 
 	go func() {
-		sigch := make(chan os.Signal)
-		signal.Notify(sigch, os.Interrupt, os.Kill)
-		for {
-			select {
-			case <-sigch:
-				os.Stderr.Sync()
-				os.Stdout.Sync()
-				runExitFuncs()
-				os.Stdout.Sync()
-				os.Stderr.Sync()
-			}
-		}
+		sigch := make(chan os.Signal, 1)
+		signal.Notify(sigch, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+		sig := <-sigch
+		log.Printf("received signal [%v]. shutting down...\n", sig)
+		os.Stderr.Sync()
+		os.Stdout.Sync()
+		runExitFuncs()
+		os.Stdout.Sync()
+		os.Stderr.Sync()
+		os.Exit(128 + int(sig.(syscall.Signal)))
 	}()
 
-	//go testNet()
 	run()
 }
 
@@ -102,21 +108,33 @@ func run() {
 	select {
 	case err := <-errc:
 		if err != nil {
-			fatalf("error: %v\n", err)
+			log.Printf("error: %v\n", err)
+			runExitFuncs()
+			os.Exit(exitCode(err))
 		}
 	}
 }
 
 func atexit(f func()) {
+	exitMu.Lock()
+	defer exitMu.Unlock()
 	exitFuncs = append(exitFuncs, f)
 }
 
+// runExitFuncs runs the functions registered with atexit, in reverse order.
+// runExitFuncs only runs them once: concurrent calls wait for the first one
+// to complete.
 func runExitFuncs() {
-	log.Printf("running atexit-funcs...\n")
-	for _, f := range exitFuncs {
-		f()
-	}
-	log.Printf("running atexit-funcs... [done]\n")
+	exitOnce.Do(func() {
+		log.Printf("running atexit-funcs...\n")
+		exitMu.Lock()
+		funcs := exitFuncs
+		exitMu.Unlock()
+		for i := len(funcs) - 1; i >= 0; i-- {
+			funcs[i]()
+		}
+		log.Printf("running atexit-funcs... [done]\n")
+	})
 }
 
 func fatalf(format string, args ...interface{}) {
@@ -129,35 +147,7 @@ func runCmd(cmd string, args ...string) error {
 	exe.Stdin = os.Stdin
 	exe.Stdout = os.Stdout
 	exe.Stderr = os.Stderr
-	atexit(func() {
-		killProc(exe)
-	})
-	return exe.Run()
-}
-
-func killProc(cmd *exec.Cmd) {
-	if cmd == nil || cmd.Process == nil {
-		return
-	}
-	state := cmd.ProcessState
-	if state != nil && state.Exited() {
-		return
-	}
-	p := cmd.Process
-	pgid, err := syscall.Getpgid(p.Pid)
-	if err != nil {
-		log.Printf("could not get process group-id of [%v]: %v\n",
-			cmd, err,
-		)
-		return
-	}
-
-	err = syscall.Kill(-pgid, syscall.SIGKILL)
-	if err != nil {
-		log.Printf("could not kill process [%v]: %v\n",
-			cmd, err,
-		)
-	}
+	return runProc(exe)
 }
 
 func getHostIP() string {
@@ -196,21 +186,36 @@ func startCWrapper(errc chan error) {
 	//cmd.Stdin = os.Stdin
 	//cmd.Stdout = os.Stderr
 	//cmd.Stderr = os.Stderr
-	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
-
-	atexit(func() {
-		killProc(cmd)
-	})
 
 	log.Printf("c-wrapper command: %v\n", cmd.Args)
 
-	err := cmd.Run()
+	err := runProc(cmd)
 	if err != nil {
 		log.Printf("c-wrapper= %v\n", err)
 		errc <- err
 	}
 }
 
+func startSimulator(errc chan error) {
+	log.Printf("Starting c-wrapper simulator... (connect to %s:%d)\n", host, port)
+
+	args := []string{fmt.Sprintf("-addr=%s:%d", host, port)}
+	if *simcfg != "" {
+		args = append(args, "-config="+*simcfg)
+	}
+	cmd := exec.Command("fcs-sim", args...)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+
+	log.Printf("c-wrapper simulator command: %v\n", cmd.Args)
+
+	err := runProc(cmd)
+	if err != nil {
+		log.Printf("c-wrapper simulator= %v\n", err)
+		errc <- err
+	}
+}
+
 func initProject() {
 	for _, proj := range []struct {
 		Dir     string
@@ -286,14 +291,19 @@ func setupEnv() {
 
 func dispatch(errc chan error) {
 	switch flag.Arg(0) {
-	case "start-localdb", "jas3", "list", "infos", "shell":
+	case "start-localdb", "jas3", "list", "infos", "shell", "replay":
 		// ok
 	default:
 		*cwrapper = true
 	}
 
 	if *cwrapper {
-		go startCWrapper(errc)
+		switch {
+		case *sim:
+			go startSimulator(errc)
+		default:
+			go startCWrapper(errc)
+		}
 	}
 
 	switch flag.Arg(0) {
@@ -313,6 +323,10 @@ func dispatch(errc chan error) {
 		startLocalDB(errc)
 	case "shell":
 		runShell(errc)
+	case "proxy":
+		runProxy(errc)
+	case "replay":
+		runReplay(errc)
 	default:
 		fatalf("unknown command [%s]\n", flag.Arg(0))
 	}
@@ -343,11 +357,7 @@ func runTestbench(errc chan error) {
 
 	log.Printf("running: %v...\n", cmd.Args)
 
-	atexit(func() {
-		killProc(cmd)
-	})
-
-	errc <- cmd.Run()
+	errc <- runProc(cmd)
 }
 
 func runSimAutochanger(errc chan error) {
@@ -380,11 +390,7 @@ func runSimAutochanger(errc chan error) {
 
 	log.Printf("running: %v...\n", cmd.Args)
 
-	atexit(func() {
-		killProc(cmd)
-	})
-
-	errc <- cmd.Run()
+	errc <- runProc(cmd)
 }
 
 func runConsole(errc chan error) {
@@ -401,11 +407,7 @@ func runConsole(errc chan error) {
 	cmd.Stderr = os.Stderr
 	cmd.Stdout = os.Stdout
 
-	atexit(func() {
-		killProc(cmd)
-	})
-
-	errc <- cmd.Run()
+	errc <- runProc(cmd)
 }
 
 func runJAS3(errc chan error) {
@@ -422,11 +424,7 @@ func runJAS3(errc chan error) {
 	cmd.Stderr = os.Stderr
 	cmd.Stdout = os.Stdout
 
-	atexit(func() {
-		killProc(cmd)
-	})
-
-	errc <- cmd.Run()
+	errc <- runProc(cmd)
 }
 
 func runListApps(errc chan error) {
@@ -443,11 +441,7 @@ func runListApps(errc chan error) {
 	cmd.Stderr = os.Stderr
 	cmd.Stdout = os.Stdout
 
-	atexit(func() {
-		killProc(cmd)
-	})
-
-	errc <- cmd.Run()
+	errc <- runProc(cmd)
 }
 
 func startLocalDB(errc chan error) {
@@ -471,11 +465,7 @@ func startLocalDB(errc chan error) {
 	cmd.Stderr = os.Stderr
 	cmd.Stdout = os.Stdout
 
-	atexit(func() {
-		killProc(cmd)
-	})
-
-	errc <- cmd.Run()
+	errc <- runProc(cmd)
 }
 
 func runInfos(errc chan error) {
@@ -492,11 +482,7 @@ func runInfos(errc chan error) {
 	cmd.Stderr = os.Stderr
 	cmd.Stdout = os.Stdout
 
-	atexit(func() {
-		killProc(cmd)
-	})
-
-	errc <- cmd.Run()
+	errc <- runProc(cmd)
 }
 
 func runShell(errc chan error) {
@@ -505,11 +491,7 @@ func runShell(errc chan error) {
 	cmd.Stderr = os.Stderr
 	cmd.Stdout = os.Stdout
 
-	atexit(func() {
-		killProc(cmd)
-	})
-
-	errc <- cmd.Run()
+	errc <- runProc(cmd)
 }
 
 func unzip(dest, src string) error {
@@ -569,44 +551,3 @@ func unzip(dest, src string) error {
 
 	return nil
 }
-
-func testNet() {
-	srv, err := net.Listen("tcp", fmt.Sprintf("%s:%d", host, port))
-	if err != nil {
-		log.Fatalf("error listening: %v\n", err)
-	}
-
-	for {
-		conn, err := srv.Accept()
-		if err != nil {
-			log.Fatalf("error accept: %v\n", err)
-		}
-		go testForward(conn)
-	}
-}
-
-func testForward(conn net.Conn) {
-	time.Sleep(20 * time.Second)
-
-	cli, err := net.DialTimeout(
-		"tcp", "134.158.120.94:50000",
-		1000*time.Second,
-	)
-	if err != nil {
-		log.Fatalf("error dialing: %v\n", err)
-	}
-
-	fw, err := os.Create("sock-w.txt")
-	if err != nil {
-		log.Fatalf("error fw: %v\n", err)
-	}
-
-	w := io.MultiWriter(fw, cli)
-	go func() {
-		defer fw.Close()
-		io.Copy(w, conn)
-	}()
-
-	r := io.TeeReader(cli, fw)
-	go io.Copy(conn, r)
-}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// proc is a child process started by runProc.
+type proc struct {
+	cmd  *exec.Cmd
+	done chan struct{} // closed when cmd completed
+}
+
+var (
+	procMu   sync.Mutex
+	procs    = make(map[*proc]bool) // running child processes
+	procOnce sync.Once
+)
+
+// runProc starts cmd in its own process group, registers its shutdown with
+// atexit and waits for it to complete.
+//
+// If cmd is attached to the terminal fcs-run is attached to, its process group
+// is made the foreground process group of that terminal for the lifetime of
+// the command, so it still receives keyboard input and signals.
+func runProc(cmd *exec.Cmd) error {
+	fg := cmd.Stdin == os.Stdin && isTerminal(os.Stdin)
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setpgid:    true,
+		Foreground: fg,
+	}
+	if fg {
+		cmd.SysProcAttr.Ctty = int(os.Stdin.Fd())
+	}
+
+	err := cmd.Start()
+	if err != nil {
+		return err
+	}
+
+	p := &proc{cmd: cmd, done: make(chan struct{})}
+	procOnce.Do(func() {
+		atexit(stopProcs)
+	})
+	procMu.Lock()
+	procs[p] = true
+	procMu.Unlock()
+
+	err = cmd.Wait()
+	close(p.done)
+
+	procMu.Lock()
+	delete(procs, p)
+	procMu.Unlock()
+
+	if fg {
+		err := tcsetpgrp(os.Stdin, syscall.Getpgrp())
+		if err != nil {
+			log.Printf("could not restore terminal foreground process group: %v\n", err)
+		}
+	}
+	return err
+}
+
+// stopProcs gracefully stops the process groups of all the running child
+// processes.
+// A SIGTERM is first sent to all the groups. The processes still running after
+// the grace period are then sent a SIGKILL.
+func stopProcs() {
+	procMu.Lock()
+	ps := make([]*proc, 0, len(procs))
+	for p := range procs {
+		ps = append(ps, p)
+	}
+	procMu.Unlock()
+
+	for _, p := range ps {
+		p.signal(syscall.SIGTERM)
+	}
+
+	timer := time.NewTimer(*grace)
+	defer timer.Stop()
+	expired := false
+	for _, p := range ps {
+		if expired {
+			break
+		}
+		select {
+		case <-p.done:
+		case <-timer.C:
+			expired = true
+		}
+	}
+
+	for _, p := range ps {
+		select {
+		case <-p.done:
+			continue
+		default:
+		}
+		log.Printf("process [%v] still running after %v. killing it...\n",
+			p.cmd.Args, *grace,
+		)
+		p.signal(syscall.SIGKILL)
+	}
+
+	for _, p := range ps {
+		<-p.done
+	}
+}
+
+// signal sends sig to the process group of p, unless p already completed.
+func (p *proc) signal(sig syscall.Signal) {
+	select {
+	case <-p.done:
+		return
+	default:
+	}
+
+	pid := p.cmd.Process.Pid
+	pgid, err := syscall.Getpgid(pid)
+	if err != nil {
+		log.Printf("could not get process group-id of [%v]: %v\n",
+			p.cmd.Args, err,
+		)
+		return
+	}
+	target := -pgid
+	if pgid == syscall.Getpgrp() {
+		// never signal our own process group.
+		target = pid
+	}
+
+	if sig == syscall.SIGTERM {
+		log.Printf("stopping process [%v] (pgid=%d)...\n", p.cmd.Args, pgid)
+	}
+	err = syscall.Kill(target, sig)
+	if err != nil {
+		log.Printf("could not send %v to process [%v]: %v\n",
+			sig, p.cmd.Args, err,
+		)
+	}
+}
+
+// exitCode returns the exit code fcs-run should use, given the error returned
+// by a child process.
+// Processes killed by a signal are reported as 128+signal, like shells do.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	if e, ok := err.(*exec.ExitError); ok {
+		if ws, ok := e.Sys().(syscall.WaitStatus); ok {
+			switch {
+			case ws.Exited():
+				return ws.ExitStatus()
+			case ws.Signaled():
+				return 128 + int(ws.Signal())
+			}
+		}
+	}
+	return 1
+}
+
+// isTerminal returns whether f is attached to a terminal.
+// Character devices such as /dev/null are not terminals: f is queried for its
+// terminal attributes instead.
+func isTerminal(f *os.File) bool {
+	var t syscall.Termios
+	_, _, errno := syscall.Syscall(
+		syscall.SYS_IOCTL,
+		f.Fd(),
+		uintptr(syscall.TCGETS),
+		uintptr(unsafe.Pointer(&t)),
+	)
+	return errno == 0
+}
+
+// tcsetpgrp makes pgrp the foreground process group of the terminal f.
+func tcsetpgrp(f *os.File, pgrp int) error {
+	// calling tcsetpgrp from a background process group raises SIGTTOU.
+	signal.Ignore(syscall.SIGTTOU)
+	defer signal.Reset(syscall.SIGTTOU)
+
+	id := int32(pgrp)
+	_, _, errno := syscall.Syscall(
+		syscall.SYS_IOCTL,
+		f.Fd(),
+		uintptr(syscall.TIOCSPGRP),
+		uintptr(unsafe.Pointer(&id)),
+	)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+// startProcs runs the shell scripts as child processes and returns the
+// channels receiving their errors.
+func startProcs(t *testing.T, scripts ...string) []chan error {
+	t.Helper()
+	var errcs []chan error
+	for _, script := range scripts {
+		errc := make(chan error, 1)
+		cmd := exec.Command("/bin/sh", "-c", script)
+		go func() {
+			errc <- runProc(cmd)
+		}()
+		errcs = append(errcs, errc)
+	}
+
+	// wait for the processes to be registered.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		procMu.Lock()
+		n := len(procs)
+		procMu.Unlock()
+		if n == len(scripts) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("processes not started: got=%d, want=%d", n, len(scripts))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// let the shells install their traps.
+	time.Sleep(200 * time.Millisecond)
+	return errcs
+}
+
+func setGrace(t *testing.T, v time.Duration) {
+	old := *grace
+	*grace = v
+	t.Cleanup(func() { *grace = old })
+}
+
+func TestExitCode(t *testing.T) {
+	for _, tc := range []struct {
+		script string
+		want   int
+	}{
+		{"exit 0", 0},
+		{"exit 3", 3},
+		{"kill -TERM $$", 128 + 15},
+		{"kill -KILL $$", 128 + 9},
+	} {
+		t.Run(tc.script, func(t *testing.T) {
+			err := runProc(exec.Command("/bin/sh", "-c", tc.script))
+			if got := exitCode(err); got != tc.want {
+				t.Fatalf("invalid exit code: got=%d, want=%d (err=%v)", got, tc.want, err)
+			}
+		})
+	}
+
+	err := runProc(exec.Command("/no/such/command"))
+	if got, want := exitCode(err), 1; got != want {
+		t.Fatalf("invalid exit code: got=%d, want=%d (err=%v)", got, want, err)
+	}
+}
+
+func TestStopProcsTerm(t *testing.T) {
+	setGrace(t, 10*time.Second)
+	errcs := startProcs(t,
+		"sleep 30",
+		"trap 'exit 7' TERM; while true; do sleep 0.05; done",
+	)
+
+	start := time.Now()
+	stopProcs()
+	if delta := time.Since(start); delta > 5*time.Second {
+		t.Fatalf("processes stopped after %v", delta)
+	}
+
+	for i, want := range []int{128 + 15, 7} {
+		if got := exitCode(<-errcs[i]); got != want {
+			t.Fatalf("proc #%d: invalid exit code: got=%d, want=%d", i, got, want)
+		}
+	}
+}
+
+func TestStopProcsKill(t *testing.T) {
+	const delay = 500 * time.Millisecond
+	setGrace(t, delay)
+	errcs := startProcs(t,
+		"trap '' TERM; sleep 30",
+		"trap '' TERM; sleep 30",
+		"trap '' TERM; sleep 30",
+	)
+
+	start := time.Now()
+	stopProcs()
+	delta := time.Since(start)
+	if delta < delay {
+		t.Fatalf("processes killed before the grace period: %v", delta)
+	}
+	if delta > 2*delay {
+		t.Fatalf("processes killed one after another: %v", delta)
+	}
+
+	for i, errc := range errcs {
+		if got, want := exitCode(<-errc), 128+9; got != want {
+			t.Fatalf("proc #%d: invalid exit code: got=%d, want=%d", i, got, want)
+		}
+	}
+
+	procMu.Lock()
+	n := len(procs)
+	procMu.Unlock()
+	if n != 0 {
+		t.Fatalf("%d processes still registered", n)
+	}
+}
+
+func TestIsTerminal(t *testing.T) {
+	f, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if isTerminal(f) {
+		t.Fatalf("%s is not a terminal", os.DevNull)
+	}
+}
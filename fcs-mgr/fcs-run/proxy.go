@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// runProxy listens for c-wrapper connections on host:port, forwards them to
+// the FCS subsystem and records the traffic into a capture file.
+func runProxy(errc chan error) {
+	if *fcsAddr == "" {
+		errc <- fmt.Errorf("proxy: missing address of the FCS subsystem (-fcs)")
+		return
+	}
+
+	f, err := os.Create(*capture)
+	if err != nil {
+		errc <- fmt.Errorf("proxy: could not create capture file: %v", err)
+		return
+	}
+	atexit(func() {
+		err := f.Close()
+		if err != nil {
+			log.Printf("proxy: error closing capture file: %v\n", err)
+		}
+	})
+
+	addr := fmt.Sprintf("%s:%d", host, port)
+	srv, err := net.Listen("tcp", addr)
+	if err != nil {
+		errc <- fmt.Errorf("proxy: could not listen on %s: %v", addr, err)
+		return
+	}
+	atexit(func() {
+		srv.Close()
+	})
+
+	log.Printf("proxy: forwarding [%s] -> [%s] (capture=%s)\n", addr, *fcsAddr, *capture)
+
+	w := NewCaptureWriter(f)
+	for i := 0; ; i++ {
+		conn, err := srv.Accept()
+		if err != nil {
+			errc <- fmt.Errorf("proxy: error accepting connection: %v", err)
+			return
+		}
+		go proxyConn(i, conn, *fcsAddr, w)
+	}
+}
+
+// proxyConn forwards the c-wrapper connection cwr to the FCS subsystem at
+// addr, recording both directions into w.
+func proxyConn(id int, cwr net.Conn, addr string, w *CaptureWriter) {
+	defer cwr.Close()
+
+	log.Printf("proxy: conn #%d: c-wrapper connected from %v\n", id, cwr.RemoteAddr())
+	fcs, err := net.Dial("tcp", addr)
+	if err != nil {
+		log.Printf("proxy: conn #%d: could not dial FCS subsystem: %v\n", id, err)
+		return
+	}
+	defer fcs.Close()
+
+	record(w, Record{Time: time.Now(), Conn: id, From: PeerCWrapper, Type: RecordOpen})
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		forward(id, fcs, cwr, PeerCWrapper, w)
+	}()
+	go func() {
+		defer wg.Done()
+		forward(id, cwr, fcs, PeerFCS, w)
+	}()
+	wg.Wait()
+
+	log.Printf("proxy: conn #%d: closed\n", id)
+}
+
+// forward copies data from src to dst until src is exhausted, recording each
+// chunk as having been sent by peer.
+func forward(id int, dst, src net.Conn, peer Peer, w *CaptureWriter) {
+	var err error
+	buf := make([]byte, 32*1024)
+	for err == nil {
+		var n int
+		n, err = src.Read(buf)
+		if n > 0 {
+			record(w, Record{
+				Time: time.Now(),
+				Conn: id,
+				From: peer,
+				Type: RecordData,
+				Data: append([]byte(nil), buf[:n]...),
+			})
+			_, werr := dst.Write(buf[:n])
+			if werr != nil {
+				err = werr
+			}
+		}
+	}
+
+	record(w, Record{Time: time.Now(), Conn: id, From: peer, Type: RecordClose})
+
+	if err != io.EOF {
+		log.Printf("proxy: conn #%d: error forwarding data from %s: %v\n", id, peer, err)
+		// unblock the other direction.
+		src.Close()
+		dst.Close()
+		return
+	}
+
+	// propagate the half-close to the other end.
+	if c, ok := dst.(*net.TCPConn); ok {
+		c.CloseWrite()
+		return
+	}
+	dst.Close()
+}
+
+func record(w *CaptureWriter, rec Record) {
+	err := w.Write(rec)
+	if err != nil {
+		log.Printf("proxy: error writing capture record: %v\n", err)
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is a complete line of the c-wrapper protocol, as reconstructed
+// from the chunks of a capture file.
+type Message struct {
+	Time time.Time
+	From Peer
+	Line string
+}
+
+// Messages reconstructs the lines exchanged over connection conn from the
+// records of a capture file.
+// Lines are timestamped with the time of the chunk which completed them.
+func Messages(recs []Record, conn int) []Message {
+	var (
+		msgs []Message
+		bufs = make(map[Peer]string)
+	)
+	for _, rec := range recs {
+		if rec.Conn != conn || rec.Type != RecordData {
+			continue
+		}
+		buf := bufs[rec.From] + string(rec.Data)
+		for {
+			i := strings.Index(buf, "\n")
+			if i < 0 {
+				break
+			}
+			msgs = append(msgs, Message{
+				Time: rec.Time,
+				From: rec.From,
+				Line: strings.TrimRight(buf[:i], "\r"),
+			})
+			buf = buf[i+1:]
+		}
+		bufs[rec.From] = buf
+	}
+	return msgs
+}
+
+// replayWindow is the number of messages replay looks ahead in the recording
+// to re-synchronize with the FCS subsystem after a divergence.
+const replayWindow = 64
+
+// runReplay impersonates the c-wrapper, replaying a capture file recorded by
+// the proxy.
+//
+// Messages sent by the c-wrapper are sent with the recorded timing. Messages
+// sent by the FCS subsystem are compared with the recorded ones and any
+// divergence is reported.
+func runReplay(errc chan error) {
+	f, err := os.Open(*capture)
+	if err != nil {
+		errc <- fmt.Errorf("replay: could not open capture file: %v", err)
+		return
+	}
+	recs, err := NewCaptureReader(f).ReadAll()
+	f.Close()
+	if err != nil {
+		errc <- fmt.Errorf("replay: could not read capture file: %v", err)
+		return
+	}
+	if len(recs) == 0 {
+		errc <- fmt.Errorf("replay: empty capture file [%s]", *capture)
+		return
+	}
+
+	var (
+		conns = Conns(recs)
+		ndiv  = 0
+		cur   net.Conn
+		mu    sync.Mutex
+	)
+	atexit(func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if cur != nil {
+			cur.Close()
+		}
+	})
+
+	// replay the connections one after another, like the c-wrapper
+	// reconnecting to the FCS subsystem.
+	for i, id := range conns {
+		msgs := Messages(recs, id)
+		log.Printf("replay: loaded %d messages of conn #%d (%d/%d) from [%s]\n",
+			len(msgs), id, i+1, len(conns), *capture,
+		)
+
+		conn, err := replayConn()
+		if err != nil {
+			errc <- fmt.Errorf("replay: conn #%d: %v", id, err)
+			return
+		}
+		mu.Lock()
+		cur = conn
+		mu.Unlock()
+
+		n := replay(conn, msgs, *timeout)
+		conn.Close()
+		if n != 0 {
+			log.Printf("replay: conn #%d: %d divergence(s) with the recording\n", id, n)
+		}
+		ndiv += n
+	}
+
+	if ndiv != 0 {
+		errc <- fmt.Errorf("replay: %d divergence(s) with the recording", ndiv)
+		return
+	}
+	log.Printf("replay: %d session(s) replayed with no divergence\n", len(conns))
+	errc <- nil
+}
+
+// Conns returns the connection numbers of the records of a capture file, in
+// the order the connections were established.
+func Conns(recs []Record) []int {
+	var (
+		conns []int
+		seen  = make(map[int]bool)
+	)
+	for _, rec := range recs {
+		if seen[rec.Conn] {
+			continue
+		}
+		seen[rec.Conn] = true
+		conns = append(conns, rec.Conn)
+	}
+	return conns
+}
+
+// replayConn returns the connection to the FCS subsystem.
+// replayConn dials the FCS subsystem, like the c-wrapper does, when its
+// address is known. Otherwise, it waits for the FCS subsystem to connect.
+func replayConn() (net.Conn, error) {
+	if *fcsAddr != "" {
+		log.Printf("replay: connecting to FCS subsystem [%s]...\n", *fcsAddr)
+		return net.Dial("tcp", *fcsAddr)
+	}
+
+	addr := fmt.Sprintf("%s:%d", host, port)
+	srv, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer srv.Close()
+
+	log.Printf("replay: waiting for FCS subsystem on [%s]...\n", addr)
+	return srv.Accept()
+}
+
+// replay plays msgs over conn and returns the number of divergences between
+// the recording and the messages received from the FCS subsystem.
+func replay(conn net.Conn, msgs []Message, timeout time.Duration) int {
+	var (
+		lines = make(chan string)
+		done  = make(chan struct{})
+	)
+	defer close(done)
+	go func() {
+		defer close(lines)
+		scan := bufio.NewScanner(conn)
+		for scan.Scan() {
+			select {
+			case lines <- strings.TrimRight(scan.Text(), "\r"):
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var (
+		ndiv = 0
+		last = time.Now()
+		prev time.Time
+	)
+	diverge := func(format string, args ...interface{}) {
+		ndiv++
+		log.Printf("replay: divergence: "+format, args...)
+	}
+
+	for i := 0; i < len(msgs); i++ {
+		msg := msgs[i]
+		if prev.IsZero() {
+			prev = msg.Time
+		}
+
+		switch msg.From {
+		case PeerCWrapper:
+			// honour the recorded delay since the previous message.
+			delay := msg.Time.Sub(prev) - time.Since(last)
+			if delay > 0 {
+				time.Sleep(delay)
+			}
+			_, err := fmt.Fprintf(conn, "%s\n", msg.Line)
+			if err != nil {
+				diverge("could not send message #%d %q: %v\n", i, msg.Line, err)
+				return ndiv
+			}
+
+		case PeerFCS:
+			var (
+				line string
+				ok   bool
+			)
+			select {
+			case line, ok = <-lines:
+				if !ok {
+					diverge("connection closed by FCS subsystem (expected message #%d %q)\n", i, msg.Line)
+					return ndiv
+				}
+			case <-time.After(timeout):
+				diverge("timeout waiting for message #%d %q\n", i, msg.Line)
+				continue
+			}
+
+			if line == msg.Line {
+				break
+			}
+
+			// try to re-synchronize with the recording.
+			j := replayFind(msgs, i+1, line)
+			if j < 0 {
+				diverge("unexpected message %q (expected message #%d %q)\n", line, i, msg.Line)
+				i-- // still expecting msg.
+				continue
+			}
+			diverge("message %q received at message #%d, expected at #%d (skipped %d messages)\n",
+				line, i, j, j-i,
+			)
+			i = j
+			msg = msgs[j]
+		}
+		prev = msg.Time
+		last = time.Now()
+	}
+
+	// the FCS subsystem should not send anything else.
+	select {
+	case line, ok := <-lines:
+		if ok {
+			diverge("unexpected message %q after end of recording\n", line)
+		}
+	case <-time.After(time.Second):
+	}
+	return ndiv
+}
+
+// replayFind returns the index of the first message from the FCS subsystem
+// equal to line, searching at most replayWindow messages from beg.
+// replayFind returns -1 if no such message could be found.
+func replayFind(msgs []Message, beg int, line string) int {
+	end := beg + replayWindow
+	if end > len(msgs) {
+		end = len(msgs)
+	}
+	for i := beg; i < end; i++ {
+		if msgs[i].From == PeerFCS && msgs[i].Line == line {
+			return i
+		}
+	}
+	return -1
+}
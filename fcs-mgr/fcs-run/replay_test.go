@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestReplayConns(t *testing.T) {
+	now := time.Now()
+	var recs []Record
+	for _, id := range []int{1, 2} {
+		recs = append(recs,
+			Record{Time: now, Conn: id, From: PeerCWrapper, Type: RecordOpen},
+			Record{Time: now, Conn: id, From: PeerCWrapper, Type: RecordData, Data: []byte(fmt.Sprintf("hello,%d\n", id))},
+			Record{Time: now, Conn: id, From: PeerFCS, Type: RecordData, Data: []byte(fmt.Sprintf("ack,%d\n", id))},
+			Record{Time: now, Conn: id, From: PeerCWrapper, Type: RecordClose},
+		)
+	}
+	if got := Conns(recs); len(got) != 2 || got[0] != 1 || got[1] != 2 {
+		t.Fatalf("invalid connections: %v", got)
+	}
+
+	buf := new(bytes.Buffer)
+	w := NewCaptureWriter(buf)
+	for _, rec := range recs {
+		err := w.Write(rec)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	fname := filepath.Join(t.TempDir(), "capture.json")
+	err := os.WriteFile(fname, buf.Bytes(), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// fake FCS subsystem, acknowledging the greeting of each connection.
+	srv, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	greets := make(chan string, 2)
+	go func() {
+		for {
+			conn, err := srv.Accept()
+			if err != nil {
+				return
+			}
+			scan := bufio.NewScanner(conn)
+			if scan.Scan() {
+				greets <- scan.Text()
+				var id int
+				fmt.Sscanf(scan.Text(), "hello,%d", &id)
+				fmt.Fprintf(conn, "ack,%d\n", id)
+			}
+			conn.Close()
+		}
+	}()
+
+	oldCapture, oldAddr := *capture, *fcsAddr
+	*capture, *fcsAddr = fname, srv.Addr().String()
+	defer func() { *capture, *fcsAddr = oldCapture, oldAddr }()
+
+	errc := make(chan error, 1)
+	runReplay(errc)
+	err = <-errc
+	if err != nil {
+		t.Fatalf("replay failed: %v", err)
+	}
+
+	for _, want := range []string{"hello,1", "hello,2"} {
+		if got := <-greets; got != want {
+			t.Fatalf("invalid greeting: got=%q, want=%q", got, want)
+		}
+	}
+}
+
+func TestReplayStopsReading(t *testing.T) {
+	conn, fcs := net.Pipe()
+	defer fcs.Close()
+	defer conn.Close()
+
+	ngo := runtime.NumGoroutine()
+	go func() {
+		// the FCS subsystem keeps talking after the end of the recording.
+		fcs.Write([]byte("ack\nextra,1\nextra,2\n"))
+	}()
+
+	now := time.Now()
+	n := replay(conn, []Message{{Time: now, From: PeerFCS, Line: "ack"}}, time.Second)
+	if n != 1 {
+		t.Fatalf("invalid number of divergences: got=%d, want=1", n)
+	}
+
+	// the goroutine reading the connection does not outlive replay.
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > ngo {
+		if time.Now().After(deadline) {
+			t.Fatalf("goroutines leaked: got=%d, want=%d", runtime.NumGoroutine(), ngo)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"time"
+)
+
+// Config describes the CANopen bus simulated by fcs-sim.
+type Config struct {
+	Nodes  []NodeConfig `json:"nodes"`
+	Script []Event      `json:"script"` // time-ordered list of scripted events
+}
+
+// NodeConfig describes a simulated CANopen node.
+type NodeConfig struct {
+	ID     uint8    `json:"id"`
+	Type   string   `json:"type"` // "adc", "motor" or "dio"
+	Ident  Identity `json:"identity"`
+	Faults Faults   `json:"faults"`
+
+	// ADC modules
+	Channels []ChannelConfig `json:"channels,omitempty"`
+
+	// motor controllers
+	Velocity float64 `json:"velocity,omitempty"` // in increments per second
+
+	// digital I/O modules
+	Inputs   []Signal `json:"inputs,omitempty"`
+	Loopback bool     `json:"loopback,omitempty"` // inputs mirror outputs
+}
+
+// ChannelConfig describes an ADC channel.
+//
+// The physical value of the channel is converted into ADC counts with:
+//
+//	count = (value - offset) / scale
+type ChannelConfig struct {
+	Name   string  `json:"name"`
+	Unit   string  `json:"unit"`
+	Scale  float64 `json:"scale"`  // physical units per ADC count
+	Offset float64 `json:"offset"` // physical value at ADC count 0
+	Signal Signal  `json:"signal"`
+}
+
+// Signal describes the time evolution of a simulated quantity.
+type Signal struct {
+	Kind      string   `json:"kind"`      // "const", "ramp", "sine" or "steps"
+	Value     float64  `json:"value"`     // constant, initial or mean value
+	Slope     float64  `json:"slope"`     // ramp slope, per second
+	Amplitude float64  `json:"amplitude"` // sine amplitude
+	Period    Duration `json:"period"`    // sine period
+	Steps     []Step   `json:"steps"`     // piecewise constant values
+	Noise     float64  `json:"noise"`     // standard deviation of the gaussian noise
+	Stuck     Duration `json:"stuck"`     // if non-zero, signal is frozen from that time on
+}
+
+// Step is a value of a piecewise constant signal, starting at time At.
+type Step struct {
+	At    Duration `json:"at"`
+	Value float64  `json:"value"`
+}
+
+// At returns the value of the signal at time t.
+func (sig Signal) At(t time.Duration, rnd *rand.Rand) float64 {
+	if sig.Stuck.Duration > 0 && t > sig.Stuck.Duration {
+		t = sig.Stuck.Duration
+		rnd = nil
+	}
+
+	v := sig.Value
+	switch sig.Kind {
+	case "", "const":
+	case "ramp":
+		v += sig.Slope * t.Seconds()
+	case "sine":
+		if sig.Period.Duration > 0 {
+			v += sig.Amplitude * math.Sin(2*math.Pi*t.Seconds()/sig.Period.Seconds())
+		}
+	case "steps":
+		for _, step := range sig.Steps {
+			if t < step.At.Duration {
+				break
+			}
+			v = step.Value
+		}
+	}
+	if sig.Noise > 0 && rnd != nil {
+		v += rnd.NormFloat64() * sig.Noise
+	}
+	return v
+}
+
+// Faults describes the faults injected into a simulated node.
+type Faults struct {
+	Delay  Duration          `json:"delay"`  // latency added to every answer
+	Drop   float64           `json:"drop"`   // probability for a command to time out
+	Silent bool              `json:"silent"` // node never answers: all commands time out
+	Aborts map[string]uint32 `json:"aborts"` // SDO abort codes, indexed by "<index>:<subindex>"
+}
+
+func (f Faults) abort(index uint16, sub uint8) (uint32, bool) {
+	if len(f.Aborts) == 0 {
+		return 0, false
+	}
+	code, ok := f.Aborts[Key{index, sub}.String()]
+	return code, ok
+}
+
+// Event is a scripted event.
+type Event struct {
+	At   Duration `json:"at"`
+	Node uint8    `json:"node"`
+	Kind string   `json:"kind"` // "write", "emcy", "boot" or "disconnect"
+
+	// write events
+	Index    uint16 `json:"index"`
+	SubIndex uint8  `json:"subindex"`
+	Value    uint32 `json:"value"`
+
+	// emcy events
+	Code     uint16 `json:"code"`
+	Register uint8  `json:"register"`
+}
+
+// Duration is a time.Duration encoded as a string in JSON (e.g. "1m30s").
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return err
+	}
+	d.Duration, err = time.ParseDuration(s)
+	return err
+}
+
+// loadConfig loads the configuration of the simulation from fname.
+func loadConfig(fname string) (Config, error) {
+	var cfg Config
+	f, err := os.Open(fname)
+	if err != nil {
+		return cfg, err
+	}
+	defer f.Close()
+
+	err = json.NewDecoder(f).Decode(&cfg)
+	if err != nil {
+		return cfg, fmt.Errorf("error decoding config file [%s]: %v", fname, err)
+	}
+	return cfg, nil
+}
+
+// defaultConfig returns a configuration modelled after the LPC test bench:
+// an ADC module reading temperature, pressure and hygrometry, a motor
+// controller and a digital I/O module.
+func defaultConfig() Config {
+	return Config{
+		Nodes: []NodeConfig{
+			{
+				ID:   0x23,
+				Type: "adc",
+				Channels: []ChannelConfig{
+					{
+						// ADC: [0; 0xFFFF) -> -10.24V;10.24V -> -20C; 80C;
+						Name:   "temperature",
+						Unit:   "C",
+						Scale:  0.3125e-3 * 10.0,
+						Offset: -20,
+						Signal: Signal{Kind: "sine", Value: 22, Amplitude: 0.5, Period: Duration{time.Hour}, Noise: 0.02},
+					},
+					{
+						// ADC: [0; 0xFFFF) -> -10.24V;10.24V -> 600mbar; 1100mbar;
+						Name:   "pressure",
+						Unit:   "mbar",
+						Scale:  0.3125e-3 * 50.0,
+						Offset: 600,
+						Signal: Signal{Kind: "const", Value: 1013, Noise: 0.1},
+					},
+					{
+						// ADC: [0; 0xFFFF) -> -10.24V;10.24V -> 0%; 100%;
+						Name:   "hygrometry",
+						Unit:   "%",
+						Scale:  0.3125e-3 * 10.0,
+						Offset: 0,
+						Signal: Signal{Kind: "const", Value: 30, Noise: 0.05},
+					},
+				},
+			},
+			{
+				ID:       0x01,
+				Type:     "motor",
+				Velocity: 1000,
+			},
+			{
+				ID:       0x02,
+				Type:     "dio",
+				Inputs:   make([]Signal, 8),
+				Loopback: true,
+			},
+		},
+	}
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// newDevice creates the simulated device described by cfg.
+func newDevice(cfg NodeConfig) (Device, Identity, error) {
+	ident := cfg.Ident
+	switch cfg.Type {
+	case "adc":
+		if ident.DeviceType == 0 {
+			ident.DeviceType = 0x00040191 // CiA 401, analog inputs
+		}
+		return newADC(cfg), ident, nil
+	case "motor":
+		if ident.DeviceType == 0 {
+			ident.DeviceType = 0x00020192 // CiA 402, servo drive
+		}
+		return newMotor(cfg), ident, nil
+	case "dio":
+		if ident.DeviceType == 0 {
+			ident.DeviceType = 0x00030191 // CiA 401, digital inputs/outputs
+		}
+		return newDIO(cfg), ident, nil
+	}
+	return nil, ident, fmt.Errorf("unknown device type %q", cfg.Type)
+}
+
+// adc simulates an analog input module (CiA 401).
+//
+// For each channel i (sub-index i+1):
+//
+//	0x6401: corrected value (int16)
+//	0x6404: raw value (int16)
+//	0x2100: accuracy (uint8)
+//	0x2101: averaging (uint8)
+//	0x6431: offset (int32, 1/0x10000 ADC count)
+//	0x6432: gain (int32, 0x10000 is unity)
+type adc struct {
+	chans []adcChannel
+	rnd   *rand.Rand
+}
+
+type adcChannel struct {
+	cfg    ChannelConfig
+	acc    uint32
+	avg    uint32
+	offset int32
+	gain   int32
+}
+
+func newADC(cfg NodeConfig) *adc {
+	dev := &adc{
+		chans: make([]adcChannel, len(cfg.Channels)),
+		rnd:   rand.New(rand.NewSource(int64(cfg.ID))),
+	}
+	for i, ch := range cfg.Channels {
+		dev.chans[i] = adcChannel{cfg: ch, acc: 3, avg: 4, gain: 0x10000}
+	}
+	return dev
+}
+
+func (dev *adc) raw(i int, t time.Duration) int16 {
+	ch := dev.chans[i].cfg
+	scale := ch.Scale
+	if scale == 0 {
+		scale = 1
+	}
+	v := math.Floor((ch.Signal.At(t, dev.rnd)-ch.Offset)/scale + 0.5)
+	switch {
+	case v > math.MaxInt16:
+		v = math.MaxInt16
+	case v < math.MinInt16:
+		v = math.MinInt16
+	}
+	return int16(v)
+}
+
+func (dev *adc) value(i int, t time.Duration) int16 {
+	ch := dev.chans[i]
+	v := (int64(dev.raw(i, t))*int64(ch.gain) + int64(ch.offset)) >> 16
+	switch {
+	case v > math.MaxInt16:
+		v = math.MaxInt16
+	case v < math.MinInt16:
+		v = math.MinInt16
+	}
+	return int16(v)
+}
+
+func (dev *adc) Objects() map[Key]*Object {
+	n := uint32(len(dev.chans))
+	objs := make(map[Key]*Object)
+	for _, index := range []uint16{0x6401, 0x6404, 0x2100, 0x2101, 0x6431, 0x6432} {
+		objs[Key{index, 0}] = &Object{Size: 1, Value: n, RO: true}
+	}
+	for i := range dev.chans {
+		i := i
+		ch := &dev.chans[i]
+		sub := uint8(i + 1)
+		objs[Key{0x6401, sub}] = &Object{
+			Size: 2, RO: true,
+			Read: func(t time.Duration) uint32 { return uint32(uint16(dev.value(i, t))) },
+		}
+		objs[Key{0x6404, sub}] = &Object{
+			Size: 2, RO: true,
+			Read: func(t time.Duration) uint32 { return uint32(uint16(dev.raw(i, t))) },
+		}
+		objs[Key{0x2100, sub}] = &Object{
+			Size:  1,
+			Read:  func(time.Duration) uint32 { return ch.acc },
+			Write: func(_ time.Duration, v uint32) error { ch.acc = v & 0xff; return nil },
+		}
+		objs[Key{0x2101, sub}] = &Object{
+			Size:  1,
+			Read:  func(time.Duration) uint32 { return ch.avg },
+			Write: func(_ time.Duration, v uint32) error { ch.avg = v & 0xff; return nil },
+		}
+		objs[Key{0x6431, sub}] = &Object{
+			Size:  4,
+			Read:  func(time.Duration) uint32 { return uint32(ch.offset) },
+			Write: func(_ time.Duration, v uint32) error { ch.offset = int32(v); return nil },
+		}
+		objs[Key{0x6432, sub}] = &Object{
+			Size: 4,
+			Read: func(time.Duration) uint32 { return uint32(ch.gain) },
+			Write: func(_ time.Duration, v uint32) error {
+				if v == 0 {
+					return fmt.Errorf("invalid null gain")
+				}
+				ch.gain = int32(v)
+				return nil
+			},
+		}
+	}
+	return objs
+}
+
+func (dev *adc) Update(t time.Duration) {}
+
+// PDO returns the corrected values of the first 4 channels.
+func (dev *adc) PDO(t time.Duration) []byte {
+	n := len(dev.chans)
+	if n > 4 {
+		n = 4
+	}
+	buf := make([]byte, 2*n)
+	for i := 0; i < n; i++ {
+		binary.LittleEndian.PutUint16(buf[2*i:], uint16(dev.value(i, t)))
+	}
+	return buf
+}
+
+// motor simulates a motor controller (CiA 402) in profile position mode.
+//
+//	0x6040: controlword (uint16)
+//	0x6041: statusword (uint16)
+//	0x6060: modes of operation (int8)
+//	0x6061: modes of operation display (int8)
+//	0x6064: position actual value (int32)
+//	0x607a: target position (int32)
+//	0x6081: profile velocity (uint32, increments per second)
+type motor struct {
+	ctrl   uint16
+	mode   uint32
+	pos    float64
+	target int32
+	vel    uint32
+	moving bool
+	last   time.Duration
+}
+
+func newMotor(cfg NodeConfig) *motor {
+	vel := cfg.Velocity
+	if vel <= 0 {
+		vel = 1000
+	}
+	return &motor{mode: 1, vel: uint32(vel)}
+}
+
+func (dev *motor) enabled() bool {
+	return dev.ctrl&0x0f == 0x0f
+}
+
+func (dev *motor) status() uint16 {
+	var sw uint16
+	switch {
+	case dev.ctrl&0x0f == 0x0f:
+		sw = 0x0027 // operation enabled
+	case dev.ctrl&0x07 == 0x07:
+		sw = 0x0023 // switched on
+	case dev.ctrl&0x07 == 0x06:
+		sw = 0x0021 // ready to switch on
+	default:
+		sw = 0x0040 // switch on disabled
+	}
+	if !dev.moving {
+		sw |= 0x0400 // target reached
+	}
+	return sw
+}
+
+func (dev *motor) Objects() map[Key]*Object {
+	return map[Key]*Object{
+		{0x6040, 0}: {
+			Size: 2,
+			Read: func(time.Duration) uint32 { return uint32(dev.ctrl) },
+			Write: func(t time.Duration, v uint32) error {
+				prev := dev.ctrl
+				dev.ctrl = uint16(v)
+				if dev.enabled() && prev&0x10 == 0 && dev.ctrl&0x10 != 0 {
+					dev.moving = true // new set-point
+					dev.last = t
+				}
+				return nil
+			},
+		},
+		{0x6041, 0}: {
+			Size: 2, RO: true,
+			Read: func(time.Duration) uint32 { return uint32(dev.status()) },
+		},
+		{0x6060, 0}: {
+			Size: 1,
+			Read: func(time.Duration) uint32 { return dev.mode },
+			Write: func(_ time.Duration, v uint32) error {
+				dev.mode = v & 0xff
+				return nil
+			},
+		},
+		{0x6061, 0}: {
+			Size: 1, RO: true,
+			Read: func(time.Duration) uint32 { return dev.mode },
+		},
+		{0x6064, 0}: {
+			Size: 4, RO: true,
+			Read: func(time.Duration) uint32 { return uint32(int32(dev.pos)) },
+		},
+		{0x607a, 0}: {
+			Size: 4,
+			Read: func(time.Duration) uint32 { return uint32(dev.target) },
+			Write: func(_ time.Duration, v uint32) error {
+				dev.target = int32(v)
+				return nil
+			},
+		},
+		{0x6081, 0}: {
+			Size: 4,
+			Read: func(time.Duration) uint32 { return dev.vel },
+			Write: func(_ time.Duration, v uint32) error {
+				dev.vel = v
+				return nil
+			},
+		},
+	}
+}
+
+func (dev *motor) Update(t time.Duration) {
+	defer func() { dev.last = t }()
+	if !dev.moving || !dev.enabled() {
+		return
+	}
+	step := float64(dev.vel) * (t - dev.last).Seconds()
+	delta := float64(dev.target) - dev.pos
+	if math.Abs(delta) <= step {
+		dev.pos = float64(dev.target)
+		dev.moving = false
+		return
+	}
+	dev.pos += math.Copysign(step, delta)
+}
+
+// PDO returns the statusword and the actual position.
+func (dev *motor) PDO(t time.Duration) []byte {
+	buf := make([]byte, 6)
+	binary.LittleEndian.PutUint16(buf[0:], dev.status())
+	binary.LittleEndian.PutUint32(buf[2:], uint32(int32(dev.pos)))
+	return buf
+}
+
+// dio simulates a digital inputs/outputs module (CiA 401).
+//
+//	0x6000: digital inputs, 8 per sub-index (uint8)
+//	0x6200: digital outputs, 8 per sub-index (uint8)
+type dio struct {
+	inputs   []Signal
+	outputs  []uint8
+	loopback bool
+}
+
+func newDIO(cfg NodeConfig) *dio {
+	n := (len(cfg.Inputs) + 7) / 8
+	if n == 0 {
+		n = 1
+	}
+	return &dio{
+		inputs:   cfg.Inputs,
+		outputs:  make([]uint8, n),
+		loopback: cfg.Loopback,
+	}
+}
+
+func (dev *dio) input(i int, t time.Duration) uint8 {
+	if dev.loopback {
+		return dev.outputs[i]
+	}
+	var v uint8
+	for j := 0; j < 8; j++ {
+		k := 8*i + j
+		if k >= len(dev.inputs) {
+			break
+		}
+		if dev.inputs[k].At(t, nil) > 0.5 {
+			v |= 1 << uint(j)
+		}
+	}
+	return v
+}
+
+func (dev *dio) Objects() map[Key]*Object {
+	n := uint32(len(dev.outputs))
+	objs := map[Key]*Object{
+		{0x6000, 0}: {Size: 1, Value: n, RO: true},
+		{0x6200, 0}: {Size: 1, Value: n, RO: true},
+	}
+	for i := range dev.outputs {
+		i := i
+		sub := uint8(i + 1)
+		objs[Key{0x6000, sub}] = &Object{
+			Size: 1, RO: true,
+			Read: func(t time.Duration) uint32 { return uint32(dev.input(i, t)) },
+		}
+		objs[Key{0x6200, sub}] = &Object{
+			Size: 1,
+			Read: func(time.Duration) uint32 { return uint32(dev.outputs[i]) },
+			Write: func(_ time.Duration, v uint32) error {
+				dev.outputs[i] = uint8(v)
+				return nil
+			},
+		}
+	}
+	return objs
+}
+
+func (dev *dio) Update(t time.Duration) {}
+
+// PDO returns the digital inputs.
+func (dev *dio) PDO(t time.Duration) []byte {
+	buf := make([]byte, len(dev.outputs))
+	for i := range buf {
+		buf[i] = dev.input(i, t)
+	}
+	return buf
+}
@@ -0,0 +1,425 @@
+// fcs-sim simulates the PC-104 c-wrapper and the CANopen nodes it drives.
+//
+// fcs-sim speaks the c-wrapper TCP protocol so the FCS subsystem can be run
+// end to end without any hardware. The simulated nodes, their behaviour and
+// the faults to inject are described in a JSON configuration file.
+//
+// ex:
+//
+//	$ fcs-sim -addr=127.0.0.1:50000
+//	$ fcs-sim -addr=127.0.0.1:50000 -config=bench.json
+//	$ fcs-sim -listen=:50000
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sbinet/lsst-ccs/fcs-mgr/cwrapper"
+)
+
+var (
+	addr    = flag.String("addr", "127.0.0.1:50000", "<ip>:<port> of the FCS subsystem to connect to")
+	listen  = flag.String("listen", "", "<ip>:<port> to listen to, instead of connecting to the FCS subsystem")
+	config  = flag.String("config", "", "path to the JSON description of the simulated nodes (default: LPC test bench)")
+	retry   = flag.Duration("retry", 2*time.Second, "delay between attempts to connect to the FCS subsystem")
+	verbose = flag.Bool("v", false, "enable verbose mode")
+)
+
+func main() {
+	flag.Parse()
+
+	cfg := defaultConfig()
+	if *config != "" {
+		var err error
+		cfg, err = loadConfig(*config)
+		if err != nil {
+			log.Fatalf("could not load configuration: %v\n", err)
+		}
+	}
+
+	sim, err := newSimulator(cfg)
+	if err != nil {
+		log.Fatalf("could not create simulator: %v\n", err)
+	}
+	go sim.runScript(cfg.Script)
+
+	switch *listen {
+	case "":
+		err = sim.dial(*addr)
+	default:
+		err = sim.listen(*listen)
+	}
+	if err != nil {
+		log.Fatalf("error: %v\n", err)
+	}
+}
+
+// Simulator simulates a c-wrapper and its CANopen bus.
+type Simulator struct {
+	mu    sync.Mutex
+	start time.Time
+	nodes map[uint8]*Node
+	ids   []uint8 // sorted node IDs
+	rnd   *rand.Rand
+	conns map[*cwrapper.Conn]struct{}
+}
+
+func newSimulator(cfg Config) (*Simulator, error) {
+	sim := &Simulator{
+		start: time.Now(),
+		nodes: make(map[uint8]*Node, len(cfg.Nodes)),
+		rnd:   rand.New(rand.NewSource(time.Now().UnixNano())),
+		conns: make(map[*cwrapper.Conn]struct{}),
+	}
+	for _, n := range cfg.Nodes {
+		if n.ID == cwrapper.Broadcast || n.ID > 127 {
+			return nil, fmt.Errorf("invalid node ID %d", n.ID)
+		}
+		if _, dup := sim.nodes[n.ID]; dup {
+			return nil, fmt.Errorf("duplicate node ID %d", n.ID)
+		}
+		dev, ident, err := newDevice(n)
+		if err != nil {
+			return nil, fmt.Errorf("node %d: %v", n.ID, err)
+		}
+		sim.nodes[n.ID] = newNode(n.ID, n.Type, ident, dev, n.Faults)
+		sim.ids = append(sim.ids, n.ID)
+		log.Printf("node 0x%02x: %s\n", n.ID, n.Type)
+	}
+	sort.Slice(sim.ids, func(i, j int) bool { return sim.ids[i] < sim.ids[j] })
+	return sim, nil
+}
+
+// now returns the simulation time.
+func (sim *Simulator) now() time.Duration {
+	return time.Since(sim.start)
+}
+
+// dial connects to the FCS subsystem at addr, like the c-wrapper does.
+// dial reconnects whenever the connection is lost, until a quit command is
+// received.
+func (sim *Simulator) dial(addr string) error {
+	for {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			log.Printf("could not connect to FCS subsystem [%s]: %v\n", addr, err)
+			time.Sleep(*retry)
+			continue
+		}
+		log.Printf("connected to FCS subsystem [%s]\n", addr)
+		if sim.serve(conn) {
+			return nil
+		}
+		time.Sleep(*retry)
+	}
+}
+
+// listen waits for connections on addr and serves them.
+func (sim *Simulator) listen(addr string) error {
+	srv, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer srv.Close()
+
+	log.Printf("listening on [%s]...\n", addr)
+	for {
+		conn, err := srv.Accept()
+		if err != nil {
+			return err
+		}
+		log.Printf("connection from [%v]\n", conn.RemoteAddr())
+		go sim.serve(conn)
+	}
+}
+
+// send sends msg over c.
+func send(c *cwrapper.Conn, msg cwrapper.Message) error {
+	if *verbose {
+		log.Printf("<<< %s\n", cwrapper.Encode(msg))
+	}
+	return c.Send(msg)
+}
+
+// broadcast sends a message to all connected clients.
+func (sim *Simulator) broadcast(msg cwrapper.Message) {
+	sim.mu.Lock()
+	conns := make([]*cwrapper.Conn, 0, len(sim.conns))
+	for c := range sim.conns {
+		conns = append(conns, c)
+	}
+	sim.mu.Unlock()
+
+	for _, c := range conns {
+		err := send(c, msg)
+		if err != nil {
+			log.Printf("error sending message: %v\n", err)
+		}
+	}
+}
+
+// serve handles the commands sent over conn.
+// serve returns whether the connection was closed by a quit command.
+func (sim *Simulator) serve(conn net.Conn) bool {
+	c := cwrapper.NewConn(conn)
+	defer c.Close()
+
+	sim.mu.Lock()
+	sim.conns[c] = struct{}{}
+	sim.mu.Unlock()
+	defer func() {
+		sim.mu.Lock()
+		delete(sim.conns, c)
+		sim.mu.Unlock()
+	}()
+
+	for _, id := range sim.ids {
+		if sim.nodes[id].Faults.Silent {
+			continue
+		}
+		send(c, cwrapper.Boot{Node: id})
+	}
+
+	err := cwrapper.Serve(c, cwrapper.HandlerFunc(sim.handle))
+	switch err {
+	case nil:
+		log.Printf("received quit command\n")
+		return true
+	case io.EOF:
+		log.Printf("connection closed\n")
+	default:
+		log.Printf("connection error: %v\n", err)
+	}
+	return false
+}
+
+// abortTimeout is the SDO abort code sent for nodes which do not answer.
+const abortTimeout = 0x05040000
+
+// handle executes cmd and sends the answer to c.
+func (sim *Simulator) handle(c *cwrapper.Conn, cmd cwrapper.Message) error {
+	if *verbose {
+		log.Printf(">>> %s\n", cwrapper.Encode(cmd))
+	}
+
+	var id uint8
+	switch cmd := cmd.(type) {
+	case cwrapper.Sync:
+		return sim.sync(c)
+	case cwrapper.NMT:
+		return sim.nmt(c, cmd)
+	case cwrapper.ReadSDO:
+		id = cmd.Node
+	case cwrapper.WriteSDO:
+		id = cmd.Node
+	case cwrapper.Info:
+		id = cmd.Node
+	default:
+		return send(c, cwrapper.Error{
+			Code: cwrapper.ErrUnknownCommand,
+			Msg:  fmt.Sprintf("unhandled command %q", cmd.Name()),
+		})
+	}
+
+	sim.mu.Lock()
+	node, ok := sim.nodes[id]
+	sim.mu.Unlock()
+	if !ok || !sim.answer(node) {
+		switch cmd := cmd.(type) {
+		case cwrapper.ReadSDO:
+			return send(c, cwrapper.ReadSDOReply{
+				Node: id, Index: cmd.Index, SubIndex: cmd.SubIndex,
+				Abort: abortTimeout,
+			})
+		case cwrapper.WriteSDO:
+			return send(c, cwrapper.WriteSDOReply{
+				Node: id, Index: cmd.Index, SubIndex: cmd.SubIndex,
+				Abort: abortTimeout,
+			})
+		default:
+			return send(c, cwrapper.Error{
+				Code: cwrapper.ErrUnknownNode,
+				Msg:  fmt.Sprintf("unknown node %x", id),
+			})
+		}
+	}
+
+	sim.mu.Lock()
+	t := sim.now()
+	var reply cwrapper.Message
+	switch cmd := cmd.(type) {
+	case cwrapper.ReadSDO:
+		v, abort := node.Read(t, cmd.Index, cmd.SubIndex)
+		reply = cwrapper.ReadSDOReply{
+			Node: id, Index: cmd.Index, SubIndex: cmd.SubIndex,
+			Abort: abort, Data: v,
+		}
+	case cwrapper.WriteSDO:
+		abort := node.Write(t, cmd.Index, cmd.SubIndex, cmd.Data)
+		reply = cwrapper.WriteSDOReply{
+			Node: id, Index: cmd.Index, SubIndex: cmd.SubIndex,
+			Abort: abort,
+		}
+	case cwrapper.Info:
+		ident := node.Ident
+		reply = cwrapper.InfoReply{
+			Node:       id,
+			DeviceType: ident.DeviceType,
+			Vendor:     ident.Vendor,
+			Product:    ident.Product,
+			Revision:   ident.Revision,
+			Serial:     ident.Serial,
+		}
+	}
+	sim.mu.Unlock()
+	return send(c, reply)
+}
+
+// answer applies the faults of node before an answer is sent.
+// answer returns whether the node should answer at all.
+func (sim *Simulator) answer(node *Node) bool {
+	faults := node.Faults
+	if faults.Silent {
+		return false
+	}
+	if faults.Drop > 0 {
+		sim.mu.Lock()
+		drop := sim.rnd.Float64() < faults.Drop
+		sim.mu.Unlock()
+		if drop {
+			return false
+		}
+	}
+	if faults.Delay.Duration > 0 {
+		time.Sleep(faults.Delay.Duration)
+	}
+	return true
+}
+
+// sync sends the PDOs of all the operational nodes.
+func (sim *Simulator) sync(c *cwrapper.Conn) error {
+	sim.mu.Lock()
+	t := sim.now()
+	var pdos []cwrapper.PDO
+	for _, id := range sim.ids {
+		node := sim.nodes[id]
+		if node.Faults.Silent {
+			continue
+		}
+		data, ok := node.PDO(t)
+		if !ok {
+			continue
+		}
+		pdos = append(pdos, cwrapper.PDO{COBID: node.COBID(), Data: data})
+	}
+	sim.mu.Unlock()
+
+	for _, pdo := range pdos {
+		err := send(c, pdo)
+		if err != nil {
+			return err
+		}
+	}
+	return send(c, cwrapper.SyncReply{N: len(pdos)})
+}
+
+// nmt applies the NMT command cmd to a node (or all nodes for
+// cwrapper.Broadcast.)
+func (sim *Simulator) nmt(c *cwrapper.Conn, cmd cwrapper.NMT) error {
+	ids := []uint8{cmd.Node}
+	if cmd.Node == cwrapper.Broadcast {
+		ids = sim.ids
+	}
+
+	var boots []uint8
+	sim.mu.Lock()
+	for _, id := range ids {
+		node, ok := sim.nodes[id]
+		if !ok {
+			sim.mu.Unlock()
+			return send(c, cwrapper.NMTReply{Cmd: cmd.Cmd, Node: id, Err: cwrapper.ErrUnknownNode})
+		}
+		switch cmd.Cmd {
+		case cwrapper.NMTStart:
+			node.State = Operational
+		case cwrapper.NMTStop:
+			node.State = Stopped
+		case cwrapper.NMTPreOperational:
+			node.State = PreOperational
+		case cwrapper.NMTResetNode, cwrapper.NMTResetComm:
+			node.State = PreOperational
+			if !node.Faults.Silent {
+				boots = append(boots, id)
+			}
+		}
+	}
+	sim.mu.Unlock()
+
+	err := send(c, cwrapper.NMTReply{Cmd: cmd.Cmd, Node: cmd.Node})
+	if err != nil {
+		return err
+	}
+	for _, id := range boots {
+		sim.broadcast(cwrapper.Boot{Node: id})
+	}
+	return nil
+}
+
+// runScript runs the scripted events.
+func (sim *Simulator) runScript(evts []Event) {
+	sort.SliceStable(evts, func(i, j int) bool {
+		return evts[i].At.Duration < evts[j].At.Duration
+	})
+
+	for _, evt := range evts {
+		time.Sleep(evt.At.Duration - sim.now())
+		log.Printf("script: %s event on node 0x%02x\n", evt.Kind, evt.Node)
+
+		switch evt.Kind {
+		case "write":
+			sim.mu.Lock()
+			node, ok := sim.nodes[evt.Node]
+			if ok {
+				abort := node.Write(sim.now(), evt.Index, evt.SubIndex, evt.Value)
+				if abort != 0 {
+					log.Printf("script: could not write 0x%x to %v: abort=0x%x\n",
+						evt.Value, Key{evt.Index, evt.SubIndex}, abort,
+					)
+				}
+			}
+			sim.mu.Unlock()
+			if !ok {
+				log.Printf("script: unknown node 0x%02x\n", evt.Node)
+			}
+
+		case "emcy":
+			sim.broadcast(cwrapper.Emcy{Node: evt.Node, Code: evt.Code, Register: evt.Register})
+
+		case "boot":
+			sim.mu.Lock()
+			if node, ok := sim.nodes[evt.Node]; ok {
+				node.State = PreOperational
+			}
+			sim.mu.Unlock()
+			sim.broadcast(cwrapper.Boot{Node: evt.Node})
+
+		case "disconnect":
+			sim.mu.Lock()
+			for c := range sim.conns {
+				c.Close()
+			}
+			sim.mu.Unlock()
+
+		default:
+			log.Printf("script: unknown event kind %q\n", evt.Kind)
+		}
+	}
+}
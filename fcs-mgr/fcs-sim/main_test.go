@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sbinet/lsst-ccs/fcs-mgr/calib"
+	"github.com/sbinet/lsst-ccs/fcs-mgr/cwrapper"
+)
+
+// newTestClient returns a client connected to a simulator of the nodes
+// described by cfg.
+func newTestClient(t *testing.T, cfg Config) (*cwrapper.Client, *Simulator) {
+	t.Helper()
+	sim, err := newSimulator(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cli, srv := net.Pipe()
+	go cwrapper.Serve(cwrapper.NewConn(srv), cwrapper.HandlerFunc(sim.handle))
+	c := cwrapper.NewClient(cli, nil)
+	c.Timeout = time.Second
+	t.Cleanup(func() { cli.Close(); srv.Close() })
+	return c, sim
+}
+
+// testConfig returns the default configuration, without noise so the ADC
+// counts can be predicted.
+func testConfig() Config {
+	cfg := defaultConfig()
+	for i := range cfg.Nodes[0].Channels {
+		sig := &cfg.Nodes[0].Channels[i].Signal
+		sig.Kind = "const"
+		sig.Noise = 0
+	}
+	cfg.Nodes[0].Faults.Aborts = map[string]uint32{"2101:3": abortGeneralError}
+	return cfg
+}
+
+func abortCode(t *testing.T, err error) uint32 {
+	t.Helper()
+	e, ok := err.(*cwrapper.AbortError)
+	if !ok {
+		t.Fatalf("expected an abort error, got %v", err)
+	}
+	return e.Code
+}
+
+func TestSDO(t *testing.T) {
+	c, _ := newTestClient(t, testConfig())
+
+	ident, err := c.Info(0x23)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ident.Node != 0x23 || ident.DeviceType != 0x00040191 {
+		t.Fatalf("invalid identity: %+v", ident)
+	}
+	v, err := c.ReadSDO(0x23, 0x1000, 0)
+	if err != nil || v != 0x00040191 {
+		t.Fatalf("invalid device type: 0x%x (err=%v)", v, err)
+	}
+
+	// read-write object.
+	v, err = c.ReadSDO(0x23, 0x2101, 1)
+	if err != nil || v != 4 {
+		t.Fatalf("invalid averaging: %d (err=%v)", v, err)
+	}
+	err = c.WriteSDO(0x23, 0x2101, 1, 1, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err = c.ReadSDO(0x23, 0x2101, 1)
+	if err != nil || v != 8 {
+		t.Fatalf("invalid averaging: %d (err=%v)", v, err)
+	}
+
+	// loopback of the digital I/O module.
+	err = c.WriteSDO(0x02, 0x6200, 1, 1, 0xa5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err = c.ReadSDO(0x02, 0x6000, 1)
+	if err != nil || v != 0xa5 {
+		t.Fatalf("invalid inputs: 0x%x (err=%v)", v, err)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		write bool
+		node  uint8
+		index uint16
+		sub   uint8
+		data  uint32
+		code  uint32
+	}{
+		{"read only", true, 0x23, 0x6401, 1, 0, abortReadOnly},
+		{"no object", false, 0x23, 0x7000, 0, 0, abortNoObject},
+		{"no sub-index", false, 0x23, 0x6401, 9, 0, abortNoSubIndex},
+		{"invalid value", true, 0x23, 0x6432, 1, 0, abortValueRange},
+		{"injected abort", false, 0x23, 0x2101, 3, 0, abortGeneralError},
+		{"unknown node read", false, 0x42, 0x1000, 0, 0, abortTimeout},
+		{"unknown node write", true, 0x42, 0x6200, 1, 1, abortTimeout},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var err error
+			if tc.write {
+				err = c.WriteSDO(tc.node, tc.index, tc.sub, 4, tc.data)
+			} else {
+				_, err = c.ReadSDO(tc.node, tc.index, tc.sub)
+			}
+			if code := abortCode(t, err); code != tc.code {
+				t.Fatalf("invalid abort code: got=0x%08x, want=0x%08x", code, tc.code)
+			}
+		})
+	}
+
+	_, err = c.Info(0x42)
+	if e, ok := err.(cwrapper.Error); !ok || e.Code != cwrapper.ErrUnknownNode {
+		t.Fatalf("expected an unknown node error, got %v", err)
+	}
+}
+
+func TestNMT(t *testing.T) {
+	c, sim := newTestClient(t, testConfig())
+
+	state := func(id uint8) State {
+		sim.mu.Lock()
+		defer sim.mu.Unlock()
+		return sim.nodes[id].State
+	}
+
+	for _, id := range sim.ids {
+		if s := state(id); s != PreOperational {
+			t.Fatalf("node 0x%02x: invalid initial state %v", id, s)
+		}
+	}
+
+	err := c.NMT(cwrapper.NMTStart, cwrapper.Broadcast)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range sim.ids {
+		if s := state(id); s != Operational {
+			t.Fatalf("node 0x%02x: invalid state %v", id, s)
+		}
+	}
+
+	err = c.NMT(cwrapper.NMTStop, 0x01)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := state(0x01); s != Stopped {
+		t.Fatalf("invalid state %v", s)
+	}
+	if s := state(0x23); s != Operational {
+		t.Fatalf("invalid state %v", s)
+	}
+	_, err = c.ReadSDO(0x01, 0x6041, 0)
+	if code := abortCode(t, err); code != abortDeviceState {
+		t.Fatalf("invalid abort code: 0x%08x", code)
+	}
+
+	err = c.NMT(cwrapper.NMTResetNode, 0x01)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := state(0x01); s != PreOperational {
+		t.Fatalf("invalid state %v", s)
+	}
+
+	err = c.NMT(cwrapper.NMTStart, 0x42)
+	if err == nil {
+		t.Fatalf("expected an error for an unknown node")
+	}
+}
+
+func TestSync(t *testing.T) {
+	cfg := testConfig()
+	c, _ := newTestClient(t, cfg)
+
+	// only operational nodes send PDOs.
+	pdos, err := c.Sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pdos) != 0 {
+		t.Fatalf("expected no PDOs, got %d", len(pdos))
+	}
+
+	err = c.NMT(cwrapper.NMTStart, 0x23)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.NMT(cwrapper.NMTStart, 0x02)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.WriteSDO(0x02, 0x6200, 1, 1, 0x3c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pdos, err = c.Sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pdos) != 2 {
+		t.Fatalf("invalid number of PDOs: %d", len(pdos))
+	}
+	if pdo := pdos[0]; pdo.COBID != 0x182 || len(pdo.Data) != 1 || pdo.Data[0] != 0x3c {
+		t.Fatalf("invalid digital I/O PDO: %+v", pdo)
+	}
+
+	// ADC counts are converted back to physical values like fcs-ana does.
+	pdo := pdos[1]
+	if pdo.COBID != 0x1a3 || pdo.Node() != 0x23 {
+		t.Fatalf("invalid ADC PDO COB-ID: 0x%x", pdo.COBID)
+	}
+	chans := cfg.Nodes[0].Channels
+	if len(pdo.Data) != 2*len(chans) {
+		t.Fatalf("invalid ADC PDO size: %d", len(pdo.Data))
+	}
+	for i, ch := range chans {
+		adc := int16(binary.LittleEndian.Uint16(pdo.Data[2*i:]))
+		got := calib.Linear{Slope: ch.Scale, Intercept: ch.Offset}.Eval(float64(adc))
+		if want := ch.Signal.Value; math.Abs(got-want) > ch.Scale/2 {
+			t.Fatalf("%s: invalid value: got=%v, want=%v (adc=%d)", ch.Name, got, want, adc)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// SDO abort codes, as defined by CiA 301.
+const (
+	abortWriteOnly    = 0x06010001 // attempt to read a write only object
+	abortReadOnly     = 0x06010002 // attempt to write a read only object
+	abortNoObject     = 0x06020000 // object does not exist
+	abortNoSubIndex   = 0x06090011 // sub-index does not exist
+	abortValueRange   = 0x06090030 // invalid value for parameter
+	abortDeviceState  = 0x08000022 // data cannot be transferred because of the device state
+	abortGeneralError = 0x08000000 // general error
+)
+
+// NMT states of a node.
+type State uint8
+
+const (
+	Initializing   State = 0x00
+	Stopped        State = 0x04
+	Operational    State = 0x05
+	PreOperational State = 0x7f
+)
+
+func (s State) String() string {
+	switch s {
+	case Initializing:
+		return "initializing"
+	case Stopped:
+		return "stopped"
+	case Operational:
+		return "operational"
+	case PreOperational:
+		return "pre-operational"
+	}
+	return fmt.Sprintf("State(0x%x)", uint8(s))
+}
+
+// Key identifies an object in an object dictionary.
+type Key struct {
+	Index    uint16
+	SubIndex uint8
+}
+
+func (k Key) String() string {
+	return fmt.Sprintf("%04x:%x", k.Index, k.SubIndex)
+}
+
+// Object is an entry of the object dictionary of a node.
+//
+// Read and Write, when set, are called to compute the value of the object
+// and to apply a new value, respectively. Otherwise, Value is used.
+type Object struct {
+	Size  int // size in bytes
+	Value uint32
+	RO    bool // read only
+	WO    bool // write only
+	Read  func(t time.Duration) uint32
+	Write func(t time.Duration, v uint32) error
+}
+
+// Identity holds the content of the identity object (0x1018) of a node.
+type Identity struct {
+	DeviceType uint32
+	Vendor     uint32
+	Product    uint32
+	Revision   uint32
+	Serial     uint32
+}
+
+// Device implements the behaviour of a simulated CANopen device.
+type Device interface {
+	// Objects returns the device specific objects.
+	Objects() map[Key]*Object
+	// Update advances the simulation of the device up to time t.
+	Update(t time.Duration)
+	// PDO returns the content of the transmit PDO sent on a SYNC.
+	PDO(t time.Duration) []byte
+}
+
+// Node is a simulated CANopen node.
+type Node struct {
+	ID     uint8
+	Type   string
+	State  State
+	Ident  Identity
+	Faults Faults
+
+	dev  Device
+	objs map[Key]*Object
+}
+
+func newNode(id uint8, typ string, ident Identity, dev Device, faults Faults) *Node {
+	node := &Node{
+		ID:     id,
+		Type:   typ,
+		State:  PreOperational,
+		Ident:  ident,
+		Faults: faults,
+		dev:    dev,
+		objs:   make(map[Key]*Object),
+	}
+
+	node.objs[Key{0x1000, 0}] = &Object{Size: 4, Value: ident.DeviceType, RO: true}
+	node.objs[Key{0x1018, 0}] = &Object{Size: 1, Value: 4, RO: true}
+	node.objs[Key{0x1018, 1}] = &Object{Size: 4, Value: ident.Vendor, RO: true}
+	node.objs[Key{0x1018, 2}] = &Object{Size: 4, Value: ident.Product, RO: true}
+	node.objs[Key{0x1018, 3}] = &Object{Size: 4, Value: ident.Revision, RO: true}
+	node.objs[Key{0x1018, 4}] = &Object{Size: 4, Value: ident.Serial, RO: true}
+	for k, obj := range dev.Objects() {
+		node.objs[k] = obj
+	}
+	return node
+}
+
+// COBID returns the COB-ID of the first transmit PDO of the node.
+func (node *Node) COBID() uint16 {
+	return 0x180 + uint16(node.ID)
+}
+
+// Read reads the object at index:sub.
+// Read returns the value of the object and a SDO abort code.
+func (node *Node) Read(t time.Duration, index uint16, sub uint8) (uint32, uint32) {
+	if abort, ok := node.Faults.abort(index, sub); ok {
+		return 0, abort
+	}
+	if node.State == Stopped {
+		return 0, abortDeviceState
+	}
+	obj, abort := node.lookup(index, sub)
+	if abort != 0 {
+		return 0, abort
+	}
+	if obj.WO {
+		return 0, abortWriteOnly
+	}
+	node.dev.Update(t)
+	if obj.Read != nil {
+		return obj.Read(t), 0
+	}
+	return obj.Value, 0
+}
+
+// Write writes v to the object at index:sub.
+// Write returns a SDO abort code.
+func (node *Node) Write(t time.Duration, index uint16, sub uint8, v uint32) uint32 {
+	if abort, ok := node.Faults.abort(index, sub); ok {
+		return abort
+	}
+	if node.State == Stopped {
+		return abortDeviceState
+	}
+	obj, abort := node.lookup(index, sub)
+	if abort != 0 {
+		return abort
+	}
+	if obj.RO {
+		return abortReadOnly
+	}
+	node.dev.Update(t)
+	if obj.Write != nil {
+		err := obj.Write(t, v)
+		if err != nil {
+			return abortValueRange
+		}
+		return 0
+	}
+	obj.Value = v
+	return 0
+}
+
+func (node *Node) lookup(index uint16, sub uint8) (*Object, uint32) {
+	obj, ok := node.objs[Key{index, sub}]
+	if ok {
+		return obj, 0
+	}
+	for k := range node.objs {
+		if k.Index == index {
+			return nil, abortNoSubIndex
+		}
+	}
+	return nil, abortNoObject
+}
+
+// PDO returns the content of the transmit PDO of the node, if any.
+func (node *Node) PDO(t time.Duration) ([]byte, bool) {
+	if node.State != Operational {
+		return nil, false
+	}
+	node.dev.Update(t)
+	data := node.dev.PDO(t)
+	return data, data != nil
+}
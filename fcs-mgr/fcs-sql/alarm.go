@@ -0,0 +1,462 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+)
+
+// AlarmConfig describes the alarm rules and the sinks of their notifications.
+//
+// ex:
+//
+//	{
+//		"rules": [
+//			{"name": "bench-temp", "channel": "testbenchLPC/temperature",
+//			 "kind": "threshold", "low": 18, "high": 24, "hysteresis": 0.2},
+//			{"name": "bench-temp-rate", "channel": "testbenchLPC/temperature",
+//			 "kind": "rate", "max": 1, "period": "10m"},
+//			{"name": "msr-stale", "channel": "MSR145_*/*",
+//			 "kind": "stale", "after": "5m"}
+//		],
+//		"sinks": [
+//			{"kind": "log", "file": "alarms.log"},
+//			{"kind": "webhook", "url": "http://localhost:9000/alarms"},
+//			{"kind": "email", "addr": "localhost:2525", "from": "fcs-sql@localhost", "to": ["bench@localhost"]}
+//		]
+//	}
+type AlarmConfig struct {
+	Rules []Rule       `json:"rules"`
+	Sinks []SinkConfig `json:"sinks"`
+}
+
+// Rule is an alarm rule, evaluated on the samples of the channels whose name
+// matches Channel (see Catalog.Select).
+//
+// A threshold alarm is raised when a value is out of [Low, High], and cleared
+// when a value is back within [Low+Hysteresis, High-Hysteresis].
+// A rate alarm is raised when values vary by more than Max within Period, and
+// cleared when they vary by less than Max-Hysteresis.
+// The hysteresis can not exceed half of [Low, High], nor Max, so that alarms
+// can be cleared.
+// A stale alarm is raised when a channel has no new sample for After, and
+// cleared with the next sample. Only channels with samples in the live window
+// are monitored.
+type Rule struct {
+	Name       string   `json:"name"`
+	Channel    string   `json:"channel"`
+	Kind       string   `json:"kind"`       // "threshold", "rate" or "stale"
+	Low        *float64 `json:"low"`        // threshold: lower limit (optional)
+	High       *float64 `json:"high"`       // threshold: upper limit (optional)
+	Max        float64  `json:"max"`        // rate: maximum variation within period
+	Period     string   `json:"period"`     // rate: time span of the variation (default: 1m)
+	After      string   `json:"after"`      // stale: maximum time without samples
+	Hysteresis float64  `json:"hysteresis"` // margin of the limits to clear an alarm
+
+	period time.Duration
+	after  time.Duration
+}
+
+func (r *Rule) validate() error {
+	if r.Channel == "" {
+		return fmt.Errorf("rule %q: missing channel", r.Name)
+	}
+	if r.Hysteresis < 0 {
+		return fmt.Errorf("rule %q: invalid hysteresis %v", r.Name, r.Hysteresis)
+	}
+
+	var err error
+	switch r.Kind {
+	case "threshold":
+		if r.Low == nil && r.High == nil {
+			return fmt.Errorf("rule %q: missing low or high limit", r.Name)
+		}
+		if r.Low != nil && r.High != nil {
+			if *r.Low > *r.High {
+				return fmt.Errorf("rule %q: invalid limits [%v, %v]", r.Name, *r.Low, *r.High)
+			}
+			// the alarm could never be cleared.
+			if r.Hysteresis > (*r.High-*r.Low)/2 {
+				return fmt.Errorf("rule %q: hysteresis %v larger than half the range [%v, %v]", r.Name, r.Hysteresis, *r.Low, *r.High)
+			}
+		}
+	case "rate":
+		if r.Max <= 0 {
+			return fmt.Errorf("rule %q: invalid maximum variation %v", r.Name, r.Max)
+		}
+		if r.Hysteresis > r.Max {
+			return fmt.Errorf("rule %q: hysteresis %v larger than the maximum variation %v", r.Name, r.Hysteresis, r.Max)
+		}
+		r.period = time.Minute
+		if r.Period != "" {
+			r.period, err = time.ParseDuration(r.Period)
+			if err != nil || r.period <= 0 {
+				return fmt.Errorf("rule %q: invalid period %q", r.Name, r.Period)
+			}
+		}
+	case "stale":
+		r.after, err = time.ParseDuration(r.After)
+		if err != nil || r.after <= 0 {
+			return fmt.Errorf("rule %q: invalid delay %q", r.Name, r.After)
+		}
+	default:
+		return fmt.Errorf("rule %q: invalid kind %q", r.Name, r.Kind)
+	}
+	if _, err := path.Match(r.Channel, ""); err != nil {
+		return fmt.Errorf("rule %q: invalid channel pattern %q", r.Name, r.Channel)
+	}
+	return nil
+}
+
+func (r *Rule) matches(name string) bool {
+	if r.Channel == "*" {
+		return true
+	}
+	ok, _ := path.Match(r.Channel, name)
+	return ok
+}
+
+// loadAlarmConfig loads the alarm configuration from a JSON file.
+func loadAlarmConfig(fname string) (*AlarmConfig, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var cfg AlarmConfig
+	err = json.NewDecoder(f).Decode(&cfg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid alarm file %q: %v", fname, err)
+	}
+
+	names := make(map[string]bool)
+	for i := range cfg.Rules {
+		r := &cfg.Rules[i]
+		if r.Name == "" || names[r.Name] {
+			return nil, fmt.Errorf("invalid alarm file %q: missing or duplicate rule name %q", fname, r.Name)
+		}
+		names[r.Name] = true
+		err = r.validate()
+		if err != nil {
+			return nil, fmt.Errorf("invalid alarm file %q: %v", fname, err)
+		}
+	}
+	return &cfg, nil
+}
+
+// Alarm is the state of a rule for a channel.
+// An alarm is displayed while it is active, or until it is acknowledged.
+type Alarm struct {
+	Rule    string    `json:"rule"`
+	Channel string    `json:"channel"`
+	Active  bool      `json:"active"`
+	Acked   bool      `json:"acked"`
+	Since   time.Time `json:"since"` // time when the alarm was raised
+	Value   float64   `json:"value"` // value which raised the alarm
+	Msg     string    `json:"msg"`
+	AckBy   string    `json:"ackBy,omitempty"`
+
+	rule *Rule
+	hist Points    // rate: samples within the period of the rule
+	last time.Time // stale: time of the last sample
+}
+
+func (a *Alarm) visible() bool { return a.Active || !a.Acked }
+
+// Event is a change of the state of an alarm.
+type Event struct {
+	Time  time.Time `json:"time"`
+	Kind  string    `json:"kind"` // "raised", "cleared" or "acknowledged"
+	Alarm Alarm     `json:"alarm"`
+}
+
+func (evt Event) String() string {
+	return fmt.Sprintf(
+		"%s [%s] %s on %s: %s",
+		evt.Time.UTC().Format(timeFormat), evt.Kind, evt.Alarm.Rule, evt.Alarm.Channel, evt.Alarm.Msg,
+	)
+}
+
+// alarms evaluates the alarm rules on the samples of the live window, and
+// notifies the sinks of the changes of the alarms.
+type alarms struct {
+	mu      sync.Mutex
+	rules   []Rule
+	state   map[string]*Alarm // rule/channel -> alarm
+	started bool              // whether the first check happened
+	pending []Event           // events to dispatch at the next check
+	events  chan Event
+}
+
+func newAlarms(cfg *AlarmConfig) (*alarms, error) {
+	sinks := make([]Sink, len(cfg.Sinks))
+	for i, sc := range cfg.Sinks {
+		sink, err := newSink(sc)
+		if err != nil {
+			return nil, err
+		}
+		sinks[i] = sink
+	}
+	a := &alarms{
+		rules:  cfg.Rules,
+		state:  make(map[string]*Alarm),
+		events: make(chan Event, 100),
+	}
+	go a.dispatch(sinks)
+	return a, nil
+}
+
+// dispatch notifies the sinks of the events.
+func (a *alarms) dispatch(sinks []Sink) {
+	for evt := range a.events {
+		for _, sink := range sinks {
+			err := sink.Notify(evt)
+			if err != nil {
+				log.Printf("error notifying alarm event (%v): %v\n", evt, err)
+			}
+		}
+	}
+}
+
+func (a *alarms) alarm(r *Rule, name string) *Alarm {
+	key := r.Name + "/" + name
+	al, ok := a.state[key]
+	if !ok {
+		al = &Alarm{Rule: r.Name, Channel: name, Acked: true, rule: r}
+		a.state[key] = al
+	}
+	return al
+}
+
+func (a *alarms) raise(al *Alarm, t time.Time, v float64, msg string) {
+	al.Active = true
+	al.Acked = false
+	al.AckBy = ""
+	al.Since = t
+	al.Value = v
+	al.Msg = msg
+	a.pending = append(a.pending, Event{Time: t, Kind: "raised", Alarm: *al})
+}
+
+func (a *alarms) clear(al *Alarm, t time.Time, msg string) {
+	al.Active = false
+	al.Msg = msg
+	a.pending = append(a.pending, Event{Time: t, Kind: "cleared", Alarm: *al})
+}
+
+// update evaluates the rules on the new samples of a channel, sorted by time.
+func (a *alarms) update(name string, pts Points) {
+	if len(pts) == 0 {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for i := range a.rules {
+		r := &a.rules[i]
+		if !r.matches(name) {
+			continue
+		}
+		al := a.alarm(r, name)
+		for _, p := range pts {
+			switch r.Kind {
+			case "threshold":
+				a.threshold(al, p)
+			case "rate":
+				a.rate(al, p)
+			case "stale":
+				if p.X.After(al.last) {
+					al.last = p.X
+				}
+				if al.Active {
+					a.clear(al, p.X, "new sample")
+				}
+			}
+		}
+	}
+}
+
+func (a *alarms) threshold(al *Alarm, p Point) {
+	r := al.rule
+	var (
+		v   = p.Y
+		out = (r.Low != nil && v < *r.Low) || (r.High != nil && v > *r.High)
+		in  = (r.Low == nil || v >= *r.Low+r.Hysteresis) && (r.High == nil || v <= *r.High-r.Hysteresis)
+	)
+	switch {
+	case !al.Active && out:
+		a.raise(al, p.X, v, fmt.Sprintf("value %g out of %s", v, limits(r.Low, r.High)))
+	case al.Active && in:
+		a.clear(al, p.X, fmt.Sprintf("value %g back in %s", v, limits(r.Low, r.High)))
+	}
+}
+
+func limits(lo, hi *float64) string {
+	switch {
+	case lo == nil:
+		return fmt.Sprintf("]-inf, %g]", *hi)
+	case hi == nil:
+		return fmt.Sprintf("[%g, +inf[", *lo)
+	default:
+		return fmt.Sprintf("[%g, %g]", *lo, *hi)
+	}
+}
+
+func (a *alarms) rate(al *Alarm, p Point) {
+	r := al.rule
+	al.hist = append(al.hist, p)
+	beg := p.X.Add(-r.period)
+	i := sort.Search(len(al.hist), func(i int) bool { return !al.hist[i].X.Before(beg) })
+	al.hist = append(al.hist[:0], al.hist[i:]...)
+	if len(al.hist) < 2 {
+		return
+	}
+
+	dv := p.Y - al.hist[0].Y
+	if dv < 0 {
+		dv = -dv
+	}
+	switch {
+	case !al.Active && dv > r.Max:
+		a.raise(al, p.X, p.Y, fmt.Sprintf("variation %.4g within %v exceeds %g", dv, r.period, r.Max))
+	case al.Active && dv <= r.Max-r.Hysteresis:
+		a.clear(al, p.X, fmt.Sprintf("variation %.4g within %v", dv, r.period))
+	}
+}
+
+// check evaluates the stale rules at time now, and dispatches the events.
+// Alarms raised and cleared before the first check (i.e. by the samples of
+// the first loaded live window) are neither notified nor displayed.
+func (a *alarms) check(now time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, al := range a.state {
+		r := al.rule
+		if r.Kind != "stale" || al.Active || al.last.IsZero() {
+			continue
+		}
+		if dt := now.Sub(al.last); dt > r.after {
+			a.raise(al, now, 0, fmt.Sprintf("no sample since %v", al.last.UTC().Format(timeFormat)))
+		}
+	}
+
+	evts := a.pending
+	a.pending = nil
+	if !a.started {
+		a.started = true
+		evts = evts[:0]
+		for _, al := range a.state {
+			if !al.Active {
+				al.Acked = true
+				continue
+			}
+			evts = append(evts, Event{Time: al.Since, Kind: "raised", Alarm: *al})
+		}
+	}
+	for _, evt := range evts {
+		select {
+		case a.events <- evt:
+		default:
+			log.Printf("dropping alarm event (%v)\n", evt)
+		}
+	}
+}
+
+// list returns the visible alarms, sorted by rule and channel.
+func (a *alarms) list() []Alarm {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	list := []Alarm{}
+	for _, al := range a.state {
+		if al.visible() {
+			list = append(list, *al)
+		}
+	}
+	sort.Sort(alarmsByRule(list))
+	return list
+}
+
+type alarmsByRule []Alarm
+
+func (p alarmsByRule) Len() int { return len(p) }
+
+func (p alarmsByRule) Less(i, j int) bool {
+	if p[i].Rule != p[j].Rule {
+		return p[i].Rule < p[j].Rule
+	}
+	return p[i].Channel < p[j].Channel
+}
+
+func (p alarmsByRule) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+
+// ack acknowledges the alarm of a rule on a channel.
+func (a *alarms) ack(rule, channel, user string, now time.Time) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	al, ok := a.state[rule+"/"+channel]
+	if !ok || !al.visible() {
+		return fmt.Errorf("no alarm %q on %q", rule, channel)
+	}
+	if al.Acked {
+		return nil
+	}
+	al.Acked = true
+	al.AckBy = user
+	evt := Event{Time: now, Kind: "acknowledged", Alarm: *al}
+	evt.Alarm.Msg = "acknowledged by " + user
+	a.pending = append(a.pending, evt)
+	return nil
+}
+
+// alarmsHandler serves the visible alarms:
+//
+//	/api/alarms
+func alarmsHandler(w http.ResponseWriter, r *http.Request) {
+	list := []Alarm{}
+	if page.alarms != nil {
+		list = page.alarms.list()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(list)
+	if err != nil {
+		log.Printf("error writing alarms: %v\n", err)
+	}
+}
+
+// ackHandler acknowledges an alarm:
+//
+//	POST /api/alarms/ack rule=NAME&channel=NAME&user=NAME
+func ackHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "invalid method "+r.Method, http.StatusMethodNotAllowed)
+		return
+	}
+	if page.alarms == nil {
+		http.Error(w, "no alarm rules", http.StatusNotFound)
+		return
+	}
+	user := r.FormValue("user")
+	if user == "" {
+		http.Error(w, "missing user", http.StatusBadRequest)
+		return
+	}
+	now := time.Now()
+	err := page.alarms.ack(r.FormValue("rule"), r.FormValue("channel"), user, now)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	// dispatch the acknowledgement.
+	page.alarms.check(now)
+	w.WriteHeader(http.StatusNoContent)
+}
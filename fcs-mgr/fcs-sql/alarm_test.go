@@ -0,0 +1,167 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func fptr(v float64) *float64 { return &v }
+
+func TestRuleValidate(t *testing.T) {
+	for _, tc := range []struct {
+		rule Rule
+		err  string
+	}{
+		{Rule{Name: "r", Channel: "a/b", Kind: "threshold", Low: fptr(18), High: fptr(24), Hysteresis: 3}, ""},
+		{Rule{Name: "r", Channel: "a/b", Kind: "threshold", High: fptr(24), Hysteresis: 10}, ""},
+		{Rule{Name: "r", Channel: "a/*", Kind: "rate", Max: 1, Hysteresis: 1}, ""},
+		{Rule{Name: "r", Channel: "*", Kind: "stale", After: "5m"}, ""},
+		{Rule{Name: "r", Kind: "threshold", Low: fptr(18)}, "missing channel"},
+		{Rule{Name: "r", Channel: "a/b", Kind: "threshold"}, "missing low or high limit"},
+		{Rule{Name: "r", Channel: "a/b", Kind: "threshold", Low: fptr(24), High: fptr(18)}, "invalid limits"},
+		{Rule{Name: "r", Channel: "a/b", Kind: "threshold", Low: fptr(18), High: fptr(24), Hysteresis: 3.5}, "larger than half the range"},
+		{Rule{Name: "r", Channel: "a/b", Kind: "threshold", Low: fptr(18), Hysteresis: -1}, "invalid hysteresis"},
+		{Rule{Name: "r", Channel: "a/b", Kind: "rate"}, "invalid maximum variation"},
+		{Rule{Name: "r", Channel: "a/b", Kind: "rate", Max: 1, Hysteresis: 2}, "larger than the maximum variation"},
+		{Rule{Name: "r", Channel: "a/b", Kind: "rate", Max: 1, Period: "-1m"}, "invalid period"},
+		{Rule{Name: "r", Channel: "a/b", Kind: "stale"}, "invalid delay"},
+		{Rule{Name: "r", Channel: "a/[", Kind: "stale", After: "1m"}, "invalid channel pattern"},
+		{Rule{Name: "r", Channel: "a/b", Kind: "other"}, "invalid kind"},
+	} {
+		err := tc.rule.validate()
+		switch {
+		case tc.err == "" && err != nil:
+			t.Errorf("%+v: unexpected error: %v", tc.rule, err)
+		case tc.err != "" && err == nil:
+			t.Errorf("%+v: expected an error", tc.rule)
+		case tc.err != "" && !strings.Contains(err.Error(), tc.err):
+			t.Errorf("%+v: invalid error: got=%q, want=%q", tc.rule, err, tc.err)
+		}
+	}
+}
+
+// newTestAlarms returns alarms evaluating the rules, without sinks.
+func newTestAlarms(t *testing.T, rules ...Rule) *alarms {
+	t.Helper()
+	for i := range rules {
+		err := rules[i].validate()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &alarms{
+		rules:  rules,
+		state:  make(map[string]*Alarm),
+		events: make(chan Event, 100),
+	}
+}
+
+// kinds returns the dispatched events, as "<kind> <rule> <channel>".
+func (a *alarms) kinds() []string {
+	var kinds []string
+	for {
+		select {
+		case evt := <-a.events:
+			kinds = append(kinds, evt.Kind+" "+evt.Alarm.Rule+" "+evt.Alarm.Channel)
+		default:
+			return kinds
+		}
+	}
+}
+
+// feed updates the alarms with values of a channel, one second apart from
+// t0+dt, and checks them.
+func feed(a *alarms, name string, dt time.Duration, vs ...float64) []string {
+	pts := make(Points, len(vs))
+	for i, v := range vs {
+		pts[i] = Point{X: t0.Add(dt + time.Duration(i)*time.Second), Y: v}
+	}
+	a.update(name, pts)
+	a.check(t0.Add(dt + time.Duration(len(vs))*time.Second))
+	return a.kinds()
+}
+
+func checkKinds(t *testing.T, step string, got []string, want ...string) {
+	t.Helper()
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("%s: invalid events:\ngot= %q\nwant=%q", step, got, want)
+	}
+}
+
+func TestAlarmThreshold(t *testing.T) {
+	a := newTestAlarms(t, Rule{
+		Name: "temp", Channel: "bench/*", Kind: "threshold",
+		Low: fptr(18), High: fptr(24), Hysteresis: 0.5,
+	})
+
+	// alarms of the first live window are not notified when cleared.
+	checkKinds(t, "first window", feed(a, "bench/T", 0, 21, 25, 21))
+	checkKinds(t, "within limits", feed(a, "bench/T", time.Minute, 20, 23.9))
+	checkKinds(t, "above", feed(a, "bench/T", 2*time.Minute, 24.1), "raised temp bench/T")
+	checkKinds(t, "still above", feed(a, "bench/T", 3*time.Minute, 25))
+	checkKinds(t, "within hysteresis", feed(a, "bench/T", 4*time.Minute, 23.9, 23.6))
+	if list := a.list(); len(list) != 1 || !list[0].Active || list[0].Value != 24.1 {
+		t.Fatalf("invalid alarms: %+v", list)
+	}
+	checkKinds(t, "back in", feed(a, "bench/T", 5*time.Minute, 23.5), "cleared temp bench/T")
+
+	// cleared alarms are displayed until acknowledged.
+	if list := a.list(); len(list) != 1 || list[0].Active {
+		t.Fatalf("invalid alarms: %+v", list)
+	}
+	err := a.ack("temp", "bench/T", "operator", t0.Add(6*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.check(t0.Add(6 * time.Minute))
+	checkKinds(t, "ack", a.kinds(), "acknowledged temp bench/T")
+	if list := a.list(); len(list) != 0 {
+		t.Fatalf("invalid alarms: %+v", list)
+	}
+	if err := a.ack("temp", "bench/T", "operator", t0); err == nil {
+		t.Fatalf("expected an error acknowledging a hidden alarm")
+	}
+
+	checkKinds(t, "below", feed(a, "bench/P", 7*time.Minute, 17.9, 18.2, 18.4), "raised temp bench/P")
+	checkKinds(t, "other channel", feed(a, "other/T", 8*time.Minute, 30))
+}
+
+func TestAlarmFirstWindow(t *testing.T) {
+	a := newTestAlarms(t, Rule{Name: "temp", Channel: "*", Kind: "threshold", High: fptr(24)})
+
+	// alarms still active after the first window are notified.
+	checkKinds(t, "first window", feed(a, "bench/T", 0, 21, 25), "raised temp bench/T")
+	checkKinds(t, "above", feed(a, "bench/T", time.Minute, 26))
+}
+
+func TestAlarmRate(t *testing.T) {
+	a := newTestAlarms(t, Rule{
+		Name: "rate", Channel: "bench/T", Kind: "rate",
+		Max: 1, Period: "3s", Hysteresis: 0.2,
+	})
+	checkKinds(t, "first window", feed(a, "bench/T", 0, 20))
+	checkKinds(t, "slow", feed(a, "bench/T", 10*time.Second, 20.5, 21, 20.5))
+	checkKinds(t, "fast", feed(a, "bench/T", 20*time.Second, 20, 20.6, 21.1), "raised rate bench/T")
+	checkKinds(t, "within hysteresis", feed(a, "bench/T", 23*time.Second, 21.2, 21.5, 22, 22.9))
+	checkKinds(t, "steady", feed(a, "bench/T", 27*time.Second, 22.9, 22.9, 22.9, 22.9), "cleared rate bench/T")
+
+	// samples older than the period are forgotten.
+	checkKinds(t, "long ramp", feed(a, "bench/T", time.Minute, 22.6, 22.3, 22, 21.7, 21.4, 21.1))
+}
+
+func TestAlarmStale(t *testing.T) {
+	a := newTestAlarms(t, Rule{Name: "stale", Channel: "bench/*", Kind: "stale", After: "1m"})
+	checkKinds(t, "first window", feed(a, "bench/T", 0, 21))
+
+	a.check(t0.Add(30 * time.Second))
+	checkKinds(t, "fresh", a.kinds())
+	a.check(t0.Add(2 * time.Minute))
+	checkKinds(t, "stale", a.kinds(), "raised stale bench/T")
+	a.check(t0.Add(3 * time.Minute))
+	checkKinds(t, "still stale", a.kinds())
+
+	checkKinds(t, "new sample", feed(a, "bench/T", 4*time.Minute, 21), "cleared stale bench/T")
+	a.check(t0.Add(4*time.Minute + 30*time.Second))
+	checkKinds(t, "fresh again", a.kinds())
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Annotation describes what happened on the bench during a time range.
+// Annotations are stored in the fcs_annotation table, next to the tables of
+// the trending database.
+type Annotation struct {
+	ID       int64
+	Start    time.Time
+	Stop     time.Time
+	Test     string // name of the test
+	Operator string
+	Filter   string // ID of the filter
+	Comment  string
+}
+
+// errNoAnnotations is returned when annotations can not be stored, e.g. in a
+// read-only database.
+var errNoAnnotations = errors.New("annotations unavailable")
+
+// initAnnotations creates the annotation table, if needed.
+func (st *sqlStore) initAnnotations() error {
+	st.annMu.Lock()
+	defer st.annMu.Unlock()
+	if st.annOK {
+		return nil
+	}
+	_, err := st.db.Exec(st.dialect.annotations)
+	if err != nil {
+		// the table may exist, without the privilege to create it.
+		ok, err2 := st.hasTable("fcs_annotation")
+		if err2 != nil || !ok {
+			log.Printf("error creating annotation table: %v\n", err)
+			return errNoAnnotations
+		}
+	}
+	st.annOK = true
+	return nil
+}
+
+// Annotate stores an annotation and returns its id.
+// The annotation table is created with the first annotation.
+func (st *sqlStore) Annotate(a Annotation) (int64, error) {
+	err := st.initAnnotations()
+	if err != nil {
+		return 0, err
+	}
+	res, err := st.db.Exec(
+		"insert into fcs_annotation (tstartmillis, tstopmillis, test, operator, filterID, comment) values (?, ?, ?, ?, ?, ?)",
+		millis(a.Start), millis(a.Stop), a.Test, a.Operator, a.Filter, a.Comment,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// Annotations returns the annotations overlapping [beg, end).
+// There are no annotations until the annotation table is created.
+func (st *sqlStore) Annotations(beg, end time.Time) ([]Annotation, error) {
+	rows, err := st.db.Query(
+		"select id, tstartmillis, tstopmillis, test, operator, filterID, comment from fcs_annotation where tstartmillis < ? and tstopmillis >= ? order by tstartmillis",
+		millis(end), millis(beg),
+	)
+	if err != nil {
+		if ok, err2 := st.hasTable("fcs_annotation"); err2 == nil && !ok {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer rows.Close()
+
+	var anns []Annotation
+	for rows.Next() {
+		var (
+			a        Annotation
+			ms1, ms2 int64
+			test     sql.NullString
+			operator sql.NullString
+			filter   sql.NullString
+			comment  sql.NullString
+		)
+		err = rows.Scan(&a.ID, &ms1, &ms2, &test, &operator, &filter, &comment)
+		if err != nil {
+			return nil, err
+		}
+		a.Start = fromMillis(ms1)
+		a.Stop = fromMillis(ms2)
+		a.Test = test.String
+		a.Operator = operator.String
+		a.Filter = filter.String
+		a.Comment = comment.String
+		anns = append(anns, a)
+	}
+	return anns, rows.Err()
+}
+
+// annotationsHandler serves the annotations overlapping a time range, or
+// stores a new annotation:
+//
+//	GET  /api/annotations?start=T&end=T
+//	POST /api/annotations start=T&end=T&test=NAME&operator=NAME&filter=ID&comment=TEXT
+func annotationsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		getAnnotations(w, r)
+	case "POST":
+		postAnnotation(w, r)
+	default:
+		http.Error(w, "invalid method "+r.Method, http.StatusMethodNotAllowed)
+	}
+}
+
+func getAnnotations(w http.ResponseWriter, r *http.Request) {
+	beg, end, err := timeRange(r.URL.Query(), defaultRange)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	anns, err := page.store.Annotations(beg, end)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	type result struct {
+		ID       int64  `json:"id"`
+		Start    string `json:"start"`
+		Stop     string `json:"stop"`
+		Test     string `json:"test"`
+		Operator string `json:"operator"`
+		Filter   string `json:"filter"`
+		Comment  string `json:"comment"`
+	}
+	res := make([]result, len(anns))
+	for i, a := range anns {
+		res[i] = result{
+			ID:       a.ID,
+			Start:    a.Start.UTC().Format(timeFormat),
+			Stop:     a.Stop.UTC().Format(timeFormat),
+			Test:     a.Test,
+			Operator: a.Operator,
+			Filter:   a.Filter,
+			Comment:  a.Comment,
+		}
+	}
+
+	ww, err := newWriter(w, r, "id", "start", "stop", "test", "operator", "filter", "comment")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	switch {
+	case !ww.isCSV():
+		err = ww.json(res)
+	default:
+		for _, rr := range res {
+			err = ww.record(strconv.FormatInt(rr.ID, 10), rr.Start, rr.Stop, rr.Test, rr.Operator, rr.Filter, rr.Comment)
+			if err != nil {
+				break
+			}
+		}
+	}
+	if err == nil {
+		err = ww.flush()
+	}
+	if err != nil {
+		log.Printf("error writing annotations: %v\n", err)
+	}
+}
+
+func postAnnotation(w http.ResponseWriter, r *http.Request) {
+	var (
+		now = time.Now()
+		a   Annotation
+		err error
+	)
+	for _, v := range []struct {
+		name string
+		t    *time.Time
+	}{
+		{"start", &a.Start},
+		{"end", &a.Stop},
+	} {
+		s := r.FormValue(v.name)
+		if s == "" {
+			http.Error(w, fmt.Sprintf("missing %s time", v.name), http.StatusBadRequest)
+			return
+		}
+		*v.t, err = parseTime(s, now)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if a.Stop.Before(a.Start) {
+		http.Error(w, fmt.Sprintf("invalid time range [%v, %v]", a.Start, a.Stop), http.StatusBadRequest)
+		return
+	}
+	a.Test = strings.TrimSpace(r.FormValue("test"))
+	a.Operator = strings.TrimSpace(r.FormValue("operator"))
+	a.Filter = strings.TrimSpace(r.FormValue("filter"))
+	a.Comment = strings.TrimSpace(r.FormValue("comment"))
+	if a.Operator == "" {
+		http.Error(w, "missing operator", http.StatusBadRequest)
+		return
+	}
+
+	a.ID, err = page.store.Annotate(a)
+	switch err {
+	case nil:
+	case errNoAnnotations:
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("annotation #%d by %q: [%v, %v] test=%q filter=%q\n", a.ID, a.Operator, a.Start, a.Stop, a.Test, a.Filter)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprintf(w, "{\"id\":%d}\n", a.ID)
+}
@@ -0,0 +1,541 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// The HTTP API serves the content of the trending database as JSON, or as
+// CSV with format=csv:
+//
+//	/api/channels                                   channels of the catalog
+//	/api/data?ch=NAME&start=T&end=T[&n=N]           raw samples, or at most N bins (N <= 10000)
+//	/api/stat?ch=NAME&start=T&end=T[&width=D]       statdata bins
+//	/api/metadata?[ch=NAME&]start=T&end=T           metadata intervals
+//	/api/alarms                                     active or unacknowledged alarms
+//	/api/annotations?start=T&end=T                  annotations (POST to create one)
+//
+// ch is a comma-separated list of patterns of channel names (see
+// Catalog.Select), and may be repeated.
+// Times are RFC 3339 times, timestamps in milliseconds, or durations relative
+// to now (e.g. start=-6h). The time range defaults to the last 24 hours.
+//
+// ex:
+//
+//	$ curl 'localhost:8080/api/data?ch=testbenchLPC/temperature&start=-1h'
+//	$ curl 'localhost:8080/api/data?ch=testbenchLPC/*&start=2016-05-01T00:00:00Z&n=500&format=csv'
+
+const (
+	defaultRange = 24 * time.Hour
+	timeFormat   = "2006-01-02T15:04:05.000Z07:00"
+)
+
+func registerAPI(mux *http.ServeMux) {
+	mux.HandleFunc("/api/channels", channelsHandler)
+	mux.HandleFunc("/api/data", rawHandler)
+	mux.HandleFunc("/api/stat", statHandler)
+	mux.HandleFunc("/api/metadata", metaDataHandler)
+	mux.HandleFunc("/api/plot", plotHandler)
+	mux.HandleFunc("/api/alarms", alarmsHandler)
+	mux.HandleFunc("/api/annotations", annotationsHandler)
+	mux.HandleFunc("/api/alarms/ack", ackHandler)
+}
+
+// parseTime parses a time parameter.
+func parseTime(s string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	if ms, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return fromMillis(ms), nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(d), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", s)
+}
+
+// timeRange returns the time range of a request, lasting span by default.
+func timeRange(q url.Values, span time.Duration) (beg, end time.Time, err error) {
+	now := time.Now()
+	end = now
+	if s := q.Get("end"); s != "" {
+		end, err = parseTime(s, now)
+		if err != nil {
+			return beg, end, err
+		}
+	}
+	beg = end.Add(-span)
+	if s := q.Get("start"); s != "" {
+		beg, err = parseTime(s, now)
+		if err != nil {
+			return beg, end, err
+		}
+	}
+	if !beg.Before(end) {
+		return beg, end, fmt.Errorf("invalid time range [%v, %v)", beg, end)
+	}
+	return beg, end, nil
+}
+
+// channels returns the channels selected by a request.
+// At least one channel must be selected if required is true.
+func channels(q url.Values, required bool) ([]Channel, error) {
+	patterns := splitPatterns(q["ch"])
+	if len(patterns) == 0 {
+		if required {
+			return nil, fmt.Errorf("missing channel (ch) parameter")
+		}
+		patterns = []string{"*"}
+	}
+
+	page.mu.RLock()
+	defer page.mu.RUnlock()
+	if page.cat == nil {
+		return nil, fmt.Errorf("channel catalog not loaded")
+	}
+	chans, err := page.cat.Select(patterns)
+	if err != nil {
+		return nil, err
+	}
+	if len(chans) == 0 && required {
+		return nil, fmt.Errorf("no channel matching %q", patterns)
+	}
+	return chans, nil
+}
+
+// writer writes the response of a request, as JSON or CSV.
+type writer struct {
+	w   http.ResponseWriter
+	csv *csv.Writer
+}
+
+func newWriter(w http.ResponseWriter, r *http.Request, header ...string) (*writer, error) {
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "json":
+		w.Header().Set("Content-Type", "application/json")
+		return &writer{w: w}, nil
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		ww := &writer{w: w, csv: csv.NewWriter(w)}
+		return ww, ww.csv.Write(header)
+	default:
+		return nil, fmt.Errorf("invalid format %q", format)
+	}
+}
+
+func (w *writer) isCSV() bool { return w.csv != nil }
+
+// json writes v as JSON. v is encoded before anything is written, so an
+// encoding error is reported with an error status instead of a truncated
+// body.
+func (w *writer) json(v interface{}) error {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w.w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+	_, err = w.w.Write(append(buf, '\n'))
+	return err
+}
+
+func (w *writer) record(rec ...string) error {
+	return w.csv.Write(rec)
+}
+
+func (w *writer) flush() error {
+	if w.csv == nil {
+		return nil
+	}
+	w.csv.Flush()
+	return w.csv.Error()
+}
+
+func formatFloat(v float64) string {
+	if math.IsNaN(v) {
+		return ""
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func channelsHandler(w http.ResponseWriter, r *http.Request) {
+	chans, err := channels(r.URL.Query(), false)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ww, err := newWriter(w, r, "id", "name", "subsystem", "type", "title", "unit")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	switch {
+	case !ww.isCSV():
+		err = ww.json(chans)
+	default:
+		for _, ch := range chans {
+			err = ww.record(strconv.FormatInt(ch.ID, 10), ch.Name, ch.Subsystem, ch.Type, ch.Title, ch.Unit)
+			if err != nil {
+				break
+			}
+		}
+	}
+	if err == nil {
+		err = ww.flush()
+	}
+	if err != nil {
+		log.Printf("error writing channels: %v\n", err)
+	}
+}
+
+// jsonSample is the JSON encoding of a sample.
+type jsonSample struct {
+	Time  string  `json:"time"`
+	Value float64 `json:"value"`
+}
+
+// jsonBin is the JSON encoding of a bin.
+// Unknown values (NaN) are omitted, as JSON has no NaN.
+type jsonBin struct {
+	Start  string   `json:"start"`
+	End    string   `json:"end"`
+	N      int64    `json:"n"`
+	Mean   *float64 `json:"mean,omitempty"`
+	Stddev *float64 `json:"stddev,omitempty"`
+	Min    *float64 `json:"min,omitempty"`
+	Max    *float64 `json:"max,omitempty"`
+}
+
+func newJSONBins(bins []Bin) []jsonBin {
+	out := make([]jsonBin, len(bins))
+	for i, bin := range bins {
+		out[i] = jsonBin{
+			Start:  bin.Start.UTC().Format(timeFormat),
+			End:    bin.End.UTC().Format(timeFormat),
+			N:      bin.N,
+			Mean:   finite(&bins[i].Mean),
+			Stddev: finite(&bins[i].Stddev),
+			Min:    finite(&bins[i].Min),
+			Max:    finite(&bins[i].Max),
+		}
+	}
+	return out
+}
+
+// finite returns v, or nil if *v is NaN or infinite.
+func finite(v *float64) *float64 {
+	if math.IsNaN(*v) || math.IsInf(*v, 0) {
+		return nil
+	}
+	return v
+}
+
+var binHeader = []string{"start", "end", "channel", "n", "mean", "stddev", "min", "max", "unit"}
+
+func (w *writer) bins(ch Channel, bins []Bin) error {
+	for _, bin := range bins {
+		err := w.record(
+			bin.Start.UTC().Format(timeFormat),
+			bin.End.UTC().Format(timeFormat),
+			ch.Name,
+			strconv.FormatInt(bin.N, 10),
+			formatFloat(bin.Mean),
+			formatFloat(bin.Stddev),
+			formatFloat(bin.Min),
+			formatFloat(bin.Max),
+			ch.Unit,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rawHandler serves the raw samples of channels.
+// Channels with more than n samples are downsampled into at most n bins.
+// n defaults to, and is capped at, maxPoints: samples are streamed into the
+// bins, so a request never loads more than maxPoints samples per channel.
+func rawHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	chans, err := channels(q, true)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	beg, end, err := timeRange(q, defaultRange)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	n := maxPoints
+	if s := q.Get("n"); s != "" {
+		n, err = strconv.Atoi(s)
+		if err != nil || n < 0 {
+			http.Error(w, fmt.Sprintf("invalid number of points %q", s), http.StatusBadRequest)
+			return
+		}
+		if n == 0 || n > maxPoints {
+			n = maxPoints
+		}
+	}
+
+	type result struct {
+		Channel Channel      `json:"channel"`
+		Samples []jsonSample `json:"samples,omitempty"`
+		Bins    []jsonBin    `json:"bins,omitempty"`
+
+		samples []Sample
+		bins    []Bin
+	}
+	res := make([]result, len(chans))
+	binned := false
+	for i, ch := range chans {
+		res[i].Channel = ch
+		count, err := page.store.CountRaw(ch.ID, beg, end)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if count <= int64(n) {
+			res[i].samples = make([]Sample, 0, count)
+			err = page.store.Raw(ch.ID, beg, end, func(s Sample) {
+				res[i].samples = append(res[i].samples, s)
+			})
+		} else {
+			b := newBinner(beg, end, n)
+			err = page.store.Raw(ch.ID, beg, end, b.add)
+			res[i].bins = b.result()
+			binned = true
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	header := []string{"time", "channel", "value", "unit"}
+	if binned {
+		header = binHeader
+	}
+	ww, err := newWriter(w, r, header...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch {
+	case !ww.isCSV():
+		for i := range res {
+			res[i].Samples = make([]jsonSample, len(res[i].samples))
+			for j, s := range res[i].samples {
+				res[i].Samples[j] = jsonSample{Time: s.Time.UTC().Format(timeFormat), Value: s.Value}
+			}
+			res[i].Bins = newJSONBins(res[i].bins)
+		}
+		err = ww.json(res)
+	case binned:
+		for _, rr := range res {
+			bins := rr.bins
+			if bins == nil {
+				// few samples: one bin per sample.
+				bins = make([]Bin, len(rr.samples))
+				for j, s := range rr.samples {
+					bins[j] = Bin{Start: s.Time, End: s.Time, N: 1, Mean: s.Value, Min: s.Value, Max: s.Value}
+				}
+			}
+			err = ww.bins(rr.Channel, bins)
+			if err != nil {
+				break
+			}
+		}
+	default:
+	loop:
+		for _, rr := range res {
+			for _, s := range rr.samples {
+				err = ww.record(s.Time.UTC().Format(timeFormat), rr.Channel.Name, formatFloat(s.Value), rr.Channel.Unit)
+				if err != nil {
+					break loop
+				}
+			}
+		}
+	}
+	if err == nil {
+		err = ww.flush()
+	}
+	if err != nil {
+		log.Printf("error writing data: %v\n", err)
+	}
+}
+
+// statHandler serves the statdata bins of channels.
+func statHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	chans, err := channels(q, true)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	beg, end, err := timeRange(q, defaultRange)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var width time.Duration
+	if s := q.Get("width"); s != "" {
+		width, err = time.ParseDuration(s)
+		if err != nil || width <= 0 {
+			http.Error(w, fmt.Sprintf("invalid bin width %q", s), http.StatusBadRequest)
+			return
+		}
+	}
+
+	type result struct {
+		Channel Channel   `json:"channel"`
+		Width   string    `json:"width"`
+		Bins    []jsonBin `json:"bins"`
+
+		bins []Bin
+	}
+	var res []result
+	for _, ch := range chans {
+		stats, err := page.store.Stat(ch.ID, beg, end, width)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for _, ss := range stats {
+			res = append(res, result{Channel: ch, Width: ss.Width.String(), bins: ss.Bins})
+		}
+	}
+
+	ww, err := newWriter(w, r, append([]string{"width"}, binHeader...)...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	switch {
+	case !ww.isCSV():
+		for i := range res {
+			res[i].Bins = newJSONBins(res[i].bins)
+		}
+		if res == nil {
+			res = []result{}
+		}
+		err = ww.json(res)
+	default:
+	loop:
+		for _, rr := range res {
+			for _, bin := range rr.bins {
+				err = ww.record(
+					rr.Width,
+					bin.Start.UTC().Format(timeFormat),
+					bin.End.UTC().Format(timeFormat),
+					rr.Channel.Name,
+					strconv.FormatInt(bin.N, 10),
+					formatFloat(bin.Mean),
+					formatFloat(bin.Stddev),
+					"", "",
+					rr.Channel.Unit,
+				)
+				if err != nil {
+					break loop
+				}
+			}
+		}
+	}
+	if err == nil {
+		err = ww.flush()
+	}
+	if err != nil {
+		log.Printf("error writing stat data: %v\n", err)
+	}
+}
+
+// metaDataHandler serves the metadata of channels, or of all channels when
+// none is selected.
+func metaDataHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	beg, end, err := timeRange(q, defaultRange)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ids := []int64{-1}
+	if len(q["ch"]) > 0 {
+		chans, err := channels(q, true)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		ids = ids[:0]
+		for _, ch := range chans {
+			ids = append(ids, ch.ID)
+		}
+	}
+
+	type result struct {
+		ID      int64  `json:"id"`
+		Name    string `json:"name"`
+		Start   string `json:"start"`
+		Stop    string `json:"stop,omitempty"`
+		Value   string `json:"value"`
+		Channel string `json:"channel,omitempty"`
+	}
+	res := []result{}
+	for _, id := range ids {
+		mds, err := page.store.MetaData(id, beg, end)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		page.mu.RLock()
+		for _, md := range mds {
+			rr := result{
+				ID:    md.ID,
+				Name:  md.Name.String,
+				Start: md.Start.Time.UTC().Format(timeFormat),
+				Value: md.Value.String,
+			}
+			if md.Stop.Valid {
+				rr.Stop = md.Stop.Time.UTC().Format(timeFormat)
+			}
+			if ch, ok := page.cat.Channel(md.RawID.Int64); md.RawID.Valid && ok {
+				rr.Channel = ch.Name
+			}
+			res = append(res, rr)
+		}
+		page.mu.RUnlock()
+	}
+
+	ww, err := newWriter(w, r, "id", "name", "start", "stop", "value", "channel")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	switch {
+	case !ww.isCSV():
+		err = ww.json(res)
+	default:
+		for _, rr := range res {
+			err = ww.record(strconv.FormatInt(rr.ID, 10), rr.Name, rr.Start, rr.Stop, rr.Value, rr.Channel)
+			if err != nil {
+				break
+			}
+		}
+	}
+	if err == nil {
+		err = ww.flush()
+	}
+	if err != nil {
+		log.Printf("error writing metadata: %v\n", err)
+	}
+}
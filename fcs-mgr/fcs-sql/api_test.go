@@ -0,0 +1,454 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testInfos describes the channels of the test database.
+var testInfos = map[string]ChannelInfo{
+	"bench/T": {Title: "temperature", Unit: "C"},
+	"bench/P": {Unit: "mbar"},
+}
+
+// useTestStore serves the content of the SQLite database fname for the
+// duration of the test.
+func useTestStore(t *testing.T, fname string) {
+	t.Helper()
+	st, err := openSQLite(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	descr, err := st.DataDesc()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	page.mu.Lock()
+	store, cat := page.store, page.cat
+	page.store = st
+	page.cat = newCatalog(descr, testInfos)
+	page.mu.Unlock()
+
+	t.Cleanup(func() {
+		page.mu.Lock()
+		page.store, page.cat = store, cat
+		page.mu.Unlock()
+		st.Close()
+	})
+}
+
+// execTestDB executes query on the SQLite database fname.
+func execTestDB(t *testing.T, fname, query string, args ...interface{}) {
+	t.Helper()
+	db, err := sql.Open("sqlite3", fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	_, err = db.Exec(query, args...)
+	if err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+}
+
+// get serves the request of the API at uri, with the parameters q.
+func get(t *testing.T, h http.HandlerFunc, uri string, q url.Values) *httptest.ResponseRecorder {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h(w, httptest.NewRequest("GET", uri+"?"+q.Encode(), nil))
+	return w
+}
+
+func TestRawHandlerMaxPoints(t *testing.T) {
+	fname := newTestDB(t)
+	// a channel with more than maxPoints samples, one every 10ms.
+	execTestDB(t, fname, "insert into datadesc (dataType, name, srcName, srcSubsystem) values ('D', 'bench/fast', 'bench', 'bench')")
+	execTestDB(t, fname, "insert into rawdata (doubleData, tstampmills, descr_id) with recursive c(i) as (select 0 union all select i+1 from c where i < 10000) select i, ? + 10*i, 3 from c", millis(t0))
+	useTestStore(t, fname)
+
+	type result struct {
+		Samples []jsonSample `json:"samples"`
+		Bins    []jsonBin    `json:"bins"`
+	}
+	for _, tc := range []struct {
+		ch      string
+		n       string
+		samples int // number of raw samples
+		bins    int // maximum number of bins, 0 for raw samples
+	}{
+		{"bench/T", "", 60, 0},
+		{"bench/T", "0", 60, 0},
+		{"bench/T", "100", 60, 0},
+		{"bench/T", "10", 0, 10},
+		{"bench/fast", "", 0, maxPoints},
+		{"bench/fast", "0", 0, maxPoints},
+		{"bench/fast", "20000", 0, maxPoints},
+		{"bench/fast", "100", 0, 100},
+	} {
+		q := url.Values{
+			"ch":    {tc.ch},
+			"start": {t0.Format(time.RFC3339)},
+			"end":   {t0.Add(10 * time.Minute).Format(time.RFC3339)},
+		}
+		if tc.n != "" {
+			q.Set("n", tc.n)
+		}
+		w := get(t, rawHandler, "/api/data", q)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s, n=%q: invalid status %d: %s", tc.ch, tc.n, w.Code, w.Body)
+		}
+		var res []result
+		err := json.Unmarshal(w.Body.Bytes(), &res)
+		if err != nil {
+			t.Fatalf("%s, n=%q: %v", tc.ch, tc.n, err)
+		}
+		if len(res) != 1 {
+			t.Fatalf("%s, n=%q: invalid number of channels: %d", tc.ch, tc.n, len(res))
+		}
+		if got := len(res[0].Samples); got != tc.samples {
+			t.Fatalf("%s, n=%q: invalid number of samples: got=%d, want=%d", tc.ch, tc.n, got, tc.samples)
+		}
+		if tc.bins == 0 {
+			if len(res[0].Bins) != 0 {
+				t.Fatalf("%s, n=%q: unexpected bins", tc.ch, tc.n)
+			}
+			continue
+		}
+		var total int64
+		for _, bin := range res[0].Bins {
+			total += bin.N
+		}
+		switch {
+		case len(res[0].Bins) == 0 || len(res[0].Bins) > tc.bins:
+			t.Fatalf("%s, n=%q: invalid number of bins: %d", tc.ch, tc.n, len(res[0].Bins))
+		case tc.ch == "bench/fast" && total != maxPoints+1:
+			t.Fatalf("%s, n=%q: invalid number of binned samples: %d", tc.ch, tc.n, total)
+		}
+	}
+}
+
+// span returns the parameters of the time range [t0+beg, t0+end).
+func span(beg, end time.Duration) url.Values {
+	return url.Values{
+		"start": {t0.Add(beg).Format(time.RFC3339)},
+		"end":   {t0.Add(end).Format(time.RFC3339)},
+	}
+}
+
+// with returns q with the additional parameters kvs (key, value, ...).
+func with(q url.Values, kvs ...string) url.Values {
+	out := url.Values{}
+	for k, v := range q {
+		out[k] = v
+	}
+	for i := 0; i < len(kvs); i += 2 {
+		out.Add(kvs[i], kvs[i+1])
+	}
+	return out
+}
+
+func decodeJSON(t *testing.T, w *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if w.Code != http.StatusOK {
+		t.Fatalf("invalid status %d: %s", w.Code, w.Body)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("invalid content type %q", ct)
+	}
+	err := json.Unmarshal(w.Body.Bytes(), v)
+	if err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, w.Body)
+	}
+}
+
+func checkCSV(t *testing.T, w *httptest.ResponseRecorder, want string) {
+	t.Helper()
+	if w.Code != http.StatusOK {
+		t.Fatalf("invalid status %d: %s", w.Code, w.Body)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "text/csv" {
+		t.Fatalf("invalid content type %q", ct)
+	}
+	if got := w.Body.String(); got != want {
+		t.Fatalf("invalid CSV:\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestChannelsHandler(t *testing.T) {
+	useTestStore(t, newTestDB(t))
+
+	var chans []Channel
+	decodeJSON(t, get(t, channelsHandler, "/api/channels", nil), &chans)
+	want := []Channel{
+		{ID: 2, Name: "bench/P", Subsystem: "bench", Type: "D", Title: "P", Unit: "mbar"},
+		{ID: 1, Name: "bench/T", Subsystem: "bench", Type: "D", Title: "temperature", Unit: "C"},
+	}
+	if !reflect.DeepEqual(chans, want) {
+		t.Fatalf("invalid channels:\ngot= %+v\nwant=%+v", chans, want)
+	}
+
+	checkCSV(t, get(t, channelsHandler, "/api/channels", url.Values{"ch": {"*/T"}, "format": {"csv"}}),
+		"id,name,subsystem,type,title,unit\n1,bench/T,bench,D,temperature,C\n",
+	)
+
+	for _, q := range []url.Values{
+		{"format": {"xml"}},
+		{"ch": {"bench/["}},
+	} {
+		if w := get(t, channelsHandler, "/api/channels", q); w.Code != http.StatusBadRequest {
+			t.Fatalf("%v: invalid status %d", q, w.Code)
+		}
+	}
+}
+
+func TestRawHandler(t *testing.T) {
+	useTestStore(t, newTestDB(t))
+
+	var res []struct {
+		Channel Channel      `json:"channel"`
+		Samples []jsonSample `json:"samples"`
+		Bins    []jsonBin    `json:"bins"`
+	}
+	decodeJSON(t, get(t, rawHandler, "/api/data", with(span(0, 10*time.Minute), "ch", "bench/P")), &res)
+	if len(res) != 1 || res[0].Channel.Name != "bench/P" || res[0].Bins != nil {
+		t.Fatalf("invalid result: %+v", res)
+	}
+	want := []jsonSample{
+		{Time: "2016-03-01T12:00:00.000Z", Value: 1013},
+		{Time: "2016-03-01T12:01:00.000Z", Value: 1014},
+		{Time: "2016-03-01T12:02:00.000Z", Value: 1015},
+	}
+	if !reflect.DeepEqual(res[0].Samples, want) {
+		t.Fatalf("invalid samples:\ngot= %+v\nwant=%+v", res[0].Samples, want)
+	}
+
+	checkCSV(t, get(t, rawHandler, "/api/data", with(span(0, 90*time.Second), "ch", "bench/P", "format", "csv")),
+		"time,channel,value,unit\n"+
+			"2016-03-01T12:00:00.000Z,bench/P,1013,mbar\n"+
+			"2016-03-01T12:01:00.000Z,bench/P,1014,mbar\n",
+	)
+
+	// bench/T is binned: bench/P is written with one bin per sample.
+	w := get(t, rawHandler, "/api/data", with(span(0, 10*time.Minute), "ch", "bench/*", "n", "10", "format", "csv"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("invalid status %d: %s", w.Code, w.Body)
+	}
+	recs, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Join(recs[0], ","), strings.Join(binHeader, ","); got != want {
+		t.Fatalf("invalid header: got=%q, want=%q", got, want)
+	}
+	if len(recs) != 1+3+10 {
+		t.Fatalf("invalid number of records: %d", len(recs))
+	}
+	if got, want := strings.Join(recs[1], ","), "2016-03-01T12:00:00.000Z,2016-03-01T12:00:00.000Z,bench/P,1,1013,0,1013,1013,mbar"; got != want {
+		t.Fatalf("invalid bin of a sample:\ngot= %s\nwant=%s", got, want)
+	}
+	for _, rec := range recs[4:] {
+		if rec[2] != "bench/T" || rec[3] != "6" || rec[8] != "C" {
+			t.Fatalf("invalid bin: %q", rec)
+		}
+	}
+	if got, want := recs[4][:5], []string{"2016-03-01T12:00:00.000Z", "2016-03-01T12:01:00.000Z", "bench/T", "6", "2.5"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid first bin: got=%q, want=%q", got, want)
+	}
+
+	for _, tc := range []struct {
+		q    url.Values
+		code int
+	}{
+		{span(0, time.Minute), http.StatusNotFound},
+		{with(span(0, time.Minute), "ch", "nope/*"), http.StatusNotFound},
+		{with(span(0, time.Minute), "ch", "bench/T", "n", "-1"), http.StatusBadRequest},
+		{with(span(0, time.Minute), "ch", "bench/T", "n", "x"), http.StatusBadRequest},
+		{with(span(time.Minute, 0), "ch", "bench/T"), http.StatusBadRequest},
+		{with(span(0, time.Minute), "ch", "bench/T", "format", "xml"), http.StatusBadRequest},
+	} {
+		if w := get(t, rawHandler, "/api/data", tc.q); w.Code != tc.code {
+			t.Fatalf("%v: invalid status: got=%d, want=%d", tc.q, w.Code, tc.code)
+		}
+	}
+}
+
+type statResult struct {
+	Channel Channel   `json:"channel"`
+	Width   string    `json:"width"`
+	Bins    []jsonBin `json:"bins"`
+}
+
+func TestStatHandler(t *testing.T) {
+	useTestStore(t, newTestDB(t))
+
+	var res []statResult
+	decodeJSON(t, get(t, statHandler, "/api/stat", with(span(0, 10*time.Minute), "ch", "bench/T")), &res)
+	if len(res) != 2 || res[0].Width != "1m0s" || res[1].Width != "5m0s" || len(res[0].Bins) != 10 || len(res[1].Bins) != 2 {
+		t.Fatalf("invalid result: %+v", res)
+	}
+	bin := res[1].Bins[0]
+	if bin.Start != "2016-03-01T12:00:00.000Z" || bin.End != "2016-03-01T12:05:00.000Z" || bin.N != 30 ||
+		bin.Mean == nil || *bin.Mean != 14.5 || bin.Stddev == nil || bin.Min != nil || bin.Max != nil {
+		t.Fatalf("invalid bin: %+v", bin)
+	}
+
+	res = nil
+	decodeJSON(t, get(t, statHandler, "/api/stat", with(span(0, 10*time.Minute), "ch", "bench/P")), &res)
+	if res == nil || len(res) != 0 {
+		t.Fatalf("invalid result for a channel without statdata: %+v", res)
+	}
+
+	w := get(t, statHandler, "/api/stat", with(span(0, 10*time.Minute), "ch", "bench/T", "width", "5m", "format", "csv"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("invalid status %d: %s", w.Code, w.Body)
+	}
+	recs, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 3 || strings.Join(recs[0], ",") != "width,"+strings.Join(binHeader, ",") {
+		t.Fatalf("invalid CSV: %q", recs)
+	}
+	if got, want := recs[2][:6], []string{"5m0s", "2016-03-01T12:05:00.000Z", "2016-03-01T12:10:00.000Z", "bench/T", "30", "44.5"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid bin: got=%q, want=%q", got, want)
+	}
+
+	for _, width := range []string{"0s", "-1m", "x"} {
+		w := get(t, statHandler, "/api/stat", with(span(0, 10*time.Minute), "ch", "bench/T", "width", width))
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("width=%q: invalid status %d", width, w.Code)
+		}
+	}
+}
+
+func TestEmptyStatBin(t *testing.T) {
+	fname := newTestDB(t)
+	// an empty bin has no mean value.
+	execTestDB(t, fname, "insert into statdata (data, n, sum2, tstampmills1, tstampmills2, descr_id) values (0, 0, 0, ?, ?, 1)",
+		millis(t0.Add(20*time.Minute)), millis(t0.Add(21*time.Minute)),
+	)
+	useTestStore(t, fname)
+
+	var res []statResult
+	decodeJSON(t, get(t, statHandler, "/api/stat", with(span(0, 30*time.Minute), "ch", "bench/T", "width", "1m")), &res)
+	if len(res) != 1 || len(res[0].Bins) != 11 {
+		t.Fatalf("invalid result: %+v", res)
+	}
+	if bin := res[0].Bins[10]; bin.N != 0 || bin.Mean != nil || bin.Stddev != nil {
+		t.Fatalf("invalid empty bin: %+v", bin)
+	}
+
+	w := get(t, statHandler, "/api/stat", with(span(20*time.Minute, 30*time.Minute), "ch", "bench/T", "width", "1m", "format", "csv"))
+	checkCSV(t, w, "width,"+strings.Join(binHeader, ",")+"\n"+
+		"1m0s,2016-03-01T12:20:00.000Z,2016-03-01T12:21:00.000Z,bench/T,0,,,,,C\n",
+	)
+
+	// empty bins are not plotted.
+	var ps map[string]struct {
+		Res    string       `json:"res"`
+		Points [][2]float64 `json:"points"`
+	}
+	decodeJSON(t, get(t, plotHandler, "/api/plot", with(span(0, 30*time.Minute), "ch", "bench/T", "n", "40")), &ps)
+	if s := ps["bench/T"]; s.Res != resStat || len(s.Points) != 10 {
+		t.Fatalf("invalid plot series: %+v", s)
+	}
+}
+
+func TestMetaDataHandler(t *testing.T) {
+	useTestStore(t, newTestDB(t))
+
+	type result struct {
+		ID      int64  `json:"id"`
+		Name    string `json:"name"`
+		Start   string `json:"start"`
+		Stop    string `json:"stop"`
+		Value   string `json:"value"`
+		Channel string `json:"channel"`
+	}
+	var res []result
+	decodeJSON(t, get(t, metaDataHandler, "/api/metadata", span(0, 10*time.Minute)), &res)
+	want := []result{
+		{ID: 1, Name: "range", Start: "2016-03-01T11:00:00.000Z", Value: "18:24", Channel: "bench/T"},
+		{ID: 2, Name: "gain", Start: "2016-03-01T12:00:00.000Z", Stop: "2016-03-01T12:02:00.000Z", Value: "2", Channel: "bench/P"},
+		{ID: 3, Name: "test", Start: "2016-03-01T12:05:00.000Z", Value: "run-1"},
+	}
+	if !reflect.DeepEqual(res, want) {
+		t.Fatalf("invalid metadata:\ngot= %+v\nwant=%+v", res, want)
+	}
+
+	res = nil
+	decodeJSON(t, get(t, metaDataHandler, "/api/metadata", span(3*time.Minute, 4*time.Minute)), &res)
+	if len(res) != 1 || res[0].Name != "range" {
+		t.Fatalf("invalid metadata: %+v", res)
+	}
+
+	checkCSV(t, get(t, metaDataHandler, "/api/metadata", with(span(0, 10*time.Minute), "ch", "bench/P", "format", "csv")),
+		"id,name,start,stop,value,channel\n"+
+			"2,gain,2016-03-01T12:00:00.000Z,2016-03-01T12:02:00.000Z,2,bench/P\n",
+	)
+
+	if w := get(t, metaDataHandler, "/api/metadata", with(span(0, time.Minute), "ch", "nope")); w.Code != http.StatusNotFound {
+		t.Fatalf("invalid status %d", w.Code)
+	}
+	if w := get(t, metaDataHandler, "/api/metadata", url.Values{"start": {"yesterday"}}); w.Code != http.StatusBadRequest {
+		t.Fatalf("invalid status %d", w.Code)
+	}
+}
+
+func TestTimeRange(t *testing.T) {
+	const day = 24 * time.Hour
+	for _, tc := range []struct {
+		q        url.Values
+		beg, end time.Time
+	}{
+		{url.Values{"start": {"2016-03-01T12:00:00Z"}, "end": {"2016-03-01T13:00:00.5+01:00"}}, t0, t0.Add(500 * time.Millisecond)},
+		{url.Values{"start": {"1456833600000"}, "end": {"1456833601500"}}, t0, t0.Add(1500 * time.Millisecond)},
+		{url.Values{"end": {"2016-03-01T12:00:00Z"}}, t0.Add(-day), t0},
+	} {
+		beg, end, err := timeRange(tc.q, day)
+		if err != nil {
+			t.Fatalf("%v: %v", tc.q, err)
+		}
+		if !beg.Equal(tc.beg) || !end.Equal(tc.end) {
+			t.Fatalf("%v: got=[%v, %v), want=[%v, %v)", tc.q, beg, end, tc.beg, tc.end)
+		}
+	}
+
+	// times relative to now.
+	now := time.Now()
+	beg, end, err := timeRange(url.Values{"start": {"-6h"}}, day)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := end.Sub(now); d < 0 || d > time.Minute {
+		t.Fatalf("invalid end: %v (now=%v)", end, now)
+	}
+	if d := end.Sub(beg); d < 6*time.Hour-time.Minute || d > 6*time.Hour+time.Minute {
+		t.Fatalf("invalid range: [%v, %v)", beg, end)
+	}
+	beg, end, err = timeRange(url.Values{}, time.Hour)
+	if err != nil || end.Sub(beg) != time.Hour {
+		t.Fatalf("invalid default range: [%v, %v) (err=%v)", beg, end, err)
+	}
+
+	for _, q := range []url.Values{
+		{"start": {"yesterday"}},
+		{"end": {"1h30"}},
+		{"start": {"2016-03-01T12:00:00Z"}, "end": {"2016-03-01T12:00:00Z"}},
+		{"start": {"2016-03-01T13:00:00Z"}, "end": {"2016-03-01T12:00:00Z"}},
+	} {
+		_, _, err := timeRange(q, day)
+		if err == nil {
+			t.Fatalf("%v: expected an error", q)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Channel describes a channel of the trending database, from its datadesc
+// entry and its entry in the catalog file.
+type Channel struct {
+	ID        int64  `json:"id"`
+	Name      string `json:"name"`      // full name, e.g. "testbenchLPC/temperature"
+	Subsystem string `json:"subsystem"` // source subsystem
+	Type      string `json:"type"`      // data type
+	Title     string `json:"title"`     // short name, used in legends
+	Unit      string `json:"unit"`      // physical unit
+}
+
+// Label returns the legend of the channel.
+func (ch Channel) Label() string {
+	if ch.Unit == "" {
+		return ch.Title
+	}
+	return fmt.Sprintf("%s (%s)", ch.Title, ch.Unit)
+}
+
+// ChannelInfo describes how to display a channel.
+type ChannelInfo struct {
+	Title string `json:"title"`
+	Unit  string `json:"unit"`
+}
+
+// defaultInfos describes the channels of the LPC test bench.
+var defaultInfos = map[string]ChannelInfo{
+	"testbenchLPC/temperature": {Title: "temperature", Unit: "°C"},
+	"testbenchLPC/pressure":    {Title: "pressure", Unit: "mbar"},
+	"testbenchLPC/hygrometry":  {Title: "hygrometry", Unit: "%"},
+}
+
+// loadInfos loads the descriptions of channels from a JSON file, mapping
+// channel names to their title and unit:
+//
+//	{
+//		"testbenchLPC/temperature": {"title": "temperature", "unit": "°C"},
+//		"MSR145_123456/RH":         {"unit": "%"}
+//	}
+//
+// Channels not described in the file use defaultInfos.
+func loadInfos(fname string) (map[string]ChannelInfo, error) {
+	infos := make(map[string]ChannelInfo, len(defaultInfos))
+	for k, v := range defaultInfos {
+		infos[k] = v
+	}
+	if fname == "" {
+		return infos, nil
+	}
+
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var file map[string]ChannelInfo
+	err = json.NewDecoder(f).Decode(&file)
+	if err != nil {
+		return nil, fmt.Errorf("invalid catalog file %q: %v", fname, err)
+	}
+	for k, v := range file {
+		infos[k] = v
+	}
+	return infos, nil
+}
+
+// Catalog holds the channels of the trending database.
+type Catalog struct {
+	Channels []Channel // channels, sorted by subsystem and name
+
+	byID map[int64]int
+}
+
+// newCatalog returns the catalog of the channels described in descr.
+func newCatalog(descr map[int64]DataDesc, infos map[string]ChannelInfo) *Catalog {
+	cat := &Catalog{
+		Channels: make([]Channel, 0, len(descr)),
+		byID:     make(map[int64]int, len(descr)),
+	}
+	for id, desc := range descr {
+		ch := Channel{
+			ID:        id,
+			Name:      desc.Name.String,
+			Subsystem: desc.SrcSubSystem.String,
+			Type:      desc.Type.String,
+		}
+		if !desc.Name.Valid {
+			ch.Name = fmt.Sprintf("datadesc-%d", id)
+		}
+		ch.Title = strings.TrimPrefix(ch.Name, ch.Subsystem+"/")
+		if info, ok := infos[ch.Name]; ok {
+			if info.Title != "" {
+				ch.Title = info.Title
+			}
+			ch.Unit = info.Unit
+		}
+		cat.Channels = append(cat.Channels, ch)
+	}
+	sort.Sort(channelsBySubsystem(cat.Channels))
+	for i, ch := range cat.Channels {
+		cat.byID[ch.ID] = i
+	}
+	return cat
+}
+
+// Channel returns the channel with the datadesc id.
+func (cat *Catalog) Channel(id int64) (Channel, bool) {
+	i, ok := cat.byID[id]
+	if !ok {
+		return Channel{}, false
+	}
+	return cat.Channels[i], true
+}
+
+// Select returns the channels whose name matches one of the patterns (see
+// path.Match), e.g. "testbenchLPC/temperature" or "MSR145_*/T".
+// The pattern "*" matches all the channels.
+func (cat *Catalog) Select(patterns []string) ([]Channel, error) {
+	chans := []Channel{}
+	for _, ch := range cat.Channels {
+		for _, pattern := range patterns {
+			if pattern == "*" {
+				chans = append(chans, ch)
+				break
+			}
+			ok, err := path.Match(pattern, ch.Name)
+			if err != nil {
+				return nil, fmt.Errorf("invalid channel pattern %q: %v", pattern, err)
+			}
+			if ok {
+				chans = append(chans, ch)
+				break
+			}
+		}
+	}
+	return chans, nil
+}
+
+// Group is a set of channels from the same subsystem.
+type Group struct {
+	Subsystem string
+	Channels  []Channel
+}
+
+// Groups returns the channels of the catalog, grouped by subsystem.
+func (cat *Catalog) Groups() []Group {
+	var groups []Group
+	for _, ch := range cat.Channels {
+		if len(groups) == 0 || groups[len(groups)-1].Subsystem != ch.Subsystem {
+			groups = append(groups, Group{Subsystem: ch.Subsystem})
+		}
+		g := &groups[len(groups)-1]
+		g.Channels = append(g.Channels, ch)
+	}
+	return groups
+}
+
+type channelsBySubsystem []Channel
+
+func (p channelsBySubsystem) Len() int { return len(p) }
+
+func (p channelsBySubsystem) Less(i, j int) bool {
+	if p[i].Subsystem != p[j].Subsystem {
+		return p[i].Subsystem < p[j].Subsystem
+	}
+	return p[i].Name < p[j].Name
+}
+
+func (p channelsBySubsystem) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+
+// splitPatterns returns the channel patterns of comma-separated lists.
+func splitPatterns(lists []string) []string {
+	var patterns []string
+	for _, list := range lists {
+		for _, pattern := range strings.Split(list, ",") {
+			pattern = strings.TrimSpace(pattern)
+			if pattern != "" {
+				patterns = append(patterns, pattern)
+			}
+		}
+	}
+	return patterns
+}
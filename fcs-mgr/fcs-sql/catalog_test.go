@@ -0,0 +1,227 @@
+package main
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func newTestCatalog(t *testing.T) *Catalog {
+	t.Helper()
+	desc := func(name, subsys string) DataDesc {
+		return DataDesc{
+			Type:         sql.NullString{String: "D", Valid: true},
+			Name:         sql.NullString{String: name, Valid: name != ""},
+			SrcSubSystem: sql.NullString{String: subsys, Valid: true},
+		}
+	}
+	descr := map[int64]DataDesc{
+		1: desc("testbenchLPC/temperature", "testbenchLPC"),
+		2: desc("testbenchLPC/pressure", "testbenchLPC"),
+		3: desc("MSR145_1/T", "MSR145_1"),
+		4: desc("MSR145_1/RH", "MSR145_1"),
+		5: desc("MSR145_2/T", "MSR145_2"),
+		6: desc("", "aaa"),
+	}
+
+	fname := filepath.Join(t.TempDir(), "catalog.json")
+	err := ioutil.WriteFile(fname, []byte(`{
+		"testbenchLPC/temperature": {"unit": "K"},
+		"MSR145_1/T": {"title": "T1", "unit": "°C"},
+		"MSR145_2/T": {"unit": "°C"}
+	}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	infos, err := loadInfos(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newCatalog(descr, infos)
+}
+
+func TestLoadInfos(t *testing.T) {
+	infos, err := loadInfos("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(infos, defaultInfos) {
+		t.Fatalf("invalid default infos: %+v", infos)
+	}
+	infos["testbenchLPC/pressure"] = ChannelInfo{}
+	if defaultInfos["testbenchLPC/pressure"].Unit != "mbar" {
+		t.Fatalf("default infos were modified")
+	}
+
+	dir := t.TempDir()
+	fname := filepath.Join(dir, "catalog.json")
+	err = ioutil.WriteFile(fname, []byte(`{
+		"testbenchLPC/temperature": {"unit": "K"},
+		"MSR145_1/T": {"title": "T1", "unit": "°C"}
+	}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	infos, err = loadInfos(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]ChannelInfo{
+		"testbenchLPC/temperature": {Unit: "K"}, // overridden
+		"testbenchLPC/pressure":    defaultInfos["testbenchLPC/pressure"],
+		"MSR145_1/T":               {Title: "T1", Unit: "°C"},
+	} {
+		if got := infos[name]; got != want {
+			t.Fatalf("%s: got=%+v, want=%+v", name, got, want)
+		}
+	}
+
+	_, err = loadInfos(filepath.Join(dir, "missing.json"))
+	if err == nil {
+		t.Fatalf("expected an error for a missing catalog file")
+	}
+	bad := filepath.Join(dir, "bad.json")
+	err = ioutil.WriteFile(bad, []byte(`["testbenchLPC/temperature"]`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = loadInfos(bad)
+	if err == nil {
+		t.Fatalf("expected an error for an invalid catalog file")
+	}
+}
+
+func TestCatalog(t *testing.T) {
+	cat := newTestCatalog(t)
+
+	want := []Channel{
+		{ID: 4, Name: "MSR145_1/RH", Subsystem: "MSR145_1", Type: "D", Title: "RH"},
+		{ID: 3, Name: "MSR145_1/T", Subsystem: "MSR145_1", Type: "D", Title: "T1", Unit: "°C"},
+		{ID: 5, Name: "MSR145_2/T", Subsystem: "MSR145_2", Type: "D", Title: "T", Unit: "°C"},
+		{ID: 6, Name: "datadesc-6", Subsystem: "aaa", Type: "D", Title: "datadesc-6"},
+		{ID: 2, Name: "testbenchLPC/pressure", Subsystem: "testbenchLPC", Type: "D", Title: "pressure", Unit: "mbar"},
+		{ID: 1, Name: "testbenchLPC/temperature", Subsystem: "testbenchLPC", Type: "D", Title: "temperature", Unit: "K"},
+	}
+	if !reflect.DeepEqual(cat.Channels, want) {
+		t.Fatalf("invalid channels:\ngot= %+v\nwant=%+v", cat.Channels, want)
+	}
+	for _, ch := range want {
+		got, ok := cat.Channel(ch.ID)
+		if !ok || got != ch {
+			t.Fatalf("channel %d: got=%+v, want=%+v", ch.ID, got, ch)
+		}
+	}
+	if _, ok := cat.Channel(42); ok {
+		t.Fatalf("unexpected channel 42")
+	}
+
+	var groups []string
+	var n []int
+	for _, g := range cat.Groups() {
+		groups = append(groups, g.Subsystem)
+		n = append(n, len(g.Channels))
+	}
+	if want := []string{"MSR145_1", "MSR145_2", "aaa", "testbenchLPC"}; !reflect.DeepEqual(groups, want) {
+		t.Fatalf("invalid groups: got=%q, want=%q", groups, want)
+	}
+	if want := []int{2, 1, 1, 2}; !reflect.DeepEqual(n, want) {
+		t.Fatalf("invalid group sizes: got=%v, want=%v", n, want)
+	}
+}
+
+func TestCatalogSelect(t *testing.T) {
+	cat := newTestCatalog(t)
+
+	for _, tc := range []struct {
+		patterns []string
+		want     []int64
+	}{
+		{nil, []int64{}},
+		{[]string{"*"}, []int64{4, 3, 5, 6, 2, 1}},
+		{[]string{"testbenchLPC/temperature"}, []int64{1}},
+		{[]string{"MSR145_*/T"}, []int64{3, 5}},
+		{[]string{"testbenchLPC/*", "MSR145_1/*"}, []int64{4, 3, 2, 1}},
+		{[]string{"*/T", "MSR145_2/T"}, []int64{3, 5}}, // no duplicates
+		{[]string{"*/t"}, []int64{}},
+		{[]string{"MSR145_[12]/RH"}, []int64{4}},
+		{[]string{"nope"}, []int64{}},
+	} {
+		chans, err := cat.Select(tc.patterns)
+		if err != nil {
+			t.Fatalf("%q: %v", tc.patterns, err)
+		}
+		ids := []int64{}
+		for _, ch := range chans {
+			ids = append(ids, ch.ID)
+		}
+		if !reflect.DeepEqual(ids, tc.want) {
+			t.Fatalf("%q: got=%v, want=%v", tc.patterns, ids, tc.want)
+		}
+	}
+
+	for _, patterns := range [][]string{
+		{"MSR145_[/T"},
+		{"*/T", "a\\"},
+	} {
+		_, err := cat.Select(patterns)
+		if err == nil {
+			t.Fatalf("%q: expected an error", patterns)
+		}
+	}
+}
+
+func TestPageView(t *testing.T) {
+	p := &Page{Title: "test", cat: newTestCatalog(t)}
+
+	v, err := p.view([]string{"MSR145_*/T", "testbenchLPC/*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(v.Groups) != 4 {
+		t.Fatalf("invalid groups: %+v", v.Groups)
+	}
+	if want := map[int64]bool{3: true, 5: true, 2: true, 1: true}; !reflect.DeepEqual(v.Selected, want) {
+		t.Fatalf("invalid selection: got=%v, want=%v", v.Selected, want)
+	}
+
+	// channels with the same unit share an axis.
+	wantAxes := []axisView{
+		{Unit: "°C", Position: "right", Channels: []string{"T1", "T"}},
+		{Unit: "mbar", Position: "left", Channels: []string{"pressure"}},
+		{Unit: "K", Position: "right", Channels: []string{"temperature"}},
+	}
+	if !reflect.DeepEqual(v.Axes, wantAxes) {
+		t.Fatalf("invalid axes:\ngot= %+v\nwant=%+v", v.Axes, wantAxes)
+	}
+	wantSeries := []seriesView{
+		{Name: "MSR145_1/T", Label: "T1 (°C)", YAxis: 1},
+		{Name: "MSR145_2/T", Label: "T (°C)", YAxis: 1},
+		{Name: "testbenchLPC/pressure", Label: "pressure (mbar)", YAxis: 2},
+		{Name: "testbenchLPC/temperature", Label: "temperature (K)", YAxis: 3},
+	}
+	if !reflect.DeepEqual(v.Series, wantSeries) {
+		t.Fatalf("invalid series:\ngot= %+v\nwant=%+v", v.Series, wantSeries)
+	}
+
+	// channels without unit share an axis too.
+	v, err = p.view([]string{"MSR145_1/RH", "datadesc-6"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(v.Axes) != 1 || v.Axes[0].Unit != "" || len(v.Series) != 2 || v.Series[1].YAxis != 1 {
+		t.Fatalf("invalid view: axes=%+v, series=%+v", v.Axes, v.Series)
+	}
+
+	_, err = p.view([]string{"MSR145_[/T"})
+	if err == nil {
+		t.Fatalf("expected an error for an invalid pattern")
+	}
+
+	// no catalog yet.
+	v, err = (&Page{Title: "test"}).view([]string{"*"})
+	if err != nil || v.Groups != nil || v.Series != nil || len(v.Selected) != 0 {
+		t.Fatalf("invalid view without catalog: %+v (err=%v)", v, err)
+	}
+}
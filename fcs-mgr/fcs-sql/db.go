@@ -26,7 +26,7 @@ func (ts *Timestamp) Scan(value interface{}) error {
 	if !rv.Type().ConvertibleTo(reflect.TypeOf(int64(0))) {
 		return fmt.Errorf("%T is not convertible to int64", value)
 	}
-	ts.Time = time.Unix(rv.Int()/1000.0, 0)
+	ts.Time = fromMillis(rv.Int())
 	return nil
 }
 
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+
+	"golang.org/x/net/websocket"
+)
+
+// message is a message sent to the websocket clients.
+//
+// A client first receives a snapshot of the live window of its channels, with
+// the series at the resolution chosen by live. Then, each update holds the
+// new raw samples of its channels, loaded from the rawdata rows up to ID.
+type message struct {
+	Type   string      `json:"type"`   // "snapshot" or "update"
+	ID     int64       `json:"id"`     // last rawdata id
+	Series interface{} `json:"series"` // channel name -> plotSeries (snapshot) or Points (update)
+}
+
+// clientQueue is the number of messages queued for a client. Clients lagging
+// further behind are disconnected.
+const clientQueue = 16
+
+// client is a websocket client, subscribed to a set of channels.
+type client struct {
+	chans map[string]bool // subscribed channel names
+	send  chan []byte     // encoded messages, closed on disconnection
+}
+
+// hub fans out the updates of the live window to the websocket clients.
+type hub struct {
+	mu      sync.Mutex
+	clients map[*client]bool
+}
+
+func newHub() *hub {
+	return &hub{clients: make(map[*client]bool)}
+}
+
+// len returns the number of clients.
+func (h *hub) len() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.clients)
+}
+
+// subscribe registers a client of the channels.
+func (h *hub) subscribe(chans []Channel) *client {
+	c := &client{
+		chans: make(map[string]bool, len(chans)),
+		send:  make(chan []byte, clientQueue),
+	}
+	for _, ch := range chans {
+		c.chans[ch.Name] = true
+	}
+
+	h.mu.Lock()
+	h.clients[c] = true
+	h.mu.Unlock()
+	return c
+}
+
+// unsubscribe unregisters a client.
+func (h *hub) unsubscribe(c *client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.drop(c)
+}
+
+func (h *hub) drop(c *client) {
+	if !h.clients[c] {
+		return
+	}
+	delete(h.clients, c)
+	close(c.send)
+}
+
+// publish sends the new samples of channels, up to the rawdata id, to their
+// subscribers.
+// publish never blocks: clients whose queue is full are disconnected, and
+// get a new snapshot when they reconnect.
+func (h *hub) publish(id int64, upd map[string]Points) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for c := range h.clients {
+		series := make(map[string]Points)
+		for name, pts := range upd {
+			if c.chans[name] {
+				series[name] = pts
+			}
+		}
+		if len(series) == 0 {
+			continue
+		}
+		buf, err := json.Marshal(message{Type: "update", ID: id, Series: series})
+		if err != nil {
+			log.Printf("error encoding update: %v\n", err)
+			continue
+		}
+		select {
+		case c.send <- buf:
+		default:
+			log.Printf("dropping slow websocket client\n")
+			h.drop(c)
+		}
+	}
+}
+
+// subscribe subscribes a client to the updates of the channels, and returns
+// the encoded snapshot of their live window.
+func (p *Page) subscribe(chans []Channel) (*client, []byte, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	series := make(map[string]plotSeries, len(chans))
+	for _, ch := range chans {
+		series[ch.Name] = live(p.series[ch.ID], *points)
+	}
+	buf, err := json.Marshal(message{Type: "snapshot", ID: p.id, Series: series})
+	if err != nil {
+		return nil, nil, err
+	}
+	return p.hub.subscribe(chans), buf, nil
+}
+
+// dataHandler serves the live updates of the channels selected by the
+// parameters of the websocket URL (as in /?ch=...).
+func dataHandler(ws *websocket.Conn) {
+	defer ws.Close()
+
+	q := ws.Request().URL.Query()
+	if len(q["ch"]) == 0 {
+		q["ch"] = []string{*chans}
+	}
+	chans, err := channels(q, false)
+	if err != nil {
+		log.Printf("invalid websocket subscription: %v\n", err)
+		return
+	}
+
+	c, snap, err := page.subscribe(chans)
+	if err != nil {
+		log.Printf("error encoding snapshot: %v\n", err)
+		return
+	}
+	defer page.hub.unsubscribe(c)
+
+	err = websocket.Message.Send(ws, string(snap))
+	if err != nil {
+		log.Printf("Can't send: %v\n", err)
+		return
+	}
+
+	// detect disconnections.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var msg string
+		for {
+			err := websocket.Message.Receive(ws, &msg)
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case buf, ok := <-c.send:
+			if !ok {
+				return
+			}
+			err = websocket.Message.Send(ws, string(buf))
+			if err != nil {
+				log.Printf("Can't send: %v\n", err)
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
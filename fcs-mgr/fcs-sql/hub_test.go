@@ -0,0 +1,290 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// memStore is an in-memory Store holding datadesc and rawdata rows.
+type memStore struct {
+	Store // other methods are not used.
+
+	mu    sync.Mutex
+	descr map[int64]DataDesc
+	rows  []RawData
+}
+
+func (st *memStore) DataDesc() (map[int64]DataDesc, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	descr := make(map[int64]DataDesc, len(st.descr))
+	for id, d := range st.descr {
+		descr[id] = d
+	}
+	return descr, nil
+}
+
+func (st *memStore) Rows(last int64, since time.Time, fn func(data RawData) error) error {
+	st.mu.Lock()
+	rows := append([]RawData(nil), st.rows...)
+	st.mu.Unlock()
+	for _, row := range rows {
+		if row.ID <= last || (!since.IsZero() && row.TStamp.Time.Before(since)) {
+			continue
+		}
+		err := fn(row)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (st *memStore) LastTime() (time.Time, bool, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if len(st.rows) == 0 {
+		return time.Time{}, false, nil
+	}
+	return st.rows[len(st.rows)-1].TStamp.Time, true, nil
+}
+
+// add appends a rawdata row with the next id.
+func (st *memStore) add(descr int64, t time.Time, v float64) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.rows = append(st.rows, RawData{
+		ID:      int64(len(st.rows) + 1),
+		Float64: sql.NullFloat64{Float64: v, Valid: true},
+		TStamp:  Timestamp{Valid: true, Time: t},
+		DescrID: descr,
+	})
+}
+
+var t0 = time.Date(2016, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// newMemStore returns a store with the channels "bench/T" (id 1) and
+// "bench/P" (id 2).
+func newMemStore() *memStore {
+	str := func(s string) sql.NullString { return sql.NullString{String: s, Valid: true} }
+	return &memStore{descr: map[int64]DataDesc{
+		1: {ID: 1, Name: str("bench/T"), SrcSubSystem: str("bench"), Type: str("D")},
+		2: {ID: 2, Name: str("bench/P"), SrcSubSystem: str("bench"), Type: str("D")},
+	}}
+}
+
+// setupPage resets the global page to display the channels of st, and
+// returns a server of its websocket endpoint.
+func setupPage(t *testing.T, st Store) *httptest.Server {
+	t.Helper()
+	page.mu.Lock()
+	page.store = st
+	page.id = 0
+	page.since = time.Time{}
+	page.descr = make(map[int64]DataDesc)
+	page.series = make(map[int64]Points)
+	page.cat = nil
+	page.hub = newHub()
+	page.mu.Unlock()
+
+	srv := httptest.NewServer(websocket.Handler(dataHandler))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func dial(t *testing.T, srv *httptest.Server, query string) *websocket.Conn {
+	t.Helper()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/data?" + query
+	ws, err := websocket.Dial(url, "", srv.URL)
+	if err != nil {
+		t.Fatalf("could not dial %s: %v", url, err)
+	}
+	t.Cleanup(func() { ws.Close() })
+	return ws
+}
+
+// recvMessage is a message received by a websocket client.
+type recvMessage struct {
+	Type   string                     `json:"type"`
+	ID     int64                      `json:"id"`
+	Series map[string]json.RawMessage `json:"series"`
+}
+
+func recv(t *testing.T, ws *websocket.Conn) recvMessage {
+	t.Helper()
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var msg recvMessage
+	err := websocket.JSON.Receive(ws, &msg)
+	if err != nil {
+		t.Fatalf("could not receive message: %v", err)
+	}
+	return msg
+}
+
+// npoints returns the number of points of a series of a message.
+func npoints(t *testing.T, msg recvMessage, name string) int {
+	t.Helper()
+	raw, ok := msg.Series[name]
+	if !ok {
+		t.Fatalf("%s message %d: missing series %q", msg.Type, msg.ID, name)
+	}
+	var pts [][2]float64
+	switch msg.Type {
+	case "snapshot":
+		var s struct {
+			Points [][2]float64 `json:"points"`
+		}
+		err := json.Unmarshal(raw, &s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pts = s.Points
+	default:
+		err := json.Unmarshal(raw, &pts)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return len(pts)
+}
+
+func waitClients(t *testing.T, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for page.hub.len() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("invalid number of clients: got=%d, want=%d", page.hub.len(), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestHubSnapshotUpdates(t *testing.T) {
+	st := newMemStore()
+	st.add(1, t0, 21.5)
+	st.add(2, t0, 1013)
+	st.add(1, t0.Add(time.Second), 21.6)
+	srv := setupPage(t, st)
+	err := page.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ws := dial(t, srv, "ch=bench/T")
+	snap := recv(t, ws)
+	if snap.Type != "snapshot" || snap.ID != 3 || len(snap.Series) != 1 {
+		t.Fatalf("invalid snapshot: %+v", snap)
+	}
+	if n := npoints(t, snap, "bench/T"); n != 2 {
+		t.Fatalf("invalid snapshot: got %d points, want 2", n)
+	}
+	waitClients(t, 1)
+
+	// only the samples past the id of the snapshot are sent.
+	st.add(1, t0.Add(2*time.Second), 21.7)
+	st.add(2, t0.Add(2*time.Second), 1014)
+	err = page.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	upd := recv(t, ws)
+	if upd.Type != "update" || upd.ID != 5 || len(upd.Series) != 1 {
+		t.Fatalf("invalid update: %+v", upd)
+	}
+	if n := npoints(t, upd, "bench/T"); n != 1 {
+		t.Fatalf("invalid update: got %d points, want 1", n)
+	}
+
+	// no update for the samples of other channels.
+	st.add(2, t0.Add(3*time.Second), 1015)
+	err = page.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.add(1, t0.Add(4*time.Second), 21.8)
+	err = page.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	upd = recv(t, ws)
+	if upd.Type != "update" || upd.ID != 7 {
+		t.Fatalf("invalid update: %+v", upd)
+	}
+	if n := npoints(t, upd, "bench/T"); n != 1 {
+		t.Fatalf("invalid update: got %d points, want 1", n)
+	}
+}
+
+func TestHubSlowClient(t *testing.T) {
+	h := newHub()
+	slow := h.subscribe([]Channel{{Name: "bench/T"}})
+	fast := h.subscribe([]Channel{{Name: "bench/T"}})
+	upd := map[string]Points{"bench/T": {{X: t0, Y: 21.5}}}
+
+	for i := 0; i <= clientQueue; i++ {
+		h.publish(int64(i+1), upd)
+		// the fast client reads its updates.
+		select {
+		case <-fast.send:
+		default:
+			t.Fatalf("update #%d not queued for the fast client", i)
+		}
+	}
+	if got := h.len(); got != 1 {
+		t.Fatalf("invalid number of clients: got=%d, want=1", got)
+	}
+
+	// the slow client gets its queued updates, then is disconnected.
+	n := 0
+	for range slow.send {
+		n++
+	}
+	if n != clientQueue {
+		t.Fatalf("invalid number of queued updates: got=%d, want=%d", n, clientQueue)
+	}
+
+	h.unsubscribe(slow) // no-op
+	h.unsubscribe(fast)
+	if _, ok := <-fast.send; ok {
+		t.Fatalf("fast client not disconnected")
+	}
+}
+
+func TestHubUnsubscribe(t *testing.T) {
+	st := newMemStore()
+	st.add(1, t0, 21.5)
+	srv := setupPage(t, st)
+	err := page.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ws1 := dial(t, srv, "ch=bench/*")
+	ws2 := dial(t, srv, "ch=bench/P")
+	recv(t, ws1)
+	recv(t, ws2)
+	waitClients(t, 2)
+
+	ws1.Close()
+	waitClients(t, 1)
+
+	// the remaining client still gets its updates.
+	st.add(2, t0.Add(time.Second), 1013)
+	err = page.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upd := recv(t, ws2); upd.Type != "update" || upd.ID != 2 {
+		t.Fatalf("invalid update: %+v", upd)
+	}
+
+	ws2.Close()
+	waitClients(t, 0)
+}
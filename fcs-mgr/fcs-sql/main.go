@@ -1,61 +1,90 @@
 package main
 
 import (
-	"database/sql"
 	"flag"
 	"fmt"
 	"html/template"
 	"log"
-	"strings"
 	"time"
-
-	_ "github.com/go-sql-driver/mysql"
 )
 
 var (
 	user    = flag.String("user", "", "db user name")
 	pass    = flag.String("password", "", "db user password")
+	dbname  = flag.String("db", "ccs", "name of the mysql db")
+	sqlite  = flag.String("sqlite", "", "path to an SQLite snapshot of the db, read instead of the mysql db")
 	verbose = flag.Bool("v", false, "enable verbose mode")
+	catalog = flag.String("catalog", "", "path to a JSON file describing the title and unit of channels")
+	chans   = flag.String("channels", "testbenchLPC/*", "comma-separated list of patterns of the channels displayed by default")
+	window  = flag.Duration("window", 24*time.Hour, "time span of the live view")
+	points  = flag.Int("points", 1000, "maximum number of points of the plotted series")
+	alarmf  = flag.String("alarms", "", "path to a JSON file describing alarm rules and notification sinks")
 
 	page = Page{
-		Title: "FCS",
-		db:    nil,
-		Tmpl:  template.Must(template.New("fcs").Parse(displayTmpl)),
-		descr: make(map[int64]DataDesc),
+		Title:  "FCS",
+		store:  nil,
+		Tmpl:   template.Must(template.New("fcs").Parse(displayTmpl)),
+		descr:  make(map[int64]DataDesc),
+		series: make(map[int64]Points),
+		hub:    newHub(),
 	}
-	datac = make(chan map[string]interface{})
 )
 
 func main() {
 
 	flag.Parse()
 
+	infos, err := loadInfos(*catalog)
+	if err != nil {
+		log.Fatalf("error loading channel catalog: %v\n", err)
+	}
+	page.infos = infos
+
+	if *alarmf != "" {
+		cfg, err := loadAlarmConfig(*alarmf)
+		if err != nil {
+			log.Fatalf("error loading alarm rules: %v\n", err)
+		}
+		page.alarms, err = newAlarms(cfg)
+		if err != nil {
+			log.Fatalf("error creating alarm sinks: %v\n", err)
+		}
+		log.Printf("monitoring %d alarm rules\n", len(cfg.Rules))
+	}
+
 	errc := make(chan error)
 	go func() {
 		errc <- startServer()
 	}()
 
-	log.Printf("connect to mysql db...\n")
-	conn := *user + ":" + *pass + "@/ccs"
-	db, err := sql.Open("mysql", conn)
-	if err != nil {
-		log.Fatalf("error opening db connection: %v\n", err)
+	var store Store
+	switch *sqlite {
+	case "":
+		log.Printf("connect to mysql db...\n")
+		store, err = openMySQL(*user, *pass, *dbname)
+	default:
+		log.Printf("open sqlite db [%s]...\n", *sqlite)
+		store, err = openSQLite(*sqlite)
 	}
-	page.db = db
-	defer db.Close()
-
-	log.Printf("ping mysql db...\n")
-	// Open doesn't open a connection. Validate DSN data:
-	err = db.Ping()
 	if err != nil {
-		log.Fatalf("error pinging db: %v\n", err)
+		log.Fatalf("error opening db: %v\n", err)
 	}
+	page.store = store
+	defer store.Close()
 
 	log.Printf("load data-desc table...\n")
-	descr, err := loadDataDesc(db)
+	descr, err := store.DataDesc()
 	if err != nil {
 		log.Fatalf("error loading rawdata descriptions: %v\n", err)
 	}
+	page.mu.Lock()
+	page.setDescr(descr)
+	cat := page.cat
+	page.mu.Unlock()
+
+	for _, g := range cat.Groups() {
+		log.Printf("subsystem %q: %d channels\n", g.Subsystem, len(g.Channels))
+	}
 
 	go func() {
 		go func() {
@@ -78,27 +107,23 @@ func main() {
 		}
 	}()
 
-	stmt, err := db.Prepare("select * from rawdata order by id and descr_id")
-	if err != nil {
-		log.Fatalf("error preparing stmt: %v\n", err)
+	if *verbose {
+		// dump the whole rawdata table.
+		err = dump(store, cat)
+		if err != nil {
+			log.Fatalf("error dumping rawdata: %v\n", err)
+		}
 	}
 
-	rows, err := stmt.Query()
+	err = <-errc
 	if err != nil {
-		log.Fatalf("error in query: %v\n", err)
+		log.Fatalf("error server: %v\n", err)
 	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var data RawData
-		err = rows.Scan(
-			&data.ID,
-			&data.Float64, &data.String, &data.TStamp,
-			&data.DescrID,
-		)
-		if err != nil {
-			log.Fatalf("error scanning: %v\n", err)
-		}
+}
+
+// dump prints the content of the rawdata table.
+func dump(store Store, cat *Catalog) error {
+	return store.Rows(0, time.Time{}, func(data RawData) error {
 		var v interface{}
 		if data.Float64.Valid {
 			v = data.Float64.Float64
@@ -106,58 +131,18 @@ func main() {
 		if data.String.Valid {
 			v = data.String.String
 		}
-		dataDesc := descr[data.DescrID]
 		name := "???"
-		if dataDesc.Name.Valid {
-			name = strings.Replace(dataDesc.Name.String, "testbenchLPC/", "", -1)
+		if ch, ok := cat.Channel(data.DescrID); ok {
+			name = ch.Name
 		}
 
-		if *verbose {
-			fmt.Printf(
-				"%d \"%v\" %-15s = %v\n",
-				data.ID,
-				data.TStamp.Time,
-				name,
-				v,
-			)
-		}
-	}
-
-	err = <-errc
-	if err != nil {
-		log.Fatalf("error server: %v\n", err)
-	}
-}
-
-func loadDataDesc(db *sql.DB) (map[int64]DataDesc, error) {
-	stmt, err := db.Prepare("select * from datadesc order by id")
-	if err != nil {
-		return nil, err
-	}
-
-	rows, err := stmt.Query()
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	descr := make(map[int64]DataDesc)
-	for rows.Next() {
-		var data DataDesc
-		err = rows.Scan(
-			&data.ID,
-			&data.Type,
-			&data.MaxSampling,
-			&data.Name,
-			&data.PDelay,
-			&data.SrcName,
-			&data.SrcSubSystem,
+		fmt.Printf(
+			"%d \"%v\" %-15s = %v\n",
+			data.ID,
+			data.TStamp.Time,
+			name,
+			v,
 		)
-		if err != nil {
-			return nil, err
-		}
-		descr[data.ID] = data
-	}
-
-	return descr, nil
+		return nil
+	})
 }
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"database/sql"
+	"math"
+	"time"
+)
+
+// millis returns the timestamp in milliseconds of t, as stored in the
+// database.
+func millis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// fromMillis returns the time of a timestamp in milliseconds.
+func fromMillis(ms int64) time.Time {
+	return time.Unix(0, ms*int64(time.Millisecond))
+}
+
+// Sample is a value of a channel.
+type Sample struct {
+	Time  time.Time
+	Value float64
+}
+
+// Raw calls fn with the numerical samples of the channel id within
+// [beg, end), sorted by time.
+func (st *sqlStore) Raw(id int64, beg, end time.Time, fn func(s Sample)) error {
+	rows, err := st.db.Query(
+		"select tstampmills, doubleData from rawdata where descr_id = ? and tstampmills >= ? and tstampmills < ? and doubleData is not null order by tstampmills",
+		id, millis(beg), millis(end),
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			ms int64
+			v  float64
+		)
+		err = rows.Scan(&ms, &v)
+		if err != nil {
+			return err
+		}
+		fn(Sample{Time: fromMillis(ms), Value: v})
+	}
+	return rows.Err()
+}
+
+// CountRaw returns the number of numerical samples of the channel id within
+// [beg, end).
+func (st *sqlStore) CountRaw(id int64, beg, end time.Time) (int64, error) {
+	var n int64
+	err := st.db.QueryRow(
+		"select count(*) from rawdata where descr_id = ? and tstampmills >= ? and tstampmills < ? and doubleData is not null",
+		id, millis(beg), millis(end),
+	).Scan(&n)
+	return n, err
+}
+
+// Bin summarizes the samples of a channel within [Start, End).
+// Min and Max are NaN when unknown.
+type Bin struct {
+	Start  time.Time
+	End    time.Time
+	N      int64
+	Mean   float64
+	Stddev float64
+	Min    float64
+	Max    float64
+}
+
+// downsample summarizes samples into at most n bins of equal width within
+// [beg, end). Empty bins are dropped.
+func downsample(samples []Sample, beg, end time.Time, n int) []Bin {
+	b := newBinner(beg, end, n)
+	for _, s := range samples {
+		b.add(s)
+	}
+	return b.result()
+}
+
+// binner summarizes samples, sorted by time, into bins of equal width.
+type binner struct {
+	beg   time.Time
+	end   time.Time
+	width time.Duration
+	bins  []Bin
+	sum   float64 // sum of the values of the last bin
+	sum2  float64 // sum of the squares of the values of the last bin
+}
+
+// newBinner returns a binner of n bins within [beg, end).
+func newBinner(beg, end time.Time, n int) *binner {
+	b := &binner{beg: beg, end: end}
+	if n <= 0 || !beg.Before(end) {
+		b.end = beg
+		return b
+	}
+	b.width = end.Sub(beg) / time.Duration(n)
+	if b.width <= 0 {
+		b.width = 1
+	}
+	return b
+}
+
+// add adds a sample to its bin. Samples out of the range of the bins are
+// ignored.
+func (b *binner) add(s Sample) {
+	if s.Time.Before(b.beg) || !s.Time.Before(b.end) {
+		return
+	}
+	start := b.beg.Add(s.Time.Sub(b.beg) / b.width * b.width)
+	if len(b.bins) == 0 || !b.bins[len(b.bins)-1].Start.Equal(start) {
+		b.flush()
+		b.bins = append(b.bins, Bin{
+			Start: start,
+			End:   start.Add(b.width),
+			Min:   s.Value,
+			Max:   s.Value,
+		})
+		b.sum, b.sum2 = 0, 0
+	}
+	bin := &b.bins[len(b.bins)-1]
+	bin.N++
+	b.sum += s.Value
+	b.sum2 += s.Value * s.Value
+	bin.Min = math.Min(bin.Min, s.Value)
+	bin.Max = math.Max(bin.Max, s.Value)
+}
+
+func (b *binner) flush() {
+	if len(b.bins) == 0 {
+		return
+	}
+	bin := &b.bins[len(b.bins)-1]
+	bin.Mean, bin.Stddev = moments(bin.N, b.sum, b.sum2)
+}
+
+// result returns the non-empty bins.
+func (b *binner) result() []Bin {
+	b.flush()
+	return b.bins
+}
+
+// moments returns the mean and standard deviation of n values, from their
+// sum and sum of squares.
+func moments(n int64, sum, sum2 float64) (mean, stddev float64) {
+	if n == 0 {
+		return math.NaN(), math.NaN()
+	}
+	mean = sum / float64(n)
+	return mean, math.Sqrt(math.Max(0, sum2/float64(n)-mean*mean))
+}
+
+// StatSeries holds the statdata bins of a channel, for one bin width.
+type StatSeries struct {
+	Width time.Duration
+	Bins  []Bin
+}
+
+// Stat returns the statdata bins of the channel id within [beg, end),
+// for each bin width (sorted in increasing order), or only for the bin width
+// width if not zero.
+//
+// The data and sum2 columns of statdata hold the sum of the values of a bin
+// and the sum of their squares.
+func (st *sqlStore) Stat(id int64, beg, end time.Time, width time.Duration) ([]StatSeries, error) {
+	query := "select d.timeBinWidth, s.tstampmills1, s.tstampmills2, s.n, s.data, s.sum2 from statdata s join statdesc d on s.descr_id = d.id where d.rawDescr_id = ? and s.tstampmills2 > ? and s.tstampmills1 < ?"
+	args := []interface{}{id, millis(beg), millis(end)}
+	if width > 0 {
+		query += " and d.timeBinWidth = ?"
+		args = append(args, int64(width/time.Millisecond))
+	}
+	query += " order by d.timeBinWidth, s.tstampmills1"
+
+	rows, err := st.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stats []StatSeries
+	for rows.Next() {
+		var (
+			w        Duration
+			ms1, ms2 int64
+			n        int64
+			sum      float64
+			sum2     float64
+		)
+		err = rows.Scan(&w, &ms1, &ms2, &n, &sum, &sum2)
+		if err != nil {
+			return nil, err
+		}
+		if len(stats) == 0 || stats[len(stats)-1].Width != w.Duration {
+			stats = append(stats, StatSeries{Width: w.Duration})
+		}
+		bin := Bin{
+			Start: fromMillis(ms1),
+			End:   fromMillis(ms2),
+			N:     n,
+			Min:   math.NaN(),
+			Max:   math.NaN(),
+		}
+		bin.Mean, bin.Stddev = moments(n, sum, sum2)
+		ss := &stats[len(stats)-1]
+		ss.Bins = append(ss.Bins, bin)
+	}
+	return stats, rows.Err()
+}
+
+// StatWidths returns the widths of the statdata bins of the channel id,
+// sorted in increasing order.
+func (st *sqlStore) StatWidths(id int64) ([]time.Duration, error) {
+	rows, err := st.db.Query(
+		"select timeBinWidth from statdesc where rawDescr_id = ? order by timeBinWidth",
+		id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var widths []time.Duration
+	for rows.Next() {
+		var w Duration
+		err = rows.Scan(&w)
+		if err != nil {
+			return nil, err
+		}
+		if w.Duration > 0 {
+			widths = append(widths, w.Duration)
+		}
+	}
+	return widths, rows.Err()
+}
+
+// MetaData returns the metadata overlapping [beg, end), attached to the
+// channel id, or to any channel if id is negative.
+// Metadata without stop time are still valid.
+func (st *sqlStore) MetaData(id int64, beg, end time.Time) ([]MetaData, error) {
+	query := "select id, name, tstartmillis, tstopmillis, value, rawDescr_id from metadata where tstartmillis < ? and (tstopmillis is null or tstopmillis = 0 or tstopmillis >= ?)"
+	args := []interface{}{millis(end), millis(beg)}
+	if id >= 0 {
+		query += " and rawDescr_id = ?"
+		args = append(args, id)
+	}
+	query += " order by tstartmillis"
+
+	rows, err := st.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var mds []MetaData
+	for rows.Next() {
+		var (
+			md       MetaData
+			ms1, ms2 sql.NullInt64
+		)
+		err = rows.Scan(&md.ID, &md.Name, &ms1, &ms2, &md.Value, &md.RawID)
+		if err != nil {
+			return nil, err
+		}
+		md.Start = Timestamp{Valid: ms1.Valid, Time: fromMillis(ms1.Int64)}
+		md.Stop = Timestamp{Valid: ms2.Valid && ms2.Int64 != 0, Time: fromMillis(ms2.Int64)}
+		mds = append(mds, md)
+	}
+	return mds, rows.Err()
+}
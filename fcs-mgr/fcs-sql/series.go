@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Resolutions of the series displayed by the web UI.
+const (
+	resRaw  = "raw"  // raw samples
+	resStat = "stat" // statdata bins
+	resBins = "bins" // raw samples downsampled into bins
+)
+
+// Band is the extent of the values of a bin of a series, drawn around its
+// mean value.
+type Band struct {
+	X  time.Time
+	Lo float64
+	Hi float64
+}
+
+func (b Band) MarshalJSON() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	err := json.NewEncoder(buf).Encode([3]interface{}{
+		millis(b.X), // flot's bottom value comes last.
+		b.Hi,
+		b.Lo,
+	})
+	return buf.Bytes(), err
+}
+
+// plotSeries is a series of a channel, at the resolution chosen for a time
+// range.
+type plotSeries struct {
+	Res    string `json:"res"`             // resolution: resRaw, resStat or resBins
+	Width  string `json:"width,omitempty"` // width of the bins
+	Points Points `json:"points"`          // values, or mean values of the bins
+	Bands  []Band `json:"bands,omitempty"` // min/max (bins) or mean±stddev (stat) of the bins
+}
+
+func rawSeries(pts Points) plotSeries {
+	if pts == nil {
+		pts = Points{}
+	}
+	return plotSeries{Res: resRaw, Points: pts}
+}
+
+// binSeries returns the series of the mean values of bins.
+// Empty bins, without mean value, are dropped.
+func binSeries(res string, width time.Duration, bins []Bin) plotSeries {
+	ps := plotSeries{
+		Res:    res,
+		Width:  width.String(),
+		Points: make(Points, 0, len(bins)),
+		Bands:  make([]Band, 0, len(bins)),
+	}
+	for _, bin := range bins {
+		if bin.N == 0 {
+			continue
+		}
+		x := bin.Start.Add(bin.End.Sub(bin.Start) / 2)
+		ps.Points = append(ps.Points, Point{X: x, Y: bin.Mean})
+		switch res {
+		case resStat:
+			ps.Bands = append(ps.Bands, Band{X: x, Lo: bin.Mean - bin.Stddev, Hi: bin.Mean + bin.Stddev})
+		default:
+			ps.Bands = append(ps.Bands, Band{X: x, Lo: bin.Min, Hi: bin.Max})
+		}
+	}
+	return ps
+}
+
+// resolve returns the series of the channel id within [beg, end), with about
+// n points at most:
+//   - the raw samples, if there are no more than n of them,
+//   - the statdata bins of the finest width giving no more than n bins,
+//   - the raw samples downsampled into n bins otherwise.
+func resolve(st Store, id int64, beg, end time.Time, n int) (plotSeries, error) {
+	var ps plotSeries
+	count, err := st.CountRaw(id, beg, end)
+	if err != nil {
+		return ps, err
+	}
+	if count <= int64(n) {
+		pts := make(Points, 0, count)
+		err = st.Raw(id, beg, end, func(s Sample) {
+			pts = append(pts, Point{X: s.Time, Y: s.Value})
+		})
+		return rawSeries(pts), err
+	}
+
+	widths, err := st.StatWidths(id)
+	if err != nil {
+		return ps, err
+	}
+	for _, width := range widths {
+		if end.Sub(beg)/width > time.Duration(n) {
+			continue
+		}
+		stats, err := st.Stat(id, beg, end, width)
+		if err != nil {
+			return ps, err
+		}
+		if len(stats) == 0 || len(stats[0].Bins) == 0 {
+			continue
+		}
+		return binSeries(resStat, width, stats[0].Bins), nil
+	}
+
+	b := newBinner(beg, end, n)
+	err = st.Raw(id, beg, end, b.add)
+	if err != nil {
+		return ps, err
+	}
+	return binSeries(resBins, b.width, b.result()), nil
+}
+
+// live returns the series of the samples of the live window, downsampled
+// into n bins if there are more than n samples.
+func live(pts Points, n int) plotSeries {
+	if len(pts) <= n || n <= 0 {
+		return rawSeries(pts)
+	}
+	beg := pts[0].X
+	end := pts[len(pts)-1].X.Add(time.Millisecond)
+	b := newBinner(beg, end, n)
+	for _, p := range pts {
+		b.add(Sample{Time: p.X, Value: p.Y})
+	}
+	return binSeries(resBins, b.width, b.result())
+}
+
+// plotHandler serves the series of channels for the plots of the web UI, at
+// the resolution chosen for the requested time range (see resolve):
+//
+//	/api/plot?ch=NAME&start=T&end=T[&n=N]
+//
+// The response maps channel names to their series.
+func plotHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	chans, err := channels(q, true)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	beg, end, err := timeRange(q, *window)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	n := *points
+	if s := q.Get("n"); s != "" {
+		n, err = strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			http.Error(w, fmt.Sprintf("invalid number of points %q", s), http.StatusBadRequest)
+			return
+		}
+		if n > maxPoints {
+			n = maxPoints
+		}
+	}
+
+	res := make(map[string]plotSeries, len(chans))
+	for _, ch := range chans {
+		res[ch.Name], err = resolve(page.store, ch.ID, beg, end, n)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(res)
+	if err != nil {
+		log.Printf("error writing plot data: %v\n", err)
+	}
+}
+
+// maxPoints is the maximum number of points of the series served to the
+// web UI.
+const maxPoints = 10000
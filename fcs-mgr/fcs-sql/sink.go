@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+)
+
+// Sink is a destination of the notifications of alarm events.
+type Sink interface {
+	Notify(evt Event) error
+}
+
+// SinkConfig describes a sink of alarm notifications.
+type SinkConfig struct {
+	Kind string   `json:"kind"` // "log", "webhook" or "email"
+	File string   `json:"file"` // log: path of the log file (default: standard error)
+	URL  string   `json:"url"`  // webhook: URL where events are POSTed as JSON
+	Addr string   `json:"addr"` // email: address of the SMTP server (default: localhost:25)
+	From string   `json:"from"` // email: sender address
+	To   []string `json:"to"`   // email: recipient addresses
+}
+
+func newSink(cfg SinkConfig) (Sink, error) {
+	switch cfg.Kind {
+	case "log":
+		w := os.Stderr
+		if cfg.File != "" {
+			f, err := os.OpenFile(cfg.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+			if err != nil {
+				return nil, err
+			}
+			w = f
+		}
+		return logSink{log.New(w, "fcs-sql: alarm: ", 0)}, nil
+	case "webhook":
+		if cfg.URL == "" {
+			return nil, fmt.Errorf("webhook sink: missing URL")
+		}
+		return webhookSink{url: cfg.URL, client: &http.Client{Timeout: 10 * time.Second}}, nil
+	case "email":
+		if cfg.From == "" || len(cfg.To) == 0 {
+			return nil, fmt.Errorf("email sink: missing sender or recipients")
+		}
+		addr := cfg.Addr
+		if addr == "" {
+			addr = "localhost:25"
+		}
+		return mailSink{addr: addr, from: cfg.From, to: cfg.To}, nil
+	default:
+		return nil, fmt.Errorf("invalid sink kind %q", cfg.Kind)
+	}
+}
+
+// logSink writes events as lines of text.
+type logSink struct {
+	log *log.Logger
+}
+
+func (s logSink) Notify(evt Event) error {
+	s.log.Println(evt)
+	return nil
+}
+
+// webhookSink POSTs events as JSON.
+type webhookSink struct {
+	url    string
+	client *http.Client
+}
+
+func (s webhookSink) Notify(evt Event) error {
+	buf := new(bytes.Buffer)
+	err := json.NewEncoder(buf).Encode(evt)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Post(s.url, "application/json", buf)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook %s: %s", s.url, resp.Status)
+	}
+	return nil
+}
+
+// mailSink sends events by email, through an SMTP server without
+// authentication (e.g. a local relay).
+type mailSink struct {
+	addr string
+	from string
+	to   []string
+}
+
+func (s mailSink) Notify(evt Event) error {
+	msg := new(bytes.Buffer)
+	fmt.Fprintf(msg, "From: %s\r\n", s.from)
+	fmt.Fprintf(msg, "To: %s\r\n", strings.Join(s.to, ", "))
+	fmt.Fprintf(msg, "Subject: [fcs-sql] alarm %s %s on %s\r\n", evt.Kind, evt.Alarm.Rule, evt.Alarm.Channel)
+	fmt.Fprintf(msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(msg, "\r\n%v\r\n", evt)
+	return smtp.SendMail(s.addr, nil, s.from, s.to, msg.Bytes())
+}
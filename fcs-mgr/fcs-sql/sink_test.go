@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testEvent() Event {
+	return Event{
+		Time: t0,
+		Kind: "raised",
+		Alarm: Alarm{
+			Rule: "temp", Channel: "bench/T", Active: true,
+			Since: t0, Value: 25, Msg: "value 25 out of [18, 24]",
+		},
+	}
+}
+
+func TestLogSink(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "alarms.log")
+	sink, err := newSink(SinkConfig{Kind: "log", File: fname})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sink.Notify(testEvent())
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := ioutil.ReadFile(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "fcs-sql: alarm: 2016-03-01T12:00:00.000Z [raised] temp on bench/T: value 25 out of [18, 24]\n"
+	if got := string(raw); got != want {
+		t.Fatalf("invalid log:\ngot= %q\nwant=%q", got, want)
+	}
+}
+
+func TestWebhookSink(t *testing.T) {
+	evts := make(chan Event, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/fail" {
+			var evt Event
+			err := json.NewDecoder(r.Body).Decode(&evt)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			evts <- evt
+			return
+		}
+		http.Error(w, "failure", http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	sink, err := newSink(SinkConfig{Kind: "webhook", URL: srv.URL + "/alarms"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sink.Notify(testEvent())
+	if err != nil {
+		t.Fatal(err)
+	}
+	evt := <-evts
+	if want := testEvent(); !evt.Time.Equal(want.Time) || evt.Kind != want.Kind || evt.Alarm.Msg != want.Alarm.Msg {
+		t.Fatalf("invalid event: got=%+v, want=%+v", evt, want)
+	}
+
+	sink, err = newSink(SinkConfig{Kind: "webhook", URL: srv.URL + "/fail"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sink.Notify(testEvent())
+	if err == nil || !strings.Contains(err.Error(), "500") {
+		t.Fatalf("expected an error, got %v", err)
+	}
+}
+
+// smtpServer is a minimal SMTP server, sending the messages it receives on
+// msgs.
+func smtpServer(t *testing.T, msgs chan string) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+		reply("220 localhost ESMTP")
+		var envelope []string
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimSpace(line)
+			cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+			switch cmd {
+			case "EHLO", "HELO":
+				reply("250 localhost")
+			case "MAIL", "RCPT":
+				envelope = append(envelope, line)
+				reply("250 OK")
+			case "DATA":
+				reply("354 end data with <CR><LF>.<CR><LF>")
+				var data []string
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if line == ".\r\n" {
+						break
+					}
+					data = append(data, line)
+				}
+				msgs <- strings.Join(envelope, "\n") + "\n" + strings.Join(data, "")
+				reply("250 OK")
+			case "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("502 unknown command")
+			}
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestMailSink(t *testing.T) {
+	msgs := make(chan string, 1)
+	addr := smtpServer(t, msgs)
+
+	sink, err := newSink(SinkConfig{
+		Kind: "email", Addr: addr,
+		From: "fcs-sql@localhost", To: []string{"bench@localhost", "shift@localhost"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sink.Notify(testEvent())
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := <-msgs
+	for _, want := range []string{
+		"MAIL FROM:<fcs-sql@localhost>",
+		"RCPT TO:<bench@localhost>",
+		"RCPT TO:<shift@localhost>",
+		"To: bench@localhost, shift@localhost\r\n",
+		"Subject: [fcs-sql] alarm raised temp on bench/T\r\n",
+		"\r\n2016-03-01T12:00:00.000Z [raised] temp on bench/T: value 25 out of [18, 24]\r\n",
+	} {
+		if !strings.Contains(msg, want) {
+			t.Fatalf("missing %q in message:\n%s", want, msg)
+		}
+	}
+}
+
+func TestNewSinkErrors(t *testing.T) {
+	for _, cfg := range []SinkConfig{
+		{Kind: "webhook"},
+		{Kind: "email", To: []string{"bench@localhost"}},
+		{Kind: "email", From: "fcs-sql@localhost"},
+		{Kind: "sms"},
+	} {
+		_, err := newSink(cfg)
+		if err == nil {
+			t.Errorf("%+v: expected an error", cfg)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Store is the storage of the trending database: the datadesc, rawdata,
+// statdesc/statdata and metadata tables written by the CCS, and the
+// annotations of fcs-sql.
+//
+// Times are stored as timestamps in milliseconds.
+type Store interface {
+	// DataDesc returns the descriptions of the channels, by id.
+	DataDesc() (map[int64]DataDesc, error)
+
+	// Rows calls fn with the rawdata rows with an id greater than last, and a
+	// time after since (if not zero), sorted by id.
+	Rows(last int64, since time.Time, fn func(data RawData) error) error
+
+	// LastTime returns the time of the last rawdata row, if any.
+	LastTime() (time.Time, bool, error)
+
+	// Raw calls fn with the numerical samples of the channel id within
+	// [beg, end), sorted by time.
+	Raw(id int64, beg, end time.Time, fn func(s Sample)) error
+
+	// CountRaw returns the number of numerical samples of the channel id
+	// within [beg, end).
+	CountRaw(id int64, beg, end time.Time) (int64, error)
+
+	// StatWidths returns the widths of the statdata bins of the channel id,
+	// sorted in increasing order.
+	StatWidths(id int64) ([]time.Duration, error)
+
+	// Stat returns the statdata bins of the channel id within [beg, end),
+	// for each bin width (sorted in increasing order), or only for the bin
+	// width width if not zero.
+	Stat(id int64, beg, end time.Time, width time.Duration) ([]StatSeries, error)
+
+	// MetaData returns the metadata overlapping [beg, end), attached to the
+	// channel id, or to any channel if id is negative.
+	MetaData(id int64, beg, end time.Time) ([]MetaData, error)
+
+	// Annotations returns the annotations overlapping [beg, end).
+	Annotations(beg, end time.Time) ([]Annotation, error)
+
+	// Annotate stores an annotation and returns its id.
+	// Annotate returns errNoAnnotations if the database can not hold
+	// annotations (e.g. when it is read-only.)
+	Annotate(a Annotation) (int64, error)
+
+	Close() error
+}
+
+// dialect describes the differences between the SQL databases holding the
+// trending database.
+type dialect struct {
+	name        string
+	driver      string
+	annotations string // statement creating the annotation table
+	hasTable    string // query counting the tables named after its argument
+}
+
+var (
+	mysqlDialect = dialect{
+		name:   "mysql",
+		driver: "mysql",
+		annotations: `create table if not exists fcs_annotation (
+	id bigint not null auto_increment,
+	tstartmillis bigint not null,
+	tstopmillis bigint not null,
+	test varchar(255),
+	operator varchar(255),
+	filterID varchar(255),
+	comment text,
+	primary key (id)
+)`,
+		hasTable: "select count(*) from information_schema.tables where table_schema = database() and table_name = ?",
+	}
+
+	sqliteDialect = dialect{
+		name:   "sqlite",
+		driver: "sqlite3",
+		annotations: `create table if not exists fcs_annotation (
+	id integer primary key autoincrement,
+	tstartmillis bigint not null,
+	tstopmillis bigint not null,
+	test varchar(255),
+	operator varchar(255),
+	filterID varchar(255),
+	comment text
+)`,
+		hasTable: "select count(*) from sqlite_master where type = 'table' and name = ?",
+	}
+)
+
+// sqlStore is a Store in an SQL database.
+// The queries are common to all the dialects.
+//
+// The database is only read, except for the annotations: their table is
+// created with the first annotation, so read-only databases (e.g. exported
+// snapshots, or a read-only account) can still be browsed.
+type sqlStore struct {
+	db      *sql.DB
+	dialect dialect
+
+	annMu sync.Mutex
+	annOK bool // whether the annotation table exists
+}
+
+// openMySQL opens the trending database dbname on the local MySQL server.
+func openMySQL(user, pass, dbname string) (Store, error) {
+	return openSQL(mysqlDialect, user+":"+pass+"@/"+dbname)
+}
+
+// openSQLite opens a trending database stored in an SQLite file, e.g. a
+// snapshot exported from the MySQL database.
+func openSQLite(fname string) (Store, error) {
+	// do not create an empty database on a typo.
+	_, err := os.Stat(fname)
+	if err != nil {
+		return nil, err
+	}
+	return openSQL(sqliteDialect, fname)
+}
+
+func openSQL(d dialect, dsn string) (Store, error) {
+	db, err := sql.Open(d.driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	// Open doesn't open a connection. Validate DSN data:
+	err = db.Ping()
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error pinging %s db: %v", d.name, err)
+	}
+	return &sqlStore{db: db, dialect: d}, nil
+}
+
+// hasTable returns whether the table name exists.
+func (st *sqlStore) hasTable(name string) (bool, error) {
+	var n int
+	err := st.db.QueryRow(st.dialect.hasTable, name).Scan(&n)
+	return n > 0, err
+}
+
+func (st *sqlStore) Close() error {
+	return st.db.Close()
+}
+
+func (st *sqlStore) DataDesc() (map[int64]DataDesc, error) {
+	rows, err := st.db.Query("select id, dataType, maxSamplingMillis, name, preservationDelay, srcName, srcSubsystem from datadesc order by id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	descr := make(map[int64]DataDesc)
+	for rows.Next() {
+		var data DataDesc
+		err = rows.Scan(
+			&data.ID,
+			&data.Type,
+			&data.MaxSampling,
+			&data.Name,
+			&data.PDelay,
+			&data.SrcName,
+			&data.SrcSubSystem,
+		)
+		if err != nil {
+			return nil, err
+		}
+		descr[data.ID] = data
+	}
+	return descr, rows.Err()
+}
+
+func (st *sqlStore) Rows(last int64, since time.Time, fn func(data RawData) error) error {
+	query := "select id, doubleData, stringData, tstampmills, descr_id from rawdata where id > ?"
+	args := []interface{}{last}
+	if !since.IsZero() {
+		query += " and tstampmills >= ?"
+		args = append(args, millis(since))
+	}
+	query += " order by id"
+
+	rows, err := st.db.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var data RawData
+		err = rows.Scan(
+			&data.ID,
+			&data.Float64, &data.String, &data.TStamp,
+			&data.DescrID,
+		)
+		if err != nil {
+			return err
+		}
+		err = fn(data)
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (st *sqlStore) LastTime() (time.Time, bool, error) {
+	var last sql.NullInt64
+	err := st.db.QueryRow("select max(tstampmills) from rawdata").Scan(&last)
+	if err != nil || !last.Valid {
+		return time.Time{}, false, err
+	}
+	return fromMillis(last.Int64), true, nil
+}
@@ -0,0 +1,427 @@
+package main
+
+import (
+	"database/sql"
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testSchema creates the tables of the trending database, as written by the
+// CCS.
+var testSchema = []string{
+	"create table datadesc (id integer primary key autoincrement, dataType varchar(1), maxSamplingMillis int default 0, name varchar(255), preservationDelay int default 0, srcName varchar(255), srcSubsystem varchar(255))",
+	"create table rawdata (id integer primary key autoincrement, doubleData double, stringData varchar(255), tstampmills bigint, descr_id bigint)",
+	"create table statdesc (id integer primary key autoincrement, preservationDelay int default 0, timeBinWidth bigint not null, rawDescr_id bigint)",
+	"create table statdata (id integer primary key autoincrement, data double not null, n int not null, sum2 double not null, tstampmills1 bigint, tstampmills2 bigint, descr_id bigint)",
+	"create table metadata (id integer primary key autoincrement, name varchar(255), tstartmillis bigint, tstopmillis bigint, value varchar(255), rawDescr_id bigint)",
+}
+
+// newTestDB creates an SQLite trending database in a temporary file, and
+// returns its path. It holds the channels:
+//   - bench/T (id 1): 60 samples, every 10s from t0, with value i for the
+//     i-th sample, and statdata bins of 1m and 5m,
+//   - bench/P (id 2): 3 samples, every minute from t0, and a string sample.
+func newTestDB(t *testing.T) string {
+	t.Helper()
+	fname := filepath.Join(t.TempDir(), "ccs.sqlite")
+	db, err := sql.Open("sqlite3", fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	exec := func(query string, args ...interface{}) {
+		t.Helper()
+		_, err := db.Exec(query, args...)
+		if err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+	}
+	for _, q := range testSchema {
+		exec(q)
+	}
+	exec("insert into datadesc (dataType, name, srcName, srcSubsystem) values ('D', 'bench/T', 'bench', 'bench'), ('D', 'bench/P', 'bench', 'bench')")
+
+	ms := func(dt time.Duration) int64 { return millis(t0.Add(dt)) }
+	for i := 0; i < 60; i++ {
+		exec("insert into rawdata (doubleData, tstampmills, descr_id) values (?, ?, 1)", float64(i), ms(time.Duration(i)*10*time.Second))
+	}
+	for i := 0; i < 3; i++ {
+		exec("insert into rawdata (doubleData, tstampmills, descr_id) values (?, ?, 2)", 1013+float64(i), ms(time.Duration(i)*time.Minute))
+	}
+	exec("insert into rawdata (stringData, tstampmills, descr_id) values ('OFF', ?, 2)", ms(30*time.Second))
+
+	// statdata of bench/T, from its samples.
+	exec("insert into statdesc (timeBinWidth, rawDescr_id) values (60000, 1), (300000, 1)")
+	for _, sd := range []struct {
+		descr int64
+		width time.Duration
+	}{{1, time.Minute}, {2, 5 * time.Minute}} {
+		n := int(sd.width / (10 * time.Second))
+		for beg := 0; beg < 60; beg += n {
+			var sum, sum2 float64
+			for i := beg; i < beg+n; i++ {
+				sum += float64(i)
+				sum2 += float64(i * i)
+			}
+			dt := time.Duration(beg) * 10 * time.Second
+			exec("insert into statdata (data, n, sum2, tstampmills1, tstampmills2, descr_id) values (?, ?, ?, ?, ?, ?)",
+				sum, n, sum2, ms(dt), ms(dt+sd.width), sd.descr,
+			)
+		}
+	}
+
+	exec("insert into metadata (name, tstartmillis, tstopmillis, value, rawDescr_id) values ('range', ?, null, '18:24', 1)", ms(-time.Hour))
+	exec("insert into metadata (name, tstartmillis, tstopmillis, value, rawDescr_id) values ('gain', ?, ?, '2', 2)", ms(0), ms(2*time.Minute))
+	exec("insert into metadata (name, tstartmillis, tstopmillis, value, rawDescr_id) values ('test', ?, 0, 'run-1', null)", ms(5*time.Minute))
+	return fname
+}
+
+func openTestStore(t *testing.T) Store {
+	t.Helper()
+	st, err := openSQLite(newTestDB(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { st.Close() })
+	return st
+}
+
+func TestOpenSQLite(t *testing.T) {
+	_, err := openSQLite(filepath.Join(t.TempDir(), "missing.sqlite"))
+	if err == nil {
+		t.Fatalf("expected an error opening a missing file")
+	}
+
+	st := openTestStore(t)
+	descr, err := st.DataDesc()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(descr) != 2 || descr[1].Name.String != "bench/T" || descr[2].Type.String != "D" {
+		t.Fatalf("invalid datadesc: %+v", descr)
+	}
+
+	last, ok, err := st.LastTime()
+	if err != nil || !ok || !last.Equal(t0.Add(590*time.Second)) {
+		t.Fatalf("invalid last time: %v %v %v", last, ok, err)
+	}
+
+	var ids []int64
+	err = st.Rows(60, t0.Add(time.Minute), func(data RawData) error {
+		ids = append(ids, data.ID)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 2 || ids[0] != 62 || ids[1] != 63 {
+		t.Fatalf("invalid rows: %v", ids)
+	}
+}
+
+func TestStoreRaw(t *testing.T) {
+	st := openTestStore(t)
+	for _, tc := range []struct {
+		id       int64
+		beg, end time.Duration
+		want     []float64
+	}{
+		{1, 0, 30 * time.Second, []float64{0, 1, 2}},
+		{1, 15 * time.Second, 40 * time.Second, []float64{2, 3}},
+		{1, -time.Hour, 0, nil},
+		{2, 0, time.Hour, []float64{1013, 1014, 1015}}, // no string samples
+		{3, 0, time.Hour, nil},
+	} {
+		beg, end := t0.Add(tc.beg), t0.Add(tc.end)
+		samples, err := queryRaw(st, tc.id, beg, end)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []float64
+		for _, s := range samples {
+			got = append(got, s.Value)
+		}
+		if len(got) != len(tc.want) {
+			t.Fatalf("channel %d [%v, %v): got=%v, want=%v", tc.id, tc.beg, tc.end, got, tc.want)
+		}
+		for i := range got {
+			if got[i] != tc.want[i] {
+				t.Fatalf("channel %d [%v, %v): got=%v, want=%v", tc.id, tc.beg, tc.end, got, tc.want)
+			}
+		}
+		if tc.id == 1 {
+			// the i-th sample is at t0+10i s.
+			for _, s := range samples {
+				if want := t0.Add(time.Duration(s.Value) * 10 * time.Second); !s.Time.Equal(want) {
+					t.Fatalf("channel %d: invalid time: got=%v, want=%v", tc.id, s.Time, want)
+				}
+			}
+		}
+
+		n, err := st.CountRaw(tc.id, beg, end)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != int64(len(tc.want)) {
+			t.Fatalf("channel %d [%v, %v): invalid count: got=%d, want=%d", tc.id, tc.beg, tc.end, n, len(tc.want))
+		}
+	}
+}
+
+func TestStoreStat(t *testing.T) {
+	st := openTestStore(t)
+
+	widths, err := st.StatWidths(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(widths) != 2 || widths[0] != time.Minute || widths[1] != 5*time.Minute {
+		t.Fatalf("invalid widths: %v", widths)
+	}
+	widths, err = st.StatWidths(2)
+	if err != nil || len(widths) != 0 {
+		t.Fatalf("invalid widths: %v %v", widths, err)
+	}
+
+	// bins overlapping [1m30s, 3m).
+	stats, err := st.Stat(1, t0.Add(90*time.Second), t0.Add(3*time.Minute), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 2 || stats[0].Width != time.Minute || stats[1].Width != 5*time.Minute {
+		t.Fatalf("invalid stat series: %+v", stats)
+	}
+	if len(stats[0].Bins) != 2 || len(stats[1].Bins) != 1 {
+		t.Fatalf("invalid number of bins: %d, %d", len(stats[0].Bins), len(stats[1].Bins))
+	}
+	bin := stats[0].Bins[0]
+	if !bin.Start.Equal(t0.Add(time.Minute)) || !bin.End.Equal(t0.Add(2*time.Minute)) || bin.N != 6 {
+		t.Fatalf("invalid bin: %+v", bin)
+	}
+	// values 6..11.
+	if bin.Mean != 8.5 || math.Abs(bin.Stddev-math.Sqrt(35.0/12)) > 1e-9 {
+		t.Fatalf("invalid moments: mean=%v, stddev=%v", bin.Mean, bin.Stddev)
+	}
+	if !math.IsNaN(bin.Min) || !math.IsNaN(bin.Max) {
+		t.Fatalf("invalid extrema: min=%v, max=%v", bin.Min, bin.Max)
+	}
+
+	stats, err = st.Stat(1, t0, t0.Add(time.Hour), 5*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 1 || len(stats[0].Bins) != 2 || stats[0].Bins[1].Mean != 44.5 {
+		t.Fatalf("invalid stat series: %+v", stats)
+	}
+}
+
+func TestStoreMetaData(t *testing.T) {
+	st := openTestStore(t)
+	for _, tc := range []struct {
+		id       int64
+		beg, end time.Duration
+		want     []string
+	}{
+		{-1, 0, time.Minute, []string{"range", "gain"}},
+		{-1, 3 * time.Minute, time.Hour, []string{"range", "test"}},
+		{1, 0, time.Hour, []string{"range"}},
+		{2, 0, time.Hour, []string{"gain"}},
+		{2, 2 * time.Minute, time.Hour, []string{"gain"}},
+		{2, 3 * time.Minute, time.Hour, nil},
+		{-1, -2 * time.Hour, -time.Hour, nil},
+	} {
+		mds, err := st.MetaData(tc.id, t0.Add(tc.beg), t0.Add(tc.end))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, md := range mds {
+			got = append(got, md.Name.String)
+		}
+		if len(got) != len(tc.want) {
+			t.Fatalf("channel %d [%v, %v): got=%q, want=%q", tc.id, tc.beg, tc.end, got, tc.want)
+		}
+		for i := range got {
+			if got[i] != tc.want[i] {
+				t.Fatalf("channel %d [%v, %v): got=%q, want=%q", tc.id, tc.beg, tc.end, got, tc.want)
+			}
+		}
+		for _, md := range mds {
+			switch md.Name.String {
+			case "range", "test":
+				if md.Stop.Valid {
+					t.Fatalf("metadata %q: unexpected stop time %v", md.Name.String, md.Stop.Time)
+				}
+			case "gain":
+				if !md.Stop.Valid || !md.Stop.Time.Equal(t0.Add(2*time.Minute)) || md.Value.String != "2" || md.RawID.Int64 != 2 {
+					t.Fatalf("invalid metadata: %+v", md)
+				}
+			}
+		}
+	}
+}
+
+func TestStoreAnnotations(t *testing.T) {
+	fname := newTestDB(t)
+	st, err := openSQLite(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+
+	// opening the database does not create the annotation table.
+	ok, err := st.(*sqlStore).hasTable("fcs_annotation")
+	if err != nil || ok {
+		t.Fatalf("annotation table created on open (err=%v)", err)
+	}
+	anns, err := st.Annotations(t0, t0.Add(time.Hour))
+	if err != nil || len(anns) != 0 {
+		t.Fatalf("invalid annotations: %v %v", anns, err)
+	}
+
+	for i, a := range []Annotation{
+		{Start: t0, Stop: t0.Add(10 * time.Minute), Test: "run-1", Operator: "op", Filter: "u", Comment: "first"},
+		{Start: t0.Add(20 * time.Minute), Stop: t0.Add(30 * time.Minute), Operator: "op"},
+	} {
+		id, err := st.Annotate(a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id != int64(i+1) {
+			t.Fatalf("invalid annotation id: got=%d, want=%d", id, i+1)
+		}
+	}
+
+	for _, tc := range []struct {
+		beg, end time.Duration
+		want     []int64
+	}{
+		{0, time.Hour, []int64{1, 2}},
+		{5 * time.Minute, 15 * time.Minute, []int64{1}},
+		{10 * time.Minute, 20 * time.Minute, []int64{1}},
+		{11 * time.Minute, 20 * time.Minute, nil},
+		{25 * time.Minute, time.Hour, []int64{2}},
+	} {
+		anns, err := st.Annotations(t0.Add(tc.beg), t0.Add(tc.end))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []int64
+		for _, a := range anns {
+			got = append(got, a.ID)
+		}
+		if len(got) != len(tc.want) || (len(got) > 0 && got[0] != tc.want[0]) {
+			t.Fatalf("[%v, %v): got=%v, want=%v", tc.beg, tc.end, got, tc.want)
+		}
+	}
+
+	anns, err = st.Annotations(t0, t0.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a := anns[0]; !a.Start.Equal(t0) || !a.Stop.Equal(t0.Add(10*time.Minute)) ||
+		a.Test != "run-1" || a.Operator != "op" || a.Filter != "u" || a.Comment != "first" {
+		t.Fatalf("invalid annotation: %+v", a)
+	}
+}
+
+func TestStoreReadOnly(t *testing.T) {
+	fname := newTestDB(t)
+	st, err := openSQL(sqliteDialect, "file:"+fname+"?mode=ro")
+	if err != nil {
+		t.Fatalf("could not open read-only database: %v", err)
+	}
+	defer st.Close()
+
+	descr, err := st.DataDesc()
+	if err != nil || len(descr) != 2 {
+		t.Fatalf("invalid datadesc: %v %v", descr, err)
+	}
+	anns, err := st.Annotations(t0, t0.Add(time.Hour))
+	if err != nil || len(anns) != 0 {
+		t.Fatalf("invalid annotations: %v %v", anns, err)
+	}
+	_, err = st.Annotate(Annotation{Start: t0, Stop: t0, Operator: "op"})
+	if err != errNoAnnotations {
+		t.Fatalf("invalid error: got=%v, want=%v", err, errNoAnnotations)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	st := openTestStore(t)
+	for _, tc := range []struct {
+		id    int64
+		n     int
+		res   string
+		width time.Duration
+		npts  int
+	}{
+		{1, 100, resRaw, 0, 60},
+		{1, 60, resRaw, 0, 60},
+		{1, 20, resStat, time.Minute, 10},
+		{1, 5, resStat, 5 * time.Minute, 2},
+		{1, 1, resBins, 10 * time.Minute, 1},
+		{2, 2, resBins, 5 * time.Minute, 1},
+		{3, 10, resRaw, 0, 0},
+	} {
+		ps, err := resolve(st, tc.id, t0, t0.Add(10*time.Minute), tc.n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ps.Res != tc.res || len(ps.Points) != tc.npts {
+			t.Fatalf("channel %d, n=%d: got %s series of %d points, want %s series of %d points",
+				tc.id, tc.n, ps.Res, len(ps.Points), tc.res, tc.npts,
+			)
+		}
+		if tc.res != resRaw && ps.Width != tc.width.String() {
+			t.Fatalf("channel %d, n=%d: invalid width: got=%s, want=%v", tc.id, tc.n, ps.Width, tc.width)
+		}
+		if ps.Points == nil {
+			t.Fatalf("channel %d, n=%d: nil points", tc.id, tc.n)
+		}
+	}
+}
+
+// queryRaw returns the numerical samples of the channel id within [beg, end).
+func queryRaw(st Store, id int64, beg, end time.Time) ([]Sample, error) {
+	var samples []Sample
+	err := st.Raw(id, beg, end, func(s Sample) {
+		samples = append(samples, s)
+	})
+	return samples, err
+}
+
+func TestDownsample(t *testing.T) {
+	var samples []Sample
+	for i := 0; i < 10; i++ {
+		samples = append(samples, Sample{Time: t0.Add(time.Duration(i) * time.Second), Value: float64(i)})
+	}
+	// samples out of range are ignored.
+	samples = append(samples, Sample{Time: t0.Add(time.Minute), Value: 100})
+
+	bins := downsample(samples, t0, t0.Add(20*time.Second), 4)
+	if len(bins) != 2 {
+		t.Fatalf("invalid number of bins: got=%d, want=2 (empty bins dropped)", len(bins))
+	}
+	for i, want := range []Bin{
+		{Start: t0, End: t0.Add(5 * time.Second), N: 5, Mean: 2, Stddev: math.Sqrt2, Min: 0, Max: 4},
+		{Start: t0.Add(5 * time.Second), End: t0.Add(10 * time.Second), N: 5, Mean: 7, Stddev: math.Sqrt2, Min: 5, Max: 9},
+	} {
+		got := bins[i]
+		if !got.Start.Equal(want.Start) || !got.End.Equal(want.End) || got.N != want.N ||
+			got.Mean != want.Mean || math.Abs(got.Stddev-want.Stddev) > 1e-9 ||
+			got.Min != want.Min || got.Max != want.Max {
+			t.Fatalf("bin #%d: got=%+v, want=%+v", i, got, want)
+		}
+	}
+
+	if bins := downsample(samples, t0, t0, 4); len(bins) != 0 {
+		t.Fatalf("invalid bins of an empty range: %+v", bins)
+	}
+	if bins := downsample(samples, t0, t0.Add(time.Second), 0); len(bins) != 0 {
+		t.Fatalf("invalid bins without bins: %+v", bins)
+	}
+}
@@ -13,23 +13,131 @@ const (
 <script type="text/javascript">
 	var sock = null;
 	var wsuri = "{{.URI}}";
-	var data = [
-	        { label: "temperature", 
-			  data: [],
-			  yaxis: 1,
-			  color: 1
-		    },
-	        { label: "pressure", 
-			  data: [],
-			  yaxis: 2,
-			  color: "black"
-		    },
-	        { label: "hygrometry", 
-			  data: [],
-			  yaxis: 3,
-			  color: 2
-		    },
-	];
+	var series = {{.Series}};
+	var npoints = {{.Points}};
+	var timeRange = {{.Range}}; // null for the live view
+	var span = {{.Window.Seconds}} * 1000; // time span of the live view, in milliseconds
+	var lastID = 0; // last rawdata id received
+
+	var data = [];     // data of the whole time range
+	var zoomed = null; // data of the zoomed time range, if any
+	var last = {};     // last series received for the whole time range
+
+	// toData converts the series sent by the server into data of the plots.
+	// Bins are drawn as their mean value, within a band showing their extent.
+	function toData(obj) {
+		var d = [];
+		$.each(series, function(i, s) {
+			var ps = obj[s.name] || { res: "raw", points: [] };
+			d.push({ label: s.label, data: ps.points, yaxis: s.yaxis, color: i });
+			if (ps.bands) {
+				d.push({
+					data: ps.bands,
+					yaxis: s.yaxis,
+					color: i,
+					lines: { show: true, lineWidth: 0, fill: 0.3 },
+					shadowSize: 0
+				});
+			}
+		});
+		return d;
+	};
+
+	// describe displays the resolution of the series.
+	function describe(obj) {
+		var txt = "";
+		$.each(series, function(i, s) {
+			var ps = obj[s.name];
+			if (!ps) {
+				return;
+			}
+			txt += s.label + ": " + ps.points.length + " points";
+			switch (ps.res) {
+			case "stat":
+				txt += " (statdata bins of " + ps.width + ": mean, mean ± stddev)";
+				break;
+			case "bins":
+				txt += " (bins of " + ps.width + ": mean, min/max)";
+				break;
+			}
+			txt += "\n";
+		});
+		$("#resolution").text(txt);
+	};
+
+	// fetch fetches the series of the time range [start, end), in milliseconds.
+	function fetch(start, end, done) {
+		var names = $.map(series, function(s) { return s.name; });
+		var q = $.param({
+			ch: names,
+			start: Math.floor(start),
+			end: Math.ceil(end),
+			n: npoints
+		}, true);
+		$.getJSON("/api/plot?" + q, done).fail(function(xhr) {
+			console.log("error fetching data: " + xhr.responseText);
+		});
+	};
+
+	var marks = [];    // shaded time ranges of metadata and annotations
+	var marksTime = 0; // time of the last loading of the marks
+
+	// load_marks loads the metadata of the displayed channels and the
+	// annotations within [start, end), in milliseconds, to shade them on the
+	// plots. Both are requested independently: one of them failing does not
+	// hide the other.
+	function load_marks(start, end) {
+		marksTime = Date.now();
+		var names = $.map(series, function(s) { return s.name; });
+		var q = { start: Math.floor(start), end: Math.ceil(end) };
+		var mdMarks = [], mdTxt = "";
+		var annMarks = [], annTxt = "";
+
+		// settle returns a promise resolved when req completes, whether it
+		// succeeds or not ($.when fails as soon as one request fails.)
+		function settle(req, what) {
+			var d = $.Deferred();
+			req.fail(function(xhr) {
+				console.log("error fetching " + what + ": " + xhr.responseText);
+			}).always(function() { d.resolve(); });
+			return d.promise();
+		};
+
+		var mdReq = $.getJSON("/api/metadata?" + $.param($.extend({ ch: names }, q), true), function(mds) {
+			$.each(mds, function(_, md) {
+				var stop = md.stop ? Date.parse(md.stop) : end;
+				mdMarks.push({ xaxis: { from: Date.parse(md.start), to: stop }, color: "rgba(255, 190, 0, 0.15)" });
+				mdTxt += "[metadata] " + md.start + " - " + (md.stop || "...") + " " + md.channel + ": " + md.name + "=" + md.value + "\n";
+			});
+		});
+		var annReq = $.getJSON("/api/annotations?" + $.param(q), function(anns) {
+			$.each(anns, function(_, a) {
+				annMarks.push({ xaxis: { from: Date.parse(a.start), to: Date.parse(a.stop) }, color: "rgba(0, 120, 255, 0.15)" });
+				annTxt += "[annotation] " + a.start + " - " + a.stop + " test=" + a.test + " operator=" + a.operator + " filter=" + a.filter + ": " + a.comment + "\n";
+			});
+		});
+
+		$.when(settle(mdReq, "metadata"), settle(annReq, "annotations")).always(function() {
+			marks = mdMarks.concat(annMarks);
+			$("#intervals").text(mdTxt + annTxt);
+			update_display();
+		});
+	};
+
+	// displayed returns the displayed time range of the plots.
+	function displayed() {
+		if (plotRange.min < plotRange.max) {
+			return { from: plotRange.min, to: plotRange.max };
+		}
+		if (timeRange) {
+			return { from: timeRange.start, to: timeRange.end };
+		}
+		if (overview) {
+			var axis = overview.getAxes().xaxis;
+			return { from: axis.min, to: axis.max };
+		}
+		return null;
+	};
 
 	var options = {
 		legend: {
@@ -42,9 +150,7 @@ const (
 			timezone: "browser",
 			timeformat: "%Y/%m/%d\n%H:%M:%S"
 		},
-		yaxis: {
-			position: "right",
-		},
+		yaxes: {{.Axes}},
 		selection: {
 			mode: "x"
 		},
@@ -63,9 +169,7 @@ const (
 			ticks: [],
 			mode: "time"
 		},
-		yaxis: {
-			position: "right",
-		},
+		yaxes: {{.Axes}},
 		/*
 		yaxis: {
 			ticks: [],
@@ -87,12 +191,20 @@ const (
 	var overview = null;
 
 	function update_display() {
-		plot = $.plot("#placeholder", data, options);
+		options.grid = { markings: marks };
+		overviewOpts.grid = { markings: marks };
+		plot = $.plot("#placeholder", zoomed || data, options);
 		overview = $.plot("#overview", data, overviewOpts);
-		// now connect the two
-		$("#placeholder").bind("plotselected", function (event, ranges) {
-			plotRange.min = ranges.xaxis.from;
-			plotRange.max = ranges.xaxis.to;
+		if (plotRange.min < plotRange.max) {
+			console.log("==> zoom... ["+plotRange.min+", "+plotRange.max+"]");
+
+			var ranges = {
+				xaxis: {
+					from: plotRange.min,
+					to: plotRange.max
+				}
+			};
+
 			// do the zooming
 			$.each(plot.getXAxes(), function(_, axis) {
 				var opts = axis.options;
@@ -101,63 +213,188 @@ const (
 			});
 			plot.setupGrid();
 			plot.draw();
-			plot.clearSelection();
+
 			// don't fire event on the overview to prevent eternal loop
 			overview.setSelection(ranges, true);
+		}
+	};
+
+	// zoom displays the selected time range, with data fetched at the
+	// resolution of the range.
+	function zoom(ranges) {
+		plotRange.min = ranges.xaxis.from;
+		plotRange.max = ranges.xaxis.to;
+		$("#annotate input[name=start]").val(new Date(plotRange.min).toISOString());
+		$("#annotate input[name=end]").val(new Date(plotRange.max).toISOString());
+		fetch(plotRange.min, plotRange.max, function(obj) {
+			zoomed = toData(obj);
+			describe(obj);
+			update_display();
+			load_marks(plotRange.min, plotRange.max);
 		});
+	};
 
-		$("#overview").bind("plotselected", function (event, ranges) {
-			plotRange.min = ranges.xaxis.from;
-			plotRange.max = ranges.xaxis.to;
-			plot.setSelection(ranges);
+	function unzoom() {
+		plotRange.min = 0;
+		plotRange.max = 0;
+		zoomed = null;
+		describe(last);
+		update_display();
+	};
+
+	// append appends the new points of an update to the live view, and drops
+	// the points older than its time span.
+	function append(obj) {
+		$.each(obj, function(name, pts) {
+			var ps = last[name];
+			if (!ps || pts.length == 0) {
+				return;
+			}
+			ps.points = ps.points.concat(pts);
+			var beg = pts[pts.length-1][0] - span;
+			while (ps.points.length > 0 && ps.points[0][0] < beg) {
+				ps.points.shift();
+			}
+			while (ps.bands && ps.bands.length > 0 && ps.bands[0][0] < beg) {
+				ps.bands.shift();
+			}
 		});
-		if (plotRange.min < plotRange.max) {
-			console.log("==> zoom... ["+plotRange.min+", "+plotRange.max+"]");
-			
-			var ranges = {
-				xaxis: {
-					from: plotRange.min,
-					to: plotRange.max
+		update(last);
+	};
+
+	// connect subscribes to the live updates of the series.
+	function connect() {
+		var names = $.map(series, function(s) { return s.name; });
+		var uri = wsuri + "?" + $.param({ ch: names }, true);
+
+		sock = new WebSocket(uri);
+
+		sock.onopen = function() {
+			console.log("connected to " + uri);
+		}
+
+		sock.onclose = function(e) {
+			console.log("connection closed (" + e.code + "), reconnecting...");
+			setTimeout(connect, 5000);
+		}
+
+		sock.onmessage = function(e) {
+			var msg = JSON.parse(e.data);
+			switch (msg.type) {
+			case "snapshot":
+				update(msg.series);
+				break;
+			case "update":
+				if (msg.id <= lastID) {
+					return;
 				}
-			};
-			
-			// do the zooming
-			$.each(plot.getXAxes(), function(_, axis) {
-				var opts = axis.options;
-				opts.min = plotRange.min;
-				opts.max = plotRange.max;
+				append(msg.series);
+				break;
+			}
+			lastID = msg.id;
+		}
+	};
+
+	// update displays the series of the whole time range.
+	function update(obj) {
+		last = obj;
+		data = toData(obj);
+		if (!zoomed) {
+			describe(obj);
+		}
+		update_display();
+		if (Date.now() - marksTime > 30000) {
+			var r = displayed();
+			if (r) {
+				load_marks(r.from, r.to);
+			}
+		}
+	};
+
+	// annotate stores the annotation of the form.
+	function annotate(event) {
+		event.preventDefault();
+		$.post("/api/annotations", $("#annotate").serialize(), function() {
+			$("#annotate input[name=comment]").val("");
+			var r = displayed();
+			if (r) {
+				load_marks(r.from, r.to);
+			}
+		}).fail(function(xhr) {
+			alert("error storing annotation: " + xhr.responseText);
+		});
+	};
+
+	// show_alarms displays the active or unacknowledged alarms.
+	function show_alarms() {
+		$.getJSON("/api/alarms", function(list) {
+			var div = $("#alarms");
+			div.empty();
+			if (list.length == 0) {
+				div.text("No alarm.");
+				return;
+			}
+			var table = $("<table>").append(
+				"<tr><th>State</th><th>Rule</th><th>Channel</th><th>Since</th><th>Message</th><th></th></tr>"
+			);
+			$.each(list, function(_, a) {
+				var tr = $("<tr>").addClass(a.active ? "alarm-active" : "alarm-cleared");
+				tr.append($("<td>").text(a.active ? "ACTIVE" : "cleared"));
+				tr.append($("<td>").text(a.rule));
+				tr.append($("<td>").text(a.channel));
+				tr.append($("<td>").text(new Date(a.since).toLocaleString()));
+				tr.append($("<td>").text(a.msg));
+				var td = $("<td>");
+				if (a.acked) {
+					td.text("acknowledged by " + a.ackBy);
+				} else {
+					$("<button>").text("Acknowledge").click(function() {
+						ack_alarm(a);
+					}).appendTo(td);
+				}
+				tr.append(td);
+				table.append(tr);
 			});
-			
-			plot.setSelection(ranges);
-			//overview.setSelection(ranges, true);
-			
+			div.append(table);
+		});
+	};
+
+	function ack_alarm(a) {
+		var user = prompt("Acknowledge " + a.rule + " on " + a.channel + " as:", "");
+		if (!user) {
+			return;
 		}
+		$.post("/api/alarms/ack", { rule: a.rule, channel: a.channel, user: user }, show_alarms);
 	};
 
-	window.onload = function() {
+	$(function() {
+		show_alarms();
+		setInterval(show_alarms, 5000);
 
-            console.log("onload");
+		// now connect the two
+		$("#placeholder").bind("plotselected", function (event, ranges) {
+			plot.clearSelection();
+			zoom(ranges);
+		});
+		$("#overview").bind("plotselected", function (event, ranges) {
+			zoom(ranges);
+		});
+		$("#unzoom").click(unzoom);
+		$("#annotate").submit(annotate);
+	});
 
-            sock = new WebSocket(wsuri);
+	window.onload = function() {
 
-            sock.onopen = function() {
-                console.log("connected to " + wsuri);
-            }
+            console.log("onload");
 
-            sock.onclose = function(e) {
-                console.log("connection closed (" + e.code + ")");
+            if (timeRange) {
+                fetch(timeRange.start, timeRange.end, update);
+                return;
             }
 
-             sock.onmessage = function(e) {
-				var obj = JSON.parse(e.data);
-				data[0]["data"] = obj["temperature"];
-				data[1]["data"] = obj["pressure"];
-				data[2]["data"] = obj["hygrometry"];
-				update_display();
-         }
+            connect();
     };
 
-
 	/*
 	// insert checkboxes 
 	var choiceContainer = $("#choices");
@@ -227,6 +464,17 @@ const (
 	-moz-box-shadow: 0 3px 10px rgba(0,0,0,0.1);
 	-webkit-box-shadow: 0 3px 10px rgba(0,0,0,0.1);
 }
+.alarm-active {
+	color: #c00;
+	font-weight: bold;
+}
+.alarm-cleared {
+	color: #888;
+}
+#alarms td, #alarms th {
+	padding: 2px 10px;
+	text-align: left;
+}
 .demo-placeholder {
 	width: 100%;
 	height: 100%;
@@ -237,6 +485,7 @@ const (
 </head>
 <body>
 <pre>{{ .Title }}</pre>
+<div id="alarms"></div>
 <div id="content">
 	<div class="demo-container">
 		<div id="placeholder" class="demo-placeholder"></div>
@@ -244,13 +493,41 @@ const (
 	<div class="demo-container" style="height:150px;">
 		<div id="overview" class="demo-placeholder"></div>
 	</div>
-	<p>The smaller plot is linked to the main plot, so it acts as an overview. Try dragging a selection on either plot, and watch the behavior of the other.</p>
+	<p>The smaller plot is linked to the main plot, so it acts as an overview. Try dragging a selection on either plot, and watch the behavior of the other.
+	Wide time ranges are displayed with binned data. <button id="unzoom">Reset zoom</button></p>
 </div>
+<pre id="resolution"></pre>
+<pre id="intervals"></pre>
+<form id="annotate">
+<fieldset>
+	<legend>Annotate a time range (select it on the plots)</legend>
+	<label>start <input type="text" name="start"></label>
+	<label>end <input type="text" name="end"></label>
+	<label>test <input type="text" name="test"></label>
+	<label>operator <input type="text" name="operator"></label>
+	<label>filter ID <input type="text" name="filter" size="8"></label>
+	<label>comment <input type="text" name="comment" size="50"></label>
+	<input type="submit" value="Annotate">
+</fieldset>
+</form>
 <pre><b>Legend</b>
-Temperature: in Celsius
-Pressure: in mbar
-Hygrometry: in per-cents
-</pre>
+Shaded ranges: metadata (orange), annotations (blue)
+{{range $axis := .Axes}}Axis on the {{$axis.Position}}: {{if $axis.Unit}}in {{$axis.Unit}}{{else}}no unit{{end}} - {{range $j, $ch := $axis.Channels}}{{if $j}}, {{end}}{{$ch}}{{end}}
+{{end}}</pre>
+<form id="channels" method="get">
+{{range .Groups}}<fieldset>
+	<legend>{{if .Subsystem}}{{.Subsystem}}{{else}}(no subsystem){{end}}</legend>
+	{{range .Channels}}<label title="{{.Name}}{{if .Type}} [{{.Type}}]{{end}}"><input type="checkbox" name="ch" value="{{.Name}}"{{if index $.Selected .ID}} checked{{end}}> {{.Label}}</label>
+	{{end}}
+</fieldset>
+{{end}}<fieldset>
+	<legend>Time range (empty: live view of the last {{.Window}})</legend>
+	<label>start <input type="text" name="start" value="{{.Start}}"></label>
+	<label>end <input type="text" name="end" value="{{.End}}"></label>
+	(RFC 3339 times, e.g. 2016-05-01T12:00:00Z, or durations relative to now, e.g. -6h)
+</fieldset>
+<input type="submit" value="Display">
+</form>
 </body>
 </html>
 	`
@@ -2,7 +2,6 @@ package main
 
 import (
 	"bytes"
-	"database/sql"
 	"encoding/json"
 	"fmt"
 	"html/template"
@@ -20,8 +19,8 @@ func handler(w http.ResponseWriter, r *http.Request) {
 	//fmt.Fprintf(w, "Hi there, I love %s!\n", r.URL.Path[1:])
 	//fmt.Fprintf(w, "Here is my db handle: %#v\n", page.db)
 
-	page.mu.Lock()
-	defer page.mu.Unlock()
+	page.mu.RLock()
+	defer page.mu.RUnlock()
 
 	//	err := page.load()
 	//	if err != nil {
@@ -29,37 +28,41 @@ func handler(w http.ResponseWriter, r *http.Request) {
 	//		return
 	//	}
 
-	err := page.write(w)
+	q := r.URL.Query()
+	patterns := splitPatterns(q["ch"])
+	if len(patterns) == 0 {
+		patterns = splitPatterns([]string{*chans})
+	}
+	v, err := page.view(patterns)
 	if err != nil {
-		http.Error(w, err.Error(), 500)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-}
-
-func dataHandler(ws *websocket.Conn) {
-	buf := new(bytes.Buffer)
-	for data := range datac {
-		buf.Reset()
-		// fmt.Printf("Sending to client: %#v\n", data)
-
-		err := json.NewEncoder(buf).Encode(data)
+	if q.Get("start") != "" || q.Get("end") != "" {
+		beg, end, err := timeRange(q, *window)
 		if err != nil {
-			log.Printf("error encoding data: %v\n", err)
-			continue
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
-
-		err = websocket.Message.Send(ws, string(buf.Bytes()))
-		if err != nil {
-			log.Printf("Can't send: %v\n", err)
-			break
+		v.Range = &timeRangeView{
+			Start: millis(beg),
+			End:   millis(end),
 		}
-		//	fmt.Printf("---[temp]: %v\n", string(buf.Bytes()))
+		v.Start = q.Get("start")
+		v.End = q.Get("end")
+	}
+
+	err = page.write(w, v)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
 	}
 }
 
 func startServer() error {
 	http.HandleFunc("/", handler)
 	http.Handle("/data", websocket.Handler(dataHandler))
+	registerAPI(http.DefaultServeMux)
 
 	const addr = "127.0.0.1:8080"
 	log.Printf("starting server on [%s]...\n", addr)
@@ -80,7 +83,7 @@ type Point struct {
 func (p Point) MarshalJSON() ([]byte, error) {
 	buf := new(bytes.Buffer)
 	err := json.NewEncoder(buf).Encode([2]interface{}{
-		p.X.Unix() * 1000, // javascript's time expects data in milliseconds
+		millis(p.X), // javascript's time expects data in milliseconds
 		p.Y,
 	})
 	return buf.Bytes(), err
@@ -90,124 +93,209 @@ type Points []Point
 
 func (p Points) Len() int { return len(p) }
 
-func (p Points) Less(i, j int) bool { return p[i].X.Unix() < p[j].X.Unix() }
+func (p Points) Less(i, j int) bool { return p[i].X.Before(p[j].X) }
 
 func (p Points) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
 
 type Page struct {
 	Title string
 	URI   string
-	db    *sql.DB
+	store Store
 	Tmpl  *template.Template
 	mu    sync.RWMutex
 	id    int64              // last id
 	descr map[int64]DataDesc // descr-id -> description
+	infos map[string]ChannelInfo
+	cat   *Catalog
 
-	Temperature Points
-	Pressure    Points
-	Hygrometry  Points
+	since  time.Time        // start of the live window
+	series map[int64]Points // descr-id -> data of the live window
+	hub    *hub             // websocket clients
+	alarms *alarms          // alarm rules, if any
+}
+
+// setDescr updates the descriptions and the catalog of the channels.
+func (p *Page) setDescr(descr map[int64]DataDesc) {
+	p.descr = descr
+	p.cat = newCatalog(descr, p.infos)
 }
 
 func (p *Page) load() error {
-	fmt.Printf("... loading db data ... (id=%d,len=%d)\n", p.id, len(p.Temperature))
+	fmt.Printf("... loading db data ... (id=%d,channels=%d)\n", p.id, len(p.series))
 	start := time.Now()
 
-	//p.Temperature = p.Temperature[:p.id]
-	//p.Pressure = p.Pressure[:p.id]
-	//p.Hygrometry = p.Hygrometry[:p.id]
-
 	if len(p.descr) == 0 {
-		descr, err := loadDataDesc(p.db)
+		descr, err := p.store.DataDesc()
 		if err != nil {
 			return err
 		}
-		p.descr = descr
+		p.mu.Lock()
+		p.setDescr(descr)
+		p.mu.Unlock()
 	}
 
-	stmt, err := p.db.Prepare("select * from rawdata where id > ? order by (id and descr_id)")
-	if err != nil {
-		return err
+	if p.id == 0 {
+		// start with the live window before the last sample.
+		last, ok, err := p.store.LastTime()
+		if err != nil {
+			return err
+		}
+		if ok {
+			p.since = last.Add(-*window)
+		}
 	}
-	defer stmt.Close()
 
 	fmt.Printf("... query...\n")
-	rows, err := stmt.Query(p.id)
+	var (
+		last  = p.id // last rawdata id
+		newID bool   // whether a channel is missing from the catalog
+		pts   = make(map[int64]Points)
+	)
+	err := p.store.Rows(p.id, p.since, func(data RawData) error {
+		if last < data.ID {
+			last = data.ID
+		}
+		if _, ok := p.descr[data.DescrID]; !ok {
+			newID = true
+		}
+		if data.Float64.Valid {
+			pts[data.DescrID] = append(pts[data.DescrID], Point{
+				X: data.TStamp.Time,
+				Y: data.Float64.Float64,
+			})
+		}
+		return nil
+	})
 	if err != nil {
 		return err
 	}
-	defer rows.Close()
 
-	fmt.Printf("... row-range...\n")
-	for rows.Next() {
-		var data RawData
-		err = rows.Scan(
-			&data.ID,
-			&data.Float64, &data.String, &data.TStamp,
-			&data.DescrID,
-		)
+	var descr map[int64]DataDesc
+	if newID {
+		fmt.Printf("... reloading data-desc table...\n")
+		descr, err = p.store.DataDesc()
 		if err != nil {
 			return err
 		}
+	}
 
-		name := ""
-		descr := p.descr[data.DescrID]
-		if descr.Name.Valid {
-			name = descr.Name.String
-		}
-
-		switch name {
-		case "testbenchLPC/temperature":
-			p.Temperature = append(
-				p.Temperature,
-				Point{
-					X: data.TStamp.Time,
-					Y: data.Float64.Float64,
-				},
-			)
-
-		case "testbenchLPC/hygrometry":
-			p.Hygrometry = append(
-				p.Hygrometry,
-				Point{
-					X: data.TStamp.Time,
-					Y: data.Float64.Float64,
-				},
-			)
-
-		case "testbenchLPC/pressure":
-			p.Pressure = append(
-				p.Pressure,
-				Point{
-					X: data.TStamp.Time,
-					Y: data.Float64.Float64,
-				},
-			)
+	p.mu.Lock()
+	if descr != nil {
+		p.setDescr(descr)
+	}
+	upd := make(map[string]Points, len(pts))
+	for id, vs := range pts {
+		sort.Sort(vs)
+		if ch, ok := p.cat.Channel(id); ok {
+			upd[ch.Name] = vs
 		}
-		if p.id < data.ID {
-			p.id = data.ID
+		vs = append(p.series[id], vs...)
+		sort.Sort(vs)
+		// drop the samples older than the live window.
+		beg := vs[len(vs)-1].X.Add(-*window)
+		i := sort.Search(len(vs), func(i int) bool { return !vs[i].X.Before(beg) })
+		p.series[id] = append(Points(nil), vs[i:]...)
+	}
+	p.id = last
+	if p.alarms != nil {
+		for name, vs := range upd {
+			p.alarms.update(name, vs)
 		}
 	}
-	sort.Sort(p.Temperature)
-	sort.Sort(p.Pressure)
-	sort.Sort(p.Hygrometry)
+	// publish under the lock, so clients subscribing concurrently get either
+	// this update or a snapshot including it.
+	p.hub.publish(p.id, upd)
+	p.mu.Unlock()
 
-	delta := time.Since(start)
-	fmt.Printf("... loading db data ...[done] (%v)\n", delta)
-
-	datac <- map[string]interface{}{
-		"temperature": p.Temperature,
-		"pressure":    p.Pressure,
-		"hygrometry":  p.Hygrometry,
+	if p.alarms != nil {
+		p.alarms.check(time.Now())
 	}
 
+	delta := time.Since(start)
+	fmt.Printf("... loading db data ...[done] (%v, %d clients)\n", delta, p.hub.len())
+
 	if *verbose {
-		fmt.Printf("temp:  %v C\n", p.Temperature[len(p.Temperature)-1].Y)
-		fmt.Printf("hygro: %v %%\n", p.Hygrometry[len(p.Hygrometry)-1].Y)
-		fmt.Printf("press: %v mbar\n", p.Pressure[len(p.Pressure)-1].Y)
+		for name, vs := range upd {
+			fmt.Printf("%-30s %v (%d new points)\n", name+":", vs[len(vs)-1].Y, len(vs))
+		}
 	}
 	return err
 }
 
-func (p *Page) write(w io.Writer) error {
-	return p.Tmpl.Execute(w, p)
+// view is the content of the page displaying a selection of channels.
+type view struct {
+	Title    string
+	URI      string
+	Groups   []Group        // all the channels, by subsystem
+	Selected map[int64]bool // selected channels
+	Series   []seriesView   // series of the selected channels
+	Axes     []axisView     // y-axes of the plots, one per unit
+	Points   int            // maximum number of points of the series
+	Window   time.Duration  // time span of the live view
+
+	Range      *timeRangeView // displayed time range, nil for the live view
+	Start, End string         // time range, as requested
+}
+
+// timeRangeView is a time range, in milliseconds.
+type timeRangeView struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+}
+
+type seriesView struct {
+	Name  string `json:"name"`
+	Label string `json:"label"`
+	YAxis int    `json:"yaxis"` // 1-based index of the y-axis
+}
+
+type axisView struct {
+	Unit     string   `json:"unit"`
+	Position string   `json:"position"`
+	Channels []string `json:"channels"`
+}
+
+// view returns the view of the channels matching patterns.
+func (p *Page) view(patterns []string) (view, error) {
+	v := view{
+		Title:    p.Title,
+		URI:      p.URI,
+		Selected: make(map[int64]bool),
+		Points:   *points,
+		Window:   *window,
+	}
+	if p.cat == nil {
+		return v, nil
+	}
+	v.Groups = p.cat.Groups()
+
+	chans, err := p.cat.Select(patterns)
+	if err != nil {
+		return v, err
+	}
+	axes := make(map[string]int) // unit -> index of axis
+	for _, ch := range chans {
+		v.Selected[ch.ID] = true
+		i, ok := axes[ch.Unit]
+		if !ok {
+			i = len(v.Axes)
+			axes[ch.Unit] = i
+			pos := "right"
+			if i%2 == 1 {
+				pos = "left"
+			}
+			v.Axes = append(v.Axes, axisView{Unit: ch.Unit, Position: pos})
+		}
+		v.Axes[i].Channels = append(v.Axes[i].Channels, ch.Title)
+		v.Series = append(v.Series, seriesView{
+			Name:  ch.Name,
+			Label: ch.Label(),
+			YAxis: i + 1,
+		})
+	}
+	return v, nil
+}
+
+func (p *Page) write(w io.Writer, v view) error {
+	return p.Tmpl.Execute(w, v)
 }
@@ -0,0 +1,167 @@
+// Package localdb writes trending data into the database of the CCS localdb
+// application.
+//
+// Channels are described by the datadesc table, and their samples are
+// stored in the rawdata table, with millisecond timestamps:
+//
+//	datadesc(id, dataType, maxSamplingMillis, name, preservationDelay, srcName, srcSubsystem)
+//	rawdata(id, doubleData, stringData, tstampmills, descr_id)
+package localdb
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+)
+
+// DB is a connection to the localdb MySQL database.
+type DB struct {
+	db *sql.DB
+}
+
+// Open opens a connection to the localdb MySQL database, described by the
+// data source name dsn (e.g. "user:password@/ccs").
+func Open(dsn string) (*DB, error) {
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return nil, err
+	}
+	// Open doesn't open a connection. Validate DSN data:
+	err = db.Ping()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &DB{db: db}, nil
+}
+
+// New returns a DB using the open connection db, e.g. to an SQLite copy of
+// the localdb tables.
+func New(db *sql.DB) *DB {
+	return &DB{db: db}
+}
+
+// Close closes the connection to the database.
+func (db *DB) Close() error {
+	return db.db.Close()
+}
+
+// Sample is a sample of a channel.
+type Sample struct {
+	Time  time.Time
+	Value float64
+}
+
+// DoubleData is the dataType of the datadesc entries of channels holding
+// floating point samples (stored in rawdata.doubleData.)
+const DoubleData = "D"
+
+// DataDesc returns the id of the datadesc entry of the channel name,
+// creating it with the given source name and subsystem if needed.
+// Channels are created with the DoubleData data type.
+//
+// Channel names are unique in the datadesc table: DataDesc fails if the
+// channel already exists in another subsystem.
+func (db *DB) DataDesc(name, srcName, subsystem string) (int64, bool, error) {
+	var (
+		id    int64
+		dtype sql.NullString
+		sub   sql.NullString
+	)
+	err := db.db.QueryRow(
+		"select id, dataType, srcSubsystem from datadesc where name = ?", name,
+	).Scan(&id, &dtype, &sub)
+	switch err {
+	case nil:
+		if sub.String != subsystem {
+			return 0, false, fmt.Errorf(
+				"localdb: channel %q already exists in subsystem %q (not %q)",
+				name, sub.String, subsystem,
+			)
+		}
+		if dtype.String == "" {
+			// entry created without its data type.
+			_, err = db.db.Exec("update datadesc set dataType = ? where id = ?", DoubleData, id)
+			if err != nil {
+				return 0, false, err
+			}
+		}
+		return id, false, nil
+	case sql.ErrNoRows:
+	default:
+		return 0, false, err
+	}
+
+	res, err := db.db.Exec(
+		"insert into datadesc (dataType, name, srcName, srcSubsystem) values (?, ?, ?, ?)",
+		DoubleData, name, srcName, subsystem,
+	)
+	if err != nil {
+		return 0, false, err
+	}
+	id, err = res.LastInsertId()
+	return id, true, err
+}
+
+// Timestamps returns the timestamps (in milliseconds) of the samples of the
+// channel id already stored, between beg and end.
+func (db *DB) Timestamps(id int64, beg, end time.Time) (map[int64]bool, error) {
+	rows, err := db.db.Query(
+		"select tstampmills from rawdata where descr_id = ? and tstampmills between ? and ?",
+		id, Millis(beg), Millis(end),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	known := make(map[int64]bool)
+	for rows.Next() {
+		var ms int64
+		err = rows.Scan(&ms)
+		if err != nil {
+			return nil, err
+		}
+		known[ms] = true
+	}
+	return known, rows.Err()
+}
+
+// Insert inserts the samples of the channel id, within a transaction.
+func (db *DB) Insert(id int64, samples []Sample) error {
+	return db.InsertAll(map[int64][]Sample{id: samples})
+}
+
+// InsertAll inserts the samples of several channels, indexed by their id,
+// within a single transaction.
+func (db *DB) InsertAll(samples map[int64][]Sample) error {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare("insert into rawdata (doubleData, tstampmills, descr_id) values (?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for id, ss := range samples {
+		for _, s := range ss {
+			_, err = stmt.Exec(s.Value, Millis(s.Time), id)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// Millis returns the number of milliseconds since the Unix epoch, as stored
+// in the localdb tables.
+func Millis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
@@ -0,0 +1,114 @@
+package localdb
+
+import (
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// openTest returns a DB backed by a temporary SQLite file holding the
+// datadesc and rawdata tables.
+func openTest(t *testing.T) *DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "localdb.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	for _, q := range []string{
+		"create table datadesc (id integer primary key autoincrement, dataType varchar(1), maxSamplingMillis int default 0, name varchar(255) unique, preservationDelay int default 0, srcName varchar(255), srcSubsystem varchar(255))",
+		"create table rawdata (id integer primary key autoincrement, doubleData double, stringData varchar(255), tstampmills bigint, descr_id bigint)",
+	} {
+		_, err = db.Exec(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return New(db)
+}
+
+func TestDataDesc(t *testing.T) {
+	db := openTest(t)
+
+	_, err := db.db.Exec(
+		"insert into datadesc (name, srcName, srcSubsystem) values (?, ?, ?)",
+		"MSR145_1/T", "MSR145_1", "msr",
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the legacy entry gets its data type on lookup.
+	id, created, err := db.DataDesc("MSR145_1/T", "MSR145_1", "msr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created || id != 1 {
+		t.Fatalf("legacy channel not reused: id=%d, created=%v", id, created)
+	}
+
+	id1, created, err := db.DataDesc("MSR145_1/RH", "MSR145_1", "msr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !created || id1 != 2 {
+		t.Fatalf("channel not created: id=%d, created=%v", id1, created)
+	}
+	id2, created, err := db.DataDesc("MSR145_1/RH", "MSR145_1", "msr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created || id2 != id1 {
+		t.Fatalf("channel not reused: id=%d, created=%v", id2, created)
+	}
+
+	for _, id := range []int64{1, 2} {
+		var dtype string
+		err = db.db.QueryRow("select dataType from datadesc where id = ?", id).Scan(&dtype)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dtype != DoubleData {
+			t.Fatalf("channel %d: invalid data type %q", id, dtype)
+		}
+	}
+
+	// names are unique across subsystems.
+	_, _, err = db.DataDesc("MSR145_1/T", "MSR145_1", "other")
+	if err == nil || !strings.Contains(err.Error(), `already exists in subsystem "msr"`) {
+		t.Fatalf("expected an error, got %v", err)
+	}
+	var n int
+	err = db.db.QueryRow("select count(*) from datadesc").Scan(&n)
+	if err != nil || n != 2 {
+		t.Fatalf("invalid number of channels: %d (err=%v)", n, err)
+	}
+}
+
+func TestInsertAll(t *testing.T) {
+	db := openTest(t)
+	t0 := time.Date(2015, 7, 29, 12, 0, 0, 0, time.UTC)
+	err := db.InsertAll(map[int64][]Sample{
+		1: {{Time: t0, Value: 21.5}, {Time: t0.Add(time.Second), Value: 21.6}},
+		2: {{Time: t0, Value: 45}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		id   int64
+		want int
+	}{{1, 2}, {2, 1}, {3, 0}} {
+		ts, err := db.Timestamps(tc.id, t0, t0.Add(time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ts) != tc.want {
+			t.Fatalf("channel %d: got %d samples, want %d", tc.id, len(ts), tc.want)
+		}
+	}
+}
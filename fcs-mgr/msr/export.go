@@ -0,0 +1,80 @@
+package msr
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"math"
+	"strconv"
+	"time"
+)
+
+// WriteCSV writes the samples of f to w as tidy CSV, with one line per
+// sample of each channel:
+//
+//	time,channel,value,unit,out_of_limits
+//	2015-07-29T12:00:00Z,T,21.5,°C,false
+//
+// out_of_limits flags the samples outside of the limits of their channel.
+// Samples are written at their actual time (see File.Series), and missing
+// (NaN) samples are not written.
+func WriteCSV(w io.Writer, f *File) error {
+	cw := csv.NewWriter(w)
+	err := cw.Write([]string{"time", "channel", "value", "unit", "out_of_limits"})
+	if err != nil {
+		return err
+	}
+	rec := make([]string, 5)
+	for _, row := range f.Rows {
+		for i, v := range row.Data {
+			if math.IsNaN(v) {
+				continue
+			}
+			col := f.Columns[i]
+			rec[0] = row.Time.Add(col.TimeDelay).Format(time.RFC3339Nano)
+			rec[1] = col.Name
+			rec[2] = strconv.FormatFloat(v, 'g', -1, 64)
+			rec[3] = col.Unit
+			rec[4] = strconv.FormatBool(col.OutOfLimits(v))
+			err = cw.Write(rec)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteJSON writes the samples of f to w as JSON lines, with one object per
+// sample of each channel:
+//
+//	{"time":"2015-07-29T12:00:00Z","channel":"T","value":21.5,"unit":"°C","out_of_limits":false}
+//
+// out_of_limits flags the samples outside of the limits of their channel.
+// Samples are written at their actual time (see File.Series), and missing
+// (NaN) samples are not written.
+func WriteJSON(w io.Writer, f *File) error {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	for _, row := range f.Rows {
+		for i, v := range row.Data {
+			if math.IsNaN(v) {
+				continue
+			}
+			col := f.Columns[i]
+			err := enc.Encode(struct {
+				Time    time.Time `json:"time"`
+				Channel string    `json:"channel"`
+				Value   float64   `json:"value"`
+				Unit    string    `json:"unit,omitempty"`
+				Out     bool      `json:"out_of_limits"`
+			}{row.Time.Add(col.TimeDelay), col.Name, v, col.Unit, col.OutOfLimits(v)})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,163 @@
+package msr
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newLimitsFile() *File {
+	t0 := time.Date(2015, 7, 29, 12, 0, 0, 0, time.UTC)
+	nan := math.NaN()
+	return &File{
+		Start: t0,
+		Columns: []Column{
+			{Name: "T", Unit: "°C", Limits: Limits{Limit1: 10, Limit2: 30}},
+			{Name: "RH", Unit: "%", Limits: NoLimits()},
+		},
+		Rows: []Row{
+			{Time: t0, Data: []float64{21.5, 45}},
+			{Time: t0.Add(time.Second), Data: []float64{35, nan}},
+			{Time: t0.Add(2 * time.Second), Data: []float64{nan, 99}},
+			{Time: t0.Add(3 * time.Second), Data: []float64{5, 50}},
+		},
+	}
+}
+
+func TestWriteCSVFlags(t *testing.T) {
+	var buf bytes.Buffer
+	err := WriteCSV(&buf, newLimitsFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `time,channel,value,unit,out_of_limits
+2015-07-29T12:00:00Z,T,21.5,°C,false
+2015-07-29T12:00:00Z,RH,45,%,false
+2015-07-29T12:00:01Z,T,35,°C,true
+2015-07-29T12:00:02Z,RH,99,%,false
+2015-07-29T12:00:03Z,T,5,°C,true
+2015-07-29T12:00:03Z,RH,50,%,false
+`
+	if got := buf.String(); got != want {
+		t.Fatalf("invalid CSV:\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestWriteJSONFlags(t *testing.T) {
+	var buf bytes.Buffer
+	err := WriteJSON(&buf, newLimitsFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []bool
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var v struct {
+			Out *bool `json:"out_of_limits"`
+		}
+		err = json.Unmarshal([]byte(line), &v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v.Out == nil {
+			t.Fatalf("missing out_of_limits field: %s", line)
+		}
+		got = append(got, *v.Out)
+	}
+	if want := []bool{false, false, true, false, true, false}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid flags: got=%v, want=%v", got, want)
+	}
+}
+
+func TestColumnar(t *testing.T) {
+	f := newLimitsFile()
+	var buf bytes.Buffer
+	err := WriteColumnar(&buf, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ReadColumnar(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Start.Equal(f.Start) || len(got.Rows) != len(f.Rows) {
+		t.Fatalf("invalid file: start=%v, rows=%d", got.Start, len(got.Rows))
+	}
+	for j, row := range got.Rows {
+		if !row.Time.Equal(f.Rows[j].Time) {
+			t.Fatalf("row #%d: invalid time: got=%v, want=%v", j, row.Time, f.Rows[j].Time)
+		}
+		for i, v := range row.Data {
+			want := f.Rows[j].Data[i]
+			if v != want && !(math.IsNaN(v) && math.IsNaN(want)) {
+				t.Fatalf("row #%d: invalid value of channel %d: got=%v, want=%v", j, i, v, want)
+			}
+		}
+	}
+
+	// the limits are read back, and flag the same samples.
+	var flags [][]bool
+	for _, col := range got.Columns {
+		var out []bool
+		for _, row := range got.Rows {
+			out = append(out, col.OutOfLimits(row.Data[len(flags)]))
+		}
+		flags = append(flags, out)
+	}
+	want := [][]bool{
+		{false, true, false, true},
+		{false, false, false, false},
+	}
+	if !reflect.DeepEqual(flags, want) {
+		t.Fatalf("invalid flags: got=%v, want=%v", flags, want)
+	}
+}
+
+func TestReadColumnarErrors(t *testing.T) {
+	var buf bytes.Buffer
+	err := WriteColumnar(&buf, newLimitsFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := buf.Bytes()
+
+	header := func(rows int) []byte {
+		var buf bytes.Buffer
+		buf.WriteString(columnarMagic)
+		buf.WriteByte(columnarVersion)
+		err := gob.NewEncoder(&buf).Encode(columnarHeader{
+			Columns: []Column{{Name: "T"}},
+			Rows:    rows,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+
+	for _, tc := range []struct {
+		name string
+		raw  []byte
+		err  string
+	}{
+		{"empty", nil, "could not read columnar header"},
+		{"magic", append([]byte("MSRX"), raw[4:]...), "not a columnar MSR file"},
+		{"version", append([]byte("MSRC\x02"), raw[5:]...), "unsupported columnar format version 2"},
+		{"metadata", []byte("MSRC\x01garbage"), "could not decode columnar metadata"},
+		{"negative rows", header(-1), "invalid number of rows -1"},
+		{"truncated times", raw[:len(raw)-8*2*4-3], "could not read time of row #3"},
+		{"truncated values", raw[:len(raw)-1], "could not read values of channel \"RH\""},
+		// a corrupted number of rows does not allocate them all.
+		{"huge rows", header(math.MaxInt32), "could not read time of row #0"},
+	} {
+		_, err := ReadColumnar(bytes.NewReader(tc.raw))
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Fatalf("%s: got error %v, want %q", tc.name, err, tc.err)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+// Package msr reads the CSV files exported from MSR data loggers.
+//
+// MSR files are made of sections, introduced by a "*<NAME>" line, describing
+// the recording (start time, modules, channels, units...) and followed by a
+// "*DATA" section holding the samples:
+//
+//	*CREATOR
+//	MSR Electronics GmbH;MSR PC-Software;5.12.04;
+//	*STARTTIME
+//	2015-07-29;12:00:00;
+//	*MODUL
+//	;MSR145;MSR145;
+//	*NAME
+//	;453196;453196;
+//	*TIMEDELAY
+//	s;0;1;
+//	*CHANNEL
+//	TIME;T;RH;
+//	*UNIT
+//	;°C;%;
+//	*LIMITS
+//	Alarm;0;1;
+//	Recorded;1;1;
+//	Limit1;10;20;
+//	Limit2;30;80;
+//	*CALIBRATION
+//	Info;;2-point;
+//	Date;;2015-06-01;
+//	X0;;10.2;
+//	Y0;;10;
+//	X1;;90.5;
+//	Y1;;90;
+//	*DATA
+//	2015-07-29 12:00:00.000;21.50;45.20;
+//
+// The first column of each section describes the time of the rows, or
+// holds the unit of the time delays or the label of the lines of the limits
+// and calibration sections. Unlabelled lines of these sections are taken in
+// the order of the example above.
+// Channels are sampled at different rates: empty cells are missing samples,
+// held as NaN. Channels may also be sampled with a delay w.r.t. the time of the
+// rows, given in the "*TIMEDELAY" section.
+// The samples of a channel, at their actual time, are returned by
+// File.Series, and can be resampled to a regular time grid (see Resample) to
+// be aligned with other channels or other data sources.
+//
+// Files can be loaded at once with Open or Decode, or row by row with a
+// Reader.
+// Recordings can be exported as tidy CSV or JSON lines (see WriteCSV and
+// WriteJSON), and stored in a compact binary columnar format (see
+// WriteColumnar and ReadColumnar.)
+package msr
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"time"
+
+	"github.com/sbinet/lsst-ccs/fcs-mgr/calib"
+)
+
+// File is a recording of an MSR data logger.
+type File struct {
+	Creator string    // software which exported the recording
+	Start   time.Time // start time of the recording
+	Columns []Column  // channels of the recording
+	Rows    []Row     // samples of the channels
+}
+
+// Column describes a channel of a recording.
+type Column struct {
+	Name      string        // title of the associated data
+	Unit      string        // units of the associated data
+	Sensor    string        // name of the sensor collecting the data
+	SensorID  string        // id of the sensor collecting the data
+	TimeDelay time.Duration // delay of the samples w.r.t. the time of their row
+	Limits    Limits
+	CalibData CalibData // zero if the channel has no calibration data
+}
+
+// Source returns the name of the data logger recording the channel, made of
+// its module and id (e.g. "MSR145_453196".)
+func (c Column) Source() string {
+	src := c.Sensor
+	if c.SensorID != "" {
+		if src != "" {
+			src += "_"
+		}
+		src += c.SensorID
+	}
+	if src == "" {
+		src = "msr"
+	}
+	return src
+}
+
+// Row holds the values of the channels at a given time.
+type Row struct {
+	Time time.Time
+	Data []float64 // values of the channels, in the order of the columns (NaN if missing)
+}
+
+// Limits are the limits configured for a channel of the data logger.
+// Limits which are not configured are NaN.
+type Limits struct {
+	Alarm    float64 // whether crossing the limits raises an alarm (0 or 1)
+	Recorded float64 // whether crossing the limits is recorded (0 or 1)
+	Limit1   float64 // first limit
+	Limit2   float64 // second limit
+}
+
+// NoLimits returns limits which are not configured.
+func NoLimits() Limits {
+	nan := math.NaN()
+	return Limits{Alarm: nan, Recorded: nan, Limit1: nan, Limit2: nan}
+}
+
+// Range returns the range of values within the limits.
+// When a single limit is configured, Limit1 is a lower bound and Limit2 an
+// upper bound. Missing bounds are infinite.
+func (l Limits) Range() (min, max float64) {
+	min, max = math.Inf(-1), math.Inf(+1)
+	switch {
+	case math.IsNaN(l.Limit1) && math.IsNaN(l.Limit2):
+	case math.IsNaN(l.Limit2):
+		min = l.Limit1
+	case math.IsNaN(l.Limit1):
+		max = l.Limit2
+	default:
+		min, max = math.Min(l.Limit1, l.Limit2), math.Max(l.Limit1, l.Limit2)
+	}
+	return min, max
+}
+
+// Contains returns whether v is within the limits.
+func (l Limits) Contains(v float64) bool {
+	min, max := l.Range()
+	return min <= v && v <= max
+}
+
+// OutOfLimits returns whether the sample v of the channel is outside of its
+// limits. Missing (NaN) samples are not out of limits.
+func (c Column) OutOfLimits(v float64) bool {
+	return !math.IsNaN(v) && !c.Limits.Contains(v)
+}
+
+// CalibData is the two-point calibration of a channel.
+type CalibData struct {
+	Info string
+	Date time.Time
+	X0   float64
+	Y0   float64
+	X1   float64
+	Y1   float64
+}
+
+// IsZero returns whether c holds no calibration data.
+func (c CalibData) IsZero() bool {
+	return c == CalibData{}
+}
+
+// Model returns the two-point linear calibration going through (X0, Y0) and
+// (X1, Y1).
+func (c CalibData) Model() (calib.Linear, error) {
+	return calib.TwoPoint(c.X0, c.Y0, c.X1, c.Y1)
+}
+
+// Open loads the MSR file fname.
+func Open(fname string) (*File, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	file, err := Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("%v (file %s)", err, fname)
+	}
+	return file, nil
+}
+
+// Decode decodes a whole MSR file from r.
+func Decode(r io.Reader) (*File, error) {
+	rr, err := NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return rr.ReadAll()
+}
+
+// Calibrate applies the two-point calibration of the channels with
+// calibration data to their samples. The calibration data of these channels
+// is then cleared, so samples are not calibrated twice.
+func (f *File) Calibrate() error {
+	for i := range f.Columns {
+		col := &f.Columns[i]
+		if col.CalibData.IsZero() {
+			continue
+		}
+		m, err := col.CalibData.Model()
+		if err != nil {
+			return fmt.Errorf("msr: invalid calibration of channel %q: %v", col.Name, err)
+		}
+		for _, row := range f.Rows {
+			if v := row.Data[i]; !math.IsNaN(v) {
+				row.Data[i] = m.Eval(v)
+			}
+		}
+		col.CalibData = CalibData{}
+	}
+	return nil
+}
+
+// Times returns the times of the samples.
+func (f *File) Times() []time.Time {
+	ts := make([]time.Time, len(f.Rows))
+	for i, row := range f.Rows {
+		ts[i] = row.Time
+	}
+	return ts
+}
+
+// Values returns the values of the i-th channel, NaN for missing samples.
+func (f *File) Values(i int) []float64 {
+	vs := make([]float64, len(f.Rows))
+	for j, row := range f.Rows {
+		vs[j] = row.Data[i]
+	}
+	return vs
+}
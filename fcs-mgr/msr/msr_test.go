@@ -0,0 +1,43 @@
+package msr
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestCalibrate(t *testing.T) {
+	nan := math.NaN()
+	f := &File{
+		Columns: []Column{
+			{Name: "T", CalibData: CalibData{X0: 0, Y0: 1, X1: 10, Y1: 21}},
+			{Name: "P"},
+		},
+		Rows: []Row{
+			{Time: time.Unix(0, 0), Data: []float64{1, 1}},
+			{Time: time.Unix(1, 0), Data: []float64{nan, 2}},
+			{Time: time.Unix(2, 0), Data: []float64{5, nan}},
+		},
+	}
+	err := f.Calibrate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]float64{{3, 1}, {nan, 2}, {11, nan}}
+	for i, row := range f.Rows {
+		for j, v := range row.Data {
+			w := want[i][j]
+			if v != w && !(math.IsNaN(v) && math.IsNaN(w)) {
+				t.Fatalf("row #%d, col #%d: got=%v, want=%v", i, j, v, w)
+			}
+		}
+	}
+	if !f.Columns[0].CalibData.IsZero() {
+		t.Fatalf("calibration data not cleared")
+	}
+
+	f.Columns[1].CalibData = CalibData{X0: 1, X1: 1}
+	if err := f.Calibrate(); err == nil {
+		t.Fatalf("expected an error for a degenerate calibration")
+	}
+}
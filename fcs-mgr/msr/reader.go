@@ -0,0 +1,443 @@
+package msr
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Error describes an invalid MSR file.
+type Error struct {
+	Line int
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("msr: line %d: %s", e.Line, e.Msg)
+}
+
+type section int
+
+const (
+	undefinedSection section = iota
+	creatorSection
+	startTimeSection
+	moduleSection
+	nameSection
+	timeDelaySection
+	channelSection
+	unitSection
+	limitsSection
+	calibrationSection
+	dataSection
+)
+
+var sections = map[string]section{
+	"*CREATOR":     creatorSection,
+	"*STARTTIME":   startTimeSection,
+	"*MODUL":       moduleSection,
+	"*NAME":        nameSection,
+	"*TIMEDELAY":   timeDelaySection,
+	"*CHANNEL":     channelSection,
+	"*UNIT":        unitSection,
+	"*LIMITS":      limitsSection,
+	"*CALIBRATION": calibrationSection,
+	"*DATA":        dataSection,
+}
+
+// delayUnits are the units of the time delays.
+var delayUnits = map[string]time.Duration{
+	"ms":  time.Millisecond,
+	"s":   time.Second,
+	"min": time.Minute,
+	"h":   time.Hour,
+}
+
+// labels of the lines of the limits and calibration sections, in their
+// default order.
+var (
+	limitLabels = []string{"alarm", "recorded", "limit1", "limit2"}
+	calibLabels = []string{"info", "date", "x0", "y0", "x1", "y1"}
+)
+
+// label returns the label of the n-th line of a section, given the content
+// of its first cell. Labels are case-insensitive and spaces are ignored.
+// Unlabelled lines are labelled after their position in the section.
+func (r *Reader) label(cell string, n int, labels []string) (string, error) {
+	if cell == "" {
+		if n >= len(labels) {
+			return "", r.errorf("too many lines in section")
+		}
+		return labels[n], nil
+	}
+	label := strings.ToLower(strings.Replace(cell, " ", "", -1))
+	for _, l := range labels {
+		if l == label {
+			return label, nil
+		}
+	}
+	return "", r.errorf("invalid line label %q", cell)
+}
+
+// Reader reads the rows of an MSR file, one at a time.
+type Reader struct {
+	Creator string    // software which exported the recording
+	Start   time.Time // start time of the recording
+	Columns []Column  // channels of the recording
+
+	scan *bufio.Scanner
+	line int
+	loc  *time.Location
+	hdrs int // number of headers read
+}
+
+// NewReader returns a Reader reading from r.
+// The header of the file, up to the data section, is read and decoded.
+// Times are in UTC.
+func NewReader(r io.Reader) (*Reader, error) {
+	return NewReaderIn(r, time.UTC)
+}
+
+// NewReaderIn is like NewReader, but the clock of the data logger is in the
+// time zone loc.
+func NewReaderIn(r io.Reader, loc *time.Location) (*Reader, error) {
+	rr := &Reader{scan: bufio.NewScanner(r), loc: loc}
+	err := rr.readHeader("")
+	if err != nil {
+		return nil, err
+	}
+	return rr, nil
+}
+
+func (r *Reader) errorf(format string, args ...interface{}) error {
+	return &Error{Line: r.line, Msg: fmt.Sprintf(format, args...)}
+}
+
+// next returns the next non-empty line.
+func (r *Reader) next() (string, error) {
+	for r.scan.Scan() {
+		r.line++
+		line := strings.TrimSpace(r.scan.Text())
+		if r.line == 1 {
+			line = strings.TrimPrefix(line, "\ufeff")
+		}
+		if len(line) == 0 {
+			continue
+		}
+		return line, nil
+	}
+	err := r.scan.Err()
+	if err == nil {
+		err = io.EOF
+	}
+	return "", err
+}
+
+// tokens splits a line into its cells, one per channel, dropping the cell of
+// the time column.
+func (r *Reader) tokens(line string) ([]string, error) {
+	toks := strings.Split(line, ";")
+	n := len(r.Columns) + 1
+	if len(r.Columns) == 0 {
+		// first section describing the columns.
+		for len(toks) > 1 && strings.TrimSpace(toks[len(toks)-1]) == "" {
+			toks = toks[:len(toks)-1]
+		}
+		r.Columns = make([]Column, len(toks)-1)
+		for i := range r.Columns {
+			r.Columns[i].Limits = NoLimits()
+		}
+		n = len(toks)
+	}
+	for len(toks) > n && strings.TrimSpace(toks[len(toks)-1]) == "" {
+		toks = toks[:len(toks)-1]
+	}
+	if len(toks) != n {
+		return nil, r.errorf("invalid number of columns (got=%d, want=%d)", len(toks), n)
+	}
+	for i, tok := range toks {
+		toks[i] = strings.TrimSpace(tok)
+	}
+	return toks, nil
+}
+
+// readHeader reads the header of the file, starting with the line first if
+// not empty.
+func (r *Reader) readHeader(first string) error {
+	var (
+		sec section
+		n   int // index of the line in the section
+		err error
+	)
+	for {
+		line := first
+		first = ""
+		if line == "" {
+			line, err = r.next()
+			if err == io.EOF {
+				return r.errorf("missing data section")
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if line[0] == '*' {
+			s, ok := sections[line]
+			if !ok {
+				return r.errorf("unknown section %q", line)
+			}
+			sec = s
+			n = -1
+			if sec == dataSection {
+				if len(r.Columns) == 0 {
+					return r.errorf("no channel described before data section")
+				}
+				for i, col := range r.Columns {
+					if col.Name == "" {
+						return r.errorf("no name for channel #%d before data section", i)
+					}
+				}
+				r.hdrs++
+				return nil
+			}
+			continue
+		}
+		n++
+
+		switch sec {
+		case undefinedSection:
+			return r.errorf("line outside of any section")
+
+		case creatorSection:
+			var toks []string
+			for _, tok := range strings.Split(line, ";") {
+				if tok = strings.TrimSpace(tok); tok != "" {
+					toks = append(toks, tok)
+				}
+			}
+			if r.Creator != "" {
+				r.Creator += " "
+			}
+			r.Creator += strings.Join(toks, " ")
+
+		case startTimeSection:
+			r.Start, err = time.ParseInLocation("2006-01-02;15:04:05;", line, r.loc)
+			if err != nil {
+				return r.errorf("invalid start time %q: %v", line, err)
+			}
+
+		case moduleSection:
+			toks, err := r.tokens(line)
+			if err != nil {
+				return err
+			}
+			for i, tok := range toks[1:] {
+				r.Columns[i].Sensor = tok
+			}
+
+		case nameSection:
+			toks, err := r.tokens(line)
+			if err != nil {
+				return err
+			}
+			for i, tok := range toks[1:] {
+				r.Columns[i].SensorID = tok
+			}
+
+		case timeDelaySection:
+			toks, err := r.tokens(line)
+			if err != nil {
+				return err
+			}
+			unit, ok := delayUnits[toks[0]]
+			if !ok {
+				return r.errorf("invalid time delay unit %q", toks[0])
+			}
+			for i, tok := range toks[1:] {
+				if tok == "" {
+					continue
+				}
+				v, err := strconv.ParseFloat(tok, 64)
+				if err != nil || v < 0 {
+					return r.errorf("invalid time delay %q of channel #%d", tok, i)
+				}
+				r.Columns[i].TimeDelay = time.Duration(v * float64(unit))
+			}
+
+		case channelSection:
+			toks, err := r.tokens(line)
+			if err != nil {
+				return err
+			}
+			for i, tok := range toks[1:] {
+				r.Columns[i].Name = tok
+			}
+
+		case unitSection:
+			toks, err := r.tokens(line)
+			if err != nil {
+				return err
+			}
+			for i, tok := range toks[1:] {
+				r.Columns[i].Unit = tok
+			}
+
+		case limitsSection:
+			toks, err := r.tokens(line)
+			if err != nil {
+				return err
+			}
+			label, err := r.label(toks[0], n, limitLabels)
+			if err != nil {
+				return err
+			}
+			for i, tok := range toks[1:] {
+				if tok == "" {
+					continue
+				}
+				v, err := strconv.ParseFloat(tok, 64)
+				if err != nil {
+					return r.errorf("invalid limit %q of channel #%d", tok, i)
+				}
+				lim := &r.Columns[i].Limits
+				switch label {
+				case "alarm":
+					lim.Alarm = v
+				case "recorded":
+					lim.Recorded = v
+				case "limit1":
+					lim.Limit1 = v
+				case "limit2":
+					lim.Limit2 = v
+				}
+			}
+
+		case calibrationSection:
+			toks, err := r.tokens(line)
+			if err != nil {
+				return err
+			}
+			label, err := r.label(toks[0], n, calibLabels)
+			if err != nil {
+				return err
+			}
+			for i, tok := range toks[1:] {
+				if tok == "" {
+					continue
+				}
+				cal := &r.Columns[i].CalibData
+				switch label {
+				case "info":
+					cal.Info = tok
+					continue
+				case "date":
+					cal.Date, err = time.Parse("2006-01-02", tok)
+					if err != nil {
+						return r.errorf("invalid calibration date %q of channel #%d", tok, i)
+					}
+					continue
+				}
+				v, err := strconv.ParseFloat(tok, 64)
+				if err != nil {
+					return r.errorf("invalid calibration value %q of channel #%d", tok, i)
+				}
+				switch label {
+				case "x0":
+					cal.X0 = v
+				case "y0":
+					cal.Y0 = v
+				case "x1":
+					cal.X1 = v
+				case "y1":
+					cal.Y1 = v
+				}
+			}
+		}
+	}
+}
+
+// Read reads the next row of the data section.
+// Empty cells are missing samples, and hold NaN.
+// Read returns io.EOF at the end of the file.
+// Malformed rows are reported as an *Error, and reading may go on with the
+// next row.
+//
+// A new header may follow the rows (e.g. in a stream from a live data
+// logger, or in concatenated files): it replaces the description of the
+// recording, and the rows which follow it refer to the new Columns.
+// Rows following an invalid header are reported as errors, up to the next
+// valid header.
+func (r *Reader) Read() (Row, error) {
+	var row Row
+	line, err := r.next()
+	if err != nil {
+		return row, err
+	}
+	if line[0] == '*' {
+		r.Creator = ""
+		r.Start = time.Time{}
+		r.Columns = nil
+		err = r.readHeader(line)
+		if err != nil {
+			// drop the partial header: data lines are rejected up to
+			// the next valid header.
+			r.Columns = nil
+			return row, err
+		}
+		return r.Read()
+	}
+	if len(r.Columns) == 0 {
+		return row, r.errorf("data line without a valid header")
+	}
+	toks, err := r.tokens(line)
+	if err != nil {
+		return row, err
+	}
+	row.Time, err = time.ParseInLocation("2006-01-02 15:04:05.999", toks[0], r.loc)
+	if err != nil {
+		return row, r.errorf("invalid time %q: %v", toks[0], err)
+	}
+	row.Data = make([]float64, len(r.Columns))
+	for i, tok := range toks[1:] {
+		switch tok {
+		case "":
+			row.Data[i] = math.NaN()
+		default:
+			val, err := strconv.ParseFloat(tok, 64)
+			if err != nil {
+				return Row{}, r.errorf("invalid value %q of channel %q", tok, r.Columns[i].Name)
+			}
+			row.Data[i] = val
+		}
+	}
+	return row, nil
+}
+
+// ReadAll reads the remaining rows of the recording.
+// The header must not change within the rows.
+func (r *Reader) ReadAll() (*File, error) {
+	f := &File{
+		Creator: r.Creator,
+		Start:   r.Start,
+		Columns: r.Columns,
+	}
+	hdrs := r.hdrs
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if r.hdrs != hdrs {
+			return nil, r.errorf("header changed within the recording")
+		}
+		f.Rows = append(f.Rows, row)
+	}
+	return f, nil
+}
@@ -0,0 +1,227 @@
+package msr
+
+import (
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func openReader(t *testing.T, name string) *Reader {
+	t.Helper()
+	f, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	r, err := NewReader(f)
+	if err != nil {
+		t.Fatalf("could not read header of %s: %v", name, err)
+	}
+	return r
+}
+
+func at(hour, min, sec int) time.Time {
+	return time.Date(2015, 7, 29, hour, min, sec, 0, time.UTC)
+}
+
+func TestReadMultiRate(t *testing.T) {
+	f, err := Open(filepath.Join("testdata", "multirate.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := f.Creator, "MSR Electronics GmbH MSR PC-Software 5.12.04"; got != want {
+		t.Fatalf("invalid creator: got=%q, want=%q", got, want)
+	}
+	if !f.Start.Equal(at(12, 0, 0)) {
+		t.Fatalf("invalid start time: %v", f.Start)
+	}
+	var names, units, srcs []string
+	for _, col := range f.Columns {
+		names = append(names, col.Name)
+		units = append(units, col.Unit)
+		srcs = append(srcs, col.Source())
+	}
+	if want := []string{"T", "RH", "P"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("invalid channels: got=%q, want=%q", names, want)
+	}
+	if want := []string{"°C", "%", "mbar"}; !reflect.DeepEqual(units, want) {
+		t.Fatalf("invalid units: got=%q, want=%q", units, want)
+	}
+	if want := []string{"MSR145_453196", "MSR145_453196", "MSR145_453196"}; !reflect.DeepEqual(srcs, want) {
+		t.Fatalf("invalid sources: got=%q, want=%q", srcs, want)
+	}
+
+	rh := f.Columns[1]
+	if rh.TimeDelay != time.Second {
+		t.Fatalf("invalid time delay: %v", rh.TimeDelay)
+	}
+	if min, max := rh.Limits.Range(); min != 20 || max != 80 {
+		t.Fatalf("invalid limits: [%v, %v]", min, max)
+	}
+	if rh.CalibData.Info != "2-point" || rh.CalibData.X0 != 10.2 || rh.CalibData.Y1 != 90 {
+		t.Fatalf("invalid calibration: %+v", rh.CalibData)
+	}
+	if min, max := f.Columns[2].Limits.Range(); !math.IsInf(min, -1) || !math.IsInf(max, +1) {
+		t.Fatalf("invalid limits of P: [%v, %v]", min, max)
+	}
+	if !f.Columns[2].CalibData.IsZero() {
+		t.Fatalf("invalid calibration of P: %+v", f.Columns[2].CalibData)
+	}
+
+	if len(f.Rows) != 6 {
+		t.Fatalf("invalid number of rows: %d", len(f.Rows))
+	}
+	for i, col := range f.Columns {
+		n := 0
+		for _, row := range f.Rows {
+			if !math.IsNaN(row.Data[i]) {
+				n++
+			}
+		}
+		if want := []int{5, 3, 2}[i]; n != want {
+			t.Fatalf("channel %q: got %d samples, want %d", col.Name, n, want)
+		}
+	}
+
+	s := f.Series(1)
+	want := []time.Time{at(12, 0, 1), at(12, 0, 4), at(12, 0, 6)}
+	if !reflect.DeepEqual(s.Times, want) || !reflect.DeepEqual(s.Values, []float64{45.2, 45.4, 45.5}) {
+		t.Fatalf("invalid series: %v %v", s.Times, s.Values)
+	}
+}
+
+func TestReadRepeatedHeader(t *testing.T) {
+	r := openReader(t, "repeated-header.csv")
+
+	type sample struct {
+		cols []string
+		time time.Time
+		data []float64
+	}
+	var got []sample
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		var cols []string
+		for _, col := range r.Columns {
+			cols = append(cols, col.Name)
+		}
+		got = append(got, sample{cols, row.Time, row.Data})
+	}
+	want := []sample{
+		{[]string{"T", "RH"}, at(12, 0, 0), []float64{21.5, 45.2}},
+		{[]string{"T", "RH"}, at(12, 0, 1), []float64{21.6, 45.3}},
+		{[]string{"P"}, at(13, 0, 0), []float64{1012.3}},
+		{[]string{"P"}, at(13, 0, 1), []float64{1012.4}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid rows:\ngot= %v\nwant=%v", got, want)
+	}
+	if !r.Start.Equal(at(13, 0, 0)) {
+		t.Fatalf("invalid start time of second header: %v", r.Start)
+	}
+
+	// a recording must have a single header.
+	f, err := os.Open(filepath.Join("testdata", "repeated-header.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	_, err = Decode(f)
+	if e, ok := err.(*Error); !ok || e.Line != 21 {
+		t.Fatalf("expected an error at line 21, got %v", err)
+	}
+}
+
+func TestReadBadLines(t *testing.T) {
+	r := openReader(t, "bad-lines.csv")
+
+	var (
+		times []time.Time
+		lines []int
+	)
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			e, ok := err.(*Error)
+			if !ok {
+				t.Fatalf("invalid error type %T: %v", err, err)
+			}
+			lines = append(lines, e.Line)
+			continue
+		}
+		times = append(times, row.Time)
+	}
+	if want := []int{9, 10, 11, 13}; !reflect.DeepEqual(lines, want) {
+		t.Fatalf("invalid error lines: got=%v, want=%v", lines, want)
+	}
+	if want := []time.Time{at(12, 0, 0), at(12, 0, 5)}; !reflect.DeepEqual(times, want) {
+		t.Fatalf("invalid rows: got=%v, want=%v", times, want)
+	}
+}
+
+func TestReadInvalidHeader(t *testing.T) {
+	const (
+		header = "*CREATOR\nfcs-msr-sim;\n*STARTTIME\n2015-07-29;12:00:00;\n*CHANNEL\n"
+		data   = "*UNIT\n;°C;%;\n*DATA\n2015-07-29 12:00:00.000;21.50;45.20;\n"
+	)
+
+	// a header without channel names, e.g. from a stream opened in the
+	// middle of a header.
+	_, err := NewReader(strings.NewReader(data))
+	if e, ok := err.(*Error); !ok || e.Line != 3 {
+		t.Fatalf("expected an error at line 3, got %v", err)
+	}
+
+	r, err := NewReader(strings.NewReader(
+		header + "TIME;T;RH;\n" + data + // lines 1-10
+			header + "TIME;;RH;\n" + data + // lines 11-20
+			"2015-07-29 12:00:01.000;21.60;45.30;\n" + // line 21
+			header + "TIME;T;RH;\n" + data, // lines 22-31
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		lines []int
+		rows  int
+	)
+	for {
+		_, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			e, ok := err.(*Error)
+			if !ok {
+				t.Fatalf("invalid error type %T: %v", err, err)
+			}
+			lines = append(lines, e.Line)
+			continue
+		}
+		rows++
+		if r.Columns[0].Name != "T" || r.Columns[1].Name != "RH" {
+			t.Fatalf("row with invalid channels: %+v", r.Columns)
+		}
+	}
+	if want := []int{19, 20, 21}; !reflect.DeepEqual(lines, want) {
+		t.Fatalf("invalid error lines: got=%v, want=%v", lines, want)
+	}
+	if rows != 2 {
+		t.Fatalf("invalid number of rows: got=%d, want=2", rows)
+	}
+}
@@ -0,0 +1,198 @@
+package msr
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// Series holds the samples of a channel, at their actual time.
+type Series struct {
+	Column Column
+	Times  []time.Time // time of the samples, corrected for the time delay of the channel
+	Values []float64
+}
+
+// Series returns the samples of the i-th channel, skipping the missing ones.
+// The time delay of the channel is applied to the time of the samples.
+func (f *File) Series(i int) Series {
+	s := Series{Column: f.Columns[i]}
+	for _, row := range f.Rows {
+		v := row.Data[i]
+		if math.IsNaN(v) {
+			continue
+		}
+		s.Times = append(s.Times, row.Time.Add(s.Column.TimeDelay))
+		s.Values = append(s.Values, v)
+	}
+	return s
+}
+
+// Method is a resampling method.
+type Method int
+
+const (
+	Last   Method = iota // last sample at or before the time of the bin
+	Linear               // linear interpolation between the surrounding samples
+	Mean                 // mean of the samples within the bin
+)
+
+func (m Method) String() string {
+	switch m {
+	case Last:
+		return "last"
+	case Linear:
+		return "linear"
+	case Mean:
+		return "mean"
+	}
+	return fmt.Sprintf("Method(%d)", int(m))
+}
+
+// ParseMethod returns the resampling method named s (last, linear or mean.)
+func ParseMethod(s string) (Method, error) {
+	for _, m := range []Method{Last, Linear, Mean} {
+		if m.String() == s {
+			return m, nil
+		}
+	}
+	return 0, fmt.Errorf("msr: unknown resampling method %q", s)
+}
+
+// Grid is a regular time grid, made of N bins of width Step starting at Start.
+type Grid struct {
+	Start time.Time
+	Step  time.Duration
+	N     int
+}
+
+// NewGrid returns the grid of bins of width step covering [start, end].
+func NewGrid(start, end time.Time, step time.Duration) Grid {
+	g := Grid{Start: start, Step: step}
+	if step > 0 && !end.Before(start) {
+		g.N = int(end.Sub(start)/step) + 1
+	}
+	return g
+}
+
+// Time returns the start time of the i-th bin.
+func (g Grid) Time(i int) time.Time {
+	return g.Start.Add(time.Duration(i) * g.Step)
+}
+
+// Resample returns the values of the series on the bins of the grid g.
+// Bins without a value (before the first sample, after the last one, or
+// without sample for the Mean method) are NaN: values are never held for
+// longer than a bin after the last sample.
+func (s Series) Resample(g Grid, m Method) []float64 {
+	out := make([]float64, g.N)
+	for i := range out {
+		out[i] = math.NaN()
+	}
+	if len(s.Times) == 0 {
+		return out
+	}
+	first, last := s.Times[0], s.Times[len(s.Times)-1]
+
+	for i := range out {
+		t := g.Time(i)
+		switch m {
+		case Last:
+			if t.Before(first) || !t.Before(last.Add(g.Step)) {
+				continue
+			}
+			j := s.search(t)
+			if j == len(s.Times) || s.Times[j].After(t) {
+				j--
+			}
+			out[i] = s.Values[j]
+
+		case Linear:
+			if t.Before(first) || t.After(last) {
+				continue
+			}
+			j := s.search(t)
+			if s.Times[j].Equal(t) {
+				out[i] = s.Values[j]
+				continue
+			}
+			t0, t1 := s.Times[j-1], s.Times[j]
+			v0, v1 := s.Values[j-1], s.Values[j]
+			out[i] = v0 + (v1-v0)*float64(t.Sub(t0))/float64(t1.Sub(t0))
+
+		case Mean:
+			var (
+				sum = 0.0
+				n   = 0
+				end = t.Add(g.Step)
+			)
+			for j := s.search(t); j < len(s.Times) && s.Times[j].Before(end); j++ {
+				sum += s.Values[j]
+				n++
+			}
+			if n > 0 {
+				out[i] = sum / float64(n)
+			}
+		}
+	}
+	return out
+}
+
+// search returns the index of the first sample at or after t.
+func (s Series) search(t time.Time) int {
+	return sort.Search(len(s.Times), func(i int) bool {
+		return !s.Times[i].Before(t)
+	})
+}
+
+// Resample returns a copy of f with all its channels resampled on a common
+// grid of bins of width step, covering all the samples.
+// The time delays of the channels are applied: the channels of the returned
+// file have no time delay.
+func (f *File) Resample(step time.Duration, m Method) (*File, error) {
+	if step <= 0 {
+		return nil, fmt.Errorf("msr: invalid resampling step %v", step)
+	}
+	var (
+		series = make([]Series, len(f.Columns))
+		start  time.Time
+		end    time.Time
+	)
+	for i := range f.Columns {
+		s := f.Series(i)
+		series[i] = s
+		if len(s.Times) == 0 {
+			continue
+		}
+		if t := s.Times[0]; start.IsZero() || t.Before(start) {
+			start = t
+		}
+		if t := s.Times[len(s.Times)-1]; end.IsZero() || t.After(end) {
+			end = t
+		}
+	}
+
+	out := &File{
+		Creator: f.Creator,
+		Start:   f.Start,
+		Columns: make([]Column, len(f.Columns)),
+	}
+	copy(out.Columns, f.Columns)
+	if start.IsZero() {
+		return out, nil
+	}
+
+	g := NewGrid(start.Truncate(step), end, step)
+	out.Rows = make([]Row, g.N)
+	for i := range out.Rows {
+		out.Rows[i] = Row{Time: g.Time(i), Data: make([]float64, len(f.Columns))}
+	}
+	for j, s := range series {
+		out.Columns[j].TimeDelay = 0
+		for i, v := range s.Resample(g, m) {
+			out.Rows[i].Data[j] = v
+		}
+	}
+	return out, nil
+}
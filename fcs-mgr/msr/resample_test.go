@@ -0,0 +1,172 @@
+package msr
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseMethod(t *testing.T) {
+	for _, m := range []Method{Last, Linear, Mean} {
+		got, err := ParseMethod(m.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != m {
+			t.Fatalf("invalid method: got=%v, want=%v", got, m)
+		}
+	}
+	_, err := ParseMethod("median")
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	if got, want := Method(42).String(), "Method(42)"; got != want {
+		t.Fatalf("invalid method name: got=%q, want=%q", got, want)
+	}
+}
+
+func TestNewGrid(t *testing.T) {
+	for _, tc := range []struct {
+		end  time.Duration
+		step time.Duration
+		n    int
+	}{
+		{0, time.Second, 1},
+		{6 * time.Second, time.Second, 7},
+		{6 * time.Second, 2 * time.Second, 4},
+		{7 * time.Second, 2 * time.Second, 4},
+		{-time.Second, time.Second, 0},
+		{time.Second, 0, 0},
+	} {
+		g := NewGrid(at(12, 0, 0), at(12, 0, 0).Add(tc.end), tc.step)
+		if g.N != tc.n {
+			t.Fatalf("grid [0, %v] of step %v: got %d bins, want %d", tc.end, tc.step, g.N, tc.n)
+		}
+	}
+	g := NewGrid(at(12, 0, 0), at(12, 1, 0), 20*time.Second)
+	if got, want := g.Time(2), at(12, 0, 40); !got.Equal(want) {
+		t.Fatalf("invalid bin time: got=%v, want=%v", got, want)
+	}
+}
+
+func TestResample(t *testing.T) {
+	f, err := Open(filepath.Join("testdata", "multirate.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// samples of testdata/multirate.csv, at their actual time (in s from
+	// 12:00:00):
+	//   T:  0:21.50 1:21.55 2:21.60 3:21.65         5:21.70
+	//   RH:         1:45.20                 4:45.40         6:45.50 (1s delay)
+	//   P:  0:1012.3                        4:1012.1
+	nan := math.NaN()
+	for _, tc := range []struct {
+		step time.Duration
+		m    Method
+		want [][]float64 // values of T, RH and P in each bin
+	}{
+		{
+			// values are held for one bin at most after the last sample.
+			step: time.Second, m: Last,
+			want: [][]float64{
+				{21.50, 21.55, 21.60, 21.65, 21.65, 21.70, nan},
+				{nan, 45.20, 45.20, 45.20, 45.40, 45.40, 45.50},
+				{1012.3, 1012.3, 1012.3, 1012.3, 1012.1, nan, nan},
+			},
+		},
+		{
+			// exact on samples, interpolated between them.
+			step: time.Second, m: Linear,
+			want: [][]float64{
+				{21.50, 21.55, 21.60, 21.65, 21.675, 21.70, nan},
+				{nan, 45.20, 45.20 + 0.2/3, 45.20 + 0.4/3, 45.40, 45.45, 45.50},
+				{1012.3, 1012.25, 1012.2, 1012.15, 1012.1, nan, nan},
+			},
+		},
+		{
+			// gaps stay NaN.
+			step: time.Second, m: Mean,
+			want: [][]float64{
+				{21.50, 21.55, 21.60, 21.65, nan, 21.70, nan},
+				{nan, 45.20, nan, nan, 45.40, nan, 45.50},
+				{1012.3, nan, nan, nan, 1012.1, nan, nan},
+			},
+		},
+		{
+			step: 2 * time.Second, m: Mean,
+			want: [][]float64{
+				{21.525, 21.625, 21.70, nan},
+				{45.20, nan, 45.40, 45.50},
+				{1012.3, nan, 1012.1, nan},
+			},
+		},
+		{
+			step: 2 * time.Second, m: Last,
+			want: [][]float64{
+				{21.50, 21.60, 21.65, 21.70},
+				{nan, 45.20, 45.40, 45.50},
+				{1012.3, 1012.3, 1012.1, nan},
+			},
+		},
+	} {
+		out, err := f.Resample(tc.step, tc.m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(out.Rows) != len(tc.want[0]) {
+			t.Fatalf("%v/%v: invalid number of rows: got=%d, want=%d", tc.m, tc.step, len(out.Rows), len(tc.want[0]))
+		}
+		for i, row := range out.Rows {
+			if want := at(12, 0, 0).Add(time.Duration(i) * tc.step); !row.Time.Equal(want) {
+				t.Fatalf("%v/%v: row #%d: invalid time: got=%v, want=%v", tc.m, tc.step, i, row.Time, want)
+			}
+			for j, v := range row.Data {
+				want := tc.want[j][i]
+				if math.IsNaN(want) != math.IsNaN(v) || math.Abs(v-want) > 1e-9 {
+					t.Fatalf("%v/%v: channel %q, row #%d: got=%v, want=%v",
+						tc.m, tc.step, out.Columns[j].Name, i, v, want,
+					)
+				}
+			}
+		}
+		for _, col := range out.Columns {
+			if col.TimeDelay != 0 {
+				t.Fatalf("%v/%v: channel %q: time delay not applied", tc.m, tc.step, col.Name)
+			}
+		}
+	}
+	if f.Columns[1].TimeDelay != time.Second {
+		t.Fatalf("time delay of the original file modified")
+	}
+
+	_, err = f.Resample(0, Last)
+	if err == nil {
+		t.Fatalf("expected an error for a null step")
+	}
+}
+
+func TestResampleEmpty(t *testing.T) {
+	nan := math.NaN()
+	f := &File{
+		Columns: []Column{{Name: "T"}},
+		Rows:    []Row{{Time: at(12, 0, 0), Data: []float64{nan}}},
+	}
+	out, err := f.Resample(time.Second, Linear)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Rows) != 0 || len(out.Columns) != 1 {
+		t.Fatalf("invalid resampling of an empty channel: %+v", out)
+	}
+
+	g := NewGrid(at(12, 0, 0), at(12, 0, 2), time.Second)
+	for _, m := range []Method{Last, Linear, Mean} {
+		for i, v := range (Series{}).Resample(g, m) {
+			if !math.IsNaN(v) {
+				t.Fatalf("%v: bin #%d: got=%v, want=NaN", m, i, v)
+			}
+		}
+	}
+}
@@ -0,0 +1,17 @@
+// Package serial opens serial devices in raw mode, and pseudo-terminals to
+// simulate them.
+package serial
+
+import "fmt"
+
+// bauds are the supported baud rates.
+var bauds = []int{1200, 2400, 4800, 9600, 19200, 38400, 57600, 115200, 230400}
+
+func checkBaud(baud int) error {
+	for _, b := range bauds {
+		if b == baud {
+			return nil
+		}
+	}
+	return fmt.Errorf("serial: unsupported baud rate %d", baud)
+}
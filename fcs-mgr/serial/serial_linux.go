@@ -0,0 +1,113 @@
+package serial
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// cbaud is the mask of the speed bits of the control flags (CBAUD.)
+const cbaud = 0010017
+
+var speeds = map[int]uint32{
+	1200:   syscall.B1200,
+	2400:   syscall.B2400,
+	4800:   syscall.B4800,
+	9600:   syscall.B9600,
+	19200:  syscall.B19200,
+	38400:  syscall.B38400,
+	57600:  syscall.B57600,
+	115200: syscall.B115200,
+	230400: syscall.B230400,
+}
+
+func ioctl(fd, req, arg uintptr) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, arg)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// Open opens the serial device name in raw mode (8 data bits, no parity,
+// 1 stop bit), at the given baud rate.
+func Open(name string, baud int) (*os.File, error) {
+	err := checkBaud(baud)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(name, os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, err
+	}
+	err = makeRaw(f, speeds[baud])
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("serial: could not configure [%s]: %v", name, err)
+	}
+	return f, nil
+}
+
+// makeRaw puts the terminal f in raw mode, at the given speed (if not 0.)
+func makeRaw(f *os.File, speed uint32) error {
+	var t syscall.Termios
+	err := ioctl(f.Fd(), syscall.TCGETS, uintptr(unsafe.Pointer(&t)))
+	if err != nil {
+		return err
+	}
+	t.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP |
+		syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	t.Oflag &^= syscall.OPOST
+	t.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	t.Cflag &^= syscall.CSIZE | syscall.PARENB | syscall.CSTOPB
+	t.Cflag |= syscall.CS8 | syscall.CREAD | syscall.CLOCAL
+	if speed != 0 {
+		t.Cflag &^= cbaud
+		t.Cflag |= speed
+		t.Ispeed = speed
+		t.Ospeed = speed
+	}
+	t.Cc[syscall.VMIN] = 1
+	t.Cc[syscall.VTIME] = 0
+	return ioctl(f.Fd(), syscall.TCSETS, uintptr(unsafe.Pointer(&t)))
+}
+
+// OpenPTY opens a new pseudo-terminal, returning its master and slave sides.
+// The name of the slave device (e.g. "/dev/pts/3") is given by slave.Name().
+// The slave side is in raw mode. Keeping it open keeps the pseudo-terminal
+// usable while programs open and close the slave device.
+func OpenPTY() (master, slave *os.File, err error) {
+	master, err = os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var unlock int32
+	err = ioctl(master.Fd(), syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock)))
+	if err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("serial: could not unlock pty: %v", err)
+	}
+
+	var n uint32
+	err = ioctl(master.Fd(), syscall.TIOCGPTN, uintptr(unsafe.Pointer(&n)))
+	if err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("serial: could not get pty number: %v", err)
+	}
+
+	name := fmt.Sprintf("/dev/pts/%d", n)
+	slave, err = os.OpenFile(name, os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	err = makeRaw(slave, 0)
+	if err != nil {
+		slave.Close()
+		master.Close()
+		return nil, nil, fmt.Errorf("serial: could not configure [%s]: %v", name, err)
+	}
+	return master, slave, nil
+}
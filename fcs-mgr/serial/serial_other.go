@@ -0,0 +1,22 @@
+//go:build !linux
+// +build !linux
+
+package serial
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// Open opens the serial device name in raw mode (8 data bits, no parity,
+// 1 stop bit), at the given baud rate.
+func Open(name string, baud int) (*os.File, error) {
+	return nil, fmt.Errorf("serial: serial devices are not supported on %s", runtime.GOOS)
+}
+
+// OpenPTY opens a new pseudo-terminal, returning its master and slave sides.
+// The name of the slave device (e.g. "/dev/pts/3") is given by slave.Name().
+func OpenPTY() (master, slave *os.File, err error) {
+	return nil, nil, fmt.Errorf("serial: pseudo-terminals are not supported on %s", runtime.GOOS)
+}